@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"strings"
+	"time"
 
 	"k8s.io/apimachinery/pkg/util/sets"
 
@@ -20,6 +22,7 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
+	policyv1 "k8s.io/api/policy/v1"
 
 	"github.com/apparentlymart/go-cidr/cidr"
 
@@ -27,6 +30,8 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/tools/events"
 
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -49,6 +54,24 @@ const (
 
 	controllerName = "dns_controller"
 
+	// defaultClusterDomain is the cluster domain that the operator uses
+	// when the cluster DNS configmap does not specify one.
+	defaultClusterDomain = "cluster.local"
+
+	// clusterDomainConfigMapKey is the key in the cluster DNS configmap
+	// that specifies the cluster domain.  The value must match the
+	// clusterDomain setting of the kubelet.
+	clusterDomainConfigMapKey = "clusterDomain"
+
+	// previousClusterDomainsAnnotationKey is the annotation key on a DNS
+	// for the comma-separated list of cluster domains that CoreDNS keeps
+	// serving after the cluster domain has been changed.  The operator
+	// adds a domain to this list when it observes a change to the cluster
+	// domain; pods that were created with the old domain in their search
+	// path keep resolving cluster names until the administrator removes
+	// the annotation (or the domain from the list).
+	previousClusterDomainsAnnotationKey = "dns.operator.openshift.io/previous-cluster-domains"
+
 	namespaceRunLevelLabel           = "openshift.io/run-level"             // "0"
 	namespaceClusterMonitoringLabel  = "openshift.io/cluster-monitoring"    // "true"
 	namespacePodSecurityEnforceLabel = "pod-security.kubernetes.io/enforce" // privileged
@@ -79,19 +102,63 @@ func New(mgr manager.Manager, config Config) (controller.Controller, error) {
 		cache:                     operatorCache,
 		dnsNameResolverEnabled:    config.DNSNameResolverEnabled,
 		dnsNameResolverNamespaces: config.DNSNameResolverNamespaces,
+		canaryMetricsScraper:      newKubeRBACProxyMetricsScraper(mgr.GetConfig(), operatorCache, config.OperatorNamespace),
+		eventRecorder:             mgr.GetEventRecorder(controllerName),
+		upstreamProber:            newUpstreamProber(operatorCache),
+		tlsPreflighter:            newTLSPreflighter(mgr.GetClient()),
+	}
+	reconciler.resourceAutosizer = newDNSResourceAutosizer(operatorCache, reconciler.canaryMetricsScraper)
+	podResourcesCache, err := newPodResourcesCache(mgr.GetConfig(), mgr.GetScheme())
+	if err != nil {
+		return nil, err
+	}
+	// Start the pod informer with the cache rather than on the first
+	// update safety check.
+	if _, err := podResourcesCache.GetInformer(context.TODO(), &corev1.Pod{}, cache.BlockUntilSynced(false)); err != nil {
+		return nil, err
 	}
+	if err := mgr.Add(podResourcesCache); err != nil {
+		return nil, err
+	}
+	reconciler.podResourcesCache = podResourcesCache
 	c, err := controller.New(controllerName, mgr, controller.Options{Reconciler: reconciler})
 	if err != nil {
 		return nil, err
 	}
+	if err := mgr.Add(reconciler.upstreamProber); err != nil {
+		return nil, err
+	}
+	if err := mgr.Add(reconciler.resourceAutosizer); err != nil {
+		return nil, err
+	}
 	scheme := mgr.GetClient().Scheme()
 	mapper := mgr.GetClient().RESTMapper()
 	if err := c.Watch(source.Kind[client.Object](operatorCache, &operatorv1.DNS{}, &handler.EnqueueRequestForObject{})); err != nil {
 		return nil, err
 	}
+	// Update the status of a DNS when the set of its unreachable upstreams
+	// changes.
+	if err := c.Watch(source.Channel(reconciler.upstreamProber.events, &handler.EnqueueRequestForObject{})); err != nil {
+		return nil, err
+	}
+	// Roll out or hold the Corefile of a DNS when its pre-flight TLS
+	// handshakes complete.
+	if err := c.Watch(source.Channel(reconciler.tlsPreflighter.events, &handler.EnqueueRequestForObject{})); err != nil {
+		return nil, err
+	}
+	// Apply or report the recommended requests of a DNS when they change.
+	if err := c.Watch(source.Channel(reconciler.resourceAutosizer.events, &handler.EnqueueRequestForObject{})); err != nil {
+		return nil, err
+	}
 	if err := c.Watch(source.Kind[client.Object](operatorCache, &appsv1.DaemonSet{}, handler.EnqueueRequestForOwner(scheme, mapper, &operatorv1.DNS{}))); err != nil {
 		return nil, err
 	}
+	if err := c.Watch(source.Kind[client.Object](operatorCache, &appsv1.Deployment{}, handler.EnqueueRequestForOwner(scheme, mapper, &operatorv1.DNS{}))); err != nil {
+		return nil, err
+	}
+	if err := c.Watch(source.Kind[client.Object](operatorCache, &policyv1.PodDisruptionBudget{}, handler.EnqueueRequestForOwner(scheme, mapper, &operatorv1.DNS{}))); err != nil {
+		return nil, err
+	}
 	if err := c.Watch(source.Kind[client.Object](operatorCache, &corev1.Service{}, handler.EnqueueRequestForOwner(scheme, mapper, &operatorv1.DNS{}))); err != nil {
 		return nil, err
 	}
@@ -106,8 +173,18 @@ func New(mgr manager.Manager, config Config) (controller.Controller, error) {
 		return nil, err
 	}
 
-	objectToDNS := func(context.Context, client.Object) []reconcile.Request {
-		return []reconcile.Request{{NamespacedName: DefaultDNSNamespaceName()}}
+	// Cluster-wide configuration and nodes affect every DNS.
+	objectToDNS := func(ctx context.Context, _ client.Object) []reconcile.Request {
+		dnsList := &operatorv1.DNSList{}
+		if err := operatorCache.List(ctx, dnsList); err != nil {
+			logrus.Errorf("failed to list dnses: %v", err)
+			return []reconcile.Request{{NamespacedName: DefaultDNSNamespaceName()}}
+		}
+		requests := make([]reconcile.Request, 0, len(dnsList.Items))
+		for _, dns := range dnsList.Items {
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: dns.Name}})
+		}
+		return requests
 	}
 	isInNS := func(namespace string) func(o client.Object) bool {
 		return func(o client.Object) bool {
@@ -144,6 +221,11 @@ func New(mgr manager.Manager, config Config) (controller.Controller, error) {
 			if !ignoreNodeForTopologyAwareHints(nu) && nodeIsValidForTopologyAwareHints(old) != nodeIsValidForTopologyAwareHints(nu) {
 				return true
 			}
+			// A node that moves to another zone changes the
+			// coverage of zone-aware upstreams' node pools.
+			if old.Labels[corev1.LabelTopologyZone] != nu.Labels[corev1.LabelTopologyZone] {
+				return true
+			}
 			return false
 
 		},
@@ -187,6 +269,9 @@ type reconciler struct {
 
 	client client.Client
 	cache  cache.Cache
+	// podResourcesCache has the pods of all namespaces, reduced to the
+	// fields that the update safety check uses.
+	podResourcesCache cache.Cache
 
 	// dnsNameResolverEnabled indicates that the "DNSNameResolver" featuregate is enabled.
 	dnsNameResolverEnabled bool
@@ -194,6 +279,28 @@ type reconciler struct {
 	// DNSNameResolver resources and updated by the CoreDNS pods the "DNSNameResolver"
 	// featuregate is enabled.
 	dnsNameResolverNamespaces []string
+	// canaryMetricsScraper scrapes the CoreDNS metrics of corefile canary
+	// pods.  If it is nil, the operator judges canary pods only by their
+	// readiness and restarts.
+	canaryMetricsScraper corefileCanaryMetricsScraper
+	// eventRecorder records events on DNSes, for example when the
+	// operator reverts a modification of a resource that it manages.
+	eventRecorder events.EventRecorder
+	// upstreamProber probes the upstream resolvers of DNSes that have
+	// upstream probes enabled.  If it is nil, the upstreams are not
+	// probed.
+	upstreamProber *upstreamProber
+	// resourceAutosizer recommends requests for the CoreDNS containers of
+	// DNSes in the Recommend or Auto resources mode.  If it is nil, no
+	// requests are recommended.
+	resourceAutosizer *dnsResourceAutosizer
+	// tlsPreflighter makes pre-flight TLS handshakes with the DNS-over-TLS
+	// upstreams of DNSes that have them enabled and records the results.
+	// If it is nil, no handshakes are made.
+	tlsPreflighter *tlsPreflighter
+	// updateSafetyStatuses records the latest refused update to the dns
+	// daemonset of each DNS.
+	updateSafetyStatuses dnsUpdateSafetyStatuses
 }
 
 // Reconcile expects request to refer to a dns and will do all the work
@@ -204,11 +311,6 @@ func (r *reconciler) Reconcile(ctx context.Context, request reconcile.Request) (
 
 	logrus.Infof("reconciling request: %v", request)
 
-	if request.NamespacedName.Name != DefaultDNSController {
-		// Return a nil error value to avoid re-triggering the event.
-		logrus.Errorf("skipping unexpected dns %s", request.NamespacedName.Name)
-		return result, nil
-	}
 	// Get the current dns state.
 	dns := &operatorv1.DNS{}
 	if err := r.client.Get(ctx, request.NamespacedName, dns); err != nil {
@@ -234,30 +336,54 @@ func (r *reconciler) Reconcile(ctx context.Context, request reconcile.Request) (
 		default:
 			logrus.SetLevel(logrus.InfoLevel)
 		}
+		clusterDomain, err := r.getClusterDomain()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get cluster domain: %w", err))
+			// Keep using the last cluster domain that was reported
+			// rather than breaking name resolution for the cluster.
+			clusterDomain = defaultClusterDomain
+			if len(dns.Status.ClusterDomain) != 0 {
+				clusterDomain = dns.Status.ClusterDomain
+			}
+		}
 		switch dns.Spec.ManagementState {
 		case operatorv1.Unmanaged:
 			// When the operator is set to unmanaged, it should not make
 			// changes to the DNS or node resolver pods, but it should still
 			// update status
-			//
-			// TODO: fetch clusterDomain from higher level openshift resource
-			// when it is exposed
-			clusterDomain := "cluster.local"
-			clusterIP, err := r.getClusterIPFromNetworkConfig()
+			clusterIP, err := r.getClusterIPForDNS(dns)
 			if err != nil {
-				errs = append(errs, fmt.Errorf("failed to get cluster IP from network config: %v", err))
+				errs = append(errs, err)
 			}
-			haveDNSDaemonset, dnsDaemonset, err := r.currentDNSDaemonSet(dns)
+			haveDNSWorkload, dnsWorkload, err := r.currentDNSWorkload(dns)
 			if err != nil {
 				errs = append(errs, err)
 			}
+			var dnsDaemonset *appsv1.DaemonSet
+			if haveDNSWorkload {
+				dnsDaemonset = dnsWorkload.daemonset
+			}
 			haveNodeResolverDaemonset, nodeResolverDaemonset, err := r.currentNodeResolverDaemonSet()
 			if err != nil {
 				errs = append(errs, err)
 			}
-			// 2*lameDuckDuration is used for transitionUnchangedToleration to add some room to cover lameDuckDuration when CoreDNS reports unavailable.
+			haveNodeLocalCacheDaemonset, nodeLocalCacheDaemonset, err := r.currentNodeLocalCacheDaemonSet(dns)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			// The transitionUnchangedToleration covers the time for which draining CoreDNS pods report unavailable.
 			// This is eventually used to prevent frequent updates.
-			if err := r.syncDNSStatus(dns, clusterIP, clusterDomain, haveDNSDaemonset, dnsDaemonset, haveNodeResolverDaemonset, nodeResolverDaemonset, 2*lameDuckDuration, &result); err != nil {
+			inputs := dnsStatusInputs{
+				clusterIP:                   clusterIP,
+				clusterDomain:               clusterDomain,
+				haveDNSDaemonset:            haveDNSWorkload,
+				dnsDaemonset:                dnsDaemonset,
+				haveNodeResolverDaemonset:   haveNodeResolverDaemonset,
+				nodeResolverDaemonset:       nodeResolverDaemonset,
+				haveNodeLocalCacheDaemonset: haveNodeLocalCacheDaemonset,
+				nodeLocalCacheDaemonset:     nodeLocalCacheDaemonset,
+			}
+			if err := r.syncDNSStatus(dns, inputs, dnsTransitionUnchangedToleration(dns), &result); err != nil {
 				errs = append(errs, fmt.Errorf("failed to sync status of dns %q: %w", dns.Name, err))
 			}
 		default:
@@ -268,14 +394,17 @@ func (r *reconciler) Reconcile(ctx context.Context, request reconcile.Request) (
 
 			if dns.DeletionTimestamp != nil {
 				// Handle deletion.
-				if err := r.ensureOpenshiftExternalNameServiceDeleted(); err != nil {
-					errs = append(errs, fmt.Errorf("failed to delete external name for openshift service: %v", err))
+				if dns.Name == DefaultDNSController {
+					if err := r.ensureOpenshiftExternalNameServiceDeleted(); err != nil {
+						errs = append(errs, fmt.Errorf("failed to delete external name for openshift service: %v", err))
+					}
 				}
-				if err := r.ensureDNSDeleted(dns); err != nil {
+				deleted, err := r.ensureDNSDeleted(dns)
+				if err != nil {
 					errs = append(errs, fmt.Errorf("failed to ensure deletion for dns %s: %v", dns.Name, err))
 				}
 
-				if len(errs) == 0 {
+				if len(errs) == 0 && deleted {
 					// Clean up the finalizer to allow the dns to be deleted.
 					if slice.ContainsString(dns.Finalizers, DNSControllerFinalizer) {
 						updated := dns.DeepCopy()
@@ -289,15 +418,17 @@ func (r *reconciler) Reconcile(ctx context.Context, request reconcile.Request) (
 				errs = append(errs, fmt.Errorf("failed to enforce finalizer for dns %s: %v", dns.Name, err))
 			} else {
 				// Handle everything else.
-				if err := r.ensureDNS(ctx, dns, &result); err != nil {
+				if err := r.ensureDNS(ctx, dns, clusterDomain, &result); err != nil {
 					switch e := err.(type) {
 					case retryable.Error:
 						logrus.Error(e, "got retryable error; requeueing", "after", e.After())
 						return reconcile.Result{RequeueAfter: e.After()}, nil
 					}
 					errs = append(errs, fmt.Errorf("failed to ensure dns %s: %v", dns.Name, err))
-				} else if err := r.ensureExternalNameForOpenshiftService(); err != nil {
-					errs = append(errs, fmt.Errorf("failed to ensure external name for openshift service: %v", err))
+				} else if dns.Name == DefaultDNSController {
+					if err := r.ensureExternalNameForOpenshiftService(clusterDomain); err != nil {
+						errs = append(errs, fmt.Errorf("failed to ensure external name for openshift service: %v", err))
+					}
 				}
 			}
 		}
@@ -313,7 +444,7 @@ func (r *reconciler) Reconcile(ctx context.Context, request reconcile.Request) (
 // ensureExternalNameForOpenshiftService ensures 'openshift.default.svc'
 // resolves to 'kubernetes.default.svc'.
 // This will ensure backward compatibility with openshift 3.x
-func (r *reconciler) ensureExternalNameForOpenshiftService() error {
+func (r *reconciler) ensureExternalNameForOpenshiftService(clusterDomain string) error {
 	svc := &corev1.Service{
 		TypeMeta: metav1.TypeMeta{
 			Kind:       "Service",
@@ -325,9 +456,10 @@ func (r *reconciler) ensureExternalNameForOpenshiftService() error {
 		},
 		Spec: corev1.ServiceSpec{
 			Type:         corev1.ServiceTypeExternalName,
-			ExternalName: "kubernetes.default.svc.cluster.local",
+			ExternalName: "kubernetes.default.svc." + clusterDomain,
 		},
 	}
+	externalName := svc.Spec.ExternalName
 
 	if err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}, svc); err != nil {
 		if !errors.IsNotFound(err) {
@@ -338,6 +470,13 @@ func (r *reconciler) ensureExternalNameForOpenshiftService() error {
 			return fmt.Errorf("failed to create external name service %s/%s: %v", svc.Namespace, svc.Name, err)
 		}
 		logrus.Infof("created external name service %s/%s", svc.Namespace, svc.Name)
+	} else if svc.Spec.Type == corev1.ServiceTypeExternalName && svc.Spec.ExternalName != externalName {
+		updated := svc.DeepCopy()
+		updated.Spec.ExternalName = externalName
+		if err := r.client.Update(context.TODO(), updated); err != nil {
+			return fmt.Errorf("failed to update external name service %s/%s: %v", svc.Namespace, svc.Name, err)
+		}
+		logrus.Infof("updated external name service %s/%s: %s -> %s", svc.Namespace, svc.Name, svc.Spec.ExternalName, externalName)
 	}
 	return nil
 }
@@ -373,15 +512,35 @@ func (r *reconciler) enforceDNSFinalizer(dns *operatorv1.DNS) error {
 	return nil
 }
 
-// ensureDNSDeleted tries to delete related dns resources.
-func (r *reconciler) ensureDNSDeleted(dns *operatorv1.DNS) error {
+// ensureDNSDeleted tries to delete related dns resources.  Returns a Boolean
+// value indicating whether the resources whose deletion must finish before the
+// dns's finalizer is removed are gone.  The node-local dns cache is torn down
+// like when it is disabled, so that its interface is removed from the nodes
+// before garbage collection would delete the cache's daemonset.
+func (r *reconciler) ensureDNSDeleted(dns *operatorv1.DNS) (bool, error) {
+	haveCache, cache, err := r.currentNodeLocalCacheDaemonSet(dns)
+	if err != nil {
+		return false, err
+	}
+	if err := r.ensureNodeLocalCacheDeleted(dns, haveCache, cache); err != nil {
+		return false, fmt.Errorf("failed to tear down node-local dns cache for dns %s: %w", dns.Name, err)
+	}
 	// DNS specific configmap and service has owner reference to daemonset.
 	// So deletion of daemonset will trigger garbage collection of corresponding
 	// configmap and service resources.
 	if err := r.ensureDNSDaemonSetDeleted(dns); err != nil {
-		return fmt.Errorf("failed to delete daemonset for dns %s: %v", dns.Name, err)
+		return false, fmt.Errorf("failed to delete daemonset for dns %s: %v", dns.Name, err)
 	}
-	return nil
+	if err := r.ensureDNSDeploymentDeleted(dns); err != nil {
+		return false, fmt.Errorf("failed to delete deployment for dns %s: %v", dns.Name, err)
+	}
+	if haveCache, _, err := r.currentNodeLocalCacheDaemonSet(dns); err != nil {
+		return false, err
+	} else if haveCache {
+		logrus.Infof("waiting for the node-local dns cache of dns %s to be torn down", dns.Name)
+		return false, nil
+	}
+	return true, nil
 }
 
 // ensureDNSNamespace ensures all the necessary scaffolding exists for
@@ -525,18 +684,44 @@ func (r *reconciler) ensureMetricsIntegration(dns *operatorv1.DNS, svc *corev1.S
 	return nil
 }
 
+// getClusterIPForDNS returns the IP address of the given DNS's service.  The
+// default DNS's service has the well-known address from the cluster network
+// configuration, which the kubelet configures as the nameserver of pods.  The
+// service of any other DNS has the address that the API assigned to it, or
+// none if the service does not exist yet.
+func (r *reconciler) getClusterIPForDNS(dns *operatorv1.DNS) (string, error) {
+	if dns.Name == DefaultDNSController {
+		clusterIP, err := r.getClusterIPFromNetworkConfig()
+		if err != nil {
+			return "", fmt.Errorf("failed to get cluster IP from network config: %v", err)
+		}
+		return clusterIP, nil
+	}
+	haveSvc, svc, err := r.currentDNSService(dns)
+	if err != nil {
+		return "", fmt.Errorf("failed to get service for dns %s: %w", dns.Name, err)
+	}
+	if !haveSvc {
+		return "", nil
+	}
+	return svc.Spec.ClusterIP, nil
+}
+
 // ensureDNS ensures all necessary dns resources exist for a given dns.
-func (r *reconciler) ensureDNS(ctx context.Context, dns *operatorv1.DNS, reconcileResult *reconcile.Result) error {
-	// TODO: fetch this from higher level openshift resource when it is exposed
-	clusterDomain := "cluster.local"
-	clusterIP, err := r.getClusterIPFromNetworkConfig()
+func (r *reconciler) ensureDNS(ctx context.Context, dns *operatorv1.DNS, clusterDomain string, reconcileResult *reconcile.Result) error {
+	clusterIP, err := r.getClusterIPForDNS(dns)
 	if err != nil {
-		return fmt.Errorf("failed to get cluster IP from network config: %v", err)
+		return err
 	}
 
 	errs := []error{}
 
-	if err := r.ensureCABundleConfigMaps(dns); err != nil {
+	if err := r.ensureClusterDomainMigration(dns, clusterDomain); err != nil {
+		errs = append(errs, fmt.Errorf("failed to record cluster domain migration for dns %s: %w", dns.Name, err))
+	}
+
+	caBundleRequeueAfter, err := r.ensureCABundleConfigMaps(dns)
+	if err != nil {
 		errs = append(errs, fmt.Errorf("failed to create ca bundle configmaps for dns %s: %w", dns.Name, err))
 	}
 
@@ -548,6 +733,17 @@ func (r *reconciler) ensureDNS(ctx context.Context, dns *operatorv1.DNS, reconci
 	// Also, the operator will not add a volume to daemonset for this configmap.
 	cmMap := r.caBundleRevisionMap(dns)
 
+	// Grant CoreDNS access to DNSNameResolver resources in the configured
+	// namespaces before the Corefile refers to them.
+	dnsNameResolverNamespaces, err := DNSNameResolverNamespaces(dns, r.dnsNameResolverNamespaces)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to get dnsnameresolver namespaces for dns %s: %w", dns.Name, err))
+		dnsNameResolverNamespaces = r.dnsNameResolverNamespaces
+	}
+	if err := r.ensureDNSNameResolverRBAC(dns, dnsNameResolverNamespaces); err != nil {
+		errs = append(errs, fmt.Errorf("failed to ensure dnsnameresolver rbac for dns %s: %w", dns.Name, err))
+	}
+
 	// Read the centralized TLS security profile from apiservers.config.openshift.io/cluster.
 	// This profile controls the cipher suites and minimum TLS version used by the
 	// kube-rbac-proxy sidecar on the CoreDNS metrics endpoint (port 9154).
@@ -566,27 +762,72 @@ func (r *reconciler) ensureDNS(ctx context.Context, dns *operatorv1.DNS, reconci
 		tlsSecurityProfile = apiServer.Spec.TLSSecurityProfile
 	}
 
-	haveDNSDaemonset, dnsDaemonset, err := r.ensureDNSDaemonSet(dns, cmMap, tlsSecurityProfile)
+	canary, canaryErr := corefileCanaryConfigForDNS(dns)
+	if canaryErr != nil {
+		errs = append(errs, fmt.Errorf("failed to get corefile canary configuration for dns %s: %w", dns.Name, canaryErr))
+	}
+	var canaryRequeueAfter time.Duration
+
+	// Run CoreDNS on the nodes of node pools before the DNS daemonset
+	// leaves those nodes to the node pools' daemonsets.
+	pendingNodePools, err := r.ensureDNSNodePools(dns, clusterDomain, cmMap, dnsNameResolverNamespaces, tlsSecurityProfile)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to ensure node pools for dns %s: %w", dns.Name, err))
+	}
+
+	haveDNSWorkload, dnsWorkload, err := r.ensureDNSWorkload(dns, cmMap, tlsSecurityProfile, pendingNodePools)
+	var dnsDaemonset *appsv1.DaemonSet
+	if haveDNSWorkload {
+		dnsDaemonset = dnsWorkload.daemonset
+	}
 	if err != nil {
-		errs = append(errs, fmt.Errorf("failed to ensure daemonset for dns %s: %v", dns.Name, err))
-	} else if !haveDNSDaemonset {
-		errs = append(errs, fmt.Errorf("failed to get daemonset for dns %s", dns.Name))
+		errs = append(errs, fmt.Errorf("failed to ensure workload for dns %s: %v", dns.Name, err))
+	}
+	if !haveDNSWorkload {
+		if err == nil {
+			errs = append(errs, fmt.Errorf("failed to get workload for dns %s", dns.Name))
+		}
 	} else {
-		trueVar := true
-		daemonsetRef := metav1.OwnerReference{
-			APIVersion: "apps/v1",
-			Kind:       "DaemonSet",
-			Name:       dnsDaemonset.Name,
-			UID:        dnsDaemonset.UID,
-			Controller: &trueVar,
+		daemonsetRef := dnsWorkload.ref
+
+		preflightHold, preflightErr := r.ensureTLSPreflight(dns, clusterDomain, cmMap, dnsNameResolverNamespaces)
+		if preflightErr != nil {
+			errs = append(errs, fmt.Errorf("failed tls preflight for dns %s: %w", dns.Name, preflightErr))
 		}
 
-		if _, _, err := r.ensureDNSConfigMap(dns, clusterDomain, cmMap); err != nil {
-			errs = append(errs, fmt.Errorf("failed to create configmap for dns %s: %v", dns.Name, err))
+		switch {
+		case canaryErr != nil:
+			// Leave the Corefile alone rather than bypassing the
+			// canary because of a typo in the annotation.
+		case preflightHold:
+			// Leave the Corefile alone until the DNS-over-TLS
+			// upstreams pass the pre-flight handshakes.
+		case canary.Enabled:
+			// Roll out Corefile changes through the canary
+			// configmap, which must exist before the canary
+			// daemonset's pods can start.
+			if requeueAfter, err := r.ensureCorefileCanaryConfigMap(dns, canary, clusterDomain, cmMap, dnsNameResolverNamespaces); err != nil {
+				errs = append(errs, fmt.Errorf("failed to ensure corefile canary configmap for dns %s: %w", dns.Name, err))
+			} else {
+				canaryRequeueAfter = requeueAfter
+			}
+			if err := r.ensureCorefileCanaryDaemonSet(dns, canary, cmMap, tlsSecurityProfile); err != nil {
+				errs = append(errs, fmt.Errorf("failed to ensure corefile canary daemonset for dns %s: %w", dns.Name, err))
+			}
+		default:
+			if err := r.ensureCorefileCanaryDeleted(dns); err != nil {
+				errs = append(errs, fmt.Errorf("failed to delete corefile canary for dns %s: %w", dns.Name, err))
+			}
+			if _, _, err := r.ensureDNSConfigMap(dns, clusterDomain, cmMap, dnsNameResolverNamespaces); err != nil {
+				errs = append(errs, fmt.Errorf("failed to create configmap for dns %s: %v", dns.Name, err))
+			}
 		}
 		if _, _, err := r.ensureDNSNetworkPolicy(ctx, dns); err != nil {
 			errs = append(errs, fmt.Errorf("failed to ensure networkpolicy for dns %s: %v", dns.Name, err))
 		}
+		if err := r.ensureUpstreamProbesNetworkPolicy(ctx, dns); err != nil {
+			errs = append(errs, fmt.Errorf("failed to ensure upstream probes networkpolicy for dns %s: %w", dns.Name, err))
+		}
 		// Ensure the default deny all network policy is present for the dns namespace.
 		if _, _, err := r.ensureDenyAllNetworkPolicy(ctx); err != nil {
 			return err
@@ -598,19 +839,58 @@ func (r *reconciler) ensureDNS(ctx context.Context, dns *operatorv1.DNS, reconci
 			errs = append(errs, fmt.Errorf("failed to create service for dns %s: %v", dns.Name, err))
 		} else if !haveSvc {
 			errs = append(errs, fmt.Errorf("failed to get service for dns %s", dns.Name))
-		} else if err := r.ensureMetricsIntegration(dns, svc, daemonsetRef); err != nil {
-			errs = append(errs, fmt.Errorf("failed to integrate metrics with openshift-monitoring for dns %s: %v", dns.Name, err))
+		} else {
+			// The API assigns the address of a new service for a
+			// DNS other than the default DNS.
+			clusterIP = svc.Spec.ClusterIP
+			if err := r.ensureMetricsIntegration(dns, svc, daemonsetRef); err != nil {
+				errs = append(errs, fmt.Errorf("failed to integrate metrics with openshift-monitoring for dns %s: %v", dns.Name, err))
+			} else if err := r.ensureDNSWorkloadRetired(dns, dnsWorkload.retire); err != nil {
+				// Delete the previous workload only after the
+				// service monitor refers to the current one,
+				// lest the garbage collector delete the service
+				// monitor.
+				errs = append(errs, fmt.Errorf("failed to delete previous workload for dns %s: %w", dns.Name, err))
+			}
 		}
 	}
 
-	haveNodeResolverDaemonset, nodeResolverDaemonset, err := r.ensureNodeResolverDaemonSet(dns, clusterIP, clusterDomain)
+	if err := r.ensureDNSPreview(dns, clusterDomain, tlsSecurityProfile); err != nil {
+		errs = append(errs, fmt.Errorf("failed to ensure preview for dns %s: %v", dns.Name, err))
+	}
+
+	// The node resolver daemonset adds the image registry's address to
+	// every node's /etc/hosts, which only the default DNS can do.  Other
+	// DNSes report its status but leave it alone.
+	var haveNodeResolverDaemonset bool
+	var nodeResolverDaemonset *appsv1.DaemonSet
+	if dns.Name == DefaultDNSController {
+		haveNodeResolverDaemonset, nodeResolverDaemonset, err = r.ensureNodeResolverDaemonSet(dns, clusterIP, clusterDomain)
+	} else {
+		haveNodeResolverDaemonset, nodeResolverDaemonset, err = r.currentNodeResolverDaemonSet()
+	}
 	if err != nil {
 		errs = append(errs, err)
 	}
 
-	// 2*lameDuckDuration is used for transitionUnchangedToleration to add some room to cover lameDuckDuration when CoreDNS reports unavailable.
+	haveNodeLocalCacheDaemonset, nodeLocalCacheDaemonset, err := r.ensureNodeLocalCache(dns, clusterIP)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to ensure node-local dns cache for dns %s: %w", dns.Name, err))
+	}
+
+	// The transitionUnchangedToleration covers the time for which draining CoreDNS pods report unavailable.
 	// This is eventually used to prevent frequent updates.
-	if err := r.syncDNSStatus(dns, clusterIP, clusterDomain, haveDNSDaemonset, dnsDaemonset, haveNodeResolverDaemonset, nodeResolverDaemonset, 2*lameDuckDuration, reconcileResult); err != nil {
+	inputs := dnsStatusInputs{
+		clusterIP:                   clusterIP,
+		clusterDomain:               clusterDomain,
+		haveDNSDaemonset:            haveDNSWorkload,
+		dnsDaemonset:                dnsDaemonset,
+		haveNodeResolverDaemonset:   haveNodeResolverDaemonset,
+		nodeResolverDaemonset:       nodeResolverDaemonset,
+		haveNodeLocalCacheDaemonset: haveNodeLocalCacheDaemonset,
+		nodeLocalCacheDaemonset:     nodeLocalCacheDaemonset,
+	}
+	if err := r.syncDNSStatus(dns, inputs, dnsTransitionUnchangedToleration(dns), reconcileResult); err != nil {
 		// If syncDNSStatus returns a retryable error, don't wrap it.  If it were wrapped, it wouldn't be recognized as a retryable error.
 		if _, ok := err.(retryable.Error); ok {
 			errs = append(errs, err)
@@ -619,27 +899,41 @@ func (r *reconciler) ensureDNS(ctx context.Context, dns *operatorv1.DNS, reconci
 		}
 	}
 
+	// Evaluate a soaking Corefile change again when its soak period ends.
+	if canaryRequeueAfter > 0 && (reconcileResult.RequeueAfter == 0 || canaryRequeueAfter < reconcileResult.RequeueAfter) {
+		reconcileResult.RequeueAfter = canaryRequeueAfter
+	}
+	// Switch the Corefile to a rotated CA bundle once the kubelet has had
+	// time to write it to the CA bundle volume.
+	if caBundleRequeueAfter > 0 && (reconcileResult.RequeueAfter == 0 || caBundleRequeueAfter < reconcileResult.RequeueAfter) {
+		reconcileResult.RequeueAfter = caBundleRequeueAfter
+	}
+
 	return retryable.NewMaybeRetryableAggregate(errs)
 }
 
-// caBundleRevisionMap generates a map of ca bundle configmaps with their resource versions
-// to be used in Corefile as the path of ca bundles and in daemonset volume mount path.
-// Resource version is appended to enable automatic reload of Corefile when there is a change
-// in the source ca bundle configmap (e.g. cert rotation). Apart from resource versions,
-// server name is also used in the path of ca bundles in case the same ca bundle configmap
-// is specified for two different servers.
+// caBundleRevisionMap generates a map of ca bundle configmaps with the revisions
+// of their ca bundles, for ca bundle configmaps that have been copied into the
+// operand namespace.  The daemonset mounts each copied configmap at a path that
+// depends only on the server name and the configmap name, so that adding or
+// removing a ca bundle rolls the daemonset but rotating one does not.  The
+// Corefile refers to the file for the revision that copiedCABundleRevision
+// returns in that directory, so a rotation changes the Corefile once the
+// kubelet has had time to write the new file, and CoreDNS reloads it.  Server name is used in
+// the path of ca bundles in case the same ca bundle configmap is specified for
+// two different servers.
 func (r *reconciler) caBundleRevisionMap(dns *operatorv1.DNS) map[string]string {
 	caBundleRevisions := map[string]string{}
 	transportConfig := dns.Spec.UpstreamResolvers.TransportConfig
 	if transportConfig.Transport == operatorv1.TLSTransport {
 		if transportConfig.TLS != nil && transportConfig.TLS.CABundle.Name != "" {
-			name := CABundleConfigMapName(transportConfig.TLS.CABundle.Name)
+			name := CABundleConfigMapName(dns, transportConfig.TLS.CABundle.Name)
 			cm := &corev1.ConfigMap{}
 			err := r.client.Get(context.TODO(), name, cm)
 			if err != nil {
 				logrus.Warningf("failed to get destination ca bundle configmap %s: %v", name.Name, err)
-			} else {
-				caBundleRevisions[transportConfig.TLS.CABundle.Name] = fmt.Sprintf("%s-%s", cm.Name, cm.ResourceVersion)
+			} else if revision, ok := copiedCABundleRevision(cm, time.Now()); ok {
+				caBundleRevisions[transportConfig.TLS.CABundle.Name] = revision
 			}
 		}
 	}
@@ -648,13 +942,13 @@ func (r *reconciler) caBundleRevisionMap(dns *operatorv1.DNS) map[string]string
 		transportConfig := server.ForwardPlugin.TransportConfig
 		if transportConfig.Transport == operatorv1.TLSTransport {
 			if transportConfig.TLS != nil && transportConfig.TLS.CABundle.Name != "" {
-				name := CABundleConfigMapName(transportConfig.TLS.CABundle.Name)
+				name := CABundleConfigMapName(dns, transportConfig.TLS.CABundle.Name)
 				cm := &corev1.ConfigMap{}
 				err := r.client.Get(context.TODO(), name, cm)
 				if err != nil {
 					logrus.Warningf("failed to get destination ca bundle configmap %s: %v", name.Name, err)
-				} else {
-					caBundleRevisions[transportConfig.TLS.CABundle.Name] = fmt.Sprintf("%s-%s", cm.Name, cm.ResourceVersion)
+				} else if revision, ok := copiedCABundleRevision(cm, time.Now()); ok {
+					caBundleRevisions[transportConfig.TLS.CABundle.Name] = revision
 				}
 			}
 		}
@@ -686,6 +980,110 @@ func (r *reconciler) getClusterIPFromNetworkConfig() (string, error) {
 	return dnsClusterIP.String(), nil
 }
 
+// getClusterDomain returns the cluster domain specified in the cluster DNS
+// configmap in the openshift-config namespace, or the default cluster domain
+// if the configmap does not exist or does not specify one.
+func (r *reconciler) getClusterDomain() (string, error) {
+	cm := &corev1.ConfigMap{}
+	if err := r.client.Get(context.TODO(), ClusterDNSConfigMapName(), cm); err != nil {
+		if errors.IsNotFound(err) {
+			return defaultClusterDomain, nil
+		}
+		return "", fmt.Errorf("failed to get configmap %s: %w", ClusterDNSConfigMapName(), err)
+	}
+	return clusterDomainFromConfigMap(cm)
+}
+
+// clusterDomainFromConfigMap returns the cluster domain that is specified in
+// the given cluster DNS configmap, or the default cluster domain if the
+// configmap does not specify one.  Returns an error if the specified value is
+// not a valid DNS subdomain.
+func clusterDomainFromConfigMap(cm *corev1.ConfigMap) (string, error) {
+	clusterDomain := strings.TrimSuffix(strings.TrimSpace(cm.Data[clusterDomainConfigMapKey]), ".")
+	if len(clusterDomain) == 0 {
+		return defaultClusterDomain, nil
+	}
+	clusterDomain = strings.ToLower(clusterDomain)
+	if errs := validation.IsDNS1123Subdomain(clusterDomain); len(errs) != 0 {
+		return "", fmt.Errorf("invalid %s %q in configmap %s/%s: %s", clusterDomainConfigMapKey, clusterDomain, cm.Namespace, cm.Name, strings.Join(errs, ", "))
+	}
+	return clusterDomain, nil
+}
+
+// previousClusterDomains returns the cluster domains that are listed in the
+// given dns's previous-cluster-domains annotation.
+func previousClusterDomains(dns *operatorv1.DNS) []string {
+	var domains []string
+	for _, domain := range strings.Split(dns.Annotations[previousClusterDomainsAnnotationKey], ",") {
+		if domain = strings.TrimSpace(domain); len(domain) != 0 {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// desiredPreviousClusterDomains returns the list of previous cluster domains
+// that CoreDNS should keep serving for the given dns and cluster domain.  The
+// cluster domain that is currently reported in the dns's status is added to
+// the list if it differs from the given cluster domain, and the given cluster
+// domain is removed from the list in case the cluster domain was changed back.
+func desiredPreviousClusterDomains(dns *operatorv1.DNS, clusterDomain string) []string {
+	domains := sets.NewString(previousClusterDomains(dns)...)
+	if current := dns.Status.ClusterDomain; len(current) != 0 && current != clusterDomain {
+		domains.Insert(current)
+	}
+	domains.Delete(clusterDomain)
+	return domains.List()
+}
+
+// ensureClusterDomainMigration records the cluster domain that the given dns
+// currently reports in its status as a previous cluster domain if it differs
+// from the given cluster domain so that CoreDNS keeps serving both domains
+// while pods are recreated with the new search path.
+func (r *reconciler) ensureClusterDomainMigration(dns *operatorv1.DNS, clusterDomain string) error {
+	current := previousClusterDomains(dns)
+	desired := desiredPreviousClusterDomains(dns, clusterDomain)
+	if sets.NewString(current...).Equal(sets.NewString(desired...)) {
+		return nil
+	}
+	updated := dns.DeepCopy()
+	if len(desired) == 0 {
+		delete(updated.Annotations, previousClusterDomainsAnnotationKey)
+	} else {
+		if updated.Annotations == nil {
+			updated.Annotations = map[string]string{}
+		}
+		updated.Annotations[previousClusterDomainsAnnotationKey] = strings.Join(desired, ",")
+	}
+	if err := r.client.Update(context.TODO(), updated); err != nil {
+		return err
+	}
+	logrus.Infof("updated previous cluster domains for dns %s to %v; cluster domain is %q", dns.Name, desired, clusterDomain)
+	updated.DeepCopyInto(dns)
+	return nil
+}
+
+// deleteCachedObject deletes the object with the given name if the operator
+// cache has it so that reconciles do not issue deletes for objects that do not
+// exist.  The description names the object in errors and logs.  The given
+// object is filled in with the cached object.
+func (r *reconciler) deleteCachedObject(description string, name types.NamespacedName, obj client.Object) error {
+	if err := r.cache.Get(context.TODO(), name, obj); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get %s %s/%s: %w", description, name.Namespace, name.Name, err)
+	}
+	if err := r.client.Delete(context.TODO(), obj); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete %s %s/%s: %w", description, name.Namespace, name.Name, err)
+	}
+	logrus.Infof("deleted %s: %s/%s", description, name.Namespace, name.Name)
+	return nil
+}
+
 func dnsOwnerRef(dns *operatorv1.DNS) metav1.OwnerReference {
 	trueVar := true
 	return metav1.OwnerReference{
@@ -2,10 +2,16 @@ package controller
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"fmt"
 	"reflect"
+	"sort"
+	"time"
 
 	operatorv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/cluster-dns-operator/pkg/manifests"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 
 	corev1 "k8s.io/api/core/v1"
@@ -14,7 +20,24 @@ import (
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// caBundleRevisionsAnnotationKey is the annotation on a copied CA
+	// bundle configmap that records, as a JSON object, the time at which
+	// the file for each CA bundle revision in the configmap was added.
+	caBundleRevisionsAnnotationKey = "dns.operator.openshift.io/ca-bundle-revisions"
+
+	// caBundleRevisionPropagationDelay is the time that the operator waits
+	// after adding the file for a new CA bundle revision to a copied CA
+	// bundle configmap before the Corefile refers to that file.  The
+	// kubelet updates configmap volumes on its sync period, which is one
+	// minute by default, and may serve the configmap from a cache that
+	// lags behind by up to the same time again.
+	caBundleRevisionPropagationDelay = 2 * time.Minute
 )
 
 var (
@@ -28,6 +51,12 @@ var (
 	cmLabelSelector = metav1.LabelSelector{
 		MatchLabels: cmLabels,
 	}
+	// caBundleConfigMapsDeletedTotal counts the CA bundle configmaps that
+	// the operator has deleted because no DNS refers to them anymore.
+	caBundleConfigMapsDeletedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "dns_operator_ca_bundle_configmaps_deleted_total",
+		Help: "Number of orphaned CA bundle configmaps that the operator has deleted.",
+	})
 	// cmSelector is a labels.Selector built from cmLabelSelector.
 	cmSelector = func() labels.Selector {
 		v, err := metav1.LabelSelectorAsSelector(&cmLabelSelector)
@@ -38,25 +67,31 @@ var (
 	}()
 )
 
+func init() {
+	ctrlmetrics.Registry.MustRegister(caBundleConfigMapsDeletedTotal)
+}
+
 // ensureCABundleConfigMaps syncs CA bundle configmaps for a DNS
 // between the openshift-config and openshift-dns namespaces if the user has
 // configured a CA bundle configmap. While syncing the configmaps, ca- is
 // prepended to the name of the configmap in openshift-dns namespace
-// to make it understandable that it is a CA bundle.
-func (r *reconciler) ensureCABundleConfigMaps(dns *operatorv1.DNS) error {
-	var configmapNames []string
-	transportConfig := dns.Spec.UpstreamResolvers.TransportConfig
-	if transportConfig.Transport == operatorv1.TLSTransport && transportConfig.TLS != nil && transportConfig.TLS.CABundle.Name != "" {
-		configmapNames = append(configmapNames, transportConfig.TLS.CABundle.Name)
-	}
-	for _, server := range dns.Spec.Servers {
-		transportConfig := server.ForwardPlugin.TransportConfig
-		if transportConfig.Transport == operatorv1.TLSTransport && transportConfig.TLS != nil && transportConfig.TLS.CABundle.Name != "" {
-			configmapNames = append(configmapNames, transportConfig.TLS.CABundle.Name)
+// to make it understandable that it is a CA bundle.  A source configmap
+// whose CA bundle is not valid PEM is reported as an error and is not copied,
+// so that the Corefile keeps using the last valid copy, if any.  Each DNS has
+// its own copies, named ca-<dns>-<source>.  Returns the duration after which
+// the copies should be synced again because a CA bundle revision becomes
+// ready for the Corefile or an old one can be dropped, or zero if none does.
+func (r *reconciler) ensureCABundleConfigMaps(dns *operatorv1.DNS) (time.Duration, error) {
+	configmapNames := caBundleConfigMapNames(dns)
+
+	var requeueAfter time.Duration
+	requeue := func(d time.Duration) {
+		if d > 0 && (requeueAfter == 0 || d < requeueAfter) {
+			requeueAfter = d
 		}
 	}
-
 	var errs []error
+	var expiries []caBundleExpiry
 	for _, name := range configmapNames {
 		sourceName := types.NamespacedName{
 			Namespace: GlobalUserSpecifiedConfigNamespace,
@@ -72,7 +107,14 @@ func (r *reconciler) ensureCABundleConfigMaps(dns *operatorv1.DNS) error {
 			continue
 		}
 
-		destName := CABundleConfigMapName(source.Name)
+		expiry := caBundleExpiryForConfigMapData(source.Name, source.Data)
+		expiries = append(expiries, expiry)
+		if expiry.Err != nil {
+			errs = append(errs, expiry.Err)
+			continue
+		}
+
+		destName := CABundleConfigMapName(dns, source.Name)
 		have, current, err := r.currentCABundleConfigMap(destName)
 		if err != nil {
 			errs = append(errs, fmt.Errorf("failed to get destination ca bundle configmap %s: %w", destName.Name, err))
@@ -97,12 +139,14 @@ func (r *reconciler) ensureCABundleConfigMaps(dns *operatorv1.DNS) error {
 				logrus.Infof("deleted configmap %s/%s", current.Namespace, current.Name)
 			}
 		case want && !have:
+			requeue(mergeCABundleRevisions(nil, desired, time.Now()))
 			if err := r.client.Create(context.TODO(), desired); err != nil {
 				errs = append(errs, fmt.Errorf("failed to create configmap: %w", err))
 			} else {
 				logrus.Infof("created configmap %s/%s", desired.Namespace, desired.Name)
 			}
 		case want && have:
+			requeue(mergeCABundleRevisions(current, desired, time.Now()))
 			if updated, err := r.updateCABundleConfigMap(current, desired); err != nil {
 				errs = append(errs, fmt.Errorf("failed to update configmap: %w", err))
 			} else if updated {
@@ -111,7 +155,9 @@ func (r *reconciler) ensureCABundleConfigMaps(dns *operatorv1.DNS) error {
 		}
 	}
 
-	// remove ca bundle configmaps that are not referred in dns anymore.
+	setCABundleExpiryMetrics(dns, expiries)
+
+	// Remove the ca bundle configmaps of this dns that it no longer refers to.
 	cmListOpts := []client.ListOption{
 		client.MatchingLabelsSelector{
 			Selector: cmSelector,
@@ -122,27 +168,85 @@ func (r *reconciler) ensureCABundleConfigMaps(dns *operatorv1.DNS) error {
 	if err := r.cache.List(context.TODO(), &cmList, cmListOpts...); err != nil {
 		errs = append(errs, fmt.Errorf("failed to list ca bundle configmaps: %w", err))
 	}
-	for _, cm := range cmList.Items {
-		referredInDNS := false
-		for _, name := range configmapNames {
-			destName := CABundleConfigMapName(name)
-			if cm.Name == destName.Name {
-				referredInDNS = true
-				break
+	for _, cm := range orphanedCABundleConfigMaps(dns, cmList.Items, configmapNames) {
+		if err := r.client.Delete(context.TODO(), &cm); err != nil {
+			if !errors.IsNotFound(err) {
+				errs = append(errs, fmt.Errorf("failed to delete configmap: %w", err))
 			}
+		} else {
+			caBundleConfigMapsDeletedTotal.Inc()
+			logrus.Infof("deleted orphaned ca bundle configmap %s/%s of dns %s", cm.Namespace, cm.Name, dns.Name)
 		}
-		if !referredInDNS {
-			if err := r.client.Delete(context.TODO(), &cm); err != nil {
-				if !errors.IsNotFound(err) {
-					errs = append(errs, fmt.Errorf("failed to delete configmap: %w", err))
-				}
-			} else {
-				logrus.Infof("deleted configmap %s/%s", cm.Namespace, cm.Name)
+	}
+
+	return requeueAfter, utilerrors.NewAggregate(errs)
+}
+
+// caBundleConfigMapNames returns the names of the CA bundle configmaps in
+// openshift-config that the given DNS refers to.
+func caBundleConfigMapNames(dns *operatorv1.DNS) []string {
+	var configmapNames []string
+	transportConfig := dns.Spec.UpstreamResolvers.TransportConfig
+	if transportConfig.Transport == operatorv1.TLSTransport && transportConfig.TLS != nil && transportConfig.TLS.CABundle.Name != "" {
+		configmapNames = append(configmapNames, transportConfig.TLS.CABundle.Name)
+	}
+	for _, server := range dns.Spec.Servers {
+		transportConfig := server.ForwardPlugin.TransportConfig
+		if transportConfig.Transport == operatorv1.TLSTransport && transportConfig.TLS != nil && transportConfig.TLS.CABundle.Name != "" {
+			configmapNames = append(configmapNames, transportConfig.TLS.CABundle.Name)
+		}
+	}
+	return configmapNames
+}
+
+// orphanedCABundleConfigMaps returns the CA bundle configmaps among the given
+// configmaps that belong to the given DNS but are not copies of the CA bundle
+// configmaps with the given names.  A CA bundle configmap belongs to the DNS
+// if it has the DNS's owning-dns label or, if it was copied before the operator
+// added that label, if the DNS is among its owners.  CA bundle configmaps with
+// neither the label nor a DNS owner belong to the default DNS.
+func orphanedCABundleConfigMaps(dns *operatorv1.DNS, configmaps []corev1.ConfigMap, names []string) []corev1.ConfigMap {
+	referenced := sets.NewString()
+	for _, name := range names {
+		referenced.Insert(CABundleConfigMapName(dns, name).Name)
+	}
+	var orphans []corev1.ConfigMap
+	for _, cm := range configmaps {
+		if referenced.Has(cm.Name) {
+			continue
+		}
+		if owner, ok := cm.Labels[manifests.OwningDNSLabel]; ok {
+			if owner != DNSDaemonSetLabel(dns) {
+				continue
 			}
+		} else if !ownedByDNS(&cm, dns) && (dns.Name != DefaultDNSController || hasDNSOwner(&cm)) {
+			continue
 		}
+		orphans = append(orphans, cm)
 	}
+	return orphans
+}
 
-	return utilerrors.NewAggregate(errs)
+// hasDNSOwner returns a Boolean value indicating whether any DNS is among the
+// owners of the given object.
+func hasDNSOwner(obj metav1.Object) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.Kind == "DNS" {
+			return true
+		}
+	}
+	return false
+}
+
+// ownedByDNS returns a Boolean value indicating whether the given DNS is among
+// the owners of the given object.
+func ownedByDNS(obj metav1.Object, dns *operatorv1.DNS) bool {
+	for _, ref := range obj.GetOwnerReferences() {
+		if ref.Kind == "DNS" && ref.Name == dns.Name && (len(dns.UID) == 0 || ref.UID == dns.UID) {
+			return true
+		}
+	}
+	return false
 }
 
 // desiredCABundleConfigMap returns the desired CA bundle configmap.  Returns a
@@ -155,19 +259,191 @@ func desiredCABundleConfigMap(dns *operatorv1.DNS, haveSource bool, sourceConfig
 	if dns.DeletionTimestamp != nil {
 		return false, nil, nil
 	}
+	labels := map[string]string{
+		manifests.OwningDNSLabel: DNSDaemonSetLabel(dns),
+	}
+	for k, v := range cmLabels {
+		labels[k] = v
+	}
+	data := map[string]string{}
+	for k, v := range sourceConfigmap.Data {
+		data[k] = v
+	}
+	if bundle, ok := sourceConfigmap.Data[caBundleFileName]; ok {
+		data[caBundleRevisionFileName(caBundleRevision(bundle))] = bundle
+	}
 	cm := corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name.Name,
 			Namespace: name.Namespace,
-			Labels:    cmLabels,
+			Labels:    labels,
 		},
-		Data: sourceConfigmap.Data,
+		Data: data,
 	}
 	cm.SetOwnerReferences([]metav1.OwnerReference{dnsOwnerRef(dns)})
 
 	return true, &cm, nil
 }
 
+// caBundleRevision returns the revision of the given CA bundle, which is a
+// hash of its contents.
+func caBundleRevision(bundle string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(bundle)))[:16]
+}
+
+// caBundleRevisionFileName returns the key under which a copied CA bundle
+// configmap stores the CA bundle with the given revision.  The Corefile refers
+// to the CA bundle by this key so that a CA rotation changes the Corefile,
+// which makes CoreDNS reload, without changing the volume or mount path in the
+// daemonset's pod template.
+func caBundleRevisionFileName(revision string) string {
+	return fmt.Sprintf("ca-bundle-%s.crt", revision)
+}
+
+// copiedCABundleRevision returns the revision of the CA bundle that the
+// Corefile should refer to at the given time for the given copied CA bundle
+// configmap and a Boolean value indicating whether the configmap has the file
+// for such a revision.  This is the newest revision whose file was added at
+// least caBundleRevisionPropagationDelay ago so that the kubelet has had time
+// to write the file to the CA bundle volume, or the oldest revision if no
+// file is that old.
+//
+// A Corefile that refers to a file that the kubelet has not yet written fails
+// to load only if the kubelet takes longer than the delay to update the
+// volume, for example because the node is overloaded or cannot reach the API.
+// In that case, CoreDNS keeps serving with the Corefile that it loaded last.
+func copiedCABundleRevision(cm *corev1.ConfigMap, now time.Time) (string, bool) {
+	times := caBundleRevisionTimes(cm)
+	revisions := sortedCABundleRevisions(times)
+	if len(revisions) == 0 {
+		return "", false
+	}
+	return revisions[servedCABundleRevisionIndex(revisions, times, now)], true
+}
+
+// caBundleRevisionTimes returns the times at which the files for the CA
+// bundle revisions in the given copied CA bundle configmap were added, keyed
+// by revision.  Revisions without a file are left out.  If the configmap was
+// copied before the operator recorded these times, the Corefile already refers
+// to the current revision, so it is given the zero time.
+func caBundleRevisionTimes(cm *corev1.ConfigMap) map[string]time.Time {
+	times := map[string]time.Time{}
+	var recorded map[string]string
+	if err := json.Unmarshal([]byte(cm.Annotations[caBundleRevisionsAnnotationKey]), &recorded); err != nil || len(recorded) == 0 {
+		if bundle, ok := cm.Data[caBundleFileName]; ok {
+			revision := caBundleRevision(bundle)
+			if _, ok := cm.Data[caBundleRevisionFileName(revision)]; ok {
+				times[revision] = time.Time{}
+			}
+		}
+		return times
+	}
+	for revision, value := range recorded {
+		if _, ok := cm.Data[caBundleRevisionFileName(revision)]; !ok {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			logrus.Warningf("ignoring invalid time %q of ca bundle revision %s in configmap %s/%s: %v", value, revision, cm.Namespace, cm.Name, err)
+			continue
+		}
+		times[revision] = t
+	}
+	return times
+}
+
+// sortedCABundleRevisions returns the revisions in the given map, oldest
+// first.
+func sortedCABundleRevisions(times map[string]time.Time) []string {
+	revisions := make([]string, 0, len(times))
+	for revision := range times {
+		revisions = append(revisions, revision)
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		if !times[revisions[i]].Equal(times[revisions[j]]) {
+			return times[revisions[i]].Before(times[revisions[j]])
+		}
+		return revisions[i] < revisions[j]
+	})
+	return revisions
+}
+
+// servedCABundleRevisionIndex returns the index in the given sorted revisions
+// of the revision that the Corefile should refer to at the given time.
+func servedCABundleRevisionIndex(revisions []string, times map[string]time.Time, now time.Time) int {
+	served := 0
+	for i, revision := range revisions {
+		if now.Sub(times[revision]) >= caBundleRevisionPropagationDelay {
+			served = i
+		}
+	}
+	return served
+}
+
+// mergeCABundleRevisions adds to the given desired copied CA bundle configmap
+// the files for the CA bundle revisions in the given current one that are
+// still needed at the given time and records when each file was added.  The
+// desired configmap keeps the revision that the Corefile refers to, any newer
+// revisions that wait for the kubelet to write their files, and, until the
+// kubelet has had time to update the Corefile volume too, the revision that the
+// Corefile referred to before.  Older revisions are dropped.  Returns the
+// duration after which a revision becomes ready for the Corefile or can be
+// dropped, or zero if none does.
+func mergeCABundleRevisions(current, desired *corev1.ConfigMap, now time.Time) time.Duration {
+	bundle, ok := desired.Data[caBundleFileName]
+	if !ok {
+		return 0
+	}
+	revision := caBundleRevision(bundle)
+	times := map[string]time.Time{}
+	if current != nil {
+		times = caBundleRevisionTimes(current)
+	}
+	if _, ok := times[revision]; !ok {
+		times[revision] = now.UTC().Truncate(time.Second)
+	}
+	revisions := sortedCABundleRevisions(times)
+	served := servedCABundleRevisionIndex(revisions, times, now)
+
+	var requeueAfter time.Duration
+	requeue := func(d time.Duration) {
+		if d > 0 && (requeueAfter == 0 || d < requeueAfter) {
+			requeueAfter = d
+		}
+	}
+	first := served
+	if served > 0 {
+		// Pods may still run a Corefile that refers to the previous
+		// revision until the kubelet updates the Corefile volume.
+		if d := times[revisions[served]].Add(2 * caBundleRevisionPropagationDelay).Sub(now); d > 0 {
+			first = served - 1
+			requeue(d)
+		}
+	}
+	for _, r := range revisions[served+1:] {
+		requeue(times[r].Add(caBundleRevisionPropagationDelay).Sub(now))
+	}
+
+	recorded := map[string]string{}
+	for _, r := range revisions[first:] {
+		key := caBundleRevisionFileName(r)
+		if _, ok := desired.Data[key]; !ok {
+			desired.Data[key] = current.Data[key]
+		}
+		recorded[r] = times[r].Format(time.RFC3339)
+	}
+	value, err := json.Marshal(recorded)
+	if err != nil {
+		// A map of strings always encodes.
+		panic(err)
+	}
+	if desired.Annotations == nil {
+		desired.Annotations = map[string]string{}
+	}
+	desired.Annotations[caBundleRevisionsAnnotationKey] = string(value)
+	return requeueAfter
+}
+
 // currentCABundleConfigMap returns the current configmap.  Returns a Boolean
 // indicating whether the configmap existed, the configmap if it did exist, and
 // an error value.
@@ -193,15 +469,33 @@ func (r *reconciler) updateCABundleConfigMap(current, desired *corev1.ConfigMap)
 	}
 	updated := current.DeepCopy()
 	updated.Data = desired.Data
+	if updated.Annotations == nil {
+		updated.Annotations = map[string]string{}
+	}
+	updated.Annotations[caBundleRevisionsAnnotationKey] = desired.Annotations[caBundleRevisionsAnnotationKey]
+	if updated.Labels == nil {
+		updated.Labels = map[string]string{}
+	}
+	for k, v := range desired.Labels {
+		updated.Labels[k] = v
+	}
 	if err := r.client.Update(context.TODO(), updated); err != nil {
 		return false, err
 	}
 	return true, nil
 }
 
-// caBundleConfigmapsEqual compares two CA bundle configmaps.  Returns true if
-// the configmaps should be considered equal for the purpose of determining
-// whether an update is necessary, false otherwise.
-func caBundleConfigmapsEqual(a, b *corev1.ConfigMap) bool {
-	return reflect.DeepEqual(a.Data, b.Data)
+// caBundleConfigmapsEqual compares a current CA bundle configmap with a desired
+// one.  Returns true if the configmaps should be considered equal for the
+// purpose of determining whether an update is necessary, false otherwise.
+func caBundleConfigmapsEqual(current, desired *corev1.ConfigMap) bool {
+	for k, v := range desired.Labels {
+		if current.Labels[k] != v {
+			return false
+		}
+	}
+	if current.Annotations[caBundleRevisionsAnnotationKey] != desired.Annotations[caBundleRevisionsAnnotationKey] {
+		return false
+	}
+	return reflect.DeepEqual(current.Data, desired.Data)
 }
@@ -5,7 +5,9 @@ import (
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	configv1 "github.com/openshift/api/config/v1"
 	operatorv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/cluster-dns-operator/pkg/manifests"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -19,8 +21,6 @@ func TestDesiredCABundleConfigmap(t *testing.T) {
 		Data: map[string]string{"caBundle": "test-bundle"},
 	}
 
-	destName := CABundleConfigMapName(sourceConfigmap.Name)
-
 	dns := &operatorv1.DNS{
 		ObjectMeta: metav1.ObjectMeta{
 			Name: DefaultDNSController,
@@ -28,6 +28,8 @@ func TestDesiredCABundleConfigmap(t *testing.T) {
 		Spec: operatorv1.DNSSpec{},
 	}
 
+	destName := CABundleConfigMapName(dns, sourceConfigmap.Name)
+
 	desired, cm, err := desiredCABundleConfigMap(dns, true, &sourceConfigmap, destName)
 	if err != nil || desired == false {
 		t.Errorf("unexpected error : %v", err)
@@ -35,6 +37,10 @@ func TestDesiredCABundleConfigmap(t *testing.T) {
 		t.Errorf("unexpected CA Bundle ConfigMap data;\n%s", diff)
 	} else if diff := cmp.Diff(cm.OwnerReferences, []metav1.OwnerReference{dnsOwnerRef(dns)}); diff != "" {
 		t.Errorf("unexpected CA Bundle ConfigMap OwnerReference;\n%s", diff)
+	} else if owner := cm.Labels[manifests.OwningDNSLabel]; owner != DNSDaemonSetLabel(dns) {
+		t.Errorf("expected CA Bundle ConfigMap to have owning-dns label %q, got %q", DNSDaemonSetLabel(dns), owner)
+	} else if len(cmLabels) != 1 {
+		t.Errorf("expected desiredCABundleConfigMap not to modify cmLabels, got %v", cmLabels)
 	}
 
 	desired, cm, err = desiredCABundleConfigMap(dns, false, &sourceConfigmap, destName)
@@ -53,3 +59,218 @@ func TestDesiredCABundleConfigmap(t *testing.T) {
 		t.Errorf("expected return values of false, nil, nil when dns.DeletionTimestamp is not nil: %v", err)
 	}
 }
+
+// TestCABundleConfigMapName verifies that DNSes that refer to the same CA
+// bundle configmap get distinct copies.
+func TestCABundleConfigMapName(t *testing.T) {
+	dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+	other := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: "other"}}
+	name := CABundleConfigMapName(dns, "cacerts")
+	otherName := CABundleConfigMapName(other, "cacerts")
+	if name == otherName {
+		t.Errorf("expected distinct copies for dnses %s and %s, got %s for both", dns.Name, other.Name, name)
+	}
+	if name.Name != "ca-default-cacerts" || otherName.Name != "ca-other-cacerts" {
+		t.Errorf("expected ca-default-cacerts and ca-other-cacerts, got %s and %s", name.Name, otherName.Name)
+	}
+}
+
+func TestOrphanedCABundleConfigMaps(t *testing.T) {
+	dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController, UID: "default-uid"}}
+	other := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: "other", UID: "other-uid"}}
+	cm := func(name string, labels map[string]string, owners ...*operatorv1.DNS) corev1.ConfigMap {
+		cm := corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+			Namespace: DefaultOperandNamespace,
+			Name:      name,
+			Labels:    map[string]string{"dns.operator.openshift.io/ca-bundle": "true"},
+		}}
+		for k, v := range labels {
+			cm.Labels[k] = v
+		}
+		for _, owner := range owners {
+			cm.OwnerReferences = append(cm.OwnerReferences, dnsOwnerRef(owner))
+		}
+		return cm
+	}
+	configmaps := []corev1.ConfigMap{
+		cm("ca-default-referenced", map[string]string{manifests.OwningDNSLabel: "default"}, dns),
+		cm("ca-default-dropped", map[string]string{manifests.OwningDNSLabel: "default"}, dns),
+		cm("ca-default-shared", map[string]string{manifests.OwningDNSLabel: "default"}, dns),
+		cm("ca-other-shared", map[string]string{manifests.OwningDNSLabel: "other"}, other),
+		cm("ca-legacy-owned", nil, dns),
+		cm("ca-legacy-other", nil, other),
+		cm("ca-legacy-unowned", nil),
+	}
+	testCases := []struct {
+		name     string
+		dns      *operatorv1.DNS
+		names    []string
+		expected []string
+	}{
+		{
+			name:     "default dns",
+			dns:      dns,
+			names:    []string{"referenced"},
+			expected: []string{"ca-default-dropped", "ca-default-shared", "ca-legacy-owned", "ca-legacy-unowned"},
+		},
+		{
+			name:     "other dns",
+			dns:      other,
+			names:    []string{"shared"},
+			expected: []string{"ca-legacy-other"},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var actual []string
+			for _, cm := range orphanedCABundleConfigMaps(tc.dns, configmaps, tc.names) {
+				actual = append(actual, cm.Name)
+			}
+			if diff := cmp.Diff(tc.expected, actual); diff != "" {
+				t.Errorf("unexpected orphans (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestCABundleRotation verifies that rotating a CA bundle keeps the previous
+// revision in the copied configmap, changes the revision that the Corefile
+// refers to only after the propagation delay, and does not change the
+// daemonset.
+func TestCABundleRotation(t *testing.T) {
+	dns := &operatorv1.DNS{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController},
+		Spec: operatorv1.DNSSpec{
+			UpstreamResolvers: operatorv1.UpstreamResolvers{
+				Upstreams: []operatorv1.Upstream{{Type: operatorv1.NetworkResolverType, Address: "1.1.1.1", Port: 853}},
+				TransportConfig: operatorv1.DNSTransportConfig{
+					Transport: operatorv1.TLSTransport,
+					TLS: &operatorv1.DNSOverTLSConfig{
+						ServerName: "example.com",
+						CABundle:   configv1.ConfigMapNameReference{Name: "cacerts"},
+					},
+				},
+			},
+		},
+	}
+	destName := CABundleConfigMapName(dns, "cacerts")
+	copyBundle := func(bundle string) *corev1.ConfigMap {
+		source := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "cacerts", Namespace: GlobalUserSpecifiedConfigNamespace},
+			Data:       map[string]string{caBundleFileName: bundle},
+		}
+		_, cm, err := desiredCABundleConfigMap(dns, true, source, destName)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return cm
+	}
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	first := copyBundle("first")
+	if requeue := mergeCABundleRevisions(nil, first, now); requeue != 0 {
+		t.Errorf("expected no requeue for the first copy, got %s", requeue)
+	}
+	firstRevision, ok := copiedCABundleRevision(first, now)
+	if !ok {
+		t.Fatalf("expected the first copy to be ready for the Corefile, got %v", first.Data)
+	}
+
+	// The Corefile keeps referring to the first revision until the
+	// kubelet has had time to write the second one.
+	now = now.Add(time.Hour)
+	second := copyBundle("second")
+	if requeue := mergeCABundleRevisions(first, second, now); requeue != caBundleRevisionPropagationDelay {
+		t.Errorf("expected requeue after %s, got %s", caBundleRevisionPropagationDelay, requeue)
+	}
+	if revision, _ := copiedCABundleRevision(second, now); revision != firstRevision {
+		t.Errorf("expected the Corefile to keep referring to revision %q, got %q", firstRevision, revision)
+	}
+	secondRevision := caBundleRevision("second")
+	if firstRevision == secondRevision {
+		t.Fatalf("expected rotation to change the revision %q", firstRevision)
+	}
+	expected := map[string]string{
+		caBundleFileName:                         "second",
+		caBundleRevisionFileName(firstRevision):  "first",
+		caBundleRevisionFileName(secondRevision): "second",
+	}
+	if diff := cmp.Diff(expected, second.Data); diff != "" {
+		t.Errorf("unexpected data after first rotation (-want +got):\n%s", diff)
+	}
+
+	// After the delay, the Corefile refers to the second revision, and
+	// the first is kept until the kubelet has had time to update the
+	// Corefile volume too.
+	now = now.Add(caBundleRevisionPropagationDelay)
+	if revision, _ := copiedCABundleRevision(second, now); revision != secondRevision {
+		t.Errorf("expected the Corefile to refer to revision %q, got %q", secondRevision, revision)
+	}
+	merged := copyBundle("second")
+	if requeue := mergeCABundleRevisions(second, merged, now); requeue != caBundleRevisionPropagationDelay {
+		t.Errorf("expected requeue after %s, got %s", caBundleRevisionPropagationDelay, requeue)
+	}
+	if diff := cmp.Diff(expected, merged.Data); diff != "" {
+		t.Errorf("unexpected data while the Corefile is updated (-want +got):\n%s", diff)
+	}
+	now = now.Add(caBundleRevisionPropagationDelay)
+	merged = copyBundle("second")
+	if requeue := mergeCABundleRevisions(second, merged, now); requeue != 0 {
+		t.Errorf("expected no requeue, got %s", requeue)
+	}
+	if _, ok := merged.Data[caBundleRevisionFileName(firstRevision)]; ok {
+		t.Errorf("expected revision %q to be dropped, got %v", firstRevision, merged.Data)
+	}
+
+	// Rotating twice in quick succession keeps every revision that the
+	// Corefile may refer to.
+	third := copyBundle("third")
+	mergeCABundleRevisions(merged, third, now)
+	fourth := copyBundle("fourth")
+	mergeCABundleRevisions(third, fourth, now.Add(time.Minute))
+	if revision, _ := copiedCABundleRevision(fourth, now.Add(time.Minute)); revision != secondRevision {
+		t.Errorf("expected the Corefile to keep referring to revision %q, got %q", secondRevision, revision)
+	}
+	for _, bundle := range []string{"second", "third", "fourth"} {
+		if _, ok := fourth.Data[caBundleRevisionFileName(caBundleRevision(bundle))]; !ok {
+			t.Errorf("expected the revision of %q to be kept, got %v", bundle, fourth.Data)
+		}
+	}
+
+	// A configmap that was copied before the operator recorded revision
+	// times keeps the revision that the Corefile refers to.
+	legacy := copyBundle("first")
+	if revision, ok := copiedCABundleRevision(legacy, now); !ok || revision != firstRevision {
+		t.Errorf("expected the Corefile to refer to revision %q of a legacy copy, got %q", firstRevision, revision)
+	}
+
+	firstCM, err := desiredDNSConfigMap(dns, "cluster.local", map[string]string{"cacerts": firstRevision}, false, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	secondCM, err := desiredDNSConfigMap(dns, "cluster.local", map[string]string{"cacerts": secondRevision}, false, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if changed, _ := corefileChanged(firstCM, secondCM); !changed {
+		t.Errorf("expected rotation to change the Corefile")
+	}
+	firstDS, err := desiredDNSDaemonSet(dns, "coredns", "kube-rbac-proxy", map[string]string{"cacerts": firstRevision}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	secondDS, err := desiredDNSDaemonSet(dns, "coredns", "kube-rbac-proxy", map[string]string{"cacerts": secondRevision}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(firstDS.Spec.Template, secondDS.Spec.Template); diff != "" {
+		t.Errorf("expected rotation not to change the pod template (-first +second):\n%s", diff)
+	}
+	noneDS, err := desiredDNSDaemonSet(dns, "coredns", "kube-rbac-proxy", map[string]string{}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cmp.Equal(firstDS.Spec.Template, noneDS.Spec.Template) {
+		t.Errorf("expected adding a CA bundle to change the pod template")
+	}
+}
@@ -0,0 +1,173 @@
+package controller
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// CABundleExpiringConditionType is the type of the DNS status
+	// condition that indicates whether a CA bundle that the DNS uses is
+	// invalid, has expired, or expires within caBundleExpiryWarningPeriod.
+	// It is only reported for a DNS that uses CA bundles.
+	CABundleExpiringConditionType = "CABundleExpiring"
+
+	// caBundleExpiryWarningPeriod is how long before a CA bundle expires
+	// the operator starts warning about it.  The CoreDNSCABundleExpiring
+	// alert uses the same period.
+	caBundleExpiryWarningPeriod = 30 * 24 * time.Hour
+)
+
+// caBundleExpiryTimestamp reports the time at which the earliest-expiring
+// certificate in each CA bundle that a DNS uses expires.
+var caBundleExpiryTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "dns_operator_ca_bundle_expiry_timestamp_seconds",
+	Help: "Time at which the earliest-expiring certificate in the CA bundle expires, in seconds since the epoch.",
+}, []string{"dns", "configmap"})
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(caBundleExpiryTimestamp)
+}
+
+// caBundleExpiry describes the expiry of a CA bundle.
+type caBundleExpiry struct {
+	// Name is the name of the CA bundle configmap in openshift-config.
+	Name string
+	// NotAfter is when the earliest-expiring certificate in the CA bundle
+	// expires.
+	NotAfter time.Time
+	// Err is the reason why the CA bundle is invalid, if it is invalid.
+	Err error
+}
+
+// parseCABundle parses the given PEM-encoded CA bundle and returns the
+// certificates in it.  It returns an error if the bundle contains anything
+// other than certificates or contains no certificates.
+func parseCABundle(data []byte) ([]*x509.Certificate, error) {
+	var certs []*x509.Certificate
+	rest := data
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("unexpected PEM block of type %q", block.Type)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse certificate %d: %w", len(certs)+1, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(strings.TrimSpace(string(rest))) != 0 {
+		return nil, fmt.Errorf("unexpected data after certificate %d", len(certs))
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found")
+	}
+	return certs, nil
+}
+
+// caBundleExpiryForConfigMapData returns the expiry of the CA bundle in the
+// given configmap data from the configmap with the given name.
+func caBundleExpiryForConfigMapData(name string, data map[string]string) caBundleExpiry {
+	expiry := caBundleExpiry{Name: name}
+	bundle, ok := data[caBundleFileName]
+	if !ok {
+		expiry.Err = fmt.Errorf("ca bundle configmap %s has no %s key", name, caBundleFileName)
+		return expiry
+	}
+	certs, err := parseCABundle([]byte(bundle))
+	if err != nil {
+		expiry.Err = fmt.Errorf("ca bundle configmap %s has an invalid %s: %w", name, caBundleFileName, err)
+		return expiry
+	}
+	for _, cert := range certs {
+		if expiry.NotAfter.IsZero() || cert.NotAfter.Before(expiry.NotAfter) {
+			expiry.NotAfter = cert.NotAfter
+		}
+	}
+	return expiry
+}
+
+// caBundleExpiries returns the expiries of the CA bundles that the given DNS
+// uses, sorted by name.  CA bundle configmaps that do not exist are omitted.
+func (r *reconciler) caBundleExpiries(dns *operatorv1.DNS) ([]caBundleExpiry, error) {
+	var expiries []caBundleExpiry
+	for _, name := range caBundleConfigMapNames(dns) {
+		haveSource, source, err := r.currentCABundleConfigMap(types.NamespacedName{Namespace: GlobalUserSpecifiedConfigNamespace, Name: name})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get source ca bundle configmap %s: %w", name, err)
+		}
+		if haveSource {
+			expiries = append(expiries, caBundleExpiryForConfigMapData(name, source.Data))
+		}
+	}
+	return expiries, nil
+}
+
+// setCABundleExpiryMetrics publishes the given CA bundle expiries for the given
+// DNS and removes the metrics of CA bundles that the DNS no longer uses.
+func setCABundleExpiryMetrics(dns *operatorv1.DNS, expiries []caBundleExpiry) {
+	caBundleExpiryTimestamp.DeletePartialMatch(prometheus.Labels{"dns": dns.Name})
+	for _, expiry := range expiries {
+		if expiry.Err == nil {
+			caBundleExpiryTimestamp.WithLabelValues(dns.Name, expiry.Name).Set(float64(expiry.NotAfter.Unix()))
+		}
+	}
+}
+
+// computeCABundleExpiringCondition computes the CABundleExpiring status
+// condition from the given CA bundle expiries at the given time.
+func computeCABundleExpiringCondition(oldCondition *operatorv1.OperatorCondition, expiries []caBundleExpiry, currentTime time.Time) operatorv1.OperatorCondition {
+	condition := &operatorv1.OperatorCondition{
+		Type: CABundleExpiringConditionType,
+	}
+	var invalid, expired, expiring []string
+	for _, expiry := range expiries {
+		switch {
+		case expiry.Err != nil:
+			invalid = append(invalid, expiry.Err.Error())
+		case !currentTime.Before(expiry.NotAfter):
+			expired = append(expired, fmt.Sprintf("%s expired at %s", expiry.Name, expiry.NotAfter.UTC().Format(time.RFC3339)))
+		case expiry.NotAfter.Sub(currentTime) < caBundleExpiryWarningPeriod:
+			expiring = append(expiring, fmt.Sprintf("%s expires at %s", expiry.Name, expiry.NotAfter.UTC().Format(time.RFC3339)))
+		}
+	}
+	sort.Strings(invalid)
+	sort.Strings(expired)
+	sort.Strings(expiring)
+	switch {
+	case len(invalid) != 0:
+		condition.Status = operatorv1.ConditionTrue
+		condition.Reason = "InvalidCABundle"
+		condition.Message = fmt.Sprintf("Invalid CA bundles are not copied to %s: %s.", DefaultOperandNamespace, strings.Join(invalid, "; "))
+	case len(expired) != 0:
+		condition.Status = operatorv1.ConditionTrue
+		condition.Reason = "CertificatesExpired"
+		condition.Message = fmt.Sprintf("CA bundles have expired certificates: %s.", strings.Join(append(expired, expiring...), "; "))
+	case len(expiring) != 0:
+		condition.Status = operatorv1.ConditionTrue
+		condition.Reason = "CertificatesExpiring"
+		condition.Message = fmt.Sprintf("CA bundles have certificates that expire within %d days: %s.", int(caBundleExpiryWarningPeriod.Hours()/24), strings.Join(expiring, "; "))
+	default:
+		condition.Status = operatorv1.ConditionFalse
+		condition.Reason = "AsExpected"
+		condition.Message = "No CA bundle certificates expire soon."
+	}
+	return setDNSLastTransitionTime(condition, oldCondition)
+}
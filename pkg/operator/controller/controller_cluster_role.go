@@ -20,7 +20,7 @@ func (r *reconciler) ensureDNSClusterRole() (bool, *rbacv1.ClusterRole, error) {
 	if err != nil {
 		return false, nil, err
 	}
-	desired := desiredDNSClusterRole(r.dnsNameResolverEnabled)
+	desired := desiredDNSClusterRole()
 
 	switch {
 	case !haveCR:
@@ -51,25 +51,11 @@ func (r *reconciler) currentDNSClusterRole() (bool, *rbacv1.ClusterRole, error)
 	return true, current, nil
 }
 
-func desiredDNSClusterRole(dnsNameResolverEnabled bool) *rbacv1.ClusterRole {
-	cr := manifests.DNSClusterRole()
-	if dnsNameResolverEnabled {
-		addDNSNameResolverPolicyRule(cr)
-	}
-	return cr
-}
-
-func addDNSNameResolverPolicyRule(cr *rbacv1.ClusterRole) {
-	cr.Rules = append(cr.Rules, rbacv1.PolicyRule{
-		APIGroups: []string{"network.openshift.io"},
-		Resources: []string{"dnsnameresolvers"},
-		Verbs:     []string{"get", "list", "watch"},
-	})
-	cr.Rules = append(cr.Rules, rbacv1.PolicyRule{
-		APIGroups: []string{"network.openshift.io"},
-		Resources: []string{"dnsnameresolvers/status"},
-		Verbs:     []string{"get", "update", "patch"},
-	})
+// desiredDNSClusterRole returns the desired cluster role for CoreDNS.  Access
+// to DNSNameResolver resources is granted per namespace by
+// ensureDNSNameResolverRBAC rather than by the cluster role.
+func desiredDNSClusterRole() *rbacv1.ClusterRole {
+	return manifests.DNSClusterRole()
 }
 
 func (r *reconciler) updateDNSClusterRole(current, desired *rbacv1.ClusterRole) (bool, error) {
@@ -0,0 +1,829 @@
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	configv1 "github.com/openshift/api/config/v1"
+	operatorv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/cluster-dns-operator/pkg/manifests"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+
+	"github.com/sirupsen/logrus"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/rest"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// corefileCanaryAnnotationKey is the annotation on a DNS that
+	// configures canary rollout of Corefile changes.  The value is a JSON
+	// object with the following fields:
+	//
+	//   - "enabled" is a Boolean value that indicates whether Corefile
+	//     changes are tried on canary nodes before they are rolled out to
+	//     all nodes.
+	//   - "nodeSelector" is the set of node labels that select canary
+	//     nodes.  The default selects nodes with the
+	//     dns.operator.openshift.io/corefile-canary label.
+	//   - "soakPeriod" is the duration, for example "10m", for which a
+	//     Corefile change must run on canary nodes without errors before
+	//     it is promoted.  The default is 10 minutes.
+	//   - "maxServerFailureRatio" is the highest ratio of SERVFAIL
+	//     responses to all responses from canary pods during the soak
+	//     period that the operator tolerates.  The default is 0.05.
+	//   - "maxEmptySoakPeriods" is the number of consecutive soak periods
+	//     without any canary pods after which the operator gives up
+	//     waiting for canary pods.  The default is 3.
+	//   - "noCanaryPodsPolicy" is what the operator does with a Corefile
+	//     change once it gives up waiting for canary pods: "RollBack" (the
+	//     default) rejects the change as though it had failed on canary
+	//     nodes, and "Promote" rolls it out to all nodes untested.
+	//
+	// While canary rollout is enabled, the DNS daemonset does not run on
+	// canary nodes.  Instead, a second daemonset runs CoreDNS on canary
+	// nodes and mounts a second configmap.  The operator writes a changed
+	// Corefile to the second configmap first and copies it to the DNS
+	// configmap only after the soak period has passed.  If a canary pod
+	// becomes unready or restarts, if CoreDNS fails to reload the
+	// Corefile, or if canary pods answer too many queries with SERVFAIL,
+	// the operator restores the current Corefile on canary nodes and does
+	// not try the rejected Corefile again.
+	corefileCanaryAnnotationKey = "dns.operator.openshift.io/corefile-canary"
+
+	// defaultCorefileCanaryNodeLabel is the node label that selects canary
+	// nodes if the corefile canary configuration does not specify a node
+	// selector.
+	defaultCorefileCanaryNodeLabel = "dns.operator.openshift.io/corefile-canary"
+
+	// defaultCorefileCanarySoakPeriod is the default soak period for
+	// Corefile changes on canary nodes.
+	defaultCorefileCanarySoakPeriod = 10 * time.Minute
+
+	// defaultCorefileCanaryMaxServerFailureRatio is the default highest
+	// tolerated ratio of SERVFAIL responses to all responses.
+	defaultCorefileCanaryMaxServerFailureRatio = 0.05
+
+	// defaultCorefileCanaryMaxEmptySoakPeriods is the default number of
+	// consecutive soak periods without canary pods after which the
+	// operator applies the no-canary-pods policy.
+	defaultCorefileCanaryMaxEmptySoakPeriods = 3
+
+	// corefileCanaryNoPodsPolicyRollBack rejects a Corefile change that
+	// could not be tried because there were no canary pods.
+	corefileCanaryNoPodsPolicyRollBack = "RollBack"
+	// corefileCanaryNoPodsPolicyPromote promotes a Corefile change that
+	// could not be tried because there were no canary pods.
+	corefileCanaryNoPodsPolicyPromote = "Promote"
+
+	// corefileCanaryMinResponses is the number of responses that canary
+	// pods must send during the soak period before the operator evaluates
+	// the ratio of SERVFAIL responses.  Fewer responses are too few to
+	// tell a broken Corefile from an unlucky upstream.
+	corefileCanaryMinResponses = 100
+
+	// corefileCanaryScrapeConcurrency is the maximum number of canary
+	// pods whose metrics the operator scrapes at the same time.
+	corefileCanaryScrapeConcurrency = 10
+	// corefileCanaryScrapeTimeout is how long the operator waits for the
+	// metrics of a single canary pod.
+	corefileCanaryScrapeTimeout = 5 * time.Second
+	// corefileCanarySampleTimeout is how long the operator waits for the
+	// metrics of all canary pods so that unresponsive pods cannot hold up
+	// the reconcile.
+	corefileCanarySampleTimeout = 15 * time.Second
+
+	// corefileCanaryPhaseAnnotationKey is the annotation on the canary
+	// configmap that records the phase of the canary rollout.
+	corefileCanaryPhaseAnnotationKey = "dns.operator.openshift.io/canary-phase"
+	// corefileCanaryMessageAnnotationKey is the annotation on the canary
+	// configmap that describes the phase of the canary rollout.
+	corefileCanaryMessageAnnotationKey = "dns.operator.openshift.io/canary-message"
+	// corefileCanaryCandidateAnnotationKey is the annotation on the
+	// canary configmap that records the hash of the Corefile that is
+	// soaking or that was rolled back.
+	corefileCanaryCandidateAnnotationKey = "dns.operator.openshift.io/canary-candidate"
+	// corefileCanaryStartedAnnotationKey is the annotation on the canary
+	// configmap that records when the soak period started.
+	corefileCanaryStartedAnnotationKey = "dns.operator.openshift.io/canary-started"
+	// corefileCanaryBaselineAnnotationKey is the annotation on the canary
+	// configmap that records the restart counts and metrics of the canary
+	// pods when the soak period started.
+	corefileCanaryBaselineAnnotationKey = "dns.operator.openshift.io/canary-baseline"
+	// corefileCanaryEmptySoakPeriodsAnnotationKey is the annotation on the
+	// canary configmap that records the number of consecutive soak
+	// periods that passed without any canary pods.
+	corefileCanaryEmptySoakPeriodsAnnotationKey = "dns.operator.openshift.io/canary-empty-soak-periods"
+
+	// corefileCanaryPhaseSoaking indicates that a Corefile change is
+	// running on canary nodes.
+	corefileCanaryPhaseSoaking = "Soaking"
+	// corefileCanaryPhaseRolledBack indicates that a Corefile change
+	// failed on canary nodes and was rolled back.
+	corefileCanaryPhaseRolledBack = "RolledBack"
+
+	// serviceCABundleConfigMapName is the name of the configmap that
+	// OpenShift injects into every namespace with the service CA bundle.
+	serviceCABundleConfigMapName = "openshift-service-ca.crt"
+	// serviceCABundleConfigMapKey is the key for the service CA bundle in
+	// the service CA bundle configmap.
+	serviceCABundleConfigMapKey = "service-ca.crt"
+)
+
+// corefileCanaryConfig is the corefile canary configuration of a DNS.
+type corefileCanaryConfig struct {
+	// Enabled indicates whether Corefile changes are tried on canary
+	// nodes first.
+	Enabled bool `json:"enabled"`
+	// NodeSelector is the set of node labels that select canary nodes.
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// SoakPeriod is the duration for which a Corefile change must run on
+	// canary nodes before it is promoted.
+	SoakPeriod string `json:"soakPeriod,omitempty"`
+	// MaxServerFailureRatio is the highest tolerated ratio of SERVFAIL
+	// responses to all responses.
+	MaxServerFailureRatio *float64 `json:"maxServerFailureRatio,omitempty"`
+	// MaxEmptySoakPeriods is the number of consecutive soak periods
+	// without canary pods after which NoCanaryPodsPolicy applies.
+	MaxEmptySoakPeriods *int `json:"maxEmptySoakPeriods,omitempty"`
+	// NoCanaryPodsPolicy is what happens to a Corefile change that could
+	// not be tried because there were no canary pods.
+	NoCanaryPodsPolicy string `json:"noCanaryPodsPolicy,omitempty"`
+
+	// soakPeriod is the parsed value of SoakPeriod.
+	soakPeriod time.Duration
+}
+
+// corefileCanaryStatus is the state of a canary rollout that the operator
+// reports in the DNS's Progressing status condition.
+type corefileCanaryStatus struct {
+	// Phase is the phase of the canary rollout, or empty if no Corefile
+	// change is soaking or rolled back.
+	Phase string
+	// Message describes the phase.
+	Message string
+}
+
+// corefileCanarySample is a sample of a canary pod's restart count and
+// CoreDNS metrics.
+type corefileCanarySample struct {
+	// Restarts is the sum of the restart counts of the pod's containers.
+	Restarts int32 `json:"restarts"`
+	// Metrics are the pod's CoreDNS metrics, or nil if they could not be
+	// scraped.
+	Metrics *corefileCanaryMetrics `json:"metrics,omitempty"`
+}
+
+// corefileCanaryMetrics are the CoreDNS metrics that the operator uses to
+// judge a Corefile change and to recommend resource requests.
+type corefileCanaryMetrics struct {
+	// ReloadFailures is the value of coredns_reload_failed_total.
+	ReloadFailures float64 `json:"reloadFailures"`
+	// Responses is the sum of coredns_dns_responses_total.
+	Responses float64 `json:"responses"`
+	// ServerFailures is the sum of coredns_dns_responses_total with the
+	// SERVFAIL rcode.
+	ServerFailures float64 `json:"serverFailures"`
+	// Requests is the sum of coredns_dns_requests_total.
+	Requests float64 `json:"requests,omitempty"`
+	// ResidentMemoryBytes is the value of process_resident_memory_bytes.
+	ResidentMemoryBytes float64 `json:"residentMemoryBytes,omitempty"`
+}
+
+// corefileCanaryMetricsScraper scrapes the CoreDNS metrics of canary pods.
+type corefileCanaryMetricsScraper interface {
+	// scrape returns the CoreDNS metrics of the given pod.  The scrape is
+	// abandoned when the given context is done.
+	scrape(ctx context.Context, dns *operatorv1.DNS, pod *corev1.Pod) (*corefileCanaryMetrics, error)
+}
+
+// corefileCanaryConfigForDNS parses and validates the corefile canary
+// configuration of the given DNS and fills in defaults.
+func corefileCanaryConfigForDNS(dns *operatorv1.DNS) (corefileCanaryConfig, error) {
+	config := corefileCanaryConfig{}
+	value, ok := dns.Annotations[corefileCanaryAnnotationKey]
+	if !ok || len(strings.TrimSpace(value)) == 0 {
+		return config, nil
+	}
+	if err := json.Unmarshal([]byte(value), &config); err != nil {
+		return corefileCanaryConfig{}, fmt.Errorf("failed to parse annotation %s: %w", corefileCanaryAnnotationKey, err)
+	}
+	if len(config.NodeSelector) == 0 {
+		config.NodeSelector = map[string]string{defaultCorefileCanaryNodeLabel: ""}
+	}
+	for k, v := range config.NodeSelector {
+		if errs := validation.IsQualifiedName(k); len(errs) != 0 {
+			return corefileCanaryConfig{}, fmt.Errorf("invalid annotation %s: invalid node selector key %q: %s", corefileCanaryAnnotationKey, k, strings.Join(errs, ", "))
+		}
+		if errs := validation.IsValidLabelValue(v); len(errs) != 0 {
+			return corefileCanaryConfig{}, fmt.Errorf("invalid annotation %s: invalid node selector value %q: %s", corefileCanaryAnnotationKey, v, strings.Join(errs, ", "))
+		}
+	}
+	config.soakPeriod = defaultCorefileCanarySoakPeriod
+	if len(config.SoakPeriod) != 0 {
+		d, err := time.ParseDuration(config.SoakPeriod)
+		if err != nil {
+			return corefileCanaryConfig{}, fmt.Errorf("invalid annotation %s: invalid soak period %q: %w", corefileCanaryAnnotationKey, config.SoakPeriod, err)
+		}
+		if d <= 0 {
+			return corefileCanaryConfig{}, fmt.Errorf("invalid annotation %s: soak period %q must be positive", corefileCanaryAnnotationKey, config.SoakPeriod)
+		}
+		config.soakPeriod = d
+	}
+	if config.MaxServerFailureRatio == nil {
+		ratio := defaultCorefileCanaryMaxServerFailureRatio
+		config.MaxServerFailureRatio = &ratio
+	} else if ratio := *config.MaxServerFailureRatio; ratio < 0 || ratio > 1 {
+		return corefileCanaryConfig{}, fmt.Errorf("invalid annotation %s: maxServerFailureRatio %v must be between 0 and 1", corefileCanaryAnnotationKey, ratio)
+	}
+	if config.MaxEmptySoakPeriods == nil {
+		n := defaultCorefileCanaryMaxEmptySoakPeriods
+		config.MaxEmptySoakPeriods = &n
+	} else if n := *config.MaxEmptySoakPeriods; n < 1 {
+		return corefileCanaryConfig{}, fmt.Errorf("invalid annotation %s: maxEmptySoakPeriods %d must be positive", corefileCanaryAnnotationKey, n)
+	}
+	switch config.NoCanaryPodsPolicy {
+	case "":
+		config.NoCanaryPodsPolicy = corefileCanaryNoPodsPolicyRollBack
+	case corefileCanaryNoPodsPolicyRollBack, corefileCanaryNoPodsPolicyPromote:
+	default:
+		return corefileCanaryConfig{}, fmt.Errorf("invalid annotation %s: unknown noCanaryPodsPolicy %q", corefileCanaryAnnotationKey, config.NoCanaryPodsPolicy)
+	}
+	return config, nil
+}
+
+// desiredCorefileCanaryDaemonSet returns the desired daemonset for corefile
+// canary pods.  The daemonset is the same as the DNS daemonset except that it
+// runs only on canary nodes and mounts the canary configmap.
+func desiredCorefileCanaryDaemonSet(dns *operatorv1.DNS, config corefileCanaryConfig, coreDNSImage, kubeRBACProxyImage string, caBundleRevisionMap map[string]string, tlsSecurityProfile *configv1.TLSSecurityProfile) (*appsv1.DaemonSet, error) {
+	daemonset, err := desiredDNSDaemonSet(dns, coreDNSImage, kubeRBACProxyImage, caBundleRevisionMap, tlsSecurityProfile)
+	if err != nil {
+		return nil, err
+	}
+	name := CorefileCanaryDaemonSetName(dns)
+	daemonset.Name = name.Name
+	daemonset.Namespace = name.Namespace
+	daemonset.Spec.Selector = CorefileCanaryDaemonSetPodSelector(dns)
+	daemonset.Spec.Template.Labels = dnsPodLabels(dns, daemonset.Spec.Selector)
+
+	// Leave the nodes of node pools to their daemonsets.
+	pools, err := dnsNodePoolsForDNS(dns)
+	if err != nil {
+		return nil, err
+	}
+	excluded := make([]map[string]string, 0, len(pools))
+	for _, pool := range pools {
+		excluded = append(excluded, pool.NodeSelector)
+	}
+	daemonset.Spec.Template.Spec.Affinity = nodeAffinityExcluding(excluded)
+
+	nodeSelector := map[string]string{}
+	for k, v := range nodeSelectorForDNS(dns) {
+		nodeSelector[k] = v
+	}
+	for k, v := range config.NodeSelector {
+		nodeSelector[k] = v
+	}
+	daemonset.Spec.Template.Spec.NodeSelector = nodeSelector
+
+	for i := range daemonset.Spec.Template.Spec.Volumes {
+		if daemonset.Spec.Template.Spec.Volumes[i].Name == "config-volume" {
+			daemonset.Spec.Template.Spec.Volumes[i].ConfigMap.Name = CorefileCanaryConfigMapName(dns).Name
+		}
+	}
+	return daemonset, nil
+}
+
+// ensureCorefileCanaryDaemonSet ensures that the corefile canary daemonset
+// exists for the given DNS.
+func (r *reconciler) ensureCorefileCanaryDaemonSet(dns *operatorv1.DNS, config corefileCanaryConfig, caBundleRevisionMap map[string]string, tlsSecurityProfile *configv1.TLSSecurityProfile) error {
+	desired, err := desiredCorefileCanaryDaemonSet(dns, config, r.CoreDNSImage, r.KubeRBACProxyImage, caBundleRevisionMap, tlsSecurityProfile)
+	if err != nil {
+		return fmt.Errorf("failed to build corefile canary daemonset: %w", err)
+	}
+	current := &appsv1.DaemonSet{}
+	if err := r.client.Get(context.TODO(), CorefileCanaryDaemonSetName(dns), current); err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to get corefile canary daemonset: %w", err)
+		}
+		setDesiredStateHash(desired, desiredStateHash(desired.Spec))
+		return r.createDNSDaemonSet(desired)
+	}
+	_, err = r.updateDNSDaemonSet(dns, current, desired)
+	return err
+}
+
+// ensureCorefileCanaryDeleted ensures that the corefile canary daemonset and
+// configmap for the given DNS do not exist.
+func (r *reconciler) ensureCorefileCanaryDeleted(dns *operatorv1.DNS) error {
+	if err := r.deleteCachedObject("corefile canary daemonset", CorefileCanaryDaemonSetName(dns), &appsv1.DaemonSet{}); err != nil {
+		return err
+	}
+	return r.deleteCachedObject("corefile canary configmap", CorefileCanaryConfigMapName(dns), &corev1.ConfigMap{})
+}
+
+// currentCorefileCanaryConfigMap returns the current corefile canary
+// configmap for the given DNS.
+func (r *reconciler) currentCorefileCanaryConfigMap(dns *operatorv1.DNS) (bool, *corev1.ConfigMap, error) {
+	cm := &corev1.ConfigMap{}
+	if err := r.client.Get(context.TODO(), CorefileCanaryConfigMapName(dns), cm); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil, nil
+		}
+		return false, nil, err
+	}
+	return true, cm, nil
+}
+
+// ensureCorefileCanaryConfigMap rolls out the desired Corefile for the given
+// DNS through the corefile canary configmap.  If the desired Corefile differs
+// from the one in the DNS configmap, it is written to the canary configmap,
+// soaks on canary nodes, and is then either copied to the DNS configmap or
+// rolled back.  Returns the duration after which the soaking Corefile should
+// be evaluated again, or zero if no Corefile is soaking.
+func (r *reconciler) ensureCorefileCanaryConfigMap(dns *operatorv1.DNS, config corefileCanaryConfig, clusterDomain string, caBundleRevisionMap map[string]string, dnsNameResolverNamespaces []string) (time.Duration, error) {
+	haveCM, current, err := r.currentDNSConfigMap(dns)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get configmap: %v", err)
+	}
+	if !haveCM {
+		// Nothing is using the Corefile yet, so there is nothing to
+		// protect from a bad change.
+		_, _, err := r.ensureDNSConfigMap(dns, clusterDomain, caBundleRevisionMap, dnsNameResolverNamespaces)
+		return 0, err
+	}
+	desired, err := desiredDNSConfigMap(dns, clusterDomain, caBundleRevisionMap, r.dnsNameResolverEnabled, dnsNameResolverNamespaces)
+	if err != nil {
+		return 0, fmt.Errorf("failed to build configmap: %v", err)
+	}
+
+	haveCanary, canary, err := r.currentCorefileCanaryConfigMap(dns)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get corefile canary configmap: %w", err)
+	}
+	if !haveCanary {
+		canary = desiredCorefileCanaryConfigMap(dns, current.Data)
+		if err := r.client.Create(context.TODO(), canary); err != nil {
+			return 0, fmt.Errorf("failed to create corefile canary configmap: %w", err)
+		}
+		logrus.Infof("created corefile canary configmap: %s/%s", canary.Namespace, canary.Name)
+	}
+
+	candidate := desiredStateHash(desired.Data)
+	switch {
+	case cmp.Equal(current.Data, desired.Data, cmpopts.EquateEmpty()):
+		// The change was reverted or promoted; canary nodes serve the
+		// same Corefile as every other node.
+		return 0, r.updateCorefileCanaryConfigMap(canary, current.Data, nil)
+	case canary.Annotations[corefileCanaryCandidateAnnotationKey] == candidate && canary.Annotations[corefileCanaryPhaseAnnotationKey] == corefileCanaryPhaseRolledBack:
+		// Keep the rolled back Corefile off the canary nodes until
+		// the desired Corefile changes.
+		return 0, r.updateCorefileCanaryConfigMap(canary, current.Data, canary.Annotations)
+	case canary.Annotations[corefileCanaryCandidateAnnotationKey] != candidate || canary.Annotations[corefileCanaryPhaseAnnotationKey] != corefileCanaryPhaseSoaking:
+		samples, err := r.corefileCanarySamples(dns)
+		if err != nil {
+			return 0, err
+		}
+		return config.soakPeriod, r.startCorefileCanarySoak(dns, config, canary, desired.Data, candidate, samples, 0)
+	}
+
+	var baseline map[string]corefileCanarySample
+	if err := json.Unmarshal([]byte(canary.Annotations[corefileCanaryBaselineAnnotationKey]), &baseline); err != nil {
+		logrus.Warningf("ignoring invalid corefile canary baseline on configmap %s/%s: %v", canary.Namespace, canary.Name, err)
+	}
+	started, err := time.Parse(time.RFC3339, canary.Annotations[corefileCanaryStartedAnnotationKey])
+	if err != nil {
+		logrus.Warningf("ignoring invalid corefile canary start time on configmap %s/%s: %v", canary.Namespace, canary.Name, err)
+		started = time.Now()
+	}
+	pods, err := r.corefileCanaryPods(dns)
+	if err != nil {
+		return 0, err
+	}
+	samples := r.sampleCorefileCanaryPods(context.TODO(), dns, pods)
+	if healthy, reason := evaluateCorefileCanary(config, baseline, pods, samples); !healthy {
+		annotations := map[string]string{
+			corefileCanaryPhaseAnnotationKey:     corefileCanaryPhaseRolledBack,
+			corefileCanaryMessageAnnotationKey:   fmt.Sprintf("A Corefile change was rolled back because %s.", reason),
+			corefileCanaryCandidateAnnotationKey: candidate,
+		}
+		if err := r.updateCorefileCanaryConfigMap(canary, current.Data, annotations); err != nil {
+			return 0, err
+		}
+		logrus.Warningf("rolled back corefile change %s on canary nodes for dns %s because %s", candidate, dns.Name, reason)
+		return 0, nil
+	}
+
+	if len(baseline) == 0 && len(samples) != 0 {
+		// There were no canary pods when the soak period started,
+		// so start it over with the pods that exist now.
+		return config.soakPeriod, r.startCorefileCanarySoak(dns, config, canary, desired.Data, candidate, samples, 0)
+	}
+	if elapsed := time.Since(started); elapsed < config.soakPeriod {
+		return config.soakPeriod - elapsed, nil
+	}
+	if len(baseline) == 0 {
+		// A whole soak period passed without canary pods.  Keep
+		// waiting for a bounded number of soak periods so that a
+		// node selector that matches no nodes does not hold the
+		// Corefile change forever.
+		emptySoakPeriods, _ := strconv.Atoi(canary.Annotations[corefileCanaryEmptySoakPeriodsAnnotationKey])
+		emptySoakPeriods++
+		if emptySoakPeriods < *config.MaxEmptySoakPeriods {
+			return config.soakPeriod, r.startCorefileCanarySoak(dns, config, canary, desired.Data, candidate, samples, emptySoakPeriods)
+		}
+		selector := labels.SelectorFromSet(config.NodeSelector)
+		if config.NoCanaryPodsPolicy != corefileCanaryNoPodsPolicyPromote {
+			reason := fmt.Sprintf("no pods ran on canary nodes matching %v for %d soak periods", selector, emptySoakPeriods)
+			annotations := map[string]string{
+				corefileCanaryPhaseAnnotationKey:     corefileCanaryPhaseRolledBack,
+				corefileCanaryMessageAnnotationKey:   fmt.Sprintf("A Corefile change was rolled back because %s.", reason),
+				corefileCanaryCandidateAnnotationKey: candidate,
+			}
+			if err := r.updateCorefileCanaryConfigMap(canary, current.Data, annotations); err != nil {
+				return 0, err
+			}
+			logrus.Warningf("rolled back corefile change %s for dns %s because %s", candidate, dns.Name, reason)
+			return 0, nil
+		}
+		logrus.Warningf("promoting corefile change %s for dns %s without trying it because no pods ran on canary nodes matching %v for %d soak periods", candidate, dns.Name, selector, emptySoakPeriods)
+	}
+
+	if _, err := r.updateDNSConfigMap(dns, current, desired); err != nil {
+		return 0, err
+	}
+	logrus.Infof("promoted corefile change %s from canary nodes for dns %s", candidate, dns.Name)
+	return 0, r.updateCorefileCanaryConfigMap(canary, desired.Data, nil)
+}
+
+// startCorefileCanarySoak writes the given candidate Corefile to the given
+// corefile canary configmap and records the start of the soak period with the
+// given samples of the canary pods as the baseline.  emptySoakPeriods is the
+// number of soak periods of the candidate that already passed without canary
+// pods.
+func (r *reconciler) startCorefileCanarySoak(dns *operatorv1.DNS, config corefileCanaryConfig, canary *corev1.ConfigMap, data map[string]string, candidate string, samples map[string]corefileCanarySample, emptySoakPeriods int) error {
+	baseline, err := json.Marshal(samples)
+	if err != nil {
+		return fmt.Errorf("failed to encode corefile canary baseline: %w", err)
+	}
+	message := fmt.Sprintf("A Corefile change is soaking on %d canary pods for %s.", len(samples), config.soakPeriod)
+	if len(samples) == 0 {
+		message = fmt.Sprintf("A Corefile change is waiting for pods on canary nodes matching %v (soak period %d of %d).", labels.SelectorFromSet(config.NodeSelector), emptySoakPeriods+1, *config.MaxEmptySoakPeriods)
+	}
+	annotations := map[string]string{
+		corefileCanaryPhaseAnnotationKey:     corefileCanaryPhaseSoaking,
+		corefileCanaryMessageAnnotationKey:   message,
+		corefileCanaryCandidateAnnotationKey: candidate,
+		corefileCanaryStartedAnnotationKey:   time.Now().UTC().Format(time.RFC3339),
+		corefileCanaryBaselineAnnotationKey:  string(baseline),
+	}
+	if emptySoakPeriods != 0 {
+		annotations[corefileCanaryEmptySoakPeriodsAnnotationKey] = strconv.Itoa(emptySoakPeriods)
+	}
+	if err := r.updateCorefileCanaryConfigMap(canary, data, annotations); err != nil {
+		return err
+	}
+	logrus.Infof("started soaking corefile change %s on %d canary pods for dns %s", candidate, len(samples), dns.Name)
+	return nil
+}
+
+// desiredCorefileCanaryConfigMap returns the corefile canary configmap with
+// the given data.
+func desiredCorefileCanaryConfigMap(dns *operatorv1.DNS, data map[string]string) *corev1.ConfigMap {
+	name := CorefileCanaryConfigMapName(dns)
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name.Name,
+			Namespace: name.Namespace,
+			Labels: map[string]string{
+				manifests.OwningDNSLabel: DNSDaemonSetLabel(dns),
+			},
+		},
+		Data: map[string]string{},
+	}
+	cm.SetOwnerReferences([]metav1.OwnerReference{dnsOwnerRef(dns)})
+	for k, v := range data {
+		cm.Data[k] = v
+	}
+	return cm
+}
+
+// updateCorefileCanaryConfigMap updates the given corefile canary configmap
+// with the given data and canary state annotations.
+func (r *reconciler) updateCorefileCanaryConfigMap(current *corev1.ConfigMap, data, annotations map[string]string) error {
+	updated := current.DeepCopy()
+	updated.Data = data
+	for _, k := range []string{corefileCanaryPhaseAnnotationKey, corefileCanaryMessageAnnotationKey, corefileCanaryCandidateAnnotationKey, corefileCanaryStartedAnnotationKey, corefileCanaryBaselineAnnotationKey, corefileCanaryEmptySoakPeriodsAnnotationKey} {
+		if v, ok := annotations[k]; ok {
+			if updated.Annotations == nil {
+				updated.Annotations = map[string]string{}
+			}
+			updated.Annotations[k] = v
+		} else {
+			delete(updated.Annotations, k)
+		}
+	}
+	if cmp.Equal(current.Data, updated.Data, cmpopts.EquateEmpty()) && cmp.Equal(current.Annotations, updated.Annotations, cmpopts.EquateEmpty()) {
+		return nil
+	}
+	// Diff before updating because the client may mutate the object.
+	diff := cmp.Diff(current, updated, cmpopts.EquateEmpty())
+	if err := r.client.Update(context.TODO(), updated); err != nil {
+		return fmt.Errorf("failed to update corefile canary configmap %s/%s: %w", updated.Namespace, updated.Name, err)
+	}
+	logrus.Infof("updated corefile canary configmap %s/%s: %v", updated.Namespace, updated.Name, diff)
+	return nil
+}
+
+// currentCorefileCanaryStatus returns the state of the canary rollout for the
+// given DNS.
+func (r *reconciler) currentCorefileCanaryStatus(dns *operatorv1.DNS) (corefileCanaryStatus, error) {
+	haveCanary, canary, err := r.currentCorefileCanaryConfigMap(dns)
+	if err != nil || !haveCanary {
+		return corefileCanaryStatus{}, err
+	}
+	return corefileCanaryStatus{
+		Phase:   canary.Annotations[corefileCanaryPhaseAnnotationKey],
+		Message: canary.Annotations[corefileCanaryMessageAnnotationKey],
+	}, nil
+}
+
+// corefileCanaryPods returns the corefile canary pods for the given DNS.
+func (r *reconciler) corefileCanaryPods(dns *operatorv1.DNS) ([]corev1.Pod, error) {
+	podList := &corev1.PodList{}
+	listOpts := []client.ListOption{
+		client.MatchingLabels(CorefileCanaryDaemonSetPodSelector(dns).MatchLabels),
+		client.InNamespace(DefaultOperandNamespace),
+	}
+	if err := r.cache.List(context.TODO(), podList, listOpts...); err != nil {
+		return nil, fmt.Errorf("failed to list corefile canary pods: %w", err)
+	}
+	return podList.Items, nil
+}
+
+// corefileCanarySamples returns samples of the corefile canary pods for the
+// given DNS, keyed by pod UID.
+func (r *reconciler) corefileCanarySamples(dns *operatorv1.DNS) (map[string]corefileCanarySample, error) {
+	pods, err := r.corefileCanaryPods(dns)
+	if err != nil {
+		return nil, err
+	}
+	return r.sampleCorefileCanaryPods(context.TODO(), dns, pods), nil
+}
+
+// sampleCorefileCanaryPods returns samples of the given pods, keyed by pod
+// UID.  The metrics of the pods are scraped concurrently, at most
+// corefileCanaryScrapeConcurrency pods at a time, each within
+// corefileCanaryScrapeTimeout, and all within corefileCanarySampleTimeout.
+// Metrics that cannot be scraped are left out of the samples so that the
+// operator still judges the canary by readiness and restarts, for example
+// while a network policy blocks the metrics port.
+func (r *reconciler) sampleCorefileCanaryPods(ctx context.Context, dns *operatorv1.DNS, pods []corev1.Pod) map[string]corefileCanarySample {
+	samples := map[string]corefileCanarySample{}
+	var scrape []*corev1.Pod
+	for i := range pods {
+		pod := &pods[i]
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		samples[string(pod.UID)] = corefileCanarySample{Restarts: podRestarts(pod)}
+		if r.canaryMetricsScraper != nil && len(pod.Status.PodIP) != 0 {
+			scrape = append(scrape, pod)
+		}
+	}
+	if len(scrape) == 0 {
+		return samples
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, corefileCanarySampleTimeout)
+	defer cancel()
+	var (
+		lock  sync.Mutex
+		wg    sync.WaitGroup
+		slots = make(chan struct{}, corefileCanaryScrapeConcurrency)
+	)
+	for _, pod := range scrape {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(pod *corev1.Pod) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			scrapeCtx, cancel := context.WithTimeout(ctx, corefileCanaryScrapeTimeout)
+			defer cancel()
+			metrics, err := r.canaryMetricsScraper.scrape(scrapeCtx, dns, pod)
+			if err != nil {
+				logrus.Warningf("failed to scrape metrics of corefile canary pod %s/%s: %v", pod.Namespace, pod.Name, err)
+				return
+			}
+			lock.Lock()
+			sample := samples[string(pod.UID)]
+			sample.Metrics = metrics
+			samples[string(pod.UID)] = sample
+			lock.Unlock()
+		}(pod)
+	}
+	wg.Wait()
+	return samples
+}
+
+// evaluateCorefileCanary compares the current samples of the canary pods with
+// their samples from the start of the soak period.  Returns a Boolean
+// indicating whether the canary is healthy, and if it is not, the reason.
+// Pods that did not exist when the soak period started are ignored because
+// they may not have become ready yet.
+func evaluateCorefileCanary(config corefileCanaryConfig, baseline map[string]corefileCanarySample, pods []corev1.Pod, samples map[string]corefileCanarySample) (bool, string) {
+	var responses, serverFailures float64
+	for i := range pods {
+		pod := &pods[i]
+		before, ok := baseline[string(pod.UID)]
+		if !ok {
+			continue
+		}
+		now, ok := samples[string(pod.UID)]
+		if !ok {
+			continue
+		}
+		if !podIsReady(pod) {
+			return false, fmt.Sprintf("canary pod %s is not ready", pod.Name)
+		}
+		if now.Restarts > before.Restarts {
+			return false, fmt.Sprintf("canary pod %s restarted %d times", pod.Name, now.Restarts-before.Restarts)
+		}
+		if now.Metrics == nil || before.Metrics == nil {
+			continue
+		}
+		if now.Metrics.ReloadFailures > before.Metrics.ReloadFailures {
+			return false, fmt.Sprintf("CoreDNS in canary pod %s failed to reload the Corefile", pod.Name)
+		}
+		responses += now.Metrics.Responses - before.Metrics.Responses
+		serverFailures += now.Metrics.ServerFailures - before.Metrics.ServerFailures
+	}
+	if responses >= corefileCanaryMinResponses && config.MaxServerFailureRatio != nil {
+		if ratio := serverFailures / responses; ratio > *config.MaxServerFailureRatio {
+			return false, fmt.Sprintf("canary pods answered %.1f%% of queries with SERVFAIL", ratio*100)
+		}
+	}
+	return true, ""
+}
+
+// podIsReady returns a Boolean indicating whether the given pod has the Ready
+// condition.
+func podIsReady(pod *corev1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// podRestarts returns the sum of the restart counts of the given pod's
+// containers.
+func podRestarts(pod *corev1.Pod) int32 {
+	var restarts int32
+	for _, status := range pod.Status.ContainerStatuses {
+		restarts += status.RestartCount
+	}
+	return restarts
+}
+
+// parseCorefileCanaryMetrics parses the CoreDNS metrics that the operator
+// uses to judge a Corefile change and to recommend resource requests from the
+// given Prometheus text exposition.
+func parseCorefileCanaryMetrics(in io.Reader) (*corefileCanaryMetrics, error) {
+	parser := expfmt.NewTextParser(model.UTF8Validation)
+	families, err := parser.TextToMetricFamilies(in)
+	if err != nil {
+		return nil, err
+	}
+	metrics := &corefileCanaryMetrics{}
+	if family, ok := families["coredns_reload_failed_total"]; ok {
+		for _, m := range family.GetMetric() {
+			metrics.ReloadFailures += m.GetCounter().GetValue()
+		}
+	}
+	if family, ok := families["coredns_dns_requests_total"]; ok {
+		for _, m := range family.GetMetric() {
+			metrics.Requests += m.GetCounter().GetValue()
+		}
+	}
+	if family, ok := families["process_resident_memory_bytes"]; ok {
+		for _, m := range family.GetMetric() {
+			metrics.ResidentMemoryBytes += m.GetGauge().GetValue()
+		}
+	}
+	if family, ok := families["coredns_dns_responses_total"]; ok {
+		for _, m := range family.GetMetric() {
+			value := m.GetCounter().GetValue()
+			metrics.Responses += value
+			for _, label := range m.GetLabel() {
+				if label.GetName() == "rcode" && label.GetValue() == "SERVFAIL" {
+					metrics.ServerFailures += value
+				}
+			}
+		}
+	}
+	return metrics, nil
+}
+
+// kubeRBACProxyMetricsScraper scrapes CoreDNS metrics through the
+// kube-rbac-proxy sidecar of a CoreDNS pod using the operator's service
+// account token.  The sidecar serves the DNS service's serving certificate,
+// which the service CA signs.
+type kubeRBACProxyMetricsScraper struct {
+	// reader reads the service CA bundle configmap.
+	reader client.Reader
+	// namespace is the namespace of the service CA bundle configmap.
+	namespace string
+	// bearerToken is the operator's token, if it is not read from
+	// bearerTokenFile.
+	bearerToken string
+	// bearerTokenFile is the file with the operator's token.  The file is
+	// read for every scrape because the token is rotated.
+	bearerTokenFile string
+}
+
+// newKubeRBACProxyMetricsScraper returns a metrics scraper that authenticates
+// with the credentials from the given REST config and reads the service CA
+// bundle from the given namespace.
+func newKubeRBACProxyMetricsScraper(config *rest.Config, reader client.Reader, namespace string) *kubeRBACProxyMetricsScraper {
+	return &kubeRBACProxyMetricsScraper{
+		reader:          reader,
+		namespace:       namespace,
+		bearerToken:     config.BearerToken,
+		bearerTokenFile: config.BearerTokenFile,
+	}
+}
+
+// scrape returns the CoreDNS metrics of the given pod.
+func (s *kubeRBACProxyMetricsScraper) scrape(ctx context.Context, dns *operatorv1.DNS, pod *corev1.Pod) (*corefileCanaryMetrics, error) {
+	cm := &corev1.ConfigMap{}
+	if err := s.reader.Get(ctx, types.NamespacedName{Namespace: s.namespace, Name: serviceCABundleConfigMapName}, cm); err != nil {
+		return nil, fmt.Errorf("failed to get service CA bundle: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(cm.Data[serviceCABundleConfigMapKey])) {
+		return nil, fmt.Errorf("configmap %s/%s has no valid service CA bundle", s.namespace, serviceCABundleConfigMapName)
+	}
+	token := s.bearerToken
+	if len(s.bearerTokenFile) != 0 {
+		b, err := os.ReadFile(s.bearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read service account token: %w", err)
+		}
+		token = strings.TrimSpace(string(b))
+	}
+
+	service := DNSServiceName(dns)
+	httpClient := &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				RootCAs:    roots,
+				ServerName: fmt.Sprintf("%s.%s.svc", service.Name, service.Namespace),
+				MinVersion: tls.VersionTLS12,
+			},
+		},
+	}
+	url := fmt.Sprintf("https://%s/metrics", net.JoinHostPort(pod.Status.PodIP, "9154"))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status from %s: %s", url, resp.Status)
+	}
+	return parseCorefileCanaryMetrics(resp.Body)
+}
@@ -0,0 +1,540 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestCorefileCanaryConfigForDNS(t *testing.T) {
+	defaultRatio := defaultCorefileCanaryMaxServerFailureRatio
+	customRatio := 0.2
+	defaultEmptySoakPeriods := defaultCorefileCanaryMaxEmptySoakPeriods
+	customEmptySoakPeriods := 5
+	testCases := []struct {
+		name           string
+		annotation     string
+		expectedConfig corefileCanaryConfig
+		expectError    bool
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:       "enabled with defaults",
+			annotation: `{"enabled":true}`,
+			expectedConfig: corefileCanaryConfig{
+				Enabled:               true,
+				NodeSelector:          map[string]string{"dns.operator.openshift.io/corefile-canary": ""},
+				MaxServerFailureRatio: &defaultRatio,
+				MaxEmptySoakPeriods:   &defaultEmptySoakPeriods,
+				NoCanaryPodsPolicy:    "RollBack",
+				soakPeriod:            10 * time.Minute,
+			},
+		},
+		{
+			name:       "enabled with custom settings",
+			annotation: `{"enabled":true,"nodeSelector":{"topology.kubernetes.io/zone":"us-east-1a"},"soakPeriod":"90s","maxServerFailureRatio":0.2,"maxEmptySoakPeriods":5,"noCanaryPodsPolicy":"Promote"}`,
+			expectedConfig: corefileCanaryConfig{
+				Enabled:               true,
+				NodeSelector:          map[string]string{"topology.kubernetes.io/zone": "us-east-1a"},
+				SoakPeriod:            "90s",
+				MaxServerFailureRatio: &customRatio,
+				MaxEmptySoakPeriods:   &customEmptySoakPeriods,
+				NoCanaryPodsPolicy:    "Promote",
+				soakPeriod:            90 * time.Second,
+			},
+		},
+		{
+			name:        "zero empty soak periods",
+			annotation:  `{"enabled":true,"maxEmptySoakPeriods":0}`,
+			expectError: true,
+		},
+		{
+			name:        "unknown no canary pods policy",
+			annotation:  `{"enabled":true,"noCanaryPodsPolicy":"Wait"}`,
+			expectError: true,
+		},
+		{
+			name:        "invalid node selector key",
+			annotation:  `{"enabled":true,"nodeSelector":{"not a label":""}}`,
+			expectError: true,
+		},
+		{
+			name:        "invalid soak period",
+			annotation:  `{"enabled":true,"soakPeriod":"ten minutes"}`,
+			expectError: true,
+		},
+		{
+			name:        "negative soak period",
+			annotation:  `{"enabled":true,"soakPeriod":"-1m"}`,
+			expectError: true,
+		},
+		{
+			name:        "ratio greater than 1",
+			annotation:  `{"enabled":true,"maxServerFailureRatio":1.5}`,
+			expectError: true,
+		},
+		{
+			name:        "invalid JSON",
+			annotation:  `{"enabled":true`,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dns := &operatorv1.DNS{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: DefaultDNSController,
+				},
+			}
+			if len(tc.annotation) != 0 {
+				dns.Annotations = map[string]string{
+					corefileCanaryAnnotationKey: tc.annotation,
+				}
+			}
+			config, err := corefileCanaryConfigForDNS(dns)
+			switch {
+			case tc.expectError && err == nil:
+				t.Fatalf("expected an error, got %+v", config)
+			case !tc.expectError && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.expectedConfig, config, cmp.AllowUnexported(corefileCanaryConfig{})); diff != "" {
+				t.Errorf("unexpected config (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestDesiredCorefileCanaryDaemonSet verifies that the canary daemonset runs
+// only on canary nodes and mounts the canary configmap and that the DNS
+// daemonset stays off canary nodes.
+func TestDesiredCorefileCanaryDaemonSet(t *testing.T) {
+	dns := &operatorv1.DNS{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: DefaultDNSController,
+			Annotations: map[string]string{
+				corefileCanaryAnnotationKey: `{"enabled":true,"nodeSelector":{"canary":"true","zone":"a"}}`,
+			},
+		},
+	}
+	config, err := corefileCanaryConfigForDNS(dns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	canary, err := desiredCorefileCanaryDaemonSet(dns, config, "", "", map[string]string{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := CorefileCanaryDaemonSetName(dns); canary.Namespace != expected.Namespace || canary.Name != expected.Name {
+		t.Errorf("expected name %s, got %s/%s", expected, canary.Namespace, canary.Name)
+	}
+	expectedLabels := map[string]string{
+		corefileCanaryDaemonSetLabel: "default",
+		dnsServingLabel:              "default",
+	}
+	if diff := cmp.Diff(expectedLabels, canary.Spec.Template.Labels); diff != "" {
+		t.Errorf("unexpected pod labels (-want +got):\n%s", diff)
+	}
+	expectedNodeSelector := map[string]string{
+		"kubernetes.io/os": "linux",
+		"canary":           "true",
+		"zone":             "a",
+	}
+	if diff := cmp.Diff(expectedNodeSelector, canary.Spec.Template.Spec.NodeSelector); diff != "" {
+		t.Errorf("unexpected node selector (-want +got):\n%s", diff)
+	}
+	if canary.Spec.Template.Spec.Affinity != nil {
+		t.Errorf("expected no affinity, got %+v", canary.Spec.Template.Spec.Affinity)
+	}
+	for _, volume := range canary.Spec.Template.Spec.Volumes {
+		if volume.Name == "config-volume" && volume.ConfigMap.Name != CorefileCanaryConfigMapName(dns).Name {
+			t.Errorf("expected config-volume to use configmap %s, got %s", CorefileCanaryConfigMapName(dns).Name, volume.ConfigMap.Name)
+		}
+	}
+
+	main, err := desiredDNSDaemonSet(dns, "", "", map[string]string{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedAffinity := &corev1.Affinity{
+		NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{
+					{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "canary", Operator: corev1.NodeSelectorOpNotIn, Values: []string{"true"}}}},
+					{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "zone", Operator: corev1.NodeSelectorOpNotIn, Values: []string{"a"}}}},
+				},
+			},
+		},
+	}
+	if diff := cmp.Diff(expectedAffinity, main.Spec.Template.Spec.Affinity); diff != "" {
+		t.Errorf("unexpected dns daemonset affinity (-want +got):\n%s", diff)
+	}
+	if changed, _ := daemonsetConfigChanged(canary, main); !changed {
+		t.Error("expected daemonsetConfigChanged to detect the affinity change")
+	}
+}
+
+func TestEvaluateCorefileCanary(t *testing.T) {
+	ratio := 0.05
+	config := corefileCanaryConfig{Enabled: true, MaxServerFailureRatio: &ratio}
+	pod := func(uid string, ready bool) corev1.Pod {
+		status := corev1.ConditionFalse
+		if ready {
+			status = corev1.ConditionTrue
+		}
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "dns-default-canary-" + uid, UID: types.UID(uid)},
+			Status: corev1.PodStatus{
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}},
+			},
+		}
+	}
+	metrics := func(reloadFailures, responses, serverFailures float64) *corefileCanaryMetrics {
+		return &corefileCanaryMetrics{ReloadFailures: reloadFailures, Responses: responses, ServerFailures: serverFailures}
+	}
+	testCases := []struct {
+		name           string
+		baseline       map[string]corefileCanarySample
+		pods           []corev1.Pod
+		samples        map[string]corefileCanarySample
+		expectHealthy  bool
+		expectedReason string
+	}{
+		{
+			name:          "healthy",
+			baseline:      map[string]corefileCanarySample{"a": {Metrics: metrics(0, 1000, 10)}},
+			pods:          []corev1.Pod{pod("a", true)},
+			samples:       map[string]corefileCanarySample{"a": {Metrics: metrics(0, 2000, 20)}},
+			expectHealthy: true,
+		},
+		{
+			name:           "unready pod",
+			baseline:       map[string]corefileCanarySample{"a": {}},
+			pods:           []corev1.Pod{pod("a", false)},
+			samples:        map[string]corefileCanarySample{"a": {}},
+			expectedReason: "is not ready",
+		},
+		{
+			name:          "unready pod that was created during the soak period",
+			baseline:      map[string]corefileCanarySample{"a": {}},
+			pods:          []corev1.Pod{pod("a", true), pod("b", false)},
+			samples:       map[string]corefileCanarySample{"a": {}, "b": {}},
+			expectHealthy: true,
+		},
+		{
+			name:           "restarted pod",
+			baseline:       map[string]corefileCanarySample{"a": {Restarts: 1}},
+			pods:           []corev1.Pod{pod("a", true)},
+			samples:        map[string]corefileCanarySample{"a": {Restarts: 2}},
+			expectedReason: "restarted 1 times",
+		},
+		{
+			name:           "reload failure",
+			baseline:       map[string]corefileCanarySample{"a": {Metrics: metrics(0, 0, 0)}},
+			pods:           []corev1.Pod{pod("a", true)},
+			samples:        map[string]corefileCanarySample{"a": {Metrics: metrics(1, 0, 0)}},
+			expectedReason: "failed to reload",
+		},
+		{
+			name:           "too many SERVFAIL responses",
+			baseline:       map[string]corefileCanarySample{"a": {Metrics: metrics(0, 1000, 10)}},
+			pods:           []corev1.Pod{pod("a", true)},
+			samples:        map[string]corefileCanarySample{"a": {Metrics: metrics(0, 1200, 50)}},
+			expectedReason: "20.0% of queries with SERVFAIL",
+		},
+		{
+			name:          "too few responses to judge",
+			baseline:      map[string]corefileCanarySample{"a": {Metrics: metrics(0, 0, 0)}},
+			pods:          []corev1.Pod{pod("a", true)},
+			samples:       map[string]corefileCanarySample{"a": {Metrics: metrics(0, 10, 10)}},
+			expectHealthy: true,
+		},
+		{
+			name:          "metrics unavailable",
+			baseline:      map[string]corefileCanarySample{"a": {Metrics: metrics(0, 0, 0)}},
+			pods:          []corev1.Pod{pod("a", true)},
+			samples:       map[string]corefileCanarySample{"a": {}},
+			expectHealthy: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			healthy, reason := evaluateCorefileCanary(config, tc.baseline, tc.pods, tc.samples)
+			if healthy != tc.expectHealthy {
+				t.Fatalf("expected healthy to be %t, got %t (%s)", tc.expectHealthy, healthy, reason)
+			}
+			if !strings.Contains(reason, tc.expectedReason) {
+				t.Errorf("expected reason to contain %q, got %q", tc.expectedReason, reason)
+			}
+		})
+	}
+}
+
+func TestParseCorefileCanaryMetrics(t *testing.T) {
+	exposition := `# HELP coredns_dns_responses_total Counter of response status codes.
+# TYPE coredns_dns_responses_total counter
+coredns_dns_responses_total{plugins="",rcode="NOERROR",server="dns://:5353",view="",zone="."} 90
+coredns_dns_responses_total{plugins="",rcode="NXDOMAIN",server="dns://:5353",view="",zone="."} 6
+coredns_dns_responses_total{plugins="",rcode="SERVFAIL",server="dns://:5353",view="",zone="."} 4
+# HELP coredns_reload_failed_total Counter of the number of failed reload attempts.
+# TYPE coredns_reload_failed_total counter
+coredns_reload_failed_total 2
+# HELP coredns_dns_requests_total Counter of DNS requests made per zone, protocol and family.
+# TYPE coredns_dns_requests_total counter
+coredns_dns_requests_total{family="1",proto="udp",server="dns://:5353",type="A",view="",zone="."} 70
+coredns_dns_requests_total{family="1",proto="tcp",server="dns://:5353",type="AAAA",view="",zone="."} 30
+# HELP process_resident_memory_bytes Resident memory size in bytes.
+# TYPE process_resident_memory_bytes gauge
+process_resident_memory_bytes 4.194304e+07
+`
+	metrics, err := parseCorefileCanaryMetrics(strings.NewReader(exposition))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &corefileCanaryMetrics{ReloadFailures: 2, Responses: 100, ServerFailures: 4, Requests: 100, ResidentMemoryBytes: 41943040}
+	if diff := cmp.Diff(expected, metrics); diff != "" {
+		t.Errorf("unexpected metrics (-want +got):\n%s", diff)
+	}
+}
+
+// TestEnsureCorefileCanaryConfigMapWithoutCanaryPods verifies that a Corefile
+// change keeps waiting for canary pods only for a bounded number of soak
+// periods and is then rolled back or promoted according to the no canary pods
+// policy.
+func TestEnsureCorefileCanaryConfigMapWithoutCanaryPods(t *testing.T) {
+	dns := &operatorv1.DNS{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: DefaultDNSController,
+		},
+	}
+	desired, err := desiredDNSConfigMap(dns, "cluster.local", nil, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	candidate := desiredStateHash(desired.Data)
+	oldData := map[string]string{"Corefile": ".:5353 {\n}\n"}
+
+	testCases := []struct {
+		name             string
+		config           string
+		emptySoakPeriods string
+		pods             []runtime.Object
+		expectPhase      string
+		expectEmpty      string
+		expectPromoted   bool
+		expectRequeue    bool
+	}{
+		{
+			name:          "first empty soak period starts another",
+			config:        `{"enabled":true}`,
+			expectPhase:   corefileCanaryPhaseSoaking,
+			expectEmpty:   "1",
+			expectRequeue: true,
+		},
+		{
+			name:             "last empty soak period rolls back",
+			config:           `{"enabled":true}`,
+			emptySoakPeriods: "2",
+			expectPhase:      corefileCanaryPhaseRolledBack,
+		},
+		{
+			name:             "last empty soak period promotes",
+			config:           `{"enabled":true,"noCanaryPodsPolicy":"Promote"}`,
+			emptySoakPeriods: "2",
+			expectPromoted:   true,
+		},
+		{
+			name:             "canary pods start a real soak period",
+			config:           `{"enabled":true}`,
+			emptySoakPeriods: "2",
+			pods: []runtime.Object{&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "dns-canary-default-abcde",
+					Namespace: DefaultOperandNamespace,
+					UID:       "1",
+					Labels:    CorefileCanaryDaemonSetPodSelector(dns).MatchLabels,
+				},
+			}},
+			expectPhase:   corefileCanaryPhaseSoaking,
+			expectRequeue: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dns := dns.DeepCopy()
+			dns.Annotations = map[string]string{corefileCanaryAnnotationKey: tc.config}
+			config, err := corefileCanaryConfigForDNS(dns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			current := desired.DeepCopy()
+			current.Data = oldData
+			canary := desiredCorefileCanaryConfigMap(dns, desired.Data)
+			canary.Annotations = map[string]string{
+				corefileCanaryPhaseAnnotationKey:     corefileCanaryPhaseSoaking,
+				corefileCanaryCandidateAnnotationKey: candidate,
+				corefileCanaryStartedAnnotationKey:   time.Now().Add(-2 * config.soakPeriod).UTC().Format(time.RFC3339),
+				corefileCanaryBaselineAnnotationKey:  "{}",
+			}
+			if len(tc.emptySoakPeriods) != 0 {
+				canary.Annotations[corefileCanaryEmptySoakPeriodsAnnotationKey] = tc.emptySoakPeriods
+			}
+			scheme := runtime.NewScheme()
+			corev1.AddToScheme(scheme)
+			fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(append(tc.pods, current, canary)...).Build()
+			informer := informertest.FakeInformers{Scheme: scheme}
+			r := &reconciler{client: fakeClient, cache: fakeCache{Informers: &informer, Reader: fakeClient}}
+
+			requeue, err := r.ensureCorefileCanaryConfigMap(dns, config, "cluster.local", nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (requeue != 0) != tc.expectRequeue {
+				t.Errorf("expected requeue %t, got %s", tc.expectRequeue, requeue)
+			}
+			if err := fakeClient.Get(context.Background(), CorefileCanaryConfigMapName(dns), canary); err != nil {
+				t.Fatal(err)
+			}
+			if phase := canary.Annotations[corefileCanaryPhaseAnnotationKey]; phase != tc.expectPhase {
+				t.Errorf("expected phase %q, got %q", tc.expectPhase, phase)
+			}
+			if empty := canary.Annotations[corefileCanaryEmptySoakPeriodsAnnotationKey]; empty != tc.expectEmpty {
+				t.Errorf("expected %q empty soak periods, got %q", tc.expectEmpty, empty)
+			}
+			if err := fakeClient.Get(context.Background(), DNSConfigMapName(dns), current); err != nil {
+				t.Fatal(err)
+			}
+			if promoted := cmp.Equal(current.Data, desired.Data); promoted != tc.expectPromoted {
+				t.Errorf("expected promoted %t, got %t", tc.expectPromoted, promoted)
+			}
+		})
+	}
+}
+
+// TestEnsureCorefileCanaryDeleted verifies that ensureCorefileCanaryDeleted
+// deletes the corefile canary daemonset and configmap and does not issue
+// deletes for ones that do not exist.
+func TestEnsureCorefileCanaryDeleted(t *testing.T) {
+	dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+	daemonsetName := CorefileCanaryDaemonSetName(dns)
+	cmName := CorefileCanaryConfigMapName(dns)
+	testCases := []struct {
+		name            string
+		objects         []client.Object
+		expectedDeletes int
+	}{
+		{
+			name: "no canary",
+		},
+		{
+			name: "canary daemonset and configmap",
+			objects: []client.Object{
+				&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Namespace: daemonsetName.Namespace, Name: daemonsetName.Name}},
+				&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: cmName.Namespace, Name: cmName.Name}},
+			},
+			expectedDeletes: 2,
+		},
+	}
+
+	scheme := runtime.NewScheme()
+	corev1.AddToScheme(scheme)
+	appsv1.AddToScheme(scheme)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			deletes := 0
+			fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(tc.objects...).WithInterceptorFuncs(interceptor.Funcs{
+				Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+					deletes++
+					return c.Delete(ctx, obj, opts...)
+				},
+			}).Build()
+			r := &reconciler{client: fakeClient, cache: fakeCache{Reader: fakeClient}}
+			if err := r.ensureCorefileCanaryDeleted(dns); err != nil {
+				t.Fatal(err)
+			}
+			if deletes != tc.expectedDeletes {
+				t.Errorf("expected %d deletes, got %d", tc.expectedDeletes, deletes)
+			}
+			if err := fakeClient.Get(context.Background(), daemonsetName, &appsv1.DaemonSet{}); err == nil {
+				t.Error("expected the corefile canary daemonset to be deleted")
+			}
+			if err := fakeClient.Get(context.Background(), cmName, &corev1.ConfigMap{}); err == nil {
+				t.Error("expected the corefile canary configmap to be deleted")
+			}
+		})
+	}
+}
+
+// TestSampleCorefileCanaryPods verifies that the metrics of canary pods are
+// scraped with bounded concurrency and that a pod that does not answer does
+// not keep the operator from sampling the other pods.
+func TestSampleCorefileCanaryPods(t *testing.T) {
+	dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+	pod := func(name, podIP string) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: DefaultOperandNamespace, Name: name, UID: types.UID(name)},
+			Status: corev1.PodStatus{
+				PodIP:             podIP,
+				ContainerStatuses: []corev1.ContainerStatus{{Name: "dns", RestartCount: 1}},
+			},
+		}
+	}
+	pods := []corev1.Pod{pod("hung", "10.0.0.1"), pod("no-ip", "")}
+	for i := 0; i < 3*corefileCanaryScrapeConcurrency; i++ {
+		pods = append(pods, pod(fmt.Sprintf("pod-%d", i), "10.0.0.1"))
+	}
+
+	scraper := &fakeMetricsScraper{}
+	r := &reconciler{canaryMetricsScraper: scraper}
+	// The deadline of the context bounds the per-pod timeout so that the
+	// test does not wait for corefileCanaryScrapeTimeout.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	samples := r.sampleCorefileCanaryPods(ctx, dns, pods)
+
+	if len(samples) != len(pods) {
+		t.Errorf("expected samples for %d pods, got %d", len(pods), len(samples))
+	}
+	for _, p := range pods {
+		sample, ok := samples[p.Name]
+		if !ok {
+			continue
+		}
+		if sample.Restarts != 1 {
+			t.Errorf("expected 1 restart for pod %s, got %d", p.Name, sample.Restarts)
+		}
+		expectMetrics := p.Name != "hung" && p.Name != "no-ip"
+		if hasMetrics := sample.Metrics != nil; hasMetrics != expectMetrics {
+			t.Errorf("expected metrics for pod %s: %t, got %+v", p.Name, expectMetrics, sample.Metrics)
+		}
+	}
+	if scraper.maxInFlight > corefileCanaryScrapeConcurrency {
+		t.Errorf("expected at most %d concurrent scrapes, got %d", corefileCanaryScrapeConcurrency, scraper.maxInFlight)
+	}
+	if scraper.maxInFlight < 2 {
+		t.Errorf("expected concurrent scrapes, got %d at a time", scraper.maxInFlight)
+	}
+}
@@ -22,9 +22,8 @@ import (
 )
 
 const (
-	resolvConf       = "/etc/resolv.conf"
-	defaultDNSPort   = 53
-	lameDuckDuration = 20 * time.Second
+	resolvConf     = "/etc/resolv.conf"
+	defaultDNSPort = 53
 
 	// cacheDefaultMaxPositiveTTLSeconds is the default maximum TTL that the
 	// operator configures CoreDNS to enforce for positive (NOERROR)
@@ -42,16 +41,52 @@ var errTransportTLSConfiguredForNonIP = fmt.Errorf("Only IP addresses are allowe
 var errTransportTLSConfiguredForSysResConf = fmt.Errorf("Using system resolv config is not allowed when configuring TLS as the DNS Transport")
 var corefileTemplate = template.Must(template.New("Corefile").Funcs(template.FuncMap{
 	"CoreDNSForwardingPolicy": coreDNSPolicy, "UpstreamResolver": coreDNSResolver,
-}).Parse(`{{range .Servers -}}
+	"CABundleVolumeMountPath": caBundleVolumeMountPath, "CABundleRevisionFileName": caBundleRevisionFileName,
+}).Parse(`{{range $view := .Views -}}
+# view {{.Name}}
+{{range .Zones}}{{.}}:5353 {{end}}{
+    view {{.Name}} {
+        expr {{.Expression}}
+    }
+    prometheus 127.0.0.1:9153
+    {{- with .Hosts}}
+    hosts {
+        {{- range .}}
+        {{.}}
+        {{- end}}
+        {{- if $view.Upstreams}}
+        fallthrough
+        {{- end}}
+    }
+    {{- end}}
+    {{- with .Upstreams}}
+    forward .{{range .}} {{.}}{{end}} {
+        policy sequential
+    }
+    {{- end}}
+    errors
+    log . {
+        {{$.LogLevel}}
+    }
+    bufsize 1232
+    cache {{ $.PositiveTTL }} {
+        denial 9984 {{ $.NegativeTTL }}
+    }
+}
+{{end -}}
+{{range $server := .Servers -}}
 # {{.Name}}
 {{range .Zones}}{{.}}:5353 {{end}}{
     {{with $fp:=.ForwardPlugin -}}
     prometheus 127.0.0.1:9153
     forward .{{range $fp.Upstreams}} {{if eq "TLS" $fp.TransportConfig.Transport}}tls://{{end}}{{.}}{{end}} {
+        {{- with index $.ForwardExcept.Servers $server.Name }}
+        except{{range .}} {{.}}{{end}}
+        {{- end}}
         {{- with $tls := .TransportConfig.TLS }}
         {{- with $serverName := $tls.ServerName }}
         tls_servername {{$serverName}}
-        tls {{- with $.CABundleRevisionMap }}{{- with $cm := (index $.CABundleRevisionMap $tls.CABundle.Name) }} /etc/pki/{{$serverName}}-{{ $cm }}/{{ $.CABundleFileName }}{{end}}{{end}}
+        tls {{- with $.CABundleRevisionMap }}{{- with $revision := (index $.CABundleRevisionMap $tls.CABundle.Name) }} {{ CABundleVolumeMountPath $serverName $tls.CABundle.Name }}/{{ CABundleRevisionFileName $revision }}{{end}}{{end}}
         {{- end}}
         {{- end}}
         policy {{ CoreDNSForwardingPolicy .Policy }}
@@ -85,17 +120,20 @@ var corefileTemplate = template.Must(template.New("Corefile").Funcs(template.Fun
         lameduck {{.LameDuckDuration}}
     }
     ready
-    kubernetes {{.ClusterDomain}} in-addr.arpa ip6.arpa {
+    kubernetes {{.ClusterDomain}}{{range .PreviousClusterDomains}} {{.}}{{end}} in-addr.arpa ip6.arpa {
         pods insecure
         fallthrough in-addr.arpa ip6.arpa
     }
     prometheus 127.0.0.1:9153
     {{- with .UpstreamResolvers }}
     forward .{{range .Upstreams}} {{if eq "TLS" $.UpstreamResolvers.TransportConfig.Transport}}tls://{{end}}{{UpstreamResolver .}}{{end}} {
+        {{- with $.ForwardExcept.UpstreamResolvers }}
+        except{{range .}} {{.}}{{end}}
+        {{- end}}
         {{- with $tls := .TransportConfig.TLS }}
         {{- with $serverName := $tls.ServerName }}
         tls_servername {{$serverName}}
-        tls {{- with $.CABundleRevisionMap }}{{- with $cm := (index $.CABundleRevisionMap $tls.CABundle.Name) }} /etc/pki/{{$serverName}}-{{ $cm }}/{{ $.CABundleFileName }}{{end}}{{end}}
+        tls {{- with $.CABundleRevisionMap }}{{- with $revision := (index $.CABundleRevisionMap $tls.CABundle.Name) }} {{ CABundleVolumeMountPath $serverName $tls.CABundle.Name }}/{{ CABundleRevisionFileName $revision }}{{end}}{{end}}
         {{- end}}
         {{- end}}
         policy {{ CoreDNSForwardingPolicy .Policy }}
@@ -104,6 +142,14 @@ var corefileTemplate = template.Must(template.New("Corefile").Funcs(template.Fun
         {{- end}}
     }
     {{- end}}
+    {{- with .DNS64 }}
+    dns64 {
+        prefix {{.Prefix}}
+        {{- if .TranslateAll}}
+        translate_all
+        {{- end}}
+    }
+    {{- end}}
     cache {{ .PositiveTTL }} {
         denial 9984 {{ .NegativeTTL }}
     }
@@ -120,25 +166,26 @@ hostname.bind:5353 {
 `))
 
 // ensureDNSConfigMap ensures that a configmap exists for a given DNS.
-func (r *reconciler) ensureDNSConfigMap(dns *operatorv1.DNS, clusterDomain string, caBundleRevisionMap map[string]string) (bool, *corev1.ConfigMap, error) {
+func (r *reconciler) ensureDNSConfigMap(dns *operatorv1.DNS, clusterDomain string, caBundleRevisionMap map[string]string, dnsNameResolverNamespaces []string) (bool, *corev1.ConfigMap, error) {
 	haveCM, current, err := r.currentDNSConfigMap(dns)
 	if err != nil {
 		return false, nil, fmt.Errorf("failed to get configmap: %v", err)
 	}
-	desired, err := desiredDNSConfigMap(dns, clusterDomain, caBundleRevisionMap, r.dnsNameResolverEnabled, r.dnsNameResolverNamespaces)
+	desired, err := desiredDNSConfigMap(dns, clusterDomain, caBundleRevisionMap, r.dnsNameResolverEnabled, dnsNameResolverNamespaces)
 	if err != nil {
 		return haveCM, current, fmt.Errorf("failed to build configmap: %v", err)
 	}
 
 	switch {
 	case !haveCM:
+		setDesiredStateHash(desired, desiredStateHash(desired.Data))
 		if err := r.client.Create(context.TODO(), desired); err != nil {
 			return false, nil, fmt.Errorf("failed to create configmap: %v", err)
 		}
 		logrus.Infof("created configmap: %s", desired.Name)
 		return r.currentDNSConfigMap(dns)
 	case haveCM:
-		if updated, err := r.updateDNSConfigMap(current, desired); err != nil {
+		if updated, err := r.updateDNSConfigMap(dns, current, desired); err != nil {
 			return true, current, err
 		} else if updated {
 			return r.currentDNSConfigMap(dns)
@@ -161,7 +208,7 @@ func (r *reconciler) currentDNSConfigMap(dns *operatorv1.DNS) (bool, *corev1.Con
 
 func desiredDNSConfigMap(dns *operatorv1.DNS, clusterDomain string, caBundleRevisionMap map[string]string, dnsNameResolverEnabled bool, dnsNameResolverNamespaces []string) (*corev1.ConfigMap, error) {
 	if len(clusterDomain) == 0 {
-		clusterDomain = "cluster.local"
+		clusterDomain = defaultClusterDomain
 	}
 
 	dns, err := sanitizeTLSSettings(dns)
@@ -202,17 +249,46 @@ func desiredDNSConfigMap(dns *operatorv1.DNS, clusterDomain string, caBundleRevi
 		upstreamResolvers.Policy = dns.Spec.UpstreamResolvers.Policy
 	}
 
+	if err := validateDNSZones(dns, clusterDomain); err != nil {
+		return nil, err
+	}
+
+	views, err := dnsViews(dns)
+	if err != nil {
+		return nil, err
+	}
+
+	except, err := dnsForwardExcept(dns)
+	if err != nil {
+		return nil, err
+	}
+
+	var dns64 *dns64Config
+	if config, err := dns64ConfigForDNS(dns); err != nil {
+		return nil, err
+	} else if config.Enabled {
+		dns64 = &config
+	}
+
+	drain, err := dnsDrainConfigForDNS(dns)
+	if err != nil {
+		return nil, err
+	}
+
 	// Calculate the caching values (in seconds) for use in the Corefile
 	pTTL, nTTL := coreDNSCache(dns)
 
 	corefileParameters := struct {
 		ClusterDomain             string
+		PreviousClusterDomains    []string
+		Views                     []corefileView
 		Servers                   interface{}
 		UpstreamResolvers         operatorv1.UpstreamResolvers
+		ForwardExcept             forwardExcept
+		DNS64                     *dns64Config
 		PolicyStr                 func(policy operatorv1.ForwardingPolicy) string
 		LogLevel                  string
 		CABundleRevisionMap       map[string]string
-		CABundleFileName          string
 		LameDuckDuration          time.Duration
 		PositiveTTL               uint32
 		NegativeTTL               uint32
@@ -220,13 +296,16 @@ func desiredDNSConfigMap(dns *operatorv1.DNS, clusterDomain string, caBundleRevi
 		DNSNameResolverNamespaces []string
 	}{
 		ClusterDomain:             clusterDomain,
+		PreviousClusterDomains:    desiredPreviousClusterDomains(dns, clusterDomain),
+		Views:                     corefileViews(views),
 		Servers:                   dns.Spec.Servers,
 		UpstreamResolvers:         upstreamResolvers,
+		ForwardExcept:             except,
+		DNS64:                     dns64,
 		PolicyStr:                 coreDNSPolicy,
 		LogLevel:                  coreDNSLogLevel(dns),
 		CABundleRevisionMap:       caBundleRevisionMap,
-		CABundleFileName:          caBundleFileName,
-		LameDuckDuration:          lameDuckDuration,
+		LameDuckDuration:          drain.lameDuckDuration,
 		PositiveTTL:               pTTL,
 		NegativeTTL:               nTTL,
 		OCPDNSNameResolver:        dnsNameResolverEnabled,
@@ -236,6 +315,10 @@ func desiredDNSConfigMap(dns *operatorv1.DNS, clusterDomain string, caBundleRevi
 	if err := corefileTemplate.Execute(corefile, corefileParameters); err != nil {
 		return nil, err
 	}
+	patchedCorefile, err := applyUnsupportedCorefileOverrides(dns, corefile.String())
+	if err != nil {
+		return nil, err
+	}
 
 	name := DNSConfigMapName(dns)
 	cm := &corev1.ConfigMap{
@@ -247,7 +330,7 @@ func desiredDNSConfigMap(dns *operatorv1.DNS, clusterDomain string, caBundleRevi
 			},
 		},
 		Data: map[string]string{
-			"Corefile": corefile.String(),
+			"Corefile": patchedCorefile,
 		},
 	}
 	cm.SetOwnerReferences([]metav1.OwnerReference{dnsOwnerRef(dns)})
@@ -323,14 +406,19 @@ func sanitizeTLSSettings(dns *operatorv1.DNS) (*operatorv1.DNS, error) {
 	return updated, nil
 }
 
-func (r *reconciler) updateDNSConfigMap(current, desired *corev1.ConfigMap) (bool, error) {
+func (r *reconciler) updateDNSConfigMap(dns *operatorv1.DNS, current, desired *corev1.ConfigMap) (bool, error) {
+	hash := desiredStateHash(desired.Data)
 	changed, updated := corefileChanged(current, desired)
 	if !changed {
-		return false, nil
+		return r.seedDesiredStateHash("ConfigMap", current, hash)
 	}
 
 	// Diff before updating because the client may mutate the object.
 	diff := cmp.Diff(current, updated, cmpopts.EquateEmpty())
+	if driftDetected(current, hash) {
+		r.reportDrift(dns, "ConfigMap", current, diff)
+	}
+	setDesiredStateHash(updated, hash)
 	if err := r.client.Update(context.TODO(), updated); err != nil {
 		return false, fmt.Errorf("failed to update configmap: %v", err)
 	}
@@ -224,7 +224,7 @@ func TestDesiredDNSConfigmap(t *testing.T) {
 
 	clusterDomain := "cluster.local"
 	cmMap := make(map[string]string)
-	cmMap["cacerts"] = "ca-cacerts-2"
+	cmMap["cacerts"] = "1f2e3d4c5b6a7980"
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -994,7 +994,7 @@ func TestDesiredDNSConfigmapUpstreamResolvers(t *testing.T) {
 
 	clusterDomain := "cluster.local"
 	cmMap := make(map[string]string)
-	cmMap["ca-bundle-config"] = "ca-ca-bundle-config-1"
+	cmMap["ca-bundle-config"] = "0a1b2c3d4e5f6071"
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -1194,7 +1194,7 @@ func TestDesiredDNSConfigmapDNSNameResolverEnabled(t *testing.T) {
 
 	clusterDomain := "cluster.local"
 	cmMap := make(map[string]string)
-	cmMap["cacerts"] = "ca-cacerts-2"
+	cmMap["cacerts"] = "1f2e3d4c5b6a7980"
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -1211,6 +1211,51 @@ func TestDesiredDNSConfigmapDNSNameResolverEnabled(t *testing.T) {
 	}
 }
 
+func TestDesiredDNSConfigmapClusterDomain(t *testing.T) {
+	testCases := []struct {
+		name             string
+		dns              *operatorv1.DNS
+		clusterDomain    string
+		expectedCoreFile string
+	}{
+		{
+			name: "Check if Corefile is rendered correctly with a custom cluster domain",
+			dns: &operatorv1.DNS{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: DefaultDNSController,
+				},
+			},
+			clusterDomain:    "corp.example",
+			expectedCoreFile: mustLoadTestFile(t, "custom_cluster_domain"),
+		},
+		{
+			name: "Check if Corefile keeps serving the previous cluster domain during a migration",
+			dns: &operatorv1.DNS{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: DefaultDNSController,
+				},
+				Status: operatorv1.DNSStatus{
+					ClusterDomain: "cluster.local",
+				},
+			},
+			clusterDomain:    "corp.example",
+			expectedCoreFile: mustLoadTestFile(t, "custom_cluster_domain_migration"),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cm, err := desiredDNSConfigMap(tc.dns, tc.clusterDomain, nil, false, nil)
+			if err != nil {
+				t.Fatalf("Unexpected error : %v", err)
+			}
+			if diff := cmp.Diff(cm.Data["Corefile"], tc.expectedCoreFile); diff != "" {
+				t.Errorf("Unexpected Corefile;\n%s", diff)
+			}
+		})
+	}
+}
+
 // mustLoadTestFile looks in the default directory of ./testdata for a file matching the name argument
 // and returns the file contents as a string.
 func mustLoadTestFile(t *testing.T, name string) string {
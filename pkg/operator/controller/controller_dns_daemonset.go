@@ -20,11 +20,9 @@ import (
 	"github.com/sirupsen/logrus"
 
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/sets"
-
-	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 const (
@@ -41,8 +39,10 @@ var (
 	managedDNSDaemonSetAnnotations = sets.NewString(enableDaemonSetEvictionAnnotationKey, targetWorkloadManagementAnnotationKey)
 )
 
-// ensureDNSDaemonSet ensures the dns daemonset exists for a given dns.
-func (r *reconciler) ensureDNSDaemonSet(dns *operatorv1.DNS, caBundleRevisionMap map[string]string, tlsSecurityProfile *configv1.TLSSecurityProfile) (bool, *appsv1.DaemonSet, error) {
+// ensureDNSDaemonSet ensures the dns daemonset exists for a given dns.  The
+// daemonset keeps running on the nodes of the node pools with the given names
+// because their daemonsets have not become available yet.
+func (r *reconciler) ensureDNSDaemonSet(dns *operatorv1.DNS, caBundleRevisionMap map[string]string, tlsSecurityProfile *configv1.TLSSecurityProfile, pendingNodePools sets.String) (bool, *appsv1.DaemonSet, error) {
 	haveDS, current, err := r.currentDNSDaemonSet(dns)
 	if err != nil {
 		return false, nil, err
@@ -51,14 +51,19 @@ func (r *reconciler) ensureDNSDaemonSet(dns *operatorv1.DNS, caBundleRevisionMap
 	if err != nil {
 		return haveDS, current, fmt.Errorf("failed to build dns daemonset: %v", err)
 	}
+	if err := keepPendingNodePools(dns, desired, pendingNodePools); err != nil {
+		return haveDS, current, fmt.Errorf("failed to build dns daemonset: %v", err)
+	}
+	r.applyAutosizedDNSRequests(dns, current, desired)
 	switch {
 	case !haveDS:
+		setDesiredStateHash(desired, desiredStateHash(desired.Spec))
 		if err := r.createDNSDaemonSet(desired); err != nil {
 			return false, nil, err
 		}
 		return r.currentDNSDaemonSet(dns)
 	case haveDS:
-		if updated, err := r.updateDNSDaemonSet(current, desired); err != nil {
+		if updated, err := r.updateDNSDaemonSet(dns, current, desired); err != nil {
 			return true, current, err
 		} else if updated {
 			return r.currentDNSDaemonSet(dns)
@@ -81,6 +86,7 @@ func (r *reconciler) ensureDNSDaemonSetDeleted(dns *operatorv1.DNS) error {
 	} else {
 		logrus.Infof("deleted dns daemonset: %s", dns.Name)
 	}
+	r.updateSafetyStatuses.set(dns, nil)
 	return nil
 }
 
@@ -107,10 +113,40 @@ func desiredDNSDaemonSet(dns *operatorv1.DNS, coreDNSImage, kubeRBACProxyImage s
 
 	// Ensure the daemonset adopts only its own pods.
 	daemonset.Spec.Selector = DNSDaemonSetPodSelector(dns)
-	daemonset.Spec.Template.Labels = daemonset.Spec.Selector.MatchLabels
+	daemonset.Spec.Template.Labels = dnsPodLabels(dns, daemonset.Spec.Selector)
 	daemonset.Spec.Template.Spec.NodeSelector = nodeSelectorForDNS(dns)
 	daemonset.Spec.Template.Spec.Tolerations = tolerationsForDNS(dns)
 
+	// Leave canary nodes to the corefile canary daemonset and the nodes of
+	// node pools to their daemonsets.
+	if excluded, err := dnsExcludedNodeSelectors(dns, nil); err != nil {
+		return nil, err
+	} else {
+		daemonset.Spec.Template.Spec.Affinity = nodeAffinityExcluding(excluded)
+	}
+
+	resources, err := dnsResourcesConfigForDNS(dns)
+	if err != nil {
+		return nil, err
+	}
+
+	runtimeConfig, err := dnsRuntimeConfigForDNS(dns)
+	if err != nil {
+		return nil, err
+	}
+
+	drain, err := dnsDrainConfigForDNS(dns)
+	if err != nil {
+		return nil, err
+	}
+	applyDNSDrainConfig(&daemonset.Spec.Template.Spec, drain)
+
+	rollout, err := dnsRolloutConfigForDNS(dns)
+	if err != nil {
+		return nil, err
+	}
+	applyDNSRolloutConfig(daemonset, rollout)
+
 	coreFileVolumeFound := false
 	for i := range daemonset.Spec.Template.Spec.Volumes {
 		// TODO: remove hardcoding of volume name
@@ -133,8 +169,12 @@ func desiredDNSDaemonSet(dns *operatorv1.DNS, coreDNSImage, kubeRBACProxyImage s
 		switch c.Name {
 		case "dns":
 			daemonset.Spec.Template.Spec.Containers[i].Image = coreDNSImage
+			if err := applyContainerResources(&daemonset.Spec.Template.Spec.Containers[i], resources.DNS); err != nil {
+				return nil, fmt.Errorf("invalid annotation %s: %w", dnsResourcesAnnotationKey, err)
+			}
+			applyDNSRuntimeConfig(&daemonset.Spec.Template.Spec.Containers[i], runtimeConfig)
 			if tls := dns.Spec.UpstreamResolvers.TransportConfig.TLS; tls != nil && tls.CABundle.Name != "" {
-				haveCM, vol, volMount := caBundleCMVolAndVolMount(tls.CABundle.Name, tls.ServerName, caBundleRevisionMap)
+				haveCM, vol, volMount := caBundleCMVolAndVolMount(dns, tls.CABundle.Name, tls.ServerName, caBundleRevisionMap)
 				if haveCM {
 					daemonset.Spec.Template.Spec.Volumes = append(daemonset.Spec.Template.Spec.Volumes, *vol)
 					daemonset.Spec.Template.Spec.Containers[i].VolumeMounts = append(daemonset.Spec.Template.Spec.Containers[i].VolumeMounts, *volMount)
@@ -142,7 +182,7 @@ func desiredDNSDaemonSet(dns *operatorv1.DNS, coreDNSImage, kubeRBACProxyImage s
 			}
 			for _, server := range dns.Spec.Servers {
 				if tls := server.ForwardPlugin.TransportConfig.TLS; tls != nil && tls.CABundle.Name != "" {
-					haveCM, vol, volMount := caBundleCMVolAndVolMount(tls.CABundle.Name, tls.ServerName, caBundleRevisionMap)
+					haveCM, vol, volMount := caBundleCMVolAndVolMount(dns, tls.CABundle.Name, tls.ServerName, caBundleRevisionMap)
 					if haveCM {
 						daemonset.Spec.Template.Spec.Volumes = append(daemonset.Spec.Template.Spec.Volumes, *vol)
 						daemonset.Spec.Template.Spec.Containers[i].VolumeMounts = append(daemonset.Spec.Template.Spec.Containers[i].VolumeMounts, *volMount)
@@ -152,9 +192,12 @@ func desiredDNSDaemonSet(dns *operatorv1.DNS, coreDNSImage, kubeRBACProxyImage s
 		case "kube-rbac-proxy":
 			daemonset.Spec.Template.Spec.Containers[i].Image = kubeRBACProxyImage
 			daemonset.Spec.Template.Spec.Containers[i].Args = kubeRBACProxyArgs(tlsSecurityProfile)
+			if err := applyContainerResources(&daemonset.Spec.Template.Spec.Containers[i], resources.KubeRBACProxy); err != nil {
+				return nil, fmt.Errorf("invalid annotation %s: %w", dnsResourcesAnnotationKey, err)
+			}
 		}
 	}
-	return daemonset, nil
+	return applyUnsupportedDaemonSetOverrides(dns, daemonset)
 }
 
 // kubeRBACProxyArgs returns the set of CLI arguments to pass to the
@@ -218,39 +261,69 @@ func openSSLNamesFromIANA(ianaCiphers []string, openSSLCiphers []string) []strin
 	return matched
 }
 
-// caBundleCMVolAndVolMount takes a CA bundle ConfigMap name and a TLS server name, and returns
+// caBundleCMVolAndVolMount takes a DNS, a CA bundle ConfigMap name and a TLS server name, and returns
 // a boolean indicating existence of the ConfigMap, the ConfigMap Volume and VolumeMount to be used for the DaemonSet.
-func caBundleCMVolAndVolMount(caBundleName string, serverName string, caBundleRevisionMap map[string]string) (bool, *corev1.Volume, *corev1.VolumeMount) {
+// The Volume refers to the DNS's copy of the ConfigMap, but its name and mount path depend only on the
+// CA bundle ConfigMap name.
+// The volume projects every key of the ConfigMap and the mount path does not depend on the
+// CA bundle's revision, so the kubelet updates the files in place when the CA bundle is rotated
+// and the DaemonSet does not roll out.
+func caBundleCMVolAndVolMount(dns *operatorv1.DNS, caBundleName string, serverName string, caBundleRevisionMap map[string]string) (bool, *corev1.Volume, *corev1.VolumeMount) {
 	if _, ok := caBundleRevisionMap[caBundleName]; !ok {
 		return false, nil, nil
 	}
-	caBundleConfigmapName := CABundleConfigMapName(caBundleName)
-	caBundleVolumeName := caBundleConfigmapName.Name
+	caBundleConfigmapName := CABundleConfigMapName(dns, caBundleName)
+	caBundleVolumeName := caBundleVolumeName(caBundleName)
 	caBundleVolume := corev1.Volume{
 		Name: caBundleVolumeName,
 		VolumeSource: corev1.VolumeSource{
-			ConfigMap: &corev1.ConfigMapVolumeSource{
-				LocalObjectReference: corev1.LocalObjectReference{
-					Name: caBundleVolumeName,
-				},
-				Items: []corev1.KeyToPath{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{
 					{
-						Key:  caBundleFileName,
-						Path: caBundleFileName,
+						ConfigMap: &corev1.ConfigMapProjection{
+							LocalObjectReference: corev1.LocalObjectReference{
+								Name: caBundleConfigmapName.Name,
+							},
+						},
 					},
 				},
 			},
 		},
 	}
-	caBundleVolumeMountPath := fmt.Sprintf("/etc/pki/%s-%s", serverName, caBundleRevisionMap[caBundleName])
 	caBundleVolumeMount := corev1.VolumeMount{
 		Name:      caBundleVolumeName,
-		MountPath: caBundleVolumeMountPath,
+		MountPath: caBundleVolumeMountPath(serverName, caBundleName),
 		ReadOnly:  true,
 	}
 	return true, &caBundleVolume, &caBundleVolumeMount
 }
 
+// caBundleVolumeMountPath returns the path at which CoreDNS finds the CA bundle
+// configmap with the given name for the given TLS server name.
+func caBundleVolumeMountPath(serverName, caBundleName string) string {
+	return fmt.Sprintf("/etc/pki/%s-%s", serverName, caBundleVolumeName(caBundleName))
+}
+
+// caBundleVolumeName returns the name of the volume for the CA bundle
+// configmap with the given name.
+func caBundleVolumeName(caBundleName string) string {
+	return "ca-" + caBundleName
+}
+
+// dnsPodLabels returns the labels for the pods of a workload of the given DNS
+// with the given selector, which are the labels that the selector requires
+// and the label that the dns service selects.
+func dnsPodLabels(dns *operatorv1.DNS, selector *metav1.LabelSelector) map[string]string {
+	podLabels := map[string]string{}
+	for k, v := range selector.MatchLabels {
+		podLabels[k] = v
+	}
+	for k, v := range DNSServingPodSelector(dns).MatchLabels {
+		podLabels[k] = v
+	}
+	return podLabels
+}
+
 // nodeSelectorForDNS takes a dns and returns the node selector that it
 // specifies, or a default node selector if it doesn't specify one.
 func nodeSelectorForDNS(dns *operatorv1.DNS) map[string]string {
@@ -294,28 +367,50 @@ func (r *reconciler) createDNSDaemonSet(daemonset *appsv1.DaemonSet) error {
 }
 
 // updateDNSDaemonSet updates a dns daemonset.
-func (r *reconciler) updateDNSDaemonSet(current, desired *appsv1.DaemonSet) (bool, error) {
+func (r *reconciler) updateDNSDaemonSet(dns *operatorv1.DNS, current, desired *appsv1.DaemonSet) (bool, error) {
+	// Only refusals to update the dns daemonset, not the corefile canary
+	// daemonset, are reported in the status of the DNS.
+	reportRefusal := current.Name == DNSDaemonSetName(dns).Name
+	hash := desiredStateHash(desired.Spec)
 	changed, updated := daemonsetConfigChanged(current, desired)
 	if !changed {
-		return false, nil
+		if reportRefusal {
+			r.updateSafetyStatuses.set(dns, nil)
+		}
+		return r.seedDesiredStateHash("DaemonSet", current, hash)
 	}
+	drifted := driftDetected(current, hash)
 
-	if safe, reason, err := r.daemonsetUpdateIsSafe(current, updated); err != nil {
+	if refused, err := r.daemonsetUpdateIsSafe(dns, current, updated); err != nil {
 		return false, err
-	} else if !safe {
+	} else if refused != nil {
+		if reportRefusal {
+			r.updateSafetyStatuses.set(dns, refused)
+		}
 		ignored := updated.DeepCopy()
-		updated.Spec.Template.Spec.Tolerations = current.Spec.Template.Spec.Tolerations
-		updated.Spec.Template.Spec.NodeSelector = current.Spec.Template.Spec.NodeSelector
+		revertDaemonSetPlacement(current, updated)
 		diff := cmp.Diff(updated, ignored, cmpopts.EquateEmpty())
-		logrus.Warnf("skipping unsafe update to the node-placement parameters for dns daemonset %s/%s: %s; ignored: %v", updated.Namespace, updated.Name, reason, diff)
+		logrus.Warnf("skipping unsafe update to the node-placement parameters for dns daemonset %s/%s: %s; ignored: %v", updated.Namespace, updated.Name, refused.message(), diff)
 		changed, _ := daemonsetConfigChanged(current, updated)
 		if !changed {
 			return false, nil
 		}
+		// The update leaves the daemonset short of the desired
+		// state, so the hash must not claim otherwise.
+		hash = ""
+		delete(updated.Annotations, desiredStateHashAnnotationKey)
+	} else if reportRefusal {
+		r.updateSafetyStatuses.set(dns, nil)
 	}
 
 	// Diff before updating because the client may mutate the object.
 	diff := cmp.Diff(current, updated, cmpopts.EquateEmpty())
+	if drifted {
+		r.reportDrift(dns, "DaemonSet", current, diff)
+	}
+	if len(hash) != 0 {
+		setDesiredStateHash(updated, hash)
+	}
 	if err := r.client.Update(context.TODO(), updated); err != nil {
 		return false, fmt.Errorf("failed to update dns daemonset %s/%s: %v", updated.Namespace, updated.Name, err)
 	}
@@ -323,66 +418,6 @@ func (r *reconciler) updateDNSDaemonSet(current, desired *appsv1.DaemonSet) (boo
 	return true, nil
 }
 
-// daemonsetUpdateIsSafe takes current and updated daemonsets, checks if the
-// update is safe, and returns a Boolean value and a string value indicating
-// whether the update is safe or the reason why it is not.
-func (r *reconciler) daemonsetUpdateIsSafe(current, updated *appsv1.DaemonSet) (bool, string, error) {
-	name := types.NamespacedName{
-		Namespace: current.Namespace,
-		Name:      current.Name,
-	}
-	// Allow the update if the current daemonset doesn't even have a
-	// valid selector.
-	selector, err := metav1.LabelSelectorAsSelector(current.Spec.Selector)
-	if err != nil {
-		logrus.Warningf("daemonset %q has an invalid spec.selector: %v", name, err)
-		return true, "", nil
-	}
-	listOpts := []client.ListOption{
-		client.MatchingLabelsSelector{Selector: selector},
-		client.InNamespace(current.Namespace),
-	}
-	podList := &corev1.PodList{}
-	if err := r.cache.List(context.TODO(), podList, listOpts...); err != nil {
-		return false, "", fmt.Errorf("failed to list the daemonset's pods: %w", err)
-	}
-	// Allow the update if the current daemonset has 0 pods as the update
-	// cannot reduce the number of pods below 0.
-	if len(podList.Items) == 0 {
-		logrus.Warningf("daemonset %q has 0 pods", name)
-		return true, "", nil
-	}
-	nodeLabelSelector := &metav1.LabelSelector{
-		MatchLabels: updated.Spec.Template.Spec.NodeSelector,
-	}
-	nodeSelector, err := metav1.LabelSelectorAsSelector(nodeLabelSelector)
-	if err != nil {
-		return false, fmt.Sprintf("daemonset %q has an invalid spec.template.spec.nodeSelector: %v", name, err), nil
-	}
-	listOpts = []client.ListOption{
-		client.MatchingLabelsSelector{Selector: nodeSelector},
-	}
-	nodeList := &corev1.NodeList{}
-	if err := r.cache.List(context.TODO(), nodeList, listOpts...); err != nil {
-		return false, "", fmt.Errorf("failed to list nodes: %w", err)
-	}
-	for _, node := range nodeList.Items {
-		if node.Spec.Unschedulable {
-			continue
-		}
-		taints := node.Spec.Taints
-		tolerations := updated.Spec.Template.Spec.Tolerations
-		if !tolerationsTolerateTaints(tolerations, taints) {
-			continue
-		}
-		// We have found a node to which it should be possible to
-		// schedule pods for the updated daemonset, so the update
-		// can be considered safe.
-		return true, "", nil
-	}
-	return false, "updated daemonset's node placement parameters match 0 schedulable nodes", nil
-}
-
 func tolerationsTolerateTaints(tolerations []corev1.Toleration, taints []corev1.Taint) bool {
 	for _, taint := range taints {
 		tolerated := false
@@ -456,8 +491,6 @@ func daemonsetConfigChanged(current, expected *appsv1.DaemonSet) (bool, *appsv1.
 			}
 		}
 	}
-	// TODO: Also check Env?
-
 	if updated.Spec.Template.Annotations == nil {
 		updated.Spec.Template.Annotations = map[string]string{}
 	}
@@ -473,10 +506,26 @@ func daemonsetConfigChanged(current, expected *appsv1.DaemonSet) (bool, *appsv1.
 		}
 	}
 
+	// The selector is immutable, but pods may gain labels, such as the
+	// serving label, that the selector does not require.
+	for k, v := range expected.Spec.Template.Labels {
+		if currentVal, have := current.Spec.Template.Labels[k]; !have || currentVal != v {
+			if updated.Spec.Template.Labels == nil {
+				updated.Spec.Template.Labels = map[string]string{}
+			}
+			updated.Spec.Template.Labels[k] = v
+			changed = true
+		}
+	}
+
 	if !cmp.Equal(current.Spec.Template.Spec.NodeSelector, expected.Spec.Template.Spec.NodeSelector, cmpopts.EquateEmpty()) {
 		updated.Spec.Template.Spec.NodeSelector = expected.Spec.Template.Spec.NodeSelector
 		changed = true
 	}
+	if !cmp.Equal(current.Spec.Template.Spec.Affinity, expected.Spec.Template.Spec.Affinity, cmpopts.EquateEmpty()) {
+		updated.Spec.Template.Spec.Affinity = expected.Spec.Template.Spec.Affinity
+		changed = true
+	}
 	if !cmp.Equal(current.Spec.Template.Spec.TerminationGracePeriodSeconds, expected.Spec.Template.Spec.TerminationGracePeriodSeconds, cmpopts.EquateEmpty(), cmp.Comparer(cmpTerminationGracePeriodSeconds)) {
 		updated.Spec.Template.Spec.TerminationGracePeriodSeconds = expected.Spec.Template.Spec.TerminationGracePeriodSeconds
 		changed = true
@@ -485,12 +534,12 @@ func daemonsetConfigChanged(current, expected *appsv1.DaemonSet) (bool, *appsv1.
 		updated.Spec.Template.Spec.Tolerations = expected.Spec.Template.Spec.Tolerations
 		changed = true
 	}
-	if !cmp.Equal(current.Spec.Template.Spec.Volumes, expected.Spec.Template.Spec.Volumes, cmpopts.EquateEmpty(), cmp.Comparer(cmpConfigMapVolumeSource), cmp.Comparer(cmpSecretVolumeSource)) {
+	if !cmp.Equal(current.Spec.Template.Spec.Volumes, expected.Spec.Template.Spec.Volumes, cmpopts.EquateEmpty(), cmp.Comparer(cmpConfigMapVolumeSource), cmp.Comparer(cmpSecretVolumeSource), cmp.Comparer(cmpProjectedVolumeSource)) {
 		updated.Spec.Template.Spec.Volumes = expected.Spec.Template.Spec.Volumes
 		changed = true
 	}
 
-	// Detect changes to container commands and volume mounts
+	// Detect changes to container commands, volume mounts, and lifecycle hooks
 	if len(current.Spec.Template.Spec.Containers) != len(expected.Spec.Template.Spec.Containers) {
 		updated.Spec.Template.Spec.Containers = expected.Spec.Template.Spec.Containers
 		changed = true
@@ -507,20 +556,48 @@ func daemonsetConfigChanged(current, expected *appsv1.DaemonSet) (bool, *appsv1.
 				changed = true
 				break
 			}
+			if !cmp.Equal(a.Env, b.Env, cmpopts.EquateEmpty()) {
+				updated.Spec.Template.Spec.Containers = expected.Spec.Template.Spec.Containers
+				changed = true
+				break
+			}
 			if !cmp.Equal(a.VolumeMounts, b.VolumeMounts, cmpopts.EquateEmpty()) {
 				updated.Spec.Template.Spec.Containers = expected.Spec.Template.Spec.Containers
 				changed = true
 				break
 			}
+			if !cmp.Equal(a.Resources, b.Resources, cmpopts.EquateEmpty(), cmp.Comparer(cmpQuantity)) {
+				updated.Spec.Template.Spec.Containers = expected.Spec.Template.Spec.Containers
+				changed = true
+				break
+			}
+			if !cmp.Equal(a.Lifecycle, b.Lifecycle, cmpopts.EquateEmpty()) {
+				updated.Spec.Template.Spec.Containers = expected.Spec.Template.Spec.Containers
+				changed = true
+				break
+			}
 		}
 	}
 
+	// A daemonset patch from unsupported config overrides may change any
+	// field, so replace the whole spec when the patch changes.
+	if current.Spec.Template.Annotations[unsupportedConfigOverridesHashAnnotationKey] != expected.Spec.Template.Annotations[unsupportedConfigOverridesHashAnnotationKey] {
+		updated.Spec = *expected.Spec.DeepCopy()
+		changed = true
+	}
+
 	if !changed {
 		return false, nil
 	}
 	return true, updated
 }
 
+// cmpQuantity compares two resource quantities and returns a Boolean
+// indicating whether they are equal.
+func cmpQuantity(a, b resource.Quantity) bool {
+	return a.Cmp(b) == 0
+}
+
 // cmpConfigMapVolumeSource compares two configmap volume source values and
 // returns a Boolean indicating whether they are equal.
 func cmpConfigMapVolumeSource(a, b corev1.ConfigMapVolumeSource) bool {
@@ -547,6 +624,23 @@ func cmpConfigMapVolumeSource(a, b corev1.ConfigMapVolumeSource) bool {
 	return true
 }
 
+// cmpProjectedVolumeSource compares two projected volume source values and
+// returns a Boolean indicating whether they are equal.
+func cmpProjectedVolumeSource(a, b corev1.ProjectedVolumeSource) bool {
+	if !cmp.Equal(a.Sources, b.Sources, cmpopts.EquateEmpty()) {
+		return false
+	}
+	aDefaultMode := corev1.ProjectedVolumeSourceDefaultMode
+	if a.DefaultMode != nil {
+		aDefaultMode = *a.DefaultMode
+	}
+	bDefaultMode := corev1.ProjectedVolumeSourceDefaultMode
+	if b.DefaultMode != nil {
+		bDefaultMode = *b.DefaultMode
+	}
+	return aDefaultMode == bDefaultMode
+}
+
 // cmpSecretVolumeSource compares two secret volume source values and returns a
 // Boolean indicating whether they are equal.
 func cmpSecretVolumeSource(a, b corev1.SecretVolumeSource) bool {
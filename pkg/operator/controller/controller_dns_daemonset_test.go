@@ -11,6 +11,7 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
@@ -48,6 +49,7 @@ func TestDesiredDNSDaemonset(t *testing.T) {
 		actualPodAnnotations := ds.Spec.Template.Annotations
 		expectedPodLabels := map[string]string{
 			"dns.operator.openshift.io/daemonset-dns": "default",
+			"dns.operator.openshift.io/serves-dns":    "default",
 		}
 		actualPodLabels := ds.Spec.Template.Labels
 		expectedNodeSelector := map[string]string{"kubernetes.io/os": "linux"}
@@ -163,9 +165,9 @@ func TestDesiredDNSDaemonsetWithCABundleConfigMaps(t *testing.T) {
 	}
 
 	cmMap := make(map[string]string)
-	cmMap["caBundle1"] = "ca-caBundle1-10"
-	cmMap["caBundle2"] = "ca-caBundle2-20"
-	cmMap["caBundle3"] = "ca-caBundle3-30"
+	cmMap["caBundle1"] = "1010101010101010"
+	cmMap["caBundle2"] = "2020202020202020"
+	cmMap["caBundle3"] = "3030303030303030"
 
 	if ds, err := desiredDNSDaemonSet(dns, coreDNSImage, kubeRBACProxyImage, cmMap, nil); err != nil {
 		t.Errorf("invalid dns daemonset: %v", err)
@@ -199,14 +201,14 @@ func TestDesiredDNSDaemonsetWithCABundleConfigMaps(t *testing.T) {
 			"ca-caBundle1": {
 				Name: "ca-caBundle1",
 				VolumeSource: corev1.VolumeSource{
-					ConfigMap: &corev1.ConfigMapVolumeSource{
-						LocalObjectReference: corev1.LocalObjectReference{
-							Name: "ca-caBundle1",
-						},
-						Items: []corev1.KeyToPath{
+					Projected: &corev1.ProjectedVolumeSource{
+						Sources: []corev1.VolumeProjection{
 							{
-								Key:  caBundleFileName,
-								Path: caBundleFileName,
+								ConfigMap: &corev1.ConfigMapProjection{
+									LocalObjectReference: corev1.LocalObjectReference{
+										Name: "ca-default-caBundle1",
+									},
+								},
 							},
 						},
 					},
@@ -215,14 +217,14 @@ func TestDesiredDNSDaemonsetWithCABundleConfigMaps(t *testing.T) {
 			"ca-caBundle2": {
 				Name: "ca-caBundle2",
 				VolumeSource: corev1.VolumeSource{
-					ConfigMap: &corev1.ConfigMapVolumeSource{
-						LocalObjectReference: corev1.LocalObjectReference{
-							Name: "ca-caBundle2",
-						},
-						Items: []corev1.KeyToPath{
+					Projected: &corev1.ProjectedVolumeSource{
+						Sources: []corev1.VolumeProjection{
 							{
-								Key:  caBundleFileName,
-								Path: caBundleFileName,
+								ConfigMap: &corev1.ConfigMapProjection{
+									LocalObjectReference: corev1.LocalObjectReference{
+										Name: "ca-default-caBundle2",
+									},
+								},
 							},
 						},
 					},
@@ -231,14 +233,14 @@ func TestDesiredDNSDaemonsetWithCABundleConfigMaps(t *testing.T) {
 			"ca-caBundle3": {
 				Name: "ca-caBundle3",
 				VolumeSource: corev1.VolumeSource{
-					ConfigMap: &corev1.ConfigMapVolumeSource{
-						LocalObjectReference: corev1.LocalObjectReference{
-							Name: "ca-caBundle3",
-						},
-						Items: []corev1.KeyToPath{
+					Projected: &corev1.ProjectedVolumeSource{
+						Sources: []corev1.VolumeProjection{
 							{
-								Key:  caBundleFileName,
-								Path: caBundleFileName,
+								ConfigMap: &corev1.ConfigMapProjection{
+									LocalObjectReference: corev1.LocalObjectReference{
+										Name: "ca-default-caBundle3",
+									},
+								},
 							},
 						},
 					},
@@ -260,17 +262,17 @@ func TestDesiredDNSDaemonsetWithCABundleConfigMaps(t *testing.T) {
 			},
 			"ca-caBundle1": {
 				Name:      "ca-caBundle1",
-				MountPath: "/etc/pki/dns.foo.com-ca-caBundle1-10",
+				MountPath: "/etc/pki/dns.foo.com-ca-caBundle1",
 				ReadOnly:  true,
 			},
 			"ca-caBundle2": {
 				Name:      "ca-caBundle2",
-				MountPath: "/etc/pki/dns.bar.com-ca-caBundle2-20",
+				MountPath: "/etc/pki/dns.bar.com-ca-caBundle2",
 				ReadOnly:  true,
 			},
 			"ca-caBundle3": {
 				Name:      "ca-caBundle3",
-				MountPath: "/etc/pki/example.com-ca-caBundle3-30",
+				MountPath: "/etc/pki/example.com-ca-caBundle3",
 				ReadOnly:  true,
 			},
 			"tmp-dir": {
@@ -391,6 +393,16 @@ func TestDaemonsetConfigChanged(t *testing.T) {
 			},
 			expect: true,
 		},
+		{
+			description: "if a pod label is added",
+			mutate: func(daemonset *appsv1.DaemonSet) {
+				if daemonset.Spec.Template.Labels == nil {
+					daemonset.Spec.Template.Labels = map[string]string{}
+				}
+				daemonset.Spec.Template.Labels[dnsServingLabel] = "default"
+			},
+			expect: true,
+		},
 		{
 			description: "if the dns container image is changed",
 			mutate: func(daemonset *appsv1.DaemonSet) {
@@ -426,6 +438,22 @@ func TestDaemonsetConfigChanged(t *testing.T) {
 			},
 			expect: true,
 		},
+		{
+			description: "if a container's resource requests change",
+			mutate: func(daemonset *appsv1.DaemonSet) {
+				daemonset.Spec.Template.Spec.Containers[0].Resources.Requests = corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse("200m"),
+				}
+			},
+			expect: true,
+		},
+		{
+			description: "if a container's env changes",
+			mutate: func(daemonset *appsv1.DaemonSet) {
+				daemonset.Spec.Template.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "GOMAXPROCS", Value: "2"}}
+			},
+			expect: true,
+		},
 		{
 			description: "if an unexpected additional container is added",
 			mutate: func(daemonset *appsv1.DaemonSet) {
@@ -469,6 +497,22 @@ func TestDaemonsetConfigChanged(t *testing.T) {
 			},
 			expect: true,
 		},
+		{
+			description: "if the ca bundle volume default mode value is defaulted",
+			mutate: func(daemonset *appsv1.DaemonSet) {
+				newVal := corev1.ProjectedVolumeSourceDefaultMode
+				daemonset.Spec.Template.Spec.Volumes[2].Projected.DefaultMode = &newVal
+			},
+			expect: false,
+		},
+		{
+			description: "if the ca bundle volume default mode value changes",
+			mutate: func(daemonset *appsv1.DaemonSet) {
+				newVal := int32(0)
+				daemonset.Spec.Template.Spec.Volumes[2].Projected.DefaultMode = &newVal
+			},
+			expect: true,
+		},
 		{
 			description: "if the readiness probe endpoint changes",
 			mutate: func(daemonset *appsv1.DaemonSet) {
@@ -636,6 +680,22 @@ func TestDaemonsetConfigChanged(t *testing.T) {
 									},
 								},
 							},
+							{
+								Name: "ca-cacerts",
+								VolumeSource: corev1.VolumeSource{
+									Projected: &corev1.ProjectedVolumeSource{
+										Sources: []corev1.VolumeProjection{
+											{
+												ConfigMap: &corev1.ConfigMapProjection{
+													LocalObjectReference: corev1.LocalObjectReference{
+														Name: "ca-default-cacerts",
+													},
+												},
+											},
+										},
+									},
+								},
+							},
 						},
 					},
 				},
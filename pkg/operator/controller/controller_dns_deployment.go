@@ -0,0 +1,582 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	configv1 "github.com/openshift/api/config/v1"
+	operatorv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/cluster-dns-operator/pkg/manifests"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/sirupsen/logrus"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	// dnsWorkloadAnnotationKey is the annotation on a DNS that configures
+	// the workload that runs CoreDNS.  The value is a JSON object with the
+	// following fields:
+	//
+	//   - "kind" is "DaemonSet" or "Deployment".  The default is
+	//     "DaemonSet", which runs a CoreDNS pod on every node.
+	//   - "coresPerReplica" and "nodesPerReplica" size the deployment in
+	//     proportion to the nodes that can run CoreDNS, as the linear mode
+	//     of the cluster-proportional-autoscaler does: the deployment has
+	//     one replica for every coresPerReplica CPU cores or for every
+	//     nodesPerReplica nodes, whichever gives more replicas.  The
+	//     defaults are 256 and 16.
+	//   - "minReplicas" and "maxReplicas" bound the number of replicas.
+	//     The default minimum is 1, and a maximum of 0, the default, means
+	//     no maximum.
+	//   - "preventSinglePointFailure" is a Boolean value that indicates
+	//     whether the deployment has at least 2 replicas if more than one
+	//     node can run CoreDNS.  The default is true.
+	//
+	// In the Deployment mode, the pods of the deployment spread across
+	// nodes and zones, and a pod disruption budget allows at most one of
+	// them to be evicted at a time.  When the mode changes, the operator
+	// keeps the previous workload until the new one is fully available so
+	// that the DNS service never lacks endpoints, and then deletes it.
+	dnsWorkloadAnnotationKey = "dns.operator.openshift.io/workload"
+
+	// dnsWorkloadKindDaemonSet runs CoreDNS in a daemonset.
+	dnsWorkloadKindDaemonSet = "DaemonSet"
+	// dnsWorkloadKindDeployment runs CoreDNS in a deployment.
+	dnsWorkloadKindDeployment = "Deployment"
+
+	// defaultDNSDeploymentCoresPerReplica is the default number of CPU
+	// cores per replica of the dns deployment.
+	defaultDNSDeploymentCoresPerReplica = 256
+	// defaultDNSDeploymentNodesPerReplica is the default number of nodes
+	// per replica of the dns deployment.
+	defaultDNSDeploymentNodesPerReplica = 16
+	// defaultDNSDeploymentMinReplicas is the default minimum number of
+	// replicas of the dns deployment.
+	defaultDNSDeploymentMinReplicas = 1
+)
+
+// dnsWorkloadConfig is the workload configuration of a DNS.
+type dnsWorkloadConfig struct {
+	// Kind is DaemonSet or Deployment.
+	Kind string `json:"kind,omitempty"`
+	// CoresPerReplica is the number of CPU cores per replica.
+	CoresPerReplica *float64 `json:"coresPerReplica,omitempty"`
+	// NodesPerReplica is the number of nodes per replica.
+	NodesPerReplica *float64 `json:"nodesPerReplica,omitempty"`
+	// MinReplicas is the minimum number of replicas.
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+	// MaxReplicas is the maximum number of replicas, or 0 for no maximum.
+	MaxReplicas int32 `json:"maxReplicas,omitempty"`
+	// PreventSinglePointFailure indicates whether the deployment has at
+	// least 2 replicas if more than one node can run CoreDNS.
+	PreventSinglePointFailure *bool `json:"preventSinglePointFailure,omitempty"`
+}
+
+// dnsWorkload is the workload that runs CoreDNS for a DNS.
+type dnsWorkload struct {
+	// daemonset is the dns daemonset, or a daemonset that reflects the
+	// dns deployment, from which the operator computes the status of the
+	// DNS.
+	daemonset *appsv1.DaemonSet
+	// ref refers to the workload, which owns the DNS service monitor.
+	ref metav1.OwnerReference
+	// retire is the kind of the previous workload if the migration from
+	// it is complete and it should be deleted, or else empty.
+	retire string
+}
+
+// dnsWorkloadConfigForDNS parses and validates the workload configuration of
+// the given DNS and fills in defaults.
+func dnsWorkloadConfigForDNS(dns *operatorv1.DNS) (dnsWorkloadConfig, error) {
+	config := dnsWorkloadConfig{}
+	value, ok := dns.Annotations[dnsWorkloadAnnotationKey]
+	if ok && len(strings.TrimSpace(value)) != 0 {
+		if err := json.Unmarshal([]byte(value), &config); err != nil {
+			return dnsWorkloadConfig{}, fmt.Errorf("failed to parse annotation %s: %w", dnsWorkloadAnnotationKey, err)
+		}
+	}
+	switch config.Kind {
+	case "":
+		config.Kind = dnsWorkloadKindDaemonSet
+	case dnsWorkloadKindDaemonSet, dnsWorkloadKindDeployment:
+	default:
+		return dnsWorkloadConfig{}, fmt.Errorf("invalid annotation %s: kind %q must be %q or %q", dnsWorkloadAnnotationKey, config.Kind, dnsWorkloadKindDaemonSet, dnsWorkloadKindDeployment)
+	}
+	for _, p := range []struct {
+		name     string
+		value    **float64
+		fallback float64
+	}{
+		{"coresPerReplica", &config.CoresPerReplica, defaultDNSDeploymentCoresPerReplica},
+		{"nodesPerReplica", &config.NodesPerReplica, defaultDNSDeploymentNodesPerReplica},
+	} {
+		if *p.value == nil {
+			fallback := p.fallback
+			*p.value = &fallback
+		} else if v := **p.value; v <= 0 {
+			return dnsWorkloadConfig{}, fmt.Errorf("invalid annotation %s: %s %v must be positive", dnsWorkloadAnnotationKey, p.name, v)
+		}
+	}
+	if config.MinReplicas == nil {
+		minReplicas := int32(defaultDNSDeploymentMinReplicas)
+		config.MinReplicas = &minReplicas
+	} else if *config.MinReplicas < 1 {
+		return dnsWorkloadConfig{}, fmt.Errorf("invalid annotation %s: minReplicas %d must be at least 1", dnsWorkloadAnnotationKey, *config.MinReplicas)
+	}
+	if config.MaxReplicas < 0 {
+		return dnsWorkloadConfig{}, fmt.Errorf("invalid annotation %s: maxReplicas %d must not be negative", dnsWorkloadAnnotationKey, config.MaxReplicas)
+	}
+	if config.MaxReplicas != 0 && config.MaxReplicas < *config.MinReplicas {
+		return dnsWorkloadConfig{}, fmt.Errorf("invalid annotation %s: maxReplicas %d must not be less than minReplicas %d", dnsWorkloadAnnotationKey, config.MaxReplicas, *config.MinReplicas)
+	}
+	if config.PreventSinglePointFailure == nil {
+		preventSinglePointFailure := true
+		config.PreventSinglePointFailure = &preventSinglePointFailure
+	}
+	return config, nil
+}
+
+// dnsDeploymentNodes returns the nodes that match the node selector and
+// tolerate the tolerations of the given DNS.  Cordoned nodes are included so
+// that draining nodes, for example during an upgrade, does not scale down the
+// dns deployment.
+func dnsDeploymentNodes(dns *operatorv1.DNS, nodes []corev1.Node) []corev1.Node {
+	selector := labels.SelectorFromSet(nodeSelectorForDNS(dns))
+	tolerations := tolerationsForDNS(dns)
+	eligible := []corev1.Node{}
+	for _, node := range nodes {
+		if !selector.Matches(labels.Set(node.Labels)) {
+			continue
+		}
+		taints := []corev1.Taint{}
+		for _, taint := range node.Spec.Taints {
+			if taint.Key == corev1.TaintNodeUnschedulable || taint.Effect == corev1.TaintEffectPreferNoSchedule {
+				continue
+			}
+			taints = append(taints, taint)
+		}
+		if !tolerationsTolerateTaints(tolerations, taints) {
+			continue
+		}
+		eligible = append(eligible, node)
+	}
+	return eligible
+}
+
+// dnsDeploymentReplicas returns the number of replicas of the dns deployment
+// for the given configuration and the given nodes that can run CoreDNS.
+func dnsDeploymentReplicas(config dnsWorkloadConfig, nodes []corev1.Node) int32 {
+	var milliCores int64
+	for _, node := range nodes {
+		milliCores += node.Status.Capacity.Cpu().MilliValue()
+	}
+	byCores := math.Ceil(float64(milliCores) / 1000 / *config.CoresPerReplica)
+	byNodes := math.Ceil(float64(len(nodes)) / *config.NodesPerReplica)
+	replicas := int32(math.Max(byCores, byNodes))
+	if *config.PreventSinglePointFailure && len(nodes) > 1 && replicas < 2 {
+		replicas = 2
+	}
+	if replicas < *config.MinReplicas {
+		replicas = *config.MinReplicas
+	}
+	if config.MaxReplicas != 0 && replicas > config.MaxReplicas {
+		replicas = config.MaxReplicas
+	}
+	return replicas
+}
+
+// desiredDNSDeployment returns the desired dns deployment, which runs the pod
+// template of the given desired dns daemonset with the given number of
+// replicas.
+func desiredDNSDeployment(dns *operatorv1.DNS, daemonset *appsv1.DaemonSet, replicas int32) *appsv1.Deployment {
+	name := DNSDeploymentName(dns)
+	template := daemonset.Spec.Template.DeepCopy()
+	// A deployment's pods are evicted like any other pods.
+	delete(template.Annotations, enableDaemonSetEvictionAnnotationKey)
+	selector := DNSDeploymentPodSelector(dns)
+	template.Labels = dnsPodLabels(dns, selector)
+	template.Spec.TopologySpreadConstraints = []corev1.TopologySpreadConstraint{{
+		MaxSkew:           1,
+		TopologyKey:       corev1.LabelHostname,
+		WhenUnsatisfiable: corev1.DoNotSchedule,
+		LabelSelector:     selector,
+	}, {
+		MaxSkew:           1,
+		TopologyKey:       corev1.LabelTopologyZone,
+		WhenUnsatisfiable: corev1.ScheduleAnyway,
+		LabelSelector:     selector,
+	}}
+	// Roll out with the daemonset's parameters, which surge rather than
+	// take down pods by default so that a deployment with few replicas
+	// keeps its capacity.
+	var rollingUpdate appsv1.RollingUpdateDeployment
+	if daemonset.Spec.UpdateStrategy.RollingUpdate != nil {
+		rollingUpdate.MaxSurge = daemonset.Spec.UpdateStrategy.RollingUpdate.MaxSurge
+		rollingUpdate.MaxUnavailable = daemonset.Spec.UpdateStrategy.RollingUpdate.MaxUnavailable
+	}
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            name.Name,
+			Namespace:       name.Namespace,
+			Labels:          daemonset.Labels,
+			OwnerReferences: []metav1.OwnerReference{dnsOwnerRef(dns)},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas:        &replicas,
+			Selector:        selector,
+			Template:        *template,
+			MinReadySeconds: daemonset.Spec.MinReadySeconds,
+			Strategy: appsv1.DeploymentStrategy{
+				Type:          appsv1.RollingUpdateDeploymentStrategyType,
+				RollingUpdate: &rollingUpdate,
+			},
+		},
+	}
+}
+
+// desiredDNSPodDisruptionBudget returns the desired pod disruption budget for
+// the dns deployment.
+func desiredDNSPodDisruptionBudget(dns *operatorv1.DNS) *policyv1.PodDisruptionBudget {
+	name := DNSPodDisruptionBudgetName(dns)
+	maxUnavailable := intstr.FromInt32(1)
+	return &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name.Name,
+			Namespace: name.Namespace,
+			Labels: map[string]string{
+				manifests.OwningDNSLabel: DNSDaemonSetLabel(dns),
+			},
+			OwnerReferences: []metav1.OwnerReference{dnsOwnerRef(dns)},
+		},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			// The selector must not match daemonset pods, for which the
+			// disruption controller cannot compute the expected number
+			// of pods.
+			Selector:       DNSDeploymentPodSelector(dns),
+			MaxUnavailable: &maxUnavailable,
+		},
+	}
+}
+
+// DNSDeploymentAsDaemonSet returns a daemonset with the pod template and a
+// status that reflect the given dns deployment so that status computations for
+// the dns daemonset apply to the dns deployment.
+func DNSDeploymentAsDaemonSet(deployment *appsv1.Deployment) *appsv1.DaemonSet {
+	desired := int32(1)
+	if deployment.Spec.Replicas != nil {
+		desired = *deployment.Spec.Replicas
+	}
+	var updateStrategy appsv1.DaemonSetUpdateStrategy
+	if rollingUpdate := deployment.Spec.Strategy.RollingUpdate; rollingUpdate != nil {
+		updateStrategy = appsv1.DaemonSetUpdateStrategy{
+			Type: appsv1.RollingUpdateDaemonSetStrategyType,
+			RollingUpdate: &appsv1.RollingUpdateDaemonSet{
+				MaxSurge:       rollingUpdate.MaxSurge,
+				MaxUnavailable: rollingUpdate.MaxUnavailable,
+			},
+		}
+	}
+	return &appsv1.DaemonSet{
+		ObjectMeta: *deployment.ObjectMeta.DeepCopy(),
+		Spec: appsv1.DaemonSetSpec{
+			Selector:        deployment.Spec.Selector.DeepCopy(),
+			Template:        *deployment.Spec.Template.DeepCopy(),
+			MinReadySeconds: deployment.Spec.MinReadySeconds,
+			UpdateStrategy:  updateStrategy,
+		},
+		Status: appsv1.DaemonSetStatus{
+			CurrentNumberScheduled: deployment.Status.Replicas,
+			DesiredNumberScheduled: desired,
+			NumberReady:            deployment.Status.ReadyReplicas,
+			UpdatedNumberScheduled: deployment.Status.UpdatedReplicas,
+			NumberAvailable:        deployment.Status.AvailableReplicas,
+			NumberUnavailable:      deployment.Status.UnavailableReplicas,
+			ObservedGeneration:     deployment.Status.ObservedGeneration,
+		},
+	}
+}
+
+// daemonsetIsAvailable returns a Boolean value indicating whether the given
+// daemonset has rolled out and all of its pods are available.
+func daemonsetIsAvailable(daemonset *appsv1.DaemonSet) bool {
+	status := daemonset.Status
+	return status.ObservedGeneration >= daemonset.Generation &&
+		status.DesiredNumberScheduled > 0 &&
+		status.UpdatedNumberScheduled >= status.DesiredNumberScheduled &&
+		status.NumberAvailable >= status.DesiredNumberScheduled
+}
+
+// deploymentIsAvailable returns a Boolean value indicating whether the given
+// deployment has rolled out and all of its replicas are available.
+func deploymentIsAvailable(deployment *appsv1.Deployment) bool {
+	return daemonsetIsAvailable(DNSDeploymentAsDaemonSet(deployment))
+}
+
+// workloadRef returns a controller reference to the given workload.
+func workloadRef(kind string, workload metav1.Object) metav1.OwnerReference {
+	trueVar := true
+	return metav1.OwnerReference{
+		APIVersion: "apps/v1",
+		Kind:       kind,
+		Name:       workload.GetName(),
+		UID:        workload.GetUID(),
+		Controller: &trueVar,
+	}
+}
+
+// ensureDNSWorkload ensures that the workload that the given DNS's workload
+// configuration specifies runs CoreDNS, migrating from the other workload if
+// necessary, and returns the workload from which the operator computes the
+// status of the DNS.
+func (r *reconciler) ensureDNSWorkload(dns *operatorv1.DNS, caBundleRevisionMap map[string]string, tlsSecurityProfile *configv1.TLSSecurityProfile, pendingNodePools sets.String) (bool, *dnsWorkload, error) {
+	config, err := dnsWorkloadConfigForDNS(dns)
+	if err != nil {
+		// Leave the workloads alone rather than migrating because of
+		// a typo in the annotation.
+		have, workload, currentErr := r.currentDNSWorkload(dns)
+		if currentErr != nil {
+			return false, nil, currentErr
+		}
+		return have, workload, err
+	}
+
+	haveDeployment, deployment, err := r.currentDNSDeployment(dns)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if config.Kind == dnsWorkloadKindDaemonSet {
+		haveDS, daemonset, err := r.ensureDNSDaemonSet(dns, caBundleRevisionMap, tlsSecurityProfile, pendingNodePools)
+		if err != nil || !haveDS {
+			return false, nil, err
+		}
+		if !haveDeployment {
+			return true, &dnsWorkload{daemonset: daemonset, ref: workloadRef("DaemonSet", daemonset)}, nil
+		}
+		if !daemonsetIsAvailable(daemonset) {
+			// Keep serving from the deployment until the daemonset
+			// is ready to take over.
+			return true, &dnsWorkload{daemonset: DNSDeploymentAsDaemonSet(deployment), ref: workloadRef("Deployment", deployment)}, nil
+		}
+		return true, &dnsWorkload{daemonset: daemonset, ref: workloadRef("DaemonSet", daemonset), retire: dnsWorkloadKindDeployment}, nil
+	}
+
+	haveDeployment, deployment, err = r.ensureDNSDeployment(dns, config, haveDeployment, deployment, caBundleRevisionMap, tlsSecurityProfile, pendingNodePools)
+	if err != nil || !haveDeployment {
+		return false, nil, err
+	}
+	if err := r.ensureDNSPodDisruptionBudget(dns); err != nil {
+		return false, nil, err
+	}
+	haveDS, daemonset, err := r.currentDNSDaemonSet(dns)
+	if err != nil {
+		return false, nil, err
+	}
+	if !haveDS {
+		return true, &dnsWorkload{daemonset: DNSDeploymentAsDaemonSet(deployment), ref: workloadRef("Deployment", deployment)}, nil
+	}
+	if !deploymentIsAvailable(deployment) {
+		// Keep serving from the daemonset until the deployment is
+		// ready to take over.
+		return true, &dnsWorkload{daemonset: daemonset, ref: workloadRef("DaemonSet", daemonset)}, nil
+	}
+	return true, &dnsWorkload{daemonset: DNSDeploymentAsDaemonSet(deployment), ref: workloadRef("Deployment", deployment), retire: dnsWorkloadKindDaemonSet}, nil
+}
+
+// currentDNSWorkload returns the workload that currently runs CoreDNS for the
+// given DNS, preferring the dns daemonset if both workloads exist.
+func (r *reconciler) currentDNSWorkload(dns *operatorv1.DNS) (bool, *dnsWorkload, error) {
+	if haveDS, daemonset, err := r.currentDNSDaemonSet(dns); err != nil {
+		return false, nil, err
+	} else if haveDS {
+		return true, &dnsWorkload{daemonset: daemonset, ref: workloadRef("DaemonSet", daemonset)}, nil
+	}
+	if haveDeployment, deployment, err := r.currentDNSDeployment(dns); err != nil {
+		return false, nil, err
+	} else if haveDeployment {
+		return true, &dnsWorkload{daemonset: DNSDeploymentAsDaemonSet(deployment), ref: workloadRef("Deployment", deployment)}, nil
+	}
+	return false, nil, nil
+}
+
+// ensureDNSWorkloadRetired deletes the given kind of workload for the given
+// DNS after the migration from it is complete.
+func (r *reconciler) ensureDNSWorkloadRetired(dns *operatorv1.DNS, kind string) error {
+	switch kind {
+	case dnsWorkloadKindDaemonSet:
+		return r.ensureDNSDaemonSetDeleted(dns)
+	case dnsWorkloadKindDeployment:
+		return r.ensureDNSDeploymentDeleted(dns)
+	}
+	return nil
+}
+
+// ensureDNSDeployment ensures that the dns deployment exists and is sized for
+// the nodes that can run CoreDNS.
+func (r *reconciler) ensureDNSDeployment(dns *operatorv1.DNS, config dnsWorkloadConfig, haveDeployment bool, current *appsv1.Deployment, caBundleRevisionMap map[string]string, tlsSecurityProfile *configv1.TLSSecurityProfile, pendingNodePools sets.String) (bool, *appsv1.Deployment, error) {
+	daemonset, err := desiredDNSDaemonSet(dns, r.CoreDNSImage, r.KubeRBACProxyImage, caBundleRevisionMap, tlsSecurityProfile)
+	if err != nil {
+		return haveDeployment, current, fmt.Errorf("failed to build dns deployment: %v", err)
+	}
+	if err := keepPendingNodePools(dns, daemonset, pendingNodePools); err != nil {
+		return haveDeployment, current, fmt.Errorf("failed to build dns deployment: %v", err)
+	}
+	var currentDaemonSet *appsv1.DaemonSet
+	if haveDeployment {
+		currentDaemonSet = DNSDeploymentAsDaemonSet(current)
+	}
+	r.applyAutosizedDNSRequests(dns, currentDaemonSet, daemonset)
+
+	nodeList := &corev1.NodeList{}
+	if err := r.cache.List(context.TODO(), nodeList); err != nil {
+		return haveDeployment, current, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	replicas := dnsDeploymentReplicas(config, dnsDeploymentNodes(dns, nodeList.Items))
+	desired := desiredDNSDeployment(dns, daemonset, replicas)
+
+	if !haveDeployment {
+		setDesiredStateHash(desired, desiredStateHash(desired.Spec))
+		if err := r.client.Create(context.TODO(), desired); err != nil {
+			return false, nil, fmt.Errorf("failed to create dns deployment %s/%s: %w", desired.Namespace, desired.Name, err)
+		}
+		logrus.Infof("created dns deployment: %s/%s", desired.Namespace, desired.Name)
+		return r.currentDNSDeployment(dns)
+	}
+	hash := desiredStateHash(desired.Spec)
+	changed, updated := deploymentConfigChanged(current, desired)
+	if !changed {
+		if seeded, err := r.seedDesiredStateHash("Deployment", current, hash); err != nil {
+			return true, current, err
+		} else if seeded {
+			return r.currentDNSDeployment(dns)
+		}
+		return true, current, nil
+	}
+	// Diff before updating because the client may mutate the object.
+	diff := cmp.Diff(current, updated, cmpopts.EquateEmpty())
+	if driftDetected(current, hash) {
+		r.reportDrift(dns, "Deployment", current, diff)
+	}
+	setDesiredStateHash(updated, hash)
+	if err := r.client.Update(context.TODO(), updated); err != nil {
+		return true, current, fmt.Errorf("failed to update dns deployment %s/%s: %w", updated.Namespace, updated.Name, err)
+	}
+	logrus.Infof("updated dns deployment %s/%s: %v", updated.Namespace, updated.Name, diff)
+	return r.currentDNSDeployment(dns)
+}
+
+// currentDNSDeployment returns the current dns deployment.
+func (r *reconciler) currentDNSDeployment(dns *operatorv1.DNS) (bool, *appsv1.Deployment, error) {
+	deployment := &appsv1.Deployment{}
+	if err := r.client.Get(context.TODO(), DNSDeploymentName(dns), deployment); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil, nil
+		}
+		return false, nil, err
+	}
+	return true, deployment, nil
+}
+
+// deploymentConfigChanged checks if the current config matches the expected
+// config for the dns deployment and if not returns the updated config.
+func deploymentConfigChanged(current, expected *appsv1.Deployment) (bool, *appsv1.Deployment) {
+	changed := false
+	updated := current.DeepCopy()
+
+	// The pod template is compared as the dns daemonset's is so that
+	// fields that the API server defaults do not cause updates.
+	if templateChanged, updatedDaemonSet := daemonsetConfigChanged(DNSDeploymentAsDaemonSet(current), DNSDeploymentAsDaemonSet(expected)); templateChanged {
+		updated.Spec.Template = updatedDaemonSet.Spec.Template
+		updated.Spec.MinReadySeconds = updatedDaemonSet.Spec.MinReadySeconds
+		changed = true
+	}
+	if !cmp.Equal(current.Spec.Template.Labels, expected.Spec.Template.Labels, cmpopts.EquateEmpty()) {
+		updated.Spec.Template.Labels = expected.Spec.Template.Labels
+		changed = true
+	}
+	if !cmp.Equal(current.Spec.Template.Spec.TopologySpreadConstraints, expected.Spec.Template.Spec.TopologySpreadConstraints, cmpopts.EquateEmpty()) {
+		updated.Spec.Template.Spec.TopologySpreadConstraints = expected.Spec.Template.Spec.TopologySpreadConstraints
+		changed = true
+	}
+	if !cmp.Equal(current.Spec.Replicas, expected.Spec.Replicas) {
+		updated.Spec.Replicas = expected.Spec.Replicas
+		changed = true
+	}
+	if !cmp.Equal(current.Spec.Strategy, expected.Spec.Strategy, cmpopts.EquateEmpty()) {
+		updated.Spec.Strategy = expected.Spec.Strategy
+		changed = true
+	}
+
+	if !changed {
+		return false, nil
+	}
+	return true, updated
+}
+
+// ensureDNSPodDisruptionBudget ensures that the pod disruption budget for the
+// dns deployment exists and is up to date.
+func (r *reconciler) ensureDNSPodDisruptionBudget(dns *operatorv1.DNS) error {
+	desired := desiredDNSPodDisruptionBudget(dns)
+	current := &policyv1.PodDisruptionBudget{}
+	if err := r.client.Get(context.TODO(), DNSPodDisruptionBudgetName(dns), current); err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to get dns poddisruptionbudget: %w", err)
+		}
+		if err := r.client.Create(context.TODO(), desired); err != nil {
+			return fmt.Errorf("failed to create dns poddisruptionbudget %s/%s: %w", desired.Namespace, desired.Name, err)
+		}
+		logrus.Infof("created dns poddisruptionbudget: %s/%s", desired.Namespace, desired.Name)
+		return nil
+	}
+	if cmp.Equal(current.Spec, desired.Spec, cmpopts.EquateEmpty()) {
+		return nil
+	}
+	updated := current.DeepCopy()
+	updated.Spec = desired.Spec
+	// Diff before updating because the client may mutate the object.
+	diff := cmp.Diff(current, updated, cmpopts.EquateEmpty())
+	if err := r.client.Update(context.TODO(), updated); err != nil {
+		return fmt.Errorf("failed to update dns poddisruptionbudget %s/%s: %w", updated.Namespace, updated.Name, err)
+	}
+	logrus.Infof("updated dns poddisruptionbudget %s/%s: %v", updated.Namespace, updated.Name, diff)
+	return nil
+}
+
+// ensureDNSDeploymentDeleted deletes the dns deployment and its pod disruption
+// budget.
+func (r *reconciler) ensureDNSDeploymentDeleted(dns *operatorv1.DNS) error {
+	name := DNSDeploymentName(dns)
+	deployment := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name}}
+	if err := r.client.Delete(context.TODO(), deployment); err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete dns deployment %s/%s: %w", name.Namespace, name.Name, err)
+		}
+	} else {
+		logrus.Infof("deleted dns deployment: %s/%s", name.Namespace, name.Name)
+	}
+	name = DNSPodDisruptionBudgetName(dns)
+	pdb := &policyv1.PodDisruptionBudget{ObjectMeta: metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name}}
+	if err := r.client.Delete(context.TODO(), pdb); err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete dns poddisruptionbudget %s/%s: %w", name.Namespace, name.Name, err)
+		}
+	} else {
+		logrus.Infof("deleted dns poddisruptionbudget: %s/%s", name.Namespace, name.Name)
+	}
+	return nil
+}
@@ -0,0 +1,322 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestDNSWorkloadConfigForDNS(t *testing.T) {
+	testCases := []struct {
+		name          string
+		annotation    string
+		expectKind    string
+		expectMin     int32
+		expectedError string
+	}{
+		{
+			name:       "no annotation",
+			expectKind: dnsWorkloadKindDaemonSet,
+			expectMin:  defaultDNSDeploymentMinReplicas,
+		},
+		{
+			name:       "deployment with bounds",
+			annotation: `{"kind":"Deployment","minReplicas":3,"maxReplicas":10,"nodesPerReplica":8}`,
+			expectKind: dnsWorkloadKindDeployment,
+			expectMin:  3,
+		},
+		{
+			name:          "invalid JSON",
+			annotation:    `{"kind":`,
+			expectedError: "failed to parse annotation",
+		},
+		{
+			name:          "invalid kind",
+			annotation:    `{"kind":"StatefulSet"}`,
+			expectedError: `kind "StatefulSet" must be`,
+		},
+		{
+			name:          "zero cores per replica",
+			annotation:    `{"kind":"Deployment","coresPerReplica":0}`,
+			expectedError: "coresPerReplica 0 must be positive",
+		},
+		{
+			name:          "zero minimum",
+			annotation:    `{"kind":"Deployment","minReplicas":0}`,
+			expectedError: "minReplicas 0 must be at least 1",
+		},
+		{
+			name:          "maximum below minimum",
+			annotation:    `{"kind":"Deployment","minReplicas":4,"maxReplicas":2}`,
+			expectedError: "maxReplicas 2 must not be less than minReplicas 4",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+			if len(tc.annotation) != 0 {
+				dns.Annotations = map[string]string{dnsWorkloadAnnotationKey: tc.annotation}
+			}
+			config, err := dnsWorkloadConfigForDNS(dns)
+			if len(tc.expectedError) != 0 {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if config.Kind != tc.expectKind {
+				t.Errorf("expected kind %q, got %q", tc.expectKind, config.Kind)
+			}
+			if *config.MinReplicas != tc.expectMin {
+				t.Errorf("expected minReplicas %d, got %d", tc.expectMin, *config.MinReplicas)
+			}
+			if config.CoresPerReplica == nil || config.NodesPerReplica == nil || config.PreventSinglePointFailure == nil {
+				t.Errorf("expected defaults to be filled in, got %+v", config)
+			}
+		})
+	}
+}
+
+func TestDNSDeploymentNodes(t *testing.T) {
+	node := func(name string, labels map[string]string, taints ...corev1.Taint) corev1.Node {
+		return corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+			Spec:       corev1.NodeSpec{Taints: taints},
+		}
+	}
+	linux := map[string]string{"kubernetes.io/os": "linux"}
+	nodes := []corev1.Node{
+		node("worker", linux),
+		node("master", linux, corev1.Taint{Key: "node-role.kubernetes.io/master", Effect: corev1.TaintEffectNoSchedule}),
+		node("cordoned", linux, corev1.Taint{Key: corev1.TaintNodeUnschedulable, Effect: corev1.TaintEffectNoSchedule}),
+		node("infra", linux, corev1.Taint{Key: "node-role.kubernetes.io/infra", Effect: corev1.TaintEffectNoSchedule}),
+		node("windows", map[string]string{"kubernetes.io/os": "windows"}),
+	}
+	dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+	eligible := dnsDeploymentNodes(dns, nodes)
+	names := []string{}
+	for _, node := range eligible {
+		names = append(names, node.Name)
+	}
+	if expected := "worker,master,cordoned"; strings.Join(names, ",") != expected {
+		t.Errorf("expected nodes %s, got %s", expected, strings.Join(names, ","))
+	}
+}
+
+func TestDNSDeploymentReplicas(t *testing.T) {
+	nodes := func(n int, cpu string) []corev1.Node {
+		nodes := make([]corev1.Node, n)
+		for i := range nodes {
+			nodes[i].Status.Capacity = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)}
+		}
+		return nodes
+	}
+	testCases := []struct {
+		name       string
+		annotation string
+		nodes      []corev1.Node
+		expect     int32
+	}{
+		{
+			name:   "single node",
+			nodes:  nodes(1, "4"),
+			expect: 1,
+		},
+		{
+			name:   "single point of failure is prevented",
+			nodes:  nodes(3, "4"),
+			expect: 2,
+		},
+		{
+			name:       "single point of failure is allowed",
+			annotation: `{"kind":"Deployment","preventSinglePointFailure":false}`,
+			nodes:      nodes(3, "4"),
+			expect:     1,
+		},
+		{
+			name:   "sized by nodes",
+			nodes:  nodes(100, "4"),
+			expect: 7,
+		},
+		{
+			name:   "sized by cores",
+			nodes:  nodes(20, "64"),
+			expect: 5,
+		},
+		{
+			name:       "clamped to the maximum",
+			annotation: `{"kind":"Deployment","maxReplicas":5}`,
+			nodes:      nodes(300, "4"),
+			expect:     5,
+		},
+		{
+			name:       "raised to the minimum",
+			annotation: `{"kind":"Deployment","minReplicas":3}`,
+			nodes:      nodes(2, "4"),
+			expect:     3,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+			if len(tc.annotation) != 0 {
+				dns.Annotations = map[string]string{dnsWorkloadAnnotationKey: tc.annotation}
+			}
+			config, err := dnsWorkloadConfigForDNS(dns)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if replicas := dnsDeploymentReplicas(config, tc.nodes); replicas != tc.expect {
+				t.Errorf("expected %d replicas, got %d", tc.expect, replicas)
+			}
+		})
+	}
+}
+
+func TestDesiredDNSDeployment(t *testing.T) {
+	dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+	daemonset, err := desiredDNSDaemonSet(dns, "coredns", "kube-rbac-proxy", nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	deployment := desiredDNSDeployment(dns, daemonset, 3)
+
+	if *deployment.Spec.Replicas != 3 {
+		t.Errorf("expected 3 replicas, got %d", *deployment.Spec.Replicas)
+	}
+	if _, ok := deployment.Spec.Template.Annotations[enableDaemonSetEvictionAnnotationKey]; ok {
+		t.Errorf("expected the deployment's pod template not to have the %s annotation", enableDaemonSetEvictionAnnotationKey)
+	}
+	if _, ok := daemonset.Spec.Template.Annotations[enableDaemonSetEvictionAnnotationKey]; !ok {
+		t.Errorf("expected the daemonset's pod template to keep the %s annotation", enableDaemonSetEvictionAnnotationKey)
+	}
+	if len(deployment.Spec.Template.Spec.TopologySpreadConstraints) != 2 {
+		t.Errorf("expected 2 topology spread constraints, got %+v", deployment.Spec.Template.Spec.TopologySpreadConstraints)
+	}
+
+	// The dns service must send queries to the deployment's pods, and
+	// the daemonset and the pod disruption budget must not select them
+	// or the daemonset's pods respectively.
+	podLabels := labels.Set(deployment.Spec.Template.Labels)
+	serviceSelector, _ := metav1.LabelSelectorAsSelector(DNSServingPodSelector(dns))
+	if !serviceSelector.Matches(podLabels) {
+		t.Errorf("expected the dns serving pod selector to match the deployment's pods %v", podLabels)
+	}
+	daemonsetSelector, _ := metav1.LabelSelectorAsSelector(DNSDaemonSetPodSelector(dns))
+	if daemonsetSelector.Matches(podLabels) {
+		t.Errorf("expected the dns daemonset pod selector not to match the deployment's pods %v", podLabels)
+	}
+	pdbSelector, _ := metav1.LabelSelectorAsSelector(desiredDNSPodDisruptionBudget(dns).Spec.Selector)
+	if !pdbSelector.Matches(podLabels) {
+		t.Errorf("expected the pod disruption budget to match the deployment's pods %v", podLabels)
+	}
+	if pdbSelector.Matches(labels.Set(daemonset.Spec.Template.Labels)) {
+		t.Errorf("expected the pod disruption budget not to match the daemonset's pods %v", daemonset.Spec.Template.Labels)
+	}
+}
+
+func TestDeploymentConfigChanged(t *testing.T) {
+	dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+	daemonset, err := desiredDNSDaemonSet(dns, "coredns", "kube-rbac-proxy", nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	testCases := []struct {
+		description string
+		mutate      func(*appsv1.Deployment)
+		expect      bool
+	}{
+		{
+			description: "if nothing changes",
+			mutate:      func(_ *appsv1.Deployment) {},
+			expect:      false,
+		},
+		{
+			description: "if the number of replicas changes",
+			mutate: func(deployment *appsv1.Deployment) {
+				replicas := int32(5)
+				deployment.Spec.Replicas = &replicas
+			},
+			expect: true,
+		},
+		{
+			description: "if the image changes",
+			mutate: func(deployment *appsv1.Deployment) {
+				deployment.Spec.Template.Spec.Containers[0].Image = "coredns:new"
+			},
+			expect: true,
+		},
+		{
+			description: "if the topology spread constraints change",
+			mutate: func(deployment *appsv1.Deployment) {
+				deployment.Spec.Template.Spec.TopologySpreadConstraints = nil
+			},
+			expect: true,
+		},
+		{
+			description: "if the pod labels change",
+			mutate: func(deployment *appsv1.Deployment) {
+				deployment.Spec.Template.Labels = DNSDaemonSetPodSelector(dns).MatchLabels
+			},
+			expect: true,
+		},
+		{
+			description: "if the status changes",
+			mutate: func(deployment *appsv1.Deployment) {
+				deployment.Status.AvailableReplicas = 1
+			},
+			expect: false,
+		},
+	}
+	for _, tc := range testCases {
+		original := desiredDNSDeployment(dns, daemonset, 3)
+		mutated := original.DeepCopy()
+		tc.mutate(mutated)
+		if changed, updated := deploymentConfigChanged(original, mutated); changed != tc.expect {
+			t.Errorf("%s, expect deploymentConfigChanged to be %t, got %t", tc.description, tc.expect, changed)
+		} else if changed {
+			if changedAgain, _ := deploymentConfigChanged(mutated, updated); changedAgain {
+				t.Errorf("%s, deploymentConfigChanged does not behave as a fixed point function", tc.description)
+			}
+		}
+	}
+}
+
+func TestDNSDeploymentAsDaemonSet(t *testing.T) {
+	replicas := int32(4)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "dns-default", Generation: 2},
+		Spec:       appsv1.DeploymentSpec{Replicas: &replicas},
+		Status: appsv1.DeploymentStatus{
+			ObservedGeneration: 2,
+			Replicas:           4,
+			UpdatedReplicas:    4,
+			AvailableReplicas:  3,
+		},
+	}
+	daemonset := DNSDeploymentAsDaemonSet(deployment)
+	if daemonset.Status.DesiredNumberScheduled != 4 || daemonset.Status.NumberAvailable != 3 || daemonset.Status.UpdatedNumberScheduled != 4 {
+		t.Errorf("unexpected daemonset status %+v", daemonset.Status)
+	}
+	if deploymentIsAvailable(deployment) {
+		t.Errorf("expected a deployment with 3 of 4 available replicas not to be available")
+	}
+	deployment.Status.AvailableReplicas = 4
+	if !deploymentIsAvailable(deployment) {
+		t.Errorf("expected a deployment with 4 of 4 available replicas to be available")
+	}
+	deployment.Generation = 3
+	if deploymentIsAvailable(deployment) {
+		t.Errorf("expected a deployment whose rollout has not been observed not to be available")
+	}
+}
@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+)
+
+const (
+	// dns64AnnotationKey is the annotation on a DNS that configures DNS64
+	// synthesis of AAAA records for names that only have A records.  The
+	// value is a JSON object with the following fields:
+	//
+	//   - "enabled" is a Boolean value that indicates whether CoreDNS
+	//     synthesizes AAAA records.
+	//   - "prefix" is the IPv6 prefix, in CIDR notation, that is used to
+	//     synthesize AAAA records.  The prefix length must be 32, 40, 48,
+	//     56, 64, or 96.  The default is the well-known prefix
+	//     64:ff9b::/96.
+	//   - "translateAll" is a Boolean value that indicates whether CoreDNS
+	//     synthesizes AAAA records even for names that have AAAA records.
+	dns64AnnotationKey = "dns.operator.openshift.io/dns64"
+
+	// defaultDNS64Prefix is the well-known prefix for IPv4/IPv6
+	// translation from RFC 6052.
+	defaultDNS64Prefix = "64:ff9b::/96"
+
+	// DNS64SuggestedConditionType is the type of the DNS status condition
+	// that indicates whether the operator suggests enabling DNS64.  It is
+	// only reported on clusters that have only IPv6 service networks.
+	DNS64SuggestedConditionType = "DNS64Suggested"
+)
+
+// dns64Config is the DNS64 configuration of a DNS.
+type dns64Config struct {
+	// Enabled indicates whether CoreDNS synthesizes AAAA records.
+	Enabled bool `json:"enabled"`
+	// Prefix is the IPv6 prefix that is used to synthesize AAAA records.
+	Prefix string `json:"prefix,omitempty"`
+	// TranslateAll indicates whether CoreDNS synthesizes AAAA records
+	// even for names that have AAAA records.
+	TranslateAll bool `json:"translateAll,omitempty"`
+}
+
+// dns64ConfigForDNS parses and validates the DNS64 configuration of the given
+// DNS.
+func dns64ConfigForDNS(dns *operatorv1.DNS) (dns64Config, error) {
+	config := dns64Config{}
+	value, ok := dns.Annotations[dns64AnnotationKey]
+	if !ok || len(strings.TrimSpace(value)) == 0 {
+		return config, nil
+	}
+	if err := json.Unmarshal([]byte(value), &config); err != nil {
+		return dns64Config{}, fmt.Errorf("failed to parse annotation %s: %w", dns64AnnotationKey, err)
+	}
+	if len(config.Prefix) == 0 {
+		config.Prefix = defaultDNS64Prefix
+	}
+	ip, prefix, err := net.ParseCIDR(config.Prefix)
+	if err != nil {
+		return dns64Config{}, fmt.Errorf("invalid annotation %s: invalid prefix %q: %w", dns64AnnotationKey, config.Prefix, err)
+	}
+	if ip.To4() != nil {
+		return dns64Config{}, fmt.Errorf("invalid annotation %s: prefix %q is not an IPv6 prefix", dns64AnnotationKey, config.Prefix)
+	}
+	// RFC 6052, section 2.2.
+	switch ones, _ := prefix.Mask.Size(); ones {
+	case 32, 40, 48, 56, 64, 96:
+	default:
+		return dns64Config{}, fmt.Errorf("invalid annotation %s: prefix %q must have a length of 32, 40, 48, 56, 64, or 96", dns64AnnotationKey, config.Prefix)
+	}
+	config.Prefix = prefix.String()
+	return config, nil
+}
+
+// serviceNetworksAreIPv6Only returns a Boolean value indicating whether the
+// given service networks are all IPv6 networks.  Returns false if there are
+// no service networks.
+func serviceNetworksAreIPv6Only(serviceNetworks []string) bool {
+	if len(serviceNetworks) == 0 {
+		return false
+	}
+	for _, network := range serviceNetworks {
+		ip, _, err := net.ParseCIDR(network)
+		if err != nil || ip.To4() != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// computeDNS64SuggestedCondition computes the DNS64Suggested status
+// condition for a cluster that has only IPv6 service networks.  The operator
+// suggests enabling DNS64 on such a cluster because pods cannot reach names
+// that only have IPv4 addresses unless the network provides NAT64.  The
+// condition is not reported on clusters with an IPv4 service network.
+func computeDNS64SuggestedCondition(oldCondition *operatorv1.OperatorCondition, dns *operatorv1.DNS) operatorv1.OperatorCondition {
+	condition := &operatorv1.OperatorCondition{
+		Type: DNS64SuggestedConditionType,
+	}
+	if config, err := dns64ConfigForDNS(dns); err == nil && config.Enabled {
+		condition.Status = operatorv1.ConditionFalse
+		condition.Reason = "DNS64Enabled"
+		condition.Message = "The cluster has only IPv6 service networks, and DNS64 is enabled."
+	} else {
+		condition.Status = operatorv1.ConditionTrue
+		condition.Reason = "IPv6OnlyServiceNetwork"
+		condition.Message = fmt.Sprintf("The cluster has only IPv6 service networks; consider enabling DNS64 using the %s annotation so that pods can reach names that only have IPv4 addresses.", dns64AnnotationKey)
+	}
+	return setDNSLastTransitionTime(condition, oldCondition)
+}
@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDNS64ConfigForDNS(t *testing.T) {
+	testCases := []struct {
+		name           string
+		annotation     string
+		expectedConfig dns64Config
+		expectError    bool
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:           "enabled with the well-known prefix",
+			annotation:     `{"enabled":true}`,
+			expectedConfig: dns64Config{Enabled: true, Prefix: "64:ff9b::/96"},
+		},
+		{
+			name:           "enabled with a custom prefix and translate-all",
+			annotation:     `{"enabled":true,"prefix":"2001:DB8:64::/48","translateAll":true}`,
+			expectedConfig: dns64Config{Enabled: true, Prefix: "2001:db8:64::/48", TranslateAll: true},
+		},
+		{
+			name:        "IPv4 prefix",
+			annotation:  `{"enabled":true,"prefix":"10.0.0.0/8"}`,
+			expectError: true,
+		},
+		{
+			name:        "prefix with an invalid length",
+			annotation:  `{"enabled":true,"prefix":"2001:db8::/80"}`,
+			expectError: true,
+		},
+		{
+			name:        "invalid JSON",
+			annotation:  `{"enabled":true`,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dns := &operatorv1.DNS{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: DefaultDNSController,
+				},
+			}
+			if len(tc.annotation) != 0 {
+				dns.Annotations = map[string]string{
+					dns64AnnotationKey: tc.annotation,
+				}
+			}
+			config, err := dns64ConfigForDNS(dns)
+			switch {
+			case tc.expectError && err == nil:
+				t.Fatalf("expected an error, got %+v", config)
+			case !tc.expectError && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.expectedConfig, config); diff != "" {
+				t.Errorf("unexpected config (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestServiceNetworksAreIPv6Only(t *testing.T) {
+	testCases := []struct {
+		serviceNetworks []string
+		expected        bool
+	}{
+		{nil, false},
+		{[]string{"172.30.0.0/16"}, false},
+		{[]string{"fd02::/112"}, true},
+		{[]string{"fd02::/112", "fd03::/112"}, true},
+		{[]string{"fd02::/112", "172.30.0.0/16"}, false},
+		{[]string{"invalid"}, false},
+	}
+
+	for _, tc := range testCases {
+		if actual := serviceNetworksAreIPv6Only(tc.serviceNetworks); actual != tc.expected {
+			t.Errorf("expected serviceNetworksAreIPv6Only(%v) to be %t, got %t", tc.serviceNetworks, tc.expected, actual)
+		}
+	}
+}
+
+func TestComputeDNS64SuggestedCondition(t *testing.T) {
+	testCases := []struct {
+		name           string
+		annotation     string
+		expectedStatus operatorv1.ConditionStatus
+		expectedReason string
+	}{
+		{
+			name:           "IPv6-only service network without DNS64",
+			expectedStatus: operatorv1.ConditionTrue,
+			expectedReason: "IPv6OnlyServiceNetwork",
+		},
+		{
+			name:           "IPv6-only service network with DNS64",
+			annotation:     `{"enabled":true}`,
+			expectedStatus: operatorv1.ConditionFalse,
+			expectedReason: "DNS64Enabled",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dns := &operatorv1.DNS{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: DefaultDNSController,
+				},
+			}
+			if len(tc.annotation) != 0 {
+				dns.Annotations = map[string]string{
+					dns64AnnotationKey: tc.annotation,
+				}
+			}
+			actual := computeDNS64SuggestedCondition(nil, dns)
+			if actual.Status != tc.expectedStatus || actual.Reason != tc.expectedReason {
+				t.Errorf("expected status %s with reason %q, got %s with reason %q", tc.expectedStatus, tc.expectedReason, actual.Status, actual.Reason)
+			}
+		})
+	}
+}
+
+func TestDesiredDNSConfigmapDNS64(t *testing.T) {
+	dns := &operatorv1.DNS{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: DefaultDNSController,
+			Annotations: map[string]string{
+				dns64AnnotationKey: `{"enabled":true,"translateAll":true}`,
+			},
+		},
+	}
+	cm, err := desiredDNSConfigMap(dns, "cluster.local", nil, false, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(cm.Data["Corefile"], mustLoadTestFile(t, "dns64")); diff != "" {
+		t.Errorf("unexpected Corefile;\n%s", diff)
+	}
+}
@@ -0,0 +1,160 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// dnsDrainAnnotationKey is the annotation on a DNS that configures how
+	// CoreDNS pods drain when they are terminated.  The value is a JSON
+	// object with the following fields:
+	//
+	//   - "lameDuckDuration" is the duration, for example "30s", for which
+	//     CoreDNS keeps answering queries after it receives SIGTERM.  The
+	//     default is 20 seconds.
+	//   - "preStopDelay" is the duration for which the kubelet waits
+	//     before it sends SIGTERM to CoreDNS, which gives load balancers
+	//     time to stop sending queries to the terminating pod.  The
+	//     default is no delay.
+	//   - "terminationGracePeriod" is the duration after which the kubelet
+	//     kills the pod.  It must be a whole number of seconds and longer
+	//     than the preStop delay and the lameduck duration together.  The
+	//     default is the larger of 30 seconds and the preStop delay plus
+	//     the lameduck duration plus 10 seconds.
+	//
+	// The operator also waits for twice the sum of the preStop delay and
+	// the lameduck duration before it reports a DNS that stopped
+	// progressing as progressing again, which covers the pods that are
+	// unavailable while they drain.
+	dnsDrainAnnotationKey = "dns.operator.openshift.io/drain"
+
+	// defaultLameDuckDuration is the default duration for which CoreDNS
+	// keeps answering queries after it receives SIGTERM.
+	defaultLameDuckDuration = 20 * time.Second
+
+	// defaultTerminationGracePeriod is the termination grace period that
+	// the API server defaults for pods.
+	defaultTerminationGracePeriod = corev1.DefaultTerminationGracePeriodSeconds * time.Second
+
+	// drainGracePeriodMargin is the time that the default termination
+	// grace period leaves CoreDNS to exit after its lameduck duration.
+	drainGracePeriodMargin = 10 * time.Second
+)
+
+// dnsDrainConfig is the drain configuration of a DNS.
+type dnsDrainConfig struct {
+	// LameDuckDuration is the duration for which CoreDNS keeps answering
+	// queries after it receives SIGTERM.
+	LameDuckDuration string `json:"lameDuckDuration,omitempty"`
+	// PreStopDelay is the duration for which the kubelet waits before it
+	// sends SIGTERM to CoreDNS.
+	PreStopDelay string `json:"preStopDelay,omitempty"`
+	// TerminationGracePeriod is the duration after which the kubelet
+	// kills the pod.
+	TerminationGracePeriod string `json:"terminationGracePeriod,omitempty"`
+
+	// lameDuckDuration is the parsed value of LameDuckDuration.
+	lameDuckDuration time.Duration
+	// preStopDelay is the parsed value of PreStopDelay.
+	preStopDelay time.Duration
+	// terminationGracePeriod is the parsed value of
+	// TerminationGracePeriod.
+	terminationGracePeriod time.Duration
+}
+
+// dnsDrainConfigForDNS parses and validates the drain configuration of the
+// given DNS and fills in defaults.
+func dnsDrainConfigForDNS(dns *operatorv1.DNS) (dnsDrainConfig, error) {
+	config := dnsDrainConfig{}
+	value, ok := dns.Annotations[dnsDrainAnnotationKey]
+	if ok && len(strings.TrimSpace(value)) != 0 {
+		if err := json.Unmarshal([]byte(value), &config); err != nil {
+			return dnsDrainConfig{}, fmt.Errorf("failed to parse annotation %s: %w", dnsDrainAnnotationKey, err)
+		}
+	}
+	for _, d := range []struct {
+		name     string
+		value    string
+		parsed   *time.Duration
+		fallback time.Duration
+	}{
+		{"lameDuckDuration", config.LameDuckDuration, &config.lameDuckDuration, defaultLameDuckDuration},
+		{"preStopDelay", config.PreStopDelay, &config.preStopDelay, 0},
+		{"terminationGracePeriod", config.TerminationGracePeriod, &config.terminationGracePeriod, 0},
+	} {
+		if len(d.value) == 0 {
+			*d.parsed = d.fallback
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil {
+			return dnsDrainConfig{}, fmt.Errorf("invalid annotation %s: invalid %s %q: %w", dnsDrainAnnotationKey, d.name, d.value, err)
+		}
+		if parsed <= 0 {
+			return dnsDrainConfig{}, fmt.Errorf("invalid annotation %s: %s %q must be positive", dnsDrainAnnotationKey, d.name, d.value)
+		}
+		if parsed%time.Second != 0 {
+			return dnsDrainConfig{}, fmt.Errorf("invalid annotation %s: %s %q must be a whole number of seconds", dnsDrainAnnotationKey, d.name, d.value)
+		}
+		*d.parsed = parsed
+	}
+	drain := config.preStopDelay + config.lameDuckDuration
+	if config.terminationGracePeriod == 0 {
+		config.terminationGracePeriod = defaultTerminationGracePeriod
+		if drain+drainGracePeriodMargin > config.terminationGracePeriod {
+			config.terminationGracePeriod = drain + drainGracePeriodMargin
+		}
+	} else if config.terminationGracePeriod <= drain {
+		return dnsDrainConfig{}, fmt.Errorf("invalid annotation %s: terminationGracePeriod %s must be longer than preStopDelay plus lameDuckDuration (%s)", dnsDrainAnnotationKey, config.terminationGracePeriod, drain)
+	}
+	return config, nil
+}
+
+// transitionUnchangedToleration returns the duration for which the operator
+// keeps a recent Progressing=False or Degraded=False status condition.  Twice
+// the drain time adds some room to cover the time for which draining CoreDNS
+// pods are unavailable.
+func (c dnsDrainConfig) transitionUnchangedToleration() time.Duration {
+	return 2 * (c.preStopDelay + c.lameDuckDuration)
+}
+
+// dnsTransitionUnchangedToleration returns the transitionUnchangedToleration
+// for the status conditions of the given DNS.  An invalid drain configuration
+// is reported by the reconciler; for the purpose of status, the default
+// configuration is used.
+func dnsTransitionUnchangedToleration(dns *operatorv1.DNS) time.Duration {
+	config, err := dnsDrainConfigForDNS(dns)
+	if err != nil {
+		return 2 * defaultLameDuckDuration
+	}
+	return config.transitionUnchangedToleration()
+}
+
+// applyDNSDrainConfig sets the termination grace period of the given pod spec
+// and the preStop hook of its dns container according to the given drain
+// configuration.
+func applyDNSDrainConfig(spec *corev1.PodSpec, config dnsDrainConfig) {
+	gracePeriod := int64(config.terminationGracePeriod / time.Second)
+	spec.TerminationGracePeriodSeconds = &gracePeriod
+	for i := range spec.Containers {
+		if spec.Containers[i].Name != "dns" {
+			continue
+		}
+		if config.preStopDelay == 0 {
+			spec.Containers[i].Lifecycle = nil
+			continue
+		}
+		spec.Containers[i].Lifecycle = &corev1.Lifecycle{
+			PreStop: &corev1.LifecycleHandler{
+				Sleep: &corev1.SleepAction{Seconds: int64(config.preStopDelay / time.Second)},
+			},
+		}
+	}
+}
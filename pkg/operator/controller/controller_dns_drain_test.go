@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDNSDrainConfigForDNS(t *testing.T) {
+	testCases := []struct {
+		name              string
+		annotation        string
+		expectLameDuck    time.Duration
+		expectPreStop     time.Duration
+		expectGracePeriod time.Duration
+		expectToleration  time.Duration
+		expectedError     string
+	}{
+		{
+			name:              "no annotation",
+			expectLameDuck:    20 * time.Second,
+			expectGracePeriod: 30 * time.Second,
+			expectToleration:  40 * time.Second,
+		},
+		{
+			name:              "all fields",
+			annotation:        `{"lameDuckDuration":"30s","preStopDelay":"5s","terminationGracePeriod":"1m"}`,
+			expectLameDuck:    30 * time.Second,
+			expectPreStop:     5 * time.Second,
+			expectGracePeriod: time.Minute,
+			expectToleration:  70 * time.Second,
+		},
+		{
+			name:              "derived grace period",
+			annotation:        `{"lameDuckDuration":"45s","preStopDelay":"10s"}`,
+			expectLameDuck:    45 * time.Second,
+			expectPreStop:     10 * time.Second,
+			expectGracePeriod: 65 * time.Second,
+			expectToleration:  110 * time.Second,
+		},
+		{
+			name:              "short lameduck keeps the default grace period",
+			annotation:        `{"lameDuckDuration":"5s"}`,
+			expectLameDuck:    5 * time.Second,
+			expectGracePeriod: 30 * time.Second,
+			expectToleration:  10 * time.Second,
+		},
+		{
+			name:          "grace period not longer than drain",
+			annotation:    `{"lameDuckDuration":"20s","preStopDelay":"10s","terminationGracePeriod":"30s"}`,
+			expectedError: "terminationGracePeriod 30s must be longer than preStopDelay plus lameDuckDuration (30s)",
+		},
+		{
+			name:          "fractional seconds",
+			annotation:    `{"preStopDelay":"1500ms"}`,
+			expectedError: `preStopDelay "1500ms" must be a whole number of seconds`,
+		},
+		{
+			name:          "negative duration",
+			annotation:    `{"lameDuckDuration":"-5s"}`,
+			expectedError: `lameDuckDuration "-5s" must be positive`,
+		},
+		{
+			name:          "invalid duration",
+			annotation:    `{"terminationGracePeriod":"forever"}`,
+			expectedError: `invalid terminationGracePeriod "forever"`,
+		},
+		{
+			name:          "invalid JSON",
+			annotation:    `{"lameDuckDuration":`,
+			expectedError: "failed to parse annotation",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+			if len(tc.annotation) != 0 {
+				dns.Annotations = map[string]string{dnsDrainAnnotationKey: tc.annotation}
+			}
+			config, err := dnsDrainConfigForDNS(dns)
+			if len(tc.expectedError) != 0 {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectedError, err)
+				}
+				if toleration := dnsTransitionUnchangedToleration(dns); toleration != 2*defaultLameDuckDuration {
+					t.Errorf("expected the default toleration for an invalid annotation, got %s", toleration)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if config.lameDuckDuration != tc.expectLameDuck || config.preStopDelay != tc.expectPreStop || config.terminationGracePeriod != tc.expectGracePeriod {
+				t.Errorf("expected lameduck %s, preStop %s, and grace period %s, got %s, %s, and %s", tc.expectLameDuck, tc.expectPreStop, tc.expectGracePeriod, config.lameDuckDuration, config.preStopDelay, config.terminationGracePeriod)
+			}
+			if toleration := dnsTransitionUnchangedToleration(dns); toleration != tc.expectToleration {
+				t.Errorf("expected toleration %s, got %s", tc.expectToleration, toleration)
+			}
+		})
+	}
+}
+
+func TestDesiredDNSDaemonSetDrain(t *testing.T) {
+	dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+	current, err := desiredDNSDaemonSet(dns, "coredns", "kube-rbac-proxy", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gracePeriod := current.Spec.Template.Spec.TerminationGracePeriodSeconds; gracePeriod == nil || *gracePeriod != 30 {
+		t.Errorf("expected the default termination grace period of 30 seconds, got %v", gracePeriod)
+	}
+	for _, container := range current.Spec.Template.Spec.Containers {
+		if container.Lifecycle != nil {
+			t.Errorf("expected container %s to have no lifecycle hooks, got %+v", container.Name, container.Lifecycle)
+		}
+	}
+
+	dns.Annotations = map[string]string{dnsDrainAnnotationKey: `{"lameDuckDuration":"30s","preStopDelay":"5s"}`}
+	desired, err := desiredDNSDaemonSet(dns, "coredns", "kube-rbac-proxy", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gracePeriod := desired.Spec.Template.Spec.TerminationGracePeriodSeconds; gracePeriod == nil || *gracePeriod != 45 {
+		t.Errorf("expected a termination grace period of 45 seconds, got %v", gracePeriod)
+	}
+	for _, container := range desired.Spec.Template.Spec.Containers {
+		switch container.Name {
+		case "dns":
+			if container.Lifecycle == nil || container.Lifecycle.PreStop == nil || container.Lifecycle.PreStop.Sleep == nil || container.Lifecycle.PreStop.Sleep.Seconds != 5 {
+				t.Errorf("expected the dns container to sleep for 5 seconds before it stops, got %+v", container.Lifecycle)
+			}
+		default:
+			if container.Lifecycle != nil {
+				t.Errorf("expected container %s to have no lifecycle hooks, got %+v", container.Name, container.Lifecycle)
+			}
+		}
+	}
+
+	changed, updated := daemonsetConfigChanged(current, desired)
+	if !changed {
+		t.Fatal("expected daemonsetConfigChanged to detect the drain configuration")
+	}
+	if changed, _ := daemonsetConfigChanged(updated, desired); changed {
+		t.Error("expected no change once the drain configuration is rolled out")
+	}
+	if changed, _ := daemonsetConfigChanged(desired, current); !changed {
+		t.Error("expected daemonsetConfigChanged to detect the removal of the preStop delay")
+	}
+
+	dns.Annotations[dnsDrainAnnotationKey] = `{"lameDuckDuration":"30s","terminationGracePeriod":"30s"}`
+	if _, err := desiredDNSDaemonSet(dns, "coredns", "kube-rbac-proxy", nil, nil); err == nil {
+		t.Error("expected an error for a termination grace period that is not longer than the lameduck duration")
+	}
+}
+
+func TestDesiredDNSConfigMapLameDuckDuration(t *testing.T) {
+	dns := &operatorv1.DNS{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        DefaultDNSController,
+			Annotations: map[string]string{dnsDrainAnnotationKey: `{"lameDuckDuration":"30s"}`},
+		},
+	}
+	cm, err := desiredDNSConfigMap(dns, "cluster.local", nil, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if corefile := cm.Data["Corefile"]; !strings.Contains(corefile, "lameduck 30s\n") {
+		t.Errorf("expected Corefile to contain %q, got:\n%s", "lameduck 30s", corefile)
+	}
+
+	dns.Annotations[dnsDrainAnnotationKey] = `{"lameDuckDuration":"0s"}`
+	if _, err := desiredDNSConfigMap(dns, "cluster.local", nil, false, nil); err == nil {
+		t.Error("expected an error for a lameduck duration of zero")
+	}
+}
@@ -0,0 +1,135 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// forwardExceptAnnotationKey is the annotation on a DNS that configures
+// domains that CoreDNS must not forward.  The value is a JSON object with the
+// following fields:
+//
+//   - "upstreamResolvers" is a list of domains that the forward plugin for
+//     spec.upstreamResolvers excludes.
+//   - "servers" maps the name of a server in spec.servers to a list of domains
+//     that the forward plugin for that server excludes.
+//
+// Queries for an excluded domain, or any of its subdomains, are not forwarded
+// and are instead answered by the plugins that follow the forward plugin,
+// which typically means that they fail with NXDOMAIN or SERVFAIL.
+const forwardExceptAnnotationKey = "dns.operator.openshift.io/forward-except"
+
+// forwardExcept describes the domains that the forward plugins in the
+// Corefile exclude.
+type forwardExcept struct {
+	// UpstreamResolvers is the list of domains that the forward plugin for
+	// spec.upstreamResolvers excludes.
+	UpstreamResolvers []string `json:"upstreamResolvers,omitempty"`
+	// Servers maps the name of a server in spec.servers to the list of
+	// domains that the forward plugin for that server excludes.
+	Servers map[string][]string `json:"servers,omitempty"`
+}
+
+// dnsForwardExcept parses and validates the forward except-lists that are
+// configured on the given DNS.  Domains are returned normalized.
+func dnsForwardExcept(dns *operatorv1.DNS) (forwardExcept, error) {
+	var except forwardExcept
+	value, ok := dns.Annotations[forwardExceptAnnotationKey]
+	if !ok || len(strings.TrimSpace(value)) == 0 {
+		return except, nil
+	}
+	if err := json.Unmarshal([]byte(value), &except); err != nil {
+		return forwardExcept{}, fmt.Errorf("failed to parse annotation %s: %w", forwardExceptAnnotationKey, err)
+	}
+	if err := validateForwardExcept(&except, dns.Spec.Servers); err != nil {
+		return forwardExcept{}, fmt.Errorf("invalid annotation %s: %w", forwardExceptAnnotationKey, err)
+	}
+	return except, nil
+}
+
+// validateForwardExcept verifies and normalizes the given except-lists.
+//
+// A domain in the except-list for spec.upstreamResolvers must not be in any
+// zone in spec.servers because queries for such a domain are handled by the
+// server block for that zone and never reach the forward plugin for
+// spec.upstreamResolvers.  Similarly, a domain in the except-list for a server
+// must be a subdomain of one of that server's zones and must not be in a
+// (more specific) zone of another server.
+func validateForwardExcept(except *forwardExcept, servers []operatorv1.Server) error {
+	normalized, err := normalizeExceptDomains(except.UpstreamResolvers)
+	if err != nil {
+		return fmt.Errorf("upstreamResolvers: %w", err)
+	}
+	for _, domain := range normalized {
+		for _, server := range servers {
+			if nameInZones(domain, server.Zones) {
+				return fmt.Errorf("upstreamResolvers: domain %q is in a zone of server %q and is never forwarded to the upstream resolvers", domain, server.Name)
+			}
+		}
+	}
+	except.UpstreamResolvers = normalized
+
+	serversByName := map[string]operatorv1.Server{}
+	for _, server := range servers {
+		serversByName[server.Name] = server
+	}
+	names := make([]string, 0, len(except.Servers))
+	for name := range except.Servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		server, ok := serversByName[name]
+		if !ok {
+			return fmt.Errorf("servers: no server named %q", name)
+		}
+		normalized, err := normalizeExceptDomains(except.Servers[name])
+		if err != nil {
+			return fmt.Errorf("servers[%s]: %w", name, err)
+		}
+		for _, domain := range normalized {
+			if !nameInZones(domain, server.Zones) {
+				return fmt.Errorf("servers[%s]: domain %q is not in any of the server's zones", name, domain)
+			}
+			for _, other := range servers {
+				if other.Name == server.Name {
+					continue
+				}
+				for _, zone := range other.Zones {
+					if nameInZones(domain, []string{zone}) && nameInZones(zone, server.Zones) {
+						return fmt.Errorf("servers[%s]: domain %q is in zone %q of server %q and is never forwarded by server %q", name, domain, zone, other.Name, name)
+					}
+				}
+			}
+		}
+		except.Servers[name] = normalized
+	}
+
+	return nil
+}
+
+// normalizeExceptDomains validates the given domains and returns them
+// normalized, sorted, and without duplicates.
+func normalizeExceptDomains(domains []string) ([]string, error) {
+	seen := map[string]struct{}{}
+	var result []string
+	for _, domain := range domains {
+		normalized := normalizeZone(domain)
+		if errs := validation.IsDNS1123Subdomain(normalized); len(errs) != 0 {
+			return nil, fmt.Errorf("invalid domain %q: %s", domain, strings.Join(errs, ", "))
+		}
+		if _, ok := seen[normalized]; ok {
+			continue
+		}
+		seen[normalized] = struct{}{}
+		result = append(result, normalized)
+	}
+	sort.Strings(result)
+	return result, nil
+}
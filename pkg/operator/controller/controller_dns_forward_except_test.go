@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDNSForwardExcept(t *testing.T) {
+	servers := []operatorv1.Server{
+		{
+			Name:  "foo",
+			Zones: []string{"foo.com"},
+			ForwardPlugin: operatorv1.ForwardPlugin{
+				Upstreams: []string{"1.1.1.1"},
+			},
+		},
+		{
+			Name:  "bar",
+			Zones: []string{"bar.foo.com"},
+			ForwardPlugin: operatorv1.ForwardPlugin{
+				Upstreams: []string{"2.2.2.2"},
+			},
+		},
+	}
+	testCases := []struct {
+		name           string
+		annotation     string
+		expectedExcept forwardExcept
+		expectError    bool
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:       "valid except-lists are normalized",
+			annotation: `{"upstreamResolvers":["Internal.Example.","corp.example","corp.example"],"servers":{"foo":["secret.foo.com"]}}`,
+			expectedExcept: forwardExcept{
+				UpstreamResolvers: []string{"corp.example", "internal.example"},
+				Servers: map[string][]string{
+					"foo": {"secret.foo.com"},
+				},
+			},
+		},
+		{
+			name:        "invalid JSON",
+			annotation:  `{"upstreamResolvers":`,
+			expectError: true,
+		},
+		{
+			name:        "invalid domain",
+			annotation:  `{"upstreamResolvers":["corp_example!"]}`,
+			expectError: true,
+		},
+		{
+			name:        "upstreamResolvers domain in a server zone",
+			annotation:  `{"upstreamResolvers":["www.foo.com"]}`,
+			expectError: true,
+		},
+		{
+			name:        "upstreamResolvers domain equal to a server zone",
+			annotation:  `{"upstreamResolvers":["foo.com"]}`,
+			expectError: true,
+		},
+		{
+			name:        "unknown server",
+			annotation:  `{"servers":{"baz":["baz.com"]}}`,
+			expectError: true,
+		},
+		{
+			name:        "server domain outside of the server's zones",
+			annotation:  `{"servers":{"foo":["example.com"]}}`,
+			expectError: true,
+		},
+		{
+			name:        "server domain in a more specific zone of another server",
+			annotation:  `{"servers":{"foo":["www.bar.foo.com"]}}`,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dns := &operatorv1.DNS{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: DefaultDNSController,
+				},
+				Spec: operatorv1.DNSSpec{
+					Servers: servers,
+				},
+			}
+			if len(tc.annotation) != 0 {
+				dns.Annotations = map[string]string{
+					forwardExceptAnnotationKey: tc.annotation,
+				}
+			}
+			except, err := dnsForwardExcept(dns)
+			switch {
+			case tc.expectError && err == nil:
+				t.Fatalf("expected an error, got %+v", except)
+			case !tc.expectError && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.expectedExcept, except, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("unexpected except-lists (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDesiredDNSConfigmapForwardExcept(t *testing.T) {
+	dns := &operatorv1.DNS{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: DefaultDNSController,
+			Annotations: map[string]string{
+				forwardExceptAnnotationKey: `{"upstreamResolvers":["internal.example","corp.example"],"servers":{"foo":["secret.foo.com"]}}`,
+			},
+		},
+		Spec: operatorv1.DNSSpec{
+			Servers: []operatorv1.Server{
+				{
+					Name:  "foo",
+					Zones: []string{"foo.com"},
+					ForwardPlugin: operatorv1.ForwardPlugin{
+						Upstreams: []string{"1.1.1.1", "2.2.2.2:5353"},
+						Policy:    operatorv1.RoundRobinForwardingPolicy,
+					},
+				},
+			},
+			UpstreamResolvers: operatorv1.UpstreamResolvers{
+				Upstreams: []operatorv1.Upstream{
+					{
+						Type:    operatorv1.NetworkResolverType,
+						Address: "3.3.3.3",
+					},
+				},
+			},
+		},
+	}
+	cm, err := desiredDNSConfigMap(dns, "cluster.local", nil, false, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(cm.Data["Corefile"], mustLoadTestFile(t, "forward_except")); diff != "" {
+		t.Errorf("unexpected Corefile;\n%s", diff)
+	}
+}
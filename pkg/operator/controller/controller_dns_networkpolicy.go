@@ -65,6 +65,11 @@ func (r *reconciler) ensureDNSNetworkPolicy(ctx context.Context, dns *operatorv1
 	}
 
 	desired := desiredDNSNetworkPolicy(dns)
+	podSelector, err := r.dnsServingPodLabels(dns)
+	if err != nil {
+		return haveNP, current, err
+	}
+	desired.Spec.PodSelector = metav1.LabelSelector{MatchLabels: podSelector}
 
 	switch {
 	case !haveNP:
@@ -107,8 +112,8 @@ func desiredDNSNetworkPolicy(dns *operatorv1.DNS) *networkingv1.NetworkPolicy {
 	}
 	np.Labels[manifests.OwningDNSLabel] = DNSDaemonSetLabel(dns)
 
-	// Ensure pod selector matches the DNS daemonset pods for this instance.
-	if sel := DNSDaemonSetPodSelector(dns); sel != nil {
+	// Ensure pod selector matches the DNS pods for this instance.
+	if sel := DNSServingPodSelector(dns); sel != nil {
 		np.Spec.PodSelector = *sel
 	}
 
@@ -0,0 +1,666 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/cluster-dns-operator/pkg/manifests"
+
+	"github.com/sirupsen/logrus"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	// nodeLocalCacheAnnotationKey is the annotation on a DNS that
+	// configures the node-local DNS cache.  The value is a JSON object
+	// with the following fields:
+	//
+	//   - "enabled" is a Boolean value that indicates whether the
+	//     operator deploys the node-local DNS cache.
+	//   - "localIP" is the link-local IP address on which the cache
+	//     listens on each node.  The default is 169.254.20.10.
+	//   - "healthPort" is the port on the local IP address on which the
+	//     cache serves health checks.  The default is 8091 for the
+	//     default DNS and a port derived from the DNS's name otherwise.
+	//
+	// No two DNSes may use the same local IP address or health port.  When
+	// the cache is disabled, the operator replaces the cache pods with pods
+	// that remove the cache's network interface from the nodes, and then
+	// deletes the daemonset.
+	// The cache runs on the host network of every node, listens on the
+	// local IP address, and forwards cache misses over TCP to the cluster
+	// DNS service, which avoids conntrack races for UDP queries.  Pods use
+	// the cache only if they are configured to use the local IP address as
+	// their nameserver, for example using the kubelet's clusterDNS setting
+	// or the pod's dnsConfig.
+	nodeLocalCacheAnnotationKey = "dns.operator.openshift.io/node-local-cache"
+
+	// defaultNodeLocalCacheIP is the default link-local IP address on
+	// which the node-local DNS cache listens.
+	defaultNodeLocalCacheIP = "169.254.20.10"
+
+	// defaultNodeLocalCacheInterface is the name of the dummy network
+	// interface to which the default DNS's node-local DNS cache's IP
+	// address is assigned.
+	defaultNodeLocalCacheInterface = "nodelocaldns"
+
+	// defaultNodeLocalCacheHealthPort is the port on the local IP address
+	// on which the default DNS's node-local DNS cache serves health
+	// checks.  Other DNSes use a port in the range from
+	// defaultNodeLocalCacheHealthPort+1 to
+	// defaultNodeLocalCacheHealthPort+nodeLocalCacheHealthPortRange.
+	defaultNodeLocalCacheHealthPort = 8091
+
+	// nodeLocalCacheHealthPortRange is the number of ports from which the
+	// health port for a DNS other than the default DNS is derived.
+	nodeLocalCacheHealthPortRange = 900
+
+	// nodeLocalCacheTeardownAnnotationKey is the annotation on a
+	// node-local DNS cache daemonset that indicates that the cache has
+	// been disabled and that the daemonset's pods remove the cache's
+	// network interface from the nodes.
+	nodeLocalCacheTeardownAnnotationKey = "dns.operator.openshift.io/node-local-cache-teardown"
+)
+
+var (
+	nodeLocalCacheCorefileTemplate = template.Must(template.New("Corefile").Parse(`.:53 {
+    bind {{.LocalIP}}
+    bufsize 1232
+    errors
+    log . {
+        {{.LogLevel}}
+    }
+    health {{.HealthAddress}} {
+        lameduck {{.LameDuckDuration}}
+    }
+    loop
+    cache {{.PositiveTTL}} {
+        denial 9984 {{.NegativeTTL}}
+    }
+    forward . {{.ClusterIP}} {
+        force_tcp
+    }
+    reload
+}
+`))
+)
+
+// nodeLocalCacheConfig is the configuration of the node-local DNS cache.
+type nodeLocalCacheConfig struct {
+	// Enabled indicates whether the node-local DNS cache is deployed.
+	Enabled bool `json:"enabled"`
+	// LocalIP is the link-local IP address on which the cache listens.
+	LocalIP string `json:"localIP,omitempty"`
+	// HealthPort is the port on the local IP address on which the cache
+	// serves health checks.
+	HealthPort int `json:"healthPort,omitempty"`
+	// Interface is the name of the dummy network interface to which the
+	// local IP address is assigned.  It is derived from the DNS's name.
+	Interface string `json:"-"`
+}
+
+// nodeLocalCacheInterfaceName returns the name of the dummy network interface
+// for the given DNS's node-local DNS cache.  Interface names are limited to
+// 15 characters, so names for DNSes other than the default DNS are derived
+// from a hash of the DNS's name.
+func nodeLocalCacheInterfaceName(dns *operatorv1.DNS) string {
+	if dns.Name == DefaultDNSController {
+		return defaultNodeLocalCacheInterface
+	}
+	return fmt.Sprintf("nld-%x", sha256.Sum256([]byte(dns.Name)))[:12]
+}
+
+// defaultNodeLocalCacheHealthPortForDNS returns the health port for the given
+// DNS's node-local DNS cache if the DNS does not specify one.
+func defaultNodeLocalCacheHealthPortForDNS(dns *operatorv1.DNS) int {
+	if dns.Name == DefaultDNSController {
+		return defaultNodeLocalCacheHealthPort
+	}
+	h := fnv.New32a()
+	h.Write([]byte(dns.Name))
+	return defaultNodeLocalCacheHealthPort + 1 + int(h.Sum32()%nodeLocalCacheHealthPortRange)
+}
+
+// nodeLocalCacheSetupScript returns a shell script that creates the dummy
+// interface with the given name for the node-local DNS cache and assigns the
+// local IP address to it, replacing any address that a previous configuration
+// assigned.  The script expects the LOCAL_IP environment variable to be set.
+func nodeLocalCacheSetupScript(iface string) string {
+	return fmt.Sprintf(`set -euo pipefail
+ip link add %[1]s type dummy 2>/dev/null || true
+ip addr flush dev %[1]s
+ip addr add "${LOCAL_IP}" dev %[1]s
+ip link set %[1]s up
+`, iface)
+}
+
+// nodeLocalCacheTeardownScript returns a shell script that deletes the dummy
+// interface with the given name for the node-local DNS cache.
+func nodeLocalCacheTeardownScript(iface string) string {
+	return fmt.Sprintf("ip link del %s 2>/dev/null || true\n", iface)
+}
+
+// nodeLocalCacheConfigForDNS parses and validates the node-local DNS cache
+// configuration of the given DNS.
+func nodeLocalCacheConfigForDNS(dns *operatorv1.DNS) (nodeLocalCacheConfig, error) {
+	config := nodeLocalCacheConfig{}
+	value, ok := dns.Annotations[nodeLocalCacheAnnotationKey]
+	if !ok || len(strings.TrimSpace(value)) == 0 {
+		return config, nil
+	}
+	if err := json.Unmarshal([]byte(value), &config); err != nil {
+		return nodeLocalCacheConfig{}, fmt.Errorf("failed to parse annotation %s: %w", nodeLocalCacheAnnotationKey, err)
+	}
+	if len(config.LocalIP) == 0 {
+		config.LocalIP = defaultNodeLocalCacheIP
+	}
+	if config.HealthPort == 0 {
+		config.HealthPort = defaultNodeLocalCacheHealthPortForDNS(dns)
+	}
+	if config.HealthPort < 1 || config.HealthPort > 65535 || config.HealthPort == 53 {
+		return nodeLocalCacheConfig{}, fmt.Errorf("invalid annotation %s: healthPort %d is not a valid port other than 53", nodeLocalCacheAnnotationKey, config.HealthPort)
+	}
+	config.Interface = nodeLocalCacheInterfaceName(dns)
+	ip := net.ParseIP(config.LocalIP)
+	if ip == nil || !ip.IsLinkLocalUnicast() {
+		return nodeLocalCacheConfig{}, fmt.Errorf("invalid annotation %s: localIP %q is not a link-local unicast IP address", nodeLocalCacheAnnotationKey, config.LocalIP)
+	}
+	config.LocalIP = ip.String()
+	return config, nil
+}
+
+// nodeLocalCacheConflicts returns an error if another of the given DNSes has
+// the node-local DNS cache enabled with the same local IP address or health
+// port as the given configuration for the given DNS.
+func nodeLocalCacheConflicts(dns *operatorv1.DNS, config nodeLocalCacheConfig, dnses []operatorv1.DNS) error {
+	for i := range dnses {
+		other := &dnses[i]
+		if other.Name == dns.Name || other.DeletionTimestamp != nil {
+			continue
+		}
+		otherConfig, err := nodeLocalCacheConfigForDNS(other)
+		if err != nil || !otherConfig.Enabled {
+			continue
+		}
+		if otherConfig.LocalIP == config.LocalIP {
+			return fmt.Errorf("invalid annotation %s: localIP %s is already used by the node-local dns cache of dns %s", nodeLocalCacheAnnotationKey, config.LocalIP, other.Name)
+		}
+		if otherConfig.HealthPort == config.HealthPort {
+			return fmt.Errorf("invalid annotation %s: healthPort %d is already used by the node-local dns cache of dns %s", nodeLocalCacheAnnotationKey, config.HealthPort, other.Name)
+		}
+	}
+	return nil
+}
+
+// ensureNodeLocalCache ensures that the node-local DNS cache's configmap and
+// daemonset exist if the cache is enabled for the given DNS and that they do
+// not exist otherwise.  Returns a Boolean indicating whether the daemonset
+// exists, the daemonset if it does exist, and an error value.
+func (r *reconciler) ensureNodeLocalCache(dns *operatorv1.DNS, clusterIP string) (bool, *appsv1.DaemonSet, error) {
+	haveDS, current, err := r.currentNodeLocalCacheDaemonSet(dns)
+	if err != nil {
+		return false, nil, err
+	}
+	config, err := nodeLocalCacheConfigForDNS(dns)
+	if err != nil {
+		// Keep the current cache, if any, rather than tearing it
+		// down because of a typo in the annotation.
+		return haveDS, current, err
+	}
+
+	if !config.Enabled {
+		if err := r.ensureNodeLocalCacheDeleted(dns, haveDS, current); err != nil {
+			return false, nil, err
+		}
+		return false, nil, nil
+	}
+
+	dnses := &operatorv1.DNSList{}
+	if err := r.client.List(context.TODO(), dnses); err != nil {
+		return haveDS, current, fmt.Errorf("failed to list dnses: %w", err)
+	}
+	if err := nodeLocalCacheConflicts(dns, config, dnses.Items); err != nil {
+		return haveDS, current, err
+	}
+
+	if len(clusterIP) == 0 {
+		return haveDS, current, fmt.Errorf("failed to configure node-local dns cache: no IP address is assigned to the DNS service")
+	}
+
+	desiredCM, err := desiredNodeLocalCacheConfigMap(dns, config, clusterIP)
+	if err != nil {
+		return haveDS, current, fmt.Errorf("failed to build node-local dns cache configmap: %w", err)
+	}
+	if err := r.ensureNodeLocalCacheConfigMap(dns, desiredCM); err != nil {
+		return haveDS, current, err
+	}
+
+	desired := desiredNodeLocalCacheDaemonSet(dns, config, r.CoreDNSImage, r.OpenshiftCLIImage)
+	switch {
+	case !haveDS:
+		if err := r.client.Create(context.TODO(), desired); err != nil {
+			return false, nil, fmt.Errorf("failed to create node-local dns cache daemonset %s/%s: %w", desired.Namespace, desired.Name, err)
+		}
+		logrus.Infof("created node-local dns cache daemonset: %s/%s", desired.Namespace, desired.Name)
+	case haveDS:
+		changed, updated := nodeLocalCacheDaemonSetConfigChanged(current, desired)
+		if !changed {
+			return true, current, nil
+		}
+		if err := r.client.Update(context.TODO(), updated); err != nil {
+			return true, current, fmt.Errorf("failed to update node-local dns cache daemonset %s/%s: %w", updated.Namespace, updated.Name, err)
+		}
+		logrus.Infof("updated node-local dns cache daemonset: %s/%s", updated.Namespace, updated.Name)
+	}
+	haveDS, current, err = r.currentNodeLocalCacheDaemonSet(dns)
+	return haveDS, current, err
+}
+
+// ensureNodeLocalCacheConfigMap creates or updates the node-local DNS cache's
+// configmap.
+func (r *reconciler) ensureNodeLocalCacheConfigMap(dns *operatorv1.DNS, desired *corev1.ConfigMap) error {
+	current := &corev1.ConfigMap{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: desired.Namespace, Name: desired.Name}, current); err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to get node-local dns cache configmap %s/%s: %w", desired.Namespace, desired.Name, err)
+		}
+		setDesiredStateHash(desired, desiredStateHash(desired.Data))
+		if err := r.client.Create(context.TODO(), desired); err != nil {
+			return fmt.Errorf("failed to create node-local dns cache configmap %s/%s: %w", desired.Namespace, desired.Name, err)
+		}
+		logrus.Infof("created node-local dns cache configmap: %s/%s", desired.Namespace, desired.Name)
+		return nil
+	}
+	_, err := r.updateDNSConfigMap(dns, current, desired)
+	return err
+}
+
+// ensureNodeLocalCacheDeleted tears down the node-local DNS cache for the given
+// DNS.  If the cache's daemonset exists, it is first replaced with one whose
+// pods remove the cache's dummy interface from the nodes; once those pods are
+// available on every node, the daemonset and the configmap are deleted.  The
+// cache pods themselves do not remove the interface because they are also
+// terminated when the cache is updated or a node is drained.
+func (r *reconciler) ensureNodeLocalCacheDeleted(dns *operatorv1.DNS, haveDS bool, current *appsv1.DaemonSet) error {
+	if haveDS {
+		if _, ok := current.Annotations[nodeLocalCacheTeardownAnnotationKey]; !ok {
+			updated := current.DeepCopy()
+			teardown := desiredNodeLocalCacheTeardownDaemonSet(dns, r.OpenshiftCLIImage)
+			updated.Annotations = teardown.Annotations
+			updated.Spec.Template = teardown.Spec.Template
+			if err := r.client.Update(context.TODO(), updated); err != nil {
+				return fmt.Errorf("failed to update node-local dns cache daemonset %s/%s for teardown: %w", updated.Namespace, updated.Name, err)
+			}
+			logrus.Infof("tearing down node-local dns cache daemonset: %s/%s", updated.Namespace, updated.Name)
+			return nil
+		}
+		if current.Status.DesiredNumberScheduled > 0 && !daemonsetIsAvailable(current) {
+			return nil
+		}
+		if err := r.client.Delete(context.TODO(), current); err != nil {
+			if !errors.IsNotFound(err) {
+				return fmt.Errorf("failed to delete node-local dns cache daemonset %s/%s: %w", current.Namespace, current.Name, err)
+			}
+		} else {
+			logrus.Infof("deleted node-local dns cache daemonset: %s/%s", current.Namespace, current.Name)
+		}
+	}
+
+	name := NodeLocalCacheConfigMapName(dns)
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name}}
+	if err := r.client.Delete(context.TODO(), cm); err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete node-local dns cache configmap %s/%s: %w", name.Namespace, name.Name, err)
+		}
+	} else {
+		logrus.Infof("deleted node-local dns cache configmap: %s/%s", name.Namespace, name.Name)
+	}
+	return nil
+}
+
+// currentNodeLocalCacheDaemonSet returns the current node-local DNS cache
+// daemonset for the given DNS.
+func (r *reconciler) currentNodeLocalCacheDaemonSet(dns *operatorv1.DNS) (bool, *appsv1.DaemonSet, error) {
+	daemonset := &appsv1.DaemonSet{}
+	if err := r.client.Get(context.TODO(), NodeLocalCacheDaemonSetName(dns), daemonset); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil, nil
+		}
+		return false, nil, err
+	}
+	return true, daemonset, nil
+}
+
+// desiredNodeLocalCacheConfigMap returns the desired configmap with the
+// Corefile for the node-local DNS cache.  The cache uses the same TTLs and log
+// level as the cluster DNS service.
+func desiredNodeLocalCacheConfigMap(dns *operatorv1.DNS, config nodeLocalCacheConfig, clusterIP string) (*corev1.ConfigMap, error) {
+	pTTL, nTTL := coreDNSCache(dns)
+	corefileParameters := struct {
+		LocalIP          string
+		HealthAddress    string
+		ClusterIP        string
+		LogLevel         string
+		LameDuckDuration time.Duration
+		PositiveTTL      uint32
+		NegativeTTL      uint32
+	}{
+		LocalIP:          config.LocalIP,
+		HealthAddress:    net.JoinHostPort(config.LocalIP, fmt.Sprint(config.HealthPort)),
+		ClusterIP:        clusterIP,
+		LogLevel:         coreDNSLogLevel(dns),
+		LameDuckDuration: defaultLameDuckDuration,
+		PositiveTTL:      pTTL,
+		NegativeTTL:      nTTL,
+	}
+	corefile := new(bytes.Buffer)
+	if err := nodeLocalCacheCorefileTemplate.Execute(corefile, corefileParameters); err != nil {
+		return nil, err
+	}
+
+	name := NodeLocalCacheConfigMapName(dns)
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name.Name,
+			Namespace: name.Namespace,
+			Labels: map[string]string{
+				manifests.OwningDNSLabel: DNSDaemonSetLabel(dns),
+			},
+		},
+		Data: map[string]string{
+			"Corefile": corefile.String(),
+		},
+	}
+	cm.SetOwnerReferences([]metav1.OwnerReference{dnsOwnerRef(dns)})
+	return cm, nil
+}
+
+// desiredNodeLocalCacheDaemonSet returns the desired node-local DNS cache
+// daemonset.
+func desiredNodeLocalCacheDaemonSet(dns *operatorv1.DNS, config nodeLocalCacheConfig, coreDNSImage, openshiftCLIImage string) *appsv1.DaemonSet {
+	trueVal := true
+	// The cache pods use host ports, so a new pod cannot be started on a
+	// node before the old one has stopped.  maxSurge must be zero when
+	// maxUnavailable is nonzero.
+	maxSurge := intstr.FromInt(0)
+	maxUnavailable := intstr.FromString("10%")
+	prefixLength := 32
+	if config.LocalIP != net.ParseIP(config.LocalIP).To4().String() {
+		prefixLength = 128
+	}
+	localIPEnv := []corev1.EnvVar{{
+		Name:  "LOCAL_IP",
+		Value: fmt.Sprintf("%s/%d", config.LocalIP, prefixLength),
+	}}
+	probeHandler := corev1.ProbeHandler{
+		HTTPGet: &corev1.HTTPGetAction{
+			Host:   config.LocalIP,
+			Path:   "/health",
+			Port:   intstr.FromInt(config.HealthPort),
+			Scheme: corev1.URISchemeHTTP,
+		},
+	}
+	name := NodeLocalCacheDaemonSetName(dns)
+	selector := NodeLocalCacheDaemonSetPodSelector(dns)
+	daemonset := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name.Name,
+			Namespace: name.Namespace,
+			Labels: map[string]string{
+				manifests.OwningDNSLabel: DNSDaemonSetLabel(dns),
+			},
+		},
+		Spec: appsv1.DaemonSetSpec{
+			Selector: selector,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						workloadPartitioningManagement: `{"effect": "PreferredDuringScheduling"}`,
+					},
+					Labels: selector.MatchLabels,
+				},
+				Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{{
+						Name:            "setup-interface",
+						Image:           openshiftCLIImage,
+						ImagePullPolicy: corev1.PullIfNotPresent,
+						Command:         []string{"/bin/bash", "-c", nodeLocalCacheSetupScript(config.Interface)},
+						Env:             localIPEnv,
+						Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{
+								corev1.ResourceCPU:    resource.MustParse("5m"),
+								corev1.ResourceMemory: resource.MustParse("10Mi"),
+							},
+						},
+						SecurityContext: &corev1.SecurityContext{
+							Privileged:             &trueVal,
+							ReadOnlyRootFilesystem: &trueVal,
+						},
+						TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+					}},
+					Containers: []corev1.Container{{
+						Name:            "dns",
+						Image:           coreDNSImage,
+						ImagePullPolicy: corev1.PullIfNotPresent,
+						Command:         []string{"coredns"},
+						Args:            []string{"-conf", "/etc/coredns/Corefile"},
+						// The container declares no ports
+						// because ports of a pod on the host
+						// network become host ports, which
+						// would keep the caches of different
+						// DNSes off the same node even though
+						// each binds port 53 only on its own
+						// local IP address.
+						ReadinessProbe: &corev1.Probe{
+							ProbeHandler:     probeHandler,
+							PeriodSeconds:    3,
+							TimeoutSeconds:   3,
+							SuccessThreshold: 1,
+							FailureThreshold: 3,
+						},
+						LivenessProbe: &corev1.Probe{
+							ProbeHandler:        probeHandler,
+							InitialDelaySeconds: 60,
+							TimeoutSeconds:      5,
+							SuccessThreshold:    1,
+							FailureThreshold:    5,
+						},
+						Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{
+								corev1.ResourceCPU:    resource.MustParse("25m"),
+								corev1.ResourceMemory: resource.MustParse("30Mi"),
+							},
+						},
+						SecurityContext: &corev1.SecurityContext{
+							Capabilities: &corev1.Capabilities{
+								Add: []corev1.Capability{"NET_BIND_SERVICE"},
+							},
+							ReadOnlyRootFilesystem: &trueVal,
+						},
+						TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+						VolumeMounts: []corev1.VolumeMount{{
+							Name:      "config-volume",
+							MountPath: "/etc/coredns",
+							ReadOnly:  true,
+						}},
+					}},
+					DNSPolicy: corev1.DNSDefault,
+					// The cache must listen on an address that
+					// is local to each node.
+					HostNetwork: true,
+					NodeSelector: map[string]string{
+						"kubernetes.io/os": "linux",
+					},
+					PriorityClassName: "system-node-critical",
+					// Like the node-resolver pods, the cache
+					// pods are privileged and use the host
+					// network, so they use the same service
+					// account.
+					ServiceAccountName: "node-resolver",
+					Tolerations: []corev1.Toleration{{
+						Operator: corev1.TolerationOpExists,
+					}},
+					Volumes: []corev1.Volume{{
+						Name: "config-volume",
+						VolumeSource: corev1.VolumeSource{
+							ConfigMap: &corev1.ConfigMapVolumeSource{
+								LocalObjectReference: corev1.LocalObjectReference{
+									Name: NodeLocalCacheConfigMapName(dns).Name,
+								},
+								Items: []corev1.KeyToPath{{
+									Key:  "Corefile",
+									Path: "Corefile",
+								}},
+							},
+						},
+					}},
+				},
+			},
+			UpdateStrategy: appsv1.DaemonSetUpdateStrategy{
+				Type: appsv1.RollingUpdateDaemonSetStrategyType,
+				RollingUpdate: &appsv1.RollingUpdateDaemonSet{
+					MaxSurge:       &maxSurge,
+					MaxUnavailable: &maxUnavailable,
+				},
+			},
+		},
+	}
+	daemonset.SetOwnerReferences([]metav1.OwnerReference{dnsOwnerRef(dns)})
+	return daemonset
+}
+
+// desiredNodeLocalCacheTeardownDaemonSet returns the node-local DNS cache
+// daemonset that replaces the cache pods with pods that remove the cache's
+// dummy interface from the nodes and then wait to be deleted.
+func desiredNodeLocalCacheTeardownDaemonSet(dns *operatorv1.DNS, openshiftCLIImage string) *appsv1.DaemonSet {
+	trueVal := true
+	daemonset := desiredNodeLocalCacheDaemonSet(dns, nodeLocalCacheConfig{LocalIP: defaultNodeLocalCacheIP, Interface: nodeLocalCacheInterfaceName(dns)}, "", openshiftCLIImage)
+	daemonset.Annotations = map[string]string{nodeLocalCacheTeardownAnnotationKey: ""}
+	spec := &daemonset.Spec.Template.Spec
+	spec.InitContainers = []corev1.Container{{
+		Name:            "teardown-interface",
+		Image:           openshiftCLIImage,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"/bin/bash", "-c", nodeLocalCacheTeardownScript(nodeLocalCacheInterfaceName(dns))},
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("5m"),
+				corev1.ResourceMemory: resource.MustParse("10Mi"),
+			},
+		},
+		SecurityContext: &corev1.SecurityContext{
+			Privileged:             &trueVal,
+			ReadOnlyRootFilesystem: &trueVal,
+		},
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}}
+	spec.Containers = []corev1.Container{{
+		Name:            "wait",
+		Image:           openshiftCLIImage,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"/bin/bash", "-c", "trap 'exit 0' TERM INT; sleep infinity & wait"},
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1m"),
+				corev1.ResourceMemory: resource.MustParse("10Mi"),
+			},
+		},
+		SecurityContext: &corev1.SecurityContext{
+			ReadOnlyRootFilesystem: &trueVal,
+		},
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}}
+	spec.Volumes = nil
+	return daemonset
+}
+
+// nodeLocalCacheDaemonSetConfigChanged checks if the current config matches
+// the expected config for the node-local DNS cache daemonset and if not
+// returns the updated config.
+func nodeLocalCacheDaemonSetConfigChanged(current, expected *appsv1.DaemonSet) (bool, *appsv1.DaemonSet) {
+	changed := false
+	updated := current.DeepCopy()
+
+	// The cache may be re-enabled while it is being torn down.
+	if _, ok := current.Annotations[nodeLocalCacheTeardownAnnotationKey]; ok {
+		if _, ok := expected.Annotations[nodeLocalCacheTeardownAnnotationKey]; !ok {
+			delete(updated.Annotations, nodeLocalCacheTeardownAnnotationKey)
+			changed = true
+		}
+	}
+	if !cmp.Equal(current.Spec.UpdateStrategy, expected.Spec.UpdateStrategy, cmpopts.EquateEmpty()) {
+		updated.Spec.UpdateStrategy = expected.Spec.UpdateStrategy
+		changed = true
+	}
+	if !containersEqual(current.Spec.Template.Spec.InitContainers, expected.Spec.Template.Spec.InitContainers) {
+		updated.Spec.Template.Spec.InitContainers = expected.Spec.Template.Spec.InitContainers
+		changed = true
+	}
+	if !containersEqual(current.Spec.Template.Spec.Containers, expected.Spec.Template.Spec.Containers) {
+		updated.Spec.Template.Spec.Containers = expected.Spec.Template.Spec.Containers
+		changed = true
+	}
+	if !cmp.Equal(current.Spec.Template.Spec.NodeSelector, expected.Spec.Template.Spec.NodeSelector, cmpopts.EquateEmpty()) {
+		updated.Spec.Template.Spec.NodeSelector = expected.Spec.Template.Spec.NodeSelector
+		changed = true
+	}
+	if !cmp.Equal(current.Spec.Template.Spec.Tolerations, expected.Spec.Template.Spec.Tolerations, cmpopts.EquateEmpty(), cmpopts.SortSlices(cmpTolerations)) {
+		updated.Spec.Template.Spec.Tolerations = expected.Spec.Template.Spec.Tolerations
+		changed = true
+	}
+	if !cmp.Equal(current.Spec.Template.Spec.Volumes, expected.Spec.Template.Spec.Volumes, cmpopts.EquateEmpty(), cmp.Comparer(cmpConfigMapVolumeSource)) {
+		updated.Spec.Template.Spec.Volumes = expected.Spec.Template.Spec.Volumes
+		changed = true
+	}
+
+	if !changed {
+		return false, nil
+	}
+	return true, updated
+}
+
+// containersEqual compares the fields of the given containers that the
+// operator sets and that the API server does not default.
+func containersEqual(current, expected []corev1.Container) bool {
+	if len(current) != len(expected) {
+		return false
+	}
+	for i := range expected {
+		a, b := current[i], expected[i]
+		if a.Name != b.Name || a.Image != b.Image {
+			return false
+		}
+		if !cmp.Equal(a.Command, b.Command, cmpopts.EquateEmpty()) || !cmp.Equal(a.Args, b.Args, cmpopts.EquateEmpty()) {
+			return false
+		}
+		if !cmp.Equal(a.Env, b.Env, cmpopts.EquateEmpty()) {
+			return false
+		}
+		if !cmp.Equal(a.Ports, b.Ports, cmpopts.EquateEmpty()) {
+			return false
+		}
+		if !cmp.Equal(a.Resources, b.Resources, cmpopts.EquateEmpty()) {
+			return false
+		}
+	}
+	return true
+}
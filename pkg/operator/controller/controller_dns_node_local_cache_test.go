@@ -0,0 +1,368 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNodeLocalCacheConfigForDNS(t *testing.T) {
+	testCases := []struct {
+		name           string
+		annotation     string
+		expectedConfig nodeLocalCacheConfig
+		expectError    bool
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:           "disabled",
+			annotation:     `{"enabled":false}`,
+			expectedConfig: nodeLocalCacheConfig{LocalIP: defaultNodeLocalCacheIP, HealthPort: 8091, Interface: "nodelocaldns"},
+		},
+		{
+			name:           "enabled with the default local IP",
+			annotation:     `{"enabled":true}`,
+			expectedConfig: nodeLocalCacheConfig{Enabled: true, LocalIP: defaultNodeLocalCacheIP, HealthPort: 8091, Interface: "nodelocaldns"},
+		},
+		{
+			name:           "enabled with an IPv6 local IP",
+			annotation:     `{"enabled":true,"localIP":"FE80::A"}`,
+			expectedConfig: nodeLocalCacheConfig{Enabled: true, LocalIP: "fe80::a", HealthPort: 8091, Interface: "nodelocaldns"},
+		},
+		{
+			name:           "enabled with a health port",
+			annotation:     `{"enabled":true,"healthPort":9000}`,
+			expectedConfig: nodeLocalCacheConfig{Enabled: true, LocalIP: defaultNodeLocalCacheIP, HealthPort: 9000, Interface: "nodelocaldns"},
+		},
+		{
+			name:        "health port that conflicts with DNS",
+			annotation:  `{"enabled":true,"healthPort":53}`,
+			expectError: true,
+		},
+		{
+			name:        "health port out of range",
+			annotation:  `{"enabled":true,"healthPort":70000}`,
+			expectError: true,
+		},
+		{
+			name:        "local IP that is not link-local",
+			annotation:  `{"enabled":true,"localIP":"10.0.0.10"}`,
+			expectError: true,
+		},
+		{
+			name:        "invalid JSON",
+			annotation:  `{"enabled":`,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dns := &operatorv1.DNS{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: DefaultDNSController,
+				},
+			}
+			if len(tc.annotation) != 0 {
+				dns.Annotations = map[string]string{
+					nodeLocalCacheAnnotationKey: tc.annotation,
+				}
+			}
+			config, err := nodeLocalCacheConfigForDNS(dns)
+			switch {
+			case tc.expectError && err == nil:
+				t.Fatalf("expected an error, got %+v", config)
+			case !tc.expectError && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.expectedConfig, config); diff != "" {
+				t.Errorf("unexpected config (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDesiredNodeLocalCacheConfigMap(t *testing.T) {
+	dns := &operatorv1.DNS{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: DefaultDNSController,
+		},
+		Spec: operatorv1.DNSSpec{
+			LogLevel: operatorv1.DNSLogLevelDebug,
+			Cache: operatorv1.DNSCache{
+				PositiveTTL: metav1.Duration{Duration: 30 * time.Second},
+			},
+		},
+	}
+	config := nodeLocalCacheConfig{Enabled: true, LocalIP: defaultNodeLocalCacheIP, HealthPort: defaultNodeLocalCacheHealthPort, Interface: defaultNodeLocalCacheInterface}
+	cm, err := desiredNodeLocalCacheConfigMap(dns, config, "172.30.0.10")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(cm.Data["Corefile"], mustLoadTestFile(t, "node_local_cache")); diff != "" {
+		t.Errorf("unexpected Corefile;\n%s", diff)
+	}
+}
+
+func TestNodeLocalCacheDaemonSetConfigChanged(t *testing.T) {
+	dns := &operatorv1.DNS{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: DefaultDNSController,
+		},
+	}
+	config := nodeLocalCacheConfig{Enabled: true, LocalIP: defaultNodeLocalCacheIP, HealthPort: defaultNodeLocalCacheHealthPort, Interface: defaultNodeLocalCacheInterface}
+	testCases := []struct {
+		description string
+		mutate      func(*appsv1.DaemonSet)
+		expect      bool
+	}{
+		{
+			description: "if nothing changes",
+			mutate:      func(_ *appsv1.DaemonSet) {},
+			expect:      false,
+		},
+		{
+			description: "if the API server sets defaults",
+			mutate: func(ds *appsv1.DaemonSet) {
+				ds.Spec.Template.Spec.SchedulerName = "default-scheduler"
+				ds.Spec.Template.Spec.Containers[0].TerminationMessagePath = "/dev/termination-log"
+			},
+			expect: false,
+		},
+		{
+			description: "if the CoreDNS image changes",
+			mutate: func(ds *appsv1.DaemonSet) {
+				ds.Spec.Template.Spec.Containers[0].Image = "coredns:old"
+			},
+			expect: true,
+		},
+		{
+			description: "if the local IP changes",
+			mutate: func(ds *appsv1.DaemonSet) {
+				ds.Spec.Template.Spec.InitContainers[0].Env[0].Value = "169.254.0.1/32"
+			},
+			expect: true,
+		},
+		{
+			description: "if the cache is re-enabled during teardown",
+			mutate: func(ds *appsv1.DaemonSet) {
+				ds.Annotations = map[string]string{nodeLocalCacheTeardownAnnotationKey: ""}
+			},
+			expect: true,
+		},
+		{
+			// Ports of a pod on the host network become host
+			// ports, which keep other DNSes' caches off the node.
+			description: "if the cache declares port 53",
+			mutate: func(ds *appsv1.DaemonSet) {
+				ds.Spec.Template.Spec.Containers[0].Ports = []corev1.ContainerPort{{Name: "dns", ContainerPort: 53, HostPort: 53, Protocol: corev1.ProtocolUDP}}
+			},
+			expect: true,
+		},
+		{
+			description: "if the update strategy changes",
+			mutate: func(ds *appsv1.DaemonSet) {
+				ds.Spec.UpdateStrategy = appsv1.DaemonSetUpdateStrategy{Type: appsv1.OnDeleteDaemonSetStrategyType}
+			},
+			expect: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		original := desiredNodeLocalCacheDaemonSet(dns, config, "coredns:new", "cli:new")
+		mutated := original.DeepCopy()
+		tc.mutate(mutated)
+		if changed, updated := nodeLocalCacheDaemonSetConfigChanged(mutated, original); changed != tc.expect {
+			t.Errorf("%s, expect nodeLocalCacheDaemonSetConfigChanged to be %t, got %t", tc.description, tc.expect, changed)
+		} else if changed {
+			if changedAgain, _ := nodeLocalCacheDaemonSetConfigChanged(updated, original); changedAgain {
+				t.Errorf("%s, nodeLocalCacheDaemonSetConfigChanged does not behave as a fixed point function", tc.description)
+			}
+		}
+	}
+}
+
+// TestNodeLocalCachePerDNS verifies that DNSes other than the default DNS get
+// their own interface name and health port and that DNSes whose caches would
+// conflict are rejected.
+func TestNodeLocalCachePerDNS(t *testing.T) {
+	dns := func(name, annotation string) operatorv1.DNS {
+		return operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{nodeLocalCacheAnnotationKey: annotation},
+		}}
+	}
+	defaultDNS := dns(DefaultDNSController, `{"enabled":true}`)
+	defaultConfig, err := nodeLocalCacheConfigForDNS(&defaultDNS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := dns("other", `{"enabled":true,"localIP":"169.254.20.11"}`)
+	otherConfig, err := nodeLocalCacheConfigForDNS(&other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if otherConfig.Interface == defaultConfig.Interface || len(otherConfig.Interface) > 15 {
+		t.Errorf("expected a distinct interface name of at most 15 characters, got %q", otherConfig.Interface)
+	}
+	if otherConfig.HealthPort == defaultConfig.HealthPort {
+		t.Errorf("expected a distinct health port, got %d", otherConfig.HealthPort)
+	}
+
+	testCases := []struct {
+		name        string
+		dns         operatorv1.DNS
+		expectError string
+	}{
+		{
+			name: "distinct local IP",
+			dns:  other,
+		},
+		{
+			name:        "same local IP",
+			dns:         dns("other", `{"enabled":true}`),
+			expectError: "localIP 169.254.20.10 is already used",
+		},
+		{
+			name:        "same health port",
+			dns:         dns("other", `{"enabled":true,"localIP":"169.254.20.11","healthPort":8091}`),
+			expectError: "healthPort 8091 is already used",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config, err := nodeLocalCacheConfigForDNS(&tc.dns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = nodeLocalCacheConflicts(&tc.dns, config, []operatorv1.DNS{defaultDNS, tc.dns})
+			switch {
+			case len(tc.expectError) == 0 && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case len(tc.expectError) != 0 && (err == nil || !strings.Contains(err.Error(), tc.expectError)):
+				t.Errorf("expected error containing %q, got %v", tc.expectError, err)
+			}
+		})
+	}
+}
+
+// TestEnsureNodeLocalCacheDeleted verifies that disabling the cache first
+// replaces the cache pods with pods that remove the interface and deletes the
+// daemonset only once those pods are available.
+func TestEnsureNodeLocalCacheDeleted(t *testing.T) {
+	scheme := runtime.NewScheme()
+	corev1.AddToScheme(scheme)
+	appsv1.AddToScheme(scheme)
+	dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+	config, err := nodeLocalCacheConfigForDNS(&operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{
+		Name:        DefaultDNSController,
+		Annotations: map[string]string{nodeLocalCacheAnnotationKey: `{"enabled":true}`},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := desiredNodeLocalCacheDaemonSet(dns, config, "coredns", "cli")
+	for _, container := range cache.Spec.Template.Spec.Containers {
+		for _, arg := range container.Command {
+			if strings.Contains(arg, "ip link del") {
+				t.Errorf("expected the cache pods not to delete the interface, got container %s with command %q", container.Name, container.Command)
+			}
+		}
+	}
+	r := &reconciler{client: fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(cache).Build()}
+	name := NodeLocalCacheDaemonSetName(dns)
+
+	haveDS, current, err := r.currentNodeLocalCacheDaemonSet(dns)
+	if err != nil || !haveDS {
+		t.Fatalf("expected the daemonset to exist: %v", err)
+	}
+	if err := r.ensureNodeLocalCacheDeleted(dns, haveDS, current); err != nil {
+		t.Fatal(err)
+	}
+	haveDS, current, err = r.currentNodeLocalCacheDaemonSet(dns)
+	if err != nil || !haveDS {
+		t.Fatalf("expected the daemonset to be kept for teardown: %v", err)
+	}
+	if _, ok := current.Annotations[nodeLocalCacheTeardownAnnotationKey]; !ok {
+		t.Fatalf("expected the daemonset to have the teardown annotation, got %v", current.Annotations)
+	}
+	if init := current.Spec.Template.Spec.InitContainers; len(init) != 1 || !strings.Contains(init[0].Command[2], "ip link del nodelocaldns") {
+		t.Errorf("expected an init container that deletes the interface, got %+v", init)
+	}
+
+	current.Status = appsv1.DaemonSetStatus{DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberAvailable: 2}
+	if err := r.ensureNodeLocalCacheDeleted(dns, true, current); err != nil {
+		t.Fatal(err)
+	}
+	if haveDS, _, _ := r.currentNodeLocalCacheDaemonSet(dns); !haveDS {
+		t.Fatalf("expected daemonset %s to be kept until the teardown pods are available", name)
+	}
+
+	current.Status.NumberAvailable = 3
+	if err := r.ensureNodeLocalCacheDeleted(dns, true, current); err != nil {
+		t.Fatal(err)
+	}
+	if haveDS, _, _ := r.currentNodeLocalCacheDaemonSet(dns); haveDS {
+		t.Errorf("expected daemonset %s to be deleted once the teardown pods are available", name)
+	}
+}
+
+// TestEnsureDNSDeletedTearsDownNodeLocalCache verifies that deleting a DNS
+// tears down its node-local DNS cache and reports the DNS as deleted only once
+// the teardown has finished.
+func TestEnsureDNSDeletedTearsDownNodeLocalCache(t *testing.T) {
+	scheme := runtime.NewScheme()
+	corev1.AddToScheme(scheme)
+	appsv1.AddToScheme(scheme)
+	policyv1.AddToScheme(scheme)
+	dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: "tenant"}}
+	config, err := nodeLocalCacheConfigForDNS(&operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{
+		Name:        dns.Name,
+		Annotations: map[string]string{nodeLocalCacheAnnotationKey: `{"enabled":true,"localIP":"169.254.20.11"}`},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := desiredNodeLocalCacheDaemonSet(dns, config, "coredns", "cli")
+	r := &reconciler{client: fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(cache).Build()}
+
+	if deleted, err := r.ensureDNSDeleted(dns); err != nil {
+		t.Fatal(err)
+	} else if deleted {
+		t.Fatal("expected the dns not to be reported as deleted before the cache is torn down")
+	}
+	haveDS, current, err := r.currentNodeLocalCacheDaemonSet(dns)
+	if err != nil || !haveDS {
+		t.Fatalf("expected the cache daemonset to be kept for teardown: %v", err)
+	}
+	if _, ok := current.Annotations[nodeLocalCacheTeardownAnnotationKey]; !ok {
+		t.Fatalf("expected the cache daemonset to have the teardown annotation, got %v", current.Annotations)
+	}
+
+	current.Status = appsv1.DaemonSetStatus{DesiredNumberScheduled: 3, UpdatedNumberScheduled: 3, NumberAvailable: 3}
+	if err := r.client.Status().Update(context.Background(), current); err != nil {
+		t.Fatal(err)
+	}
+	if deleted, err := r.ensureDNSDeleted(dns); err != nil {
+		t.Fatal(err)
+	} else if !deleted {
+		t.Error("expected the dns to be reported as deleted once the cache is torn down")
+	}
+	if haveDS, _, _ := r.currentNodeLocalCacheDaemonSet(dns); haveDS {
+		t.Error("expected the cache daemonset to be deleted")
+	}
+}
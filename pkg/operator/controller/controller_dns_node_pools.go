@@ -0,0 +1,591 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	configv1 "github.com/openshift/api/config/v1"
+	operatorv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/cluster-dns-operator/pkg/manifests"
+
+	"github.com/sirupsen/logrus"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// dnsNodePoolsAnnotationKey is the annotation on a DNS that configures
+	// node pools with their own Corefile.  The value is a JSON list of
+	// objects with the following fields:
+	//
+	//   - "name" is the name of the node pool, which must be a valid DNS
+	//     label and unique within the list.
+	//   - "nodeSelector" is the non-empty set of node labels that select
+	//     the nodes of the node pool.
+	//   - "servers" is a list of servers, in the format of the DNS's
+	//     spec.servers, that replace the DNS's servers with the same name
+	//     or else are added to them on the nodes of the node pool.
+	//   - "upstreamResolvers" replaces the DNS's spec.upstreamResolvers on
+	//     the nodes of the node pool.
+	//
+	// For each node pool, the operator renders a configmap with the
+	// overridden Corefile and runs a daemonset that mounts it on the nodes
+	// of the node pool.  The DNS daemonset and the corefile canary
+	// daemonset do not run on these nodes once the node pool's daemonset
+	// has become available for the first time.  Node pools must not overlap so
+	// that every node runs exactly one DNS pod: any two node selectors must
+	// require different values for some label.  Corefile changes for a node
+	// pool are not tried on canary nodes first, and servers and upstream
+	// resolvers of a node pool cannot use DNS-over-TLS.
+	dnsNodePoolsAnnotationKey = "dns.operator.openshift.io/node-pools"
+
+	// nodePoolHandoverAnnotationKey is the annotation on a node pool's
+	// daemonset that records the node selector for which the daemonset
+	// has become available.  The DNS daemonset leaves the nodes of a node
+	// pool to the node pool's daemonset only once the daemonset has this
+	// annotation with the node pool's node selector, so that a rolling
+	// update of the node pool's daemonset does not bring the DNS
+	// daemonset back to the node pool's nodes.
+	nodePoolHandoverAnnotationKey = "dns.operator.openshift.io/node-pool-handover"
+
+	// maxDNSNodePoolAffinityTerms is the highest number of node selector
+	// terms that the node affinity of the DNS daemonset may need to
+	// exclude the nodes of all node pools.  The affinity has one term for
+	// each combination of one label from each node pool's node selector.
+	maxDNSNodePoolAffinityTerms = 64
+
+	// maxReportedNodePoolNodes is the number of nodes that the Progressing
+	// status condition lists by name for each coverage problem.
+	maxReportedNodePoolNodes = 10
+)
+
+// dnsNodePool is a set of nodes with its own Corefile.
+type dnsNodePool struct {
+	// Name is the name of the node pool.
+	Name string `json:"name"`
+	// NodeSelector is the set of node labels that select the nodes of
+	// the node pool.
+	NodeSelector map[string]string `json:"nodeSelector"`
+	// Servers replace or are added to the DNS's servers.
+	Servers []operatorv1.Server `json:"servers,omitempty"`
+	// UpstreamResolvers, if set, replaces the DNS's upstream resolvers.
+	UpstreamResolvers *operatorv1.UpstreamResolvers `json:"upstreamResolvers,omitempty"`
+}
+
+// dnsNodePoolsForDNS parses and validates the node pools of the given DNS,
+// which are either the node pools of the node pools annotation or the zones
+// of the upstream zones annotation.
+func dnsNodePoolsForDNS(dns *operatorv1.DNS) ([]dnsNodePool, error) {
+	pools := []dnsNodePool{}
+	value, ok := dns.Annotations[dnsNodePoolsAnnotationKey]
+	if ok && len(strings.TrimSpace(value)) != 0 {
+		if err := json.Unmarshal([]byte(value), &pools); err != nil {
+			return nil, fmt.Errorf("failed to parse annotation %s: %w", dnsNodePoolsAnnotationKey, err)
+		}
+	}
+	annotationKey := dnsNodePoolsAnnotationKey
+	zonePools, err := dnsUpstreamZonePoolsForDNS(dns)
+	if err != nil {
+		return nil, err
+	}
+	if len(zonePools) != 0 {
+		if len(pools) != 0 {
+			return nil, fmt.Errorf("invalid annotation %s: zone-aware upstreams cannot be combined with the node pools of annotation %s", dnsUpstreamZonesAnnotationKey, dnsNodePoolsAnnotationKey)
+		}
+		pools, annotationKey = zonePools, dnsUpstreamZonesAnnotationKey
+	}
+	if err := validateDNSNodePools(dns, annotationKey, pools); err != nil {
+		return nil, err
+	}
+	return pools, nil
+}
+
+// validateDNSNodePools validates the given node pools of the given DNS, which
+// are configured by the annotation with the given key.
+func validateDNSNodePools(dns *operatorv1.DNS, annotationKey string, pools []dnsNodePool) error {
+	dnsNodeSelector := nodeSelectorForDNS(dns)
+	names := sets.NewString()
+	terms := 1
+	for i, pool := range pools {
+		if errs := validation.IsDNS1123Label(pool.Name); len(errs) != 0 {
+			return fmt.Errorf("invalid annotation %s: invalid node pool name %q: %s", annotationKey, pool.Name, strings.Join(errs, ", "))
+		}
+		if names.Has(pool.Name) {
+			return fmt.Errorf("invalid annotation %s: duplicate node pool name %q", annotationKey, pool.Name)
+		}
+		names.Insert(pool.Name)
+		if len(pool.NodeSelector) == 0 {
+			return fmt.Errorf("invalid annotation %s: node pool %q must have a node selector", annotationKey, pool.Name)
+		}
+		for k, v := range pool.NodeSelector {
+			if errs := validation.IsQualifiedName(k); len(errs) != 0 {
+				return fmt.Errorf("invalid annotation %s: invalid node selector key %q in node pool %q: %s", annotationKey, k, pool.Name, strings.Join(errs, ", "))
+			}
+			if errs := validation.IsValidLabelValue(v); len(errs) != 0 {
+				return fmt.Errorf("invalid annotation %s: invalid node selector value %q in node pool %q: %s", annotationKey, v, pool.Name, strings.Join(errs, ", "))
+			}
+			// A conflicting label would run the node pool's pods
+			// on nodes that the DNS does not select.
+			if dnsValue, ok := dnsNodeSelector[k]; ok && dnsValue != v {
+				return fmt.Errorf("invalid annotation %s: node selector of node pool %q requires %s=%s, but the DNS's node selector requires %s=%s", annotationKey, pool.Name, k, v, k, dnsValue)
+			}
+		}
+		for _, other := range pools[:i] {
+			if !nodeSelectorsDisjoint(pool.NodeSelector, other.NodeSelector) {
+				return fmt.Errorf("invalid annotation %s: node selectors of node pools %q and %q may select the same nodes", annotationKey, other.Name, pool.Name)
+			}
+		}
+		terms *= len(pool.NodeSelector)
+		if terms > maxDNSNodePoolAffinityTerms {
+			return fmt.Errorf("invalid annotation %s: node selectors of node pools have too many labels to exclude their nodes from the DNS daemonset", annotationKey)
+		}
+		if err := validateDNSNodePoolServers(pool); err != nil {
+			return fmt.Errorf("invalid annotation %s: %w", annotationKey, err)
+		}
+	}
+	return nil
+}
+
+// validateDNSNodePoolServers validates the servers and upstream resolvers of
+// the given node pool.
+func validateDNSNodePoolServers(pool dnsNodePool) error {
+	names := sets.NewString()
+	for _, server := range pool.Servers {
+		if len(server.Name) == 0 {
+			return fmt.Errorf("server in node pool %q must have a name", pool.Name)
+		}
+		if names.Has(server.Name) {
+			return fmt.Errorf("duplicate server %q in node pool %q", server.Name, pool.Name)
+		}
+		names.Insert(server.Name)
+		if len(server.Zones) == 0 {
+			return fmt.Errorf("server %q in node pool %q must have zones", server.Name, pool.Name)
+		}
+		if len(server.ForwardPlugin.Upstreams) == 0 {
+			return fmt.Errorf("server %q in node pool %q must have upstreams", server.Name, pool.Name)
+		}
+		if server.ForwardPlugin.TransportConfig.Transport == operatorv1.TLSTransport {
+			return fmt.Errorf("server %q in node pool %q cannot use the TLS transport", server.Name, pool.Name)
+		}
+	}
+	if pool.UpstreamResolvers != nil && pool.UpstreamResolvers.TransportConfig.Transport == operatorv1.TLSTransport {
+		return fmt.Errorf("upstream resolvers of node pool %q cannot use the TLS transport", pool.Name)
+	}
+	return nil
+}
+
+// nodeSelectorsDisjoint returns a Boolean value indicating whether no node
+// can match both of the given node selectors, which is the case if they
+// require different values for the same label.
+func nodeSelectorsDisjoint(a, b map[string]string) bool {
+	for k, v := range a {
+		if other, ok := b[k]; ok && other != v {
+			return true
+		}
+	}
+	return false
+}
+
+// dnsExcludedNodeSelectors returns the node selectors of the nodes that the
+// dns daemonset leaves to the corefile canary daemonset and to the daemonsets
+// of the node pools of the given DNS, except for the node pools with the given
+// names, whose daemonsets have not become available yet.
+func dnsExcludedNodeSelectors(dns *operatorv1.DNS, pendingNodePools sets.String) ([]map[string]string, error) {
+	selectors := []map[string]string{}
+	canary, err := corefileCanaryConfigForDNS(dns)
+	if err != nil {
+		return nil, err
+	}
+	if canary.Enabled {
+		selectors = append(selectors, canary.NodeSelector)
+	}
+	pools, err := dnsNodePoolsForDNS(dns)
+	if err != nil {
+		return nil, err
+	}
+	for _, pool := range pools {
+		if pendingNodePools.Has(pool.Name) {
+			continue
+		}
+		selectors = append(selectors, pool.NodeSelector)
+	}
+	return selectors, nil
+}
+
+// keepPendingNodePools changes the node affinity of the given desired dns
+// daemonset so that it keeps running on the nodes of the node pools with the
+// given names, whose daemonsets have not become available yet.
+func keepPendingNodePools(dns *operatorv1.DNS, daemonset *appsv1.DaemonSet, pendingNodePools sets.String) error {
+	if pendingNodePools.Len() == 0 {
+		return nil
+	}
+	excluded, err := dnsExcludedNodeSelectors(dns, pendingNodePools)
+	if err != nil {
+		return err
+	}
+	daemonset.Spec.Template.Spec.Affinity = nodeAffinityExcluding(excluded)
+	return nil
+}
+
+// nodeAffinityExcluding returns a node affinity that excludes the nodes that
+// any of the given node selectors selects, or nil if there are none.  A node
+// matches the affinity if, for every node selector, it lacks one of the
+// labels.  Node selector terms are ORed and their requirements are ANDed, so
+// the affinity has one term for each combination of one label from each node
+// selector.
+func nodeAffinityExcluding(nodeSelectors []map[string]string) *corev1.Affinity {
+	if len(nodeSelectors) == 0 {
+		return nil
+	}
+	terms := []corev1.NodeSelectorTerm{{}}
+	for _, nodeSelector := range nodeSelectors {
+		keys := make([]string, 0, len(nodeSelector))
+		for k := range nodeSelector {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		product := make([]corev1.NodeSelectorTerm, 0, len(terms)*len(keys))
+		for _, term := range terms {
+			for _, k := range keys {
+				expressions := append([]corev1.NodeSelectorRequirement{}, term.MatchExpressions...)
+				expressions = append(expressions, corev1.NodeSelectorRequirement{
+					Key:      k,
+					Operator: corev1.NodeSelectorOpNotIn,
+					Values:   []string{nodeSelector[k]},
+				})
+				product = append(product, corev1.NodeSelectorTerm{MatchExpressions: expressions})
+			}
+		}
+		terms = product
+	}
+	return &corev1.Affinity{
+		NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: terms,
+			},
+		},
+	}
+}
+
+// dnsForNodePool returns a copy of the given DNS with the servers and upstream
+// resolvers of the given node pool.
+func dnsForNodePool(dns *operatorv1.DNS, pool dnsNodePool) *operatorv1.DNS {
+	updated := dns.DeepCopy()
+	for _, server := range pool.Servers {
+		replaced := false
+		for i := range updated.Spec.Servers {
+			if updated.Spec.Servers[i].Name == server.Name {
+				updated.Spec.Servers[i] = *server.DeepCopy()
+				replaced = true
+			}
+		}
+		if !replaced {
+			updated.Spec.Servers = append(updated.Spec.Servers, *server.DeepCopy())
+		}
+	}
+	if pool.UpstreamResolvers != nil {
+		updated.Spec.UpstreamResolvers = *pool.UpstreamResolvers.DeepCopy()
+	}
+	return updated
+}
+
+// desiredNodePoolConfigMap returns the desired configmap with the Corefile for
+// the given node pool.
+func desiredNodePoolConfigMap(dns *operatorv1.DNS, pool dnsNodePool, clusterDomain string, caBundleRevisionMap map[string]string, dnsNameResolverEnabled bool, dnsNameResolverNamespaces []string) (*corev1.ConfigMap, error) {
+	cm, err := desiredDNSConfigMap(dnsForNodePool(dns, pool), clusterDomain, caBundleRevisionMap, dnsNameResolverEnabled, dnsNameResolverNamespaces)
+	if err != nil {
+		return nil, err
+	}
+	name := NodePoolConfigMapName(dns, pool.Name)
+	cm.Name = name.Name
+	cm.Namespace = name.Namespace
+	cm.Labels[nodePoolDaemonSetLabel] = pool.Name
+	return cm, nil
+}
+
+// desiredNodePoolDaemonSet returns the desired daemonset for the given node
+// pool.  The daemonset is the same as the DNS daemonset except that it runs
+// only on the nodes of the node pool and mounts the node pool's configmap.
+func desiredNodePoolDaemonSet(dns *operatorv1.DNS, pool dnsNodePool, coreDNSImage, kubeRBACProxyImage string, caBundleRevisionMap map[string]string, tlsSecurityProfile *configv1.TLSSecurityProfile) (*appsv1.DaemonSet, error) {
+	daemonset, err := desiredDNSDaemonSet(dns, coreDNSImage, kubeRBACProxyImage, caBundleRevisionMap, tlsSecurityProfile)
+	if err != nil {
+		return nil, err
+	}
+	name := NodePoolDaemonSetName(dns, pool.Name)
+	daemonset.Name = name.Name
+	daemonset.Namespace = name.Namespace
+	daemonset.Labels[nodePoolDaemonSetLabel] = pool.Name
+	daemonset.Spec.Selector = NodePoolDaemonSetPodSelector(dns, pool.Name)
+	daemonset.Spec.Template.Labels = dnsPodLabels(dns, daemonset.Spec.Selector)
+	daemonset.Spec.Template.Spec.Affinity = nil
+
+	nodeSelector := map[string]string{}
+	for k, v := range nodeSelectorForDNS(dns) {
+		nodeSelector[k] = v
+	}
+	for k, v := range pool.NodeSelector {
+		nodeSelector[k] = v
+	}
+	daemonset.Spec.Template.Spec.NodeSelector = nodeSelector
+
+	for i := range daemonset.Spec.Template.Spec.Volumes {
+		if daemonset.Spec.Template.Spec.Volumes[i].Name == "config-volume" {
+			daemonset.Spec.Template.Spec.Volumes[i].ConfigMap.Name = NodePoolConfigMapName(dns, pool.Name).Name
+		}
+	}
+	return daemonset, nil
+}
+
+// ensureDNSNodePools ensures that the configmap and the daemonset of each node
+// pool of the given DNS exist and that those of node pools that were removed
+// do not.  The daemonsets must run before the DNS daemonset leaves their nodes
+// to them, so returns the names of the node pools whose daemonsets have not
+// become available yet.
+func (r *reconciler) ensureDNSNodePools(dns *operatorv1.DNS, clusterDomain string, caBundleRevisionMap map[string]string, dnsNameResolverNamespaces []string, tlsSecurityProfile *configv1.TLSSecurityProfile) (sets.String, error) {
+	pools, err := dnsNodePoolsForDNS(dns)
+	if err != nil {
+		// Leave the node pools alone rather than deleting them
+		// because of a typo in the annotation.
+		return nil, err
+	}
+	errs := []error{}
+	names := sets.NewString()
+	pending := sets.NewString()
+	for _, pool := range pools {
+		names.Insert(pool.Name)
+		if err := r.ensureNodePoolConfigMap(dns, pool, clusterDomain, caBundleRevisionMap, dnsNameResolverNamespaces); err != nil {
+			errs = append(errs, err)
+			pending.Insert(pool.Name)
+			continue
+		}
+		handedOver, err := r.ensureNodePoolDaemonSet(dns, pool, caBundleRevisionMap, tlsSecurityProfile)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if !handedOver {
+			pending.Insert(pool.Name)
+		}
+	}
+	if err := r.ensureStaleNodePoolsDeleted(dns, names); err != nil {
+		errs = append(errs, err)
+	}
+	return pending, utilerrors.NewAggregate(errs)
+}
+
+// ensureNodePoolConfigMap ensures that the configmap for the given node pool
+// exists and has the desired Corefile.
+func (r *reconciler) ensureNodePoolConfigMap(dns *operatorv1.DNS, pool dnsNodePool, clusterDomain string, caBundleRevisionMap map[string]string, dnsNameResolverNamespaces []string) error {
+	desired, err := desiredNodePoolConfigMap(dns, pool, clusterDomain, caBundleRevisionMap, r.dnsNameResolverEnabled, dnsNameResolverNamespaces)
+	if err != nil {
+		return fmt.Errorf("failed to build configmap for node pool %s: %w", pool.Name, err)
+	}
+	current := &corev1.ConfigMap{}
+	if err := r.client.Get(context.TODO(), NodePoolConfigMapName(dns, pool.Name), current); err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to get configmap for node pool %s: %w", pool.Name, err)
+		}
+		setDesiredStateHash(desired, desiredStateHash(desired.Data))
+		if err := r.client.Create(context.TODO(), desired); err != nil {
+			return fmt.Errorf("failed to create configmap for node pool %s: %w", pool.Name, err)
+		}
+		logrus.Infof("created node pool configmap: %s/%s", desired.Namespace, desired.Name)
+		return nil
+	}
+	_, err = r.updateDNSConfigMap(dns, current, desired)
+	return err
+}
+
+// ensureNodePoolDaemonSet ensures that the daemonset for the given node pool
+// exists.  Returns a Boolean value indicating whether the daemonset has become
+// available for the node pool's node selector, so that the DNS daemonset can
+// leave the node pool's nodes to it.
+func (r *reconciler) ensureNodePoolDaemonSet(dns *operatorv1.DNS, pool dnsNodePool, caBundleRevisionMap map[string]string, tlsSecurityProfile *configv1.TLSSecurityProfile) (bool, error) {
+	desired, err := desiredNodePoolDaemonSet(dns, pool, r.CoreDNSImage, r.KubeRBACProxyImage, caBundleRevisionMap, tlsSecurityProfile)
+	if err != nil {
+		return false, fmt.Errorf("failed to build daemonset for node pool %s: %w", pool.Name, err)
+	}
+	current := &appsv1.DaemonSet{}
+	if err := r.client.Get(context.TODO(), NodePoolDaemonSetName(dns, pool.Name), current); err != nil {
+		if !errors.IsNotFound(err) {
+			return false, fmt.Errorf("failed to get daemonset for node pool %s: %w", pool.Name, err)
+		}
+		setDesiredStateHash(desired, desiredStateHash(desired.Spec))
+		return false, r.createDNSDaemonSet(desired)
+	}
+	updated, err := r.updateDNSDaemonSet(dns, current, desired)
+	if err != nil {
+		return false, err
+	}
+	return r.ensureNodePoolHandover(pool, current, updated)
+}
+
+// ensureNodePoolHandover records on the given current daemonset of the given
+// node pool that the daemonset is available for the node pool's node selector.
+// Returns a Boolean value indicating whether the daemonset has been available
+// for the node pool's node selector.
+func (r *reconciler) ensureNodePoolHandover(pool dnsNodePool, current *appsv1.DaemonSet, updated bool) (bool, error) {
+	selector := labels.SelectorFromSet(pool.NodeSelector).String()
+	if current.Annotations[nodePoolHandoverAnnotationKey] == selector {
+		return true, nil
+	}
+	// The status of a daemonset that was just updated is that of the
+	// previous spec, so check again on the next reconciliation.
+	if updated || !daemonsetIsAvailable(current) {
+		return false, nil
+	}
+	handedOver := current.DeepCopy()
+	if handedOver.Annotations == nil {
+		handedOver.Annotations = map[string]string{}
+	}
+	handedOver.Annotations[nodePoolHandoverAnnotationKey] = selector
+	if err := r.client.Update(context.TODO(), handedOver); err != nil {
+		return false, fmt.Errorf("failed to update daemonset for node pool %s: %w", pool.Name, err)
+	}
+	logrus.Infof("handing over nodes matching %s from dns daemonset to node pool daemonset %s/%s", selector, handedOver.Namespace, handedOver.Name)
+	return true, nil
+}
+
+// ensureStaleNodePoolsDeleted deletes the daemonsets and configmaps of the node
+// pools of the given DNS that are not in the given set of names.
+func (r *reconciler) ensureStaleNodePoolsDeleted(dns *operatorv1.DNS, names sets.String) error {
+	listOpts := []client.ListOption{
+		client.MatchingLabels{manifests.OwningDNSLabel: DNSDaemonSetLabel(dns)},
+		client.HasLabels{nodePoolDaemonSetLabel},
+		client.InNamespace(DefaultOperandNamespace),
+	}
+	daemonsets := &appsv1.DaemonSetList{}
+	if err := r.client.List(context.TODO(), daemonsets, listOpts...); err != nil {
+		return fmt.Errorf("failed to list node pool daemonsets: %w", err)
+	}
+	for i := range daemonsets.Items {
+		daemonset := &daemonsets.Items[i]
+		if names.Has(daemonset.Labels[nodePoolDaemonSetLabel]) {
+			continue
+		}
+		if err := r.client.Delete(context.TODO(), daemonset); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete node pool daemonset %s/%s: %w", daemonset.Namespace, daemonset.Name, err)
+		}
+		logrus.Infof("deleted node pool daemonset: %s/%s", daemonset.Namespace, daemonset.Name)
+	}
+	configmaps := &corev1.ConfigMapList{}
+	if err := r.client.List(context.TODO(), configmaps, listOpts...); err != nil {
+		return fmt.Errorf("failed to list node pool configmaps: %w", err)
+	}
+	for i := range configmaps.Items {
+		cm := &configmaps.Items[i]
+		if names.Has(cm.Labels[nodePoolDaemonSetLabel]) {
+			continue
+		}
+		if err := r.client.Delete(context.TODO(), cm); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete node pool configmap %s/%s: %w", cm.Namespace, cm.Name, err)
+		}
+		logrus.Infof("deleted node pool configmap: %s/%s", cm.Namespace, cm.Name)
+	}
+	return nil
+}
+
+// currentDNSNodePoolMessages returns messages that describe the rollout of
+// the node pools' daemonsets and any nodes that do not run exactly one DNS
+// pod, for the Progressing status condition of the given DNS.
+func (r *reconciler) currentDNSNodePoolMessages(dns *operatorv1.DNS) ([]string, error) {
+	pools, err := dnsNodePoolsForDNS(dns)
+	if err != nil || len(pools) == 0 {
+		// The reconciler reports an invalid annotation.
+		return nil, nil
+	}
+	// The replicas of a dns deployment do not cover every node.
+	if workload, err := dnsWorkloadConfigForDNS(dns); err != nil || workload.Kind != dnsWorkloadKindDaemonSet {
+		return nil, nil
+	}
+	daemonsets := &appsv1.DaemonSetList{}
+	if err := r.cache.List(context.TODO(), daemonsets, client.MatchingLabels{manifests.OwningDNSLabel: DNSDaemonSetLabel(dns)}, client.HasLabels{nodePoolDaemonSetLabel}, client.InNamespace(DefaultOperandNamespace)); err != nil {
+		return nil, fmt.Errorf("failed to list node pool daemonsets: %w", err)
+	}
+	pods := &corev1.PodList{}
+	if err := r.cache.List(context.TODO(), pods, client.MatchingLabels(DNSServingPodSelector(dns).MatchLabels), client.InNamespace(DefaultOperandNamespace)); err != nil {
+		return nil, fmt.Errorf("failed to list dns pods: %w", err)
+	}
+	nodes := &corev1.NodeList{}
+	if err := r.cache.List(context.TODO(), nodes); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	return dnsNodePoolMessages(dns, pools, daemonsets.Items, pods.Items, nodes.Items), nil
+}
+
+// dnsNodePoolMessages returns messages that describe the rollout of the given
+// daemonsets of the given node pools and the nodes that do not run exactly one
+// of the given DNS pods although the DNS's node selector and tolerations allow
+// them to.
+func dnsNodePoolMessages(dns *operatorv1.DNS, pools []dnsNodePool, daemonsets []appsv1.DaemonSet, pods []corev1.Pod, nodes []corev1.Node) []string {
+	messages := []string{}
+	daemonsetsByPool := map[string]*appsv1.DaemonSet{}
+	for i := range daemonsets {
+		daemonsetsByPool[daemonsets[i].Labels[nodePoolDaemonSetLabel]] = &daemonsets[i]
+	}
+	for _, pool := range pools {
+		daemonset, ok := daemonsetsByPool[pool.Name]
+		if !ok {
+			messages = append(messages, fmt.Sprintf("The DNS daemonset for node pool %s does not exist.", pool.Name))
+			continue
+		}
+		want := daemonset.Status.DesiredNumberScheduled
+		have := daemonset.Status.UpdatedNumberScheduled
+		if have < want {
+			messages = append(messages, fmt.Sprintf("Have %d up-to-date DNS pods in node pool %s, want %d.", have, pool.Name, want))
+		}
+	}
+
+	podsByNode := map[string]int{}
+	for i := range pods {
+		pod := &pods[i]
+		if len(pod.Spec.NodeName) != 0 && !podIsTerminal(pod) && pod.DeletionTimestamp == nil {
+			podsByNode[pod.Spec.NodeName]++
+		}
+	}
+	template := &corev1.PodTemplateSpec{
+		Spec: corev1.PodSpec{
+			NodeSelector: nodeSelectorForDNS(dns),
+			Tolerations:  tolerationsForDNS(dns),
+		},
+	}
+	var uncovered, overcovered []string
+	for i := range nodes {
+		node := &nodes[i]
+		switch count := podsByNode[node.Name]; {
+		case count > 1:
+			overcovered = append(overcovered, node.Name)
+		case count == 0:
+			if fits, _ := dnsPodFitsNode(template, node, nil); fits {
+				uncovered = append(uncovered, node.Name)
+			}
+		}
+	}
+	if len(uncovered) != 0 {
+		messages = append(messages, fmt.Sprintf("Nodes without a DNS pod: %s.", nodeNamesList(uncovered)))
+	}
+	if len(overcovered) != 0 {
+		messages = append(messages, fmt.Sprintf("Nodes with more than one DNS pod: %s.", nodeNamesList(overcovered)))
+	}
+	return messages
+}
+
+// nodeNamesList returns a sorted, comma-separated list of the given node
+// names, truncated to maxReportedNodePoolNodes names.
+func nodeNamesList(names []string) string {
+	sort.Strings(names)
+	if len(names) > maxReportedNodePoolNodes {
+		return strings.Join(names[:maxReportedNodePoolNodes], ", ") + fmt.Sprintf(", and %d more", len(names)-maxReportedNodePoolNodes)
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,355 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestDNSNodePoolsForDNS(t *testing.T) {
+	testCases := []struct {
+		name          string
+		annotation    string
+		expectPools   []string
+		expectedError string
+	}{
+		{
+			name:        "no annotation",
+			expectPools: []string{},
+		},
+		{
+			name:        "disjoint node pools",
+			annotation:  `[{"name":"edge","nodeSelector":{"site":"edge"},"servers":[{"name":"corp","zones":["corp.example.com"],"forwardPlugin":{"upstreams":["10.1.0.53"]}}]},{"name":"dc","nodeSelector":{"site":"dc","role":"worker"}}]`,
+			expectPools: []string{"edge", "dc"},
+		},
+		{
+			name:          "invalid JSON",
+			annotation:    `[{"name":`,
+			expectedError: "failed to parse annotation",
+		},
+		{
+			name:          "invalid name",
+			annotation:    `[{"name":"Edge","nodeSelector":{"site":"edge"}}]`,
+			expectedError: `invalid node pool name "Edge"`,
+		},
+		{
+			name:          "duplicate name",
+			annotation:    `[{"name":"edge","nodeSelector":{"site":"edge"}},{"name":"edge","nodeSelector":{"site":"dc"}}]`,
+			expectedError: `duplicate node pool name "edge"`,
+		},
+		{
+			name:          "empty node selector",
+			annotation:    `[{"name":"edge"}]`,
+			expectedError: `node pool "edge" must have a node selector`,
+		},
+		{
+			name:          "invalid node selector key",
+			annotation:    `[{"name":"edge","nodeSelector":{"-site":"edge"}}]`,
+			expectedError: `invalid node selector key "-site"`,
+		},
+		{
+			name:          "conflict with the DNS's node selector",
+			annotation:    `[{"name":"windows","nodeSelector":{"kubernetes.io/os":"windows"}}]`,
+			expectedError: "but the DNS's node selector requires kubernetes.io/os=linux",
+		},
+		{
+			name:          "overlapping node pools",
+			annotation:    `[{"name":"edge","nodeSelector":{"site":"edge"}},{"name":"gpu","nodeSelector":{"gpu":"true"}}]`,
+			expectedError: `node selectors of node pools "edge" and "gpu" may select the same nodes`,
+		},
+		{
+			name:          "server without zones",
+			annotation:    `[{"name":"edge","nodeSelector":{"site":"edge"},"servers":[{"name":"corp","forwardPlugin":{"upstreams":["10.1.0.53"]}}]}]`,
+			expectedError: `server "corp" in node pool "edge" must have zones`,
+		},
+		{
+			name:          "server with TLS",
+			annotation:    `[{"name":"edge","nodeSelector":{"site":"edge"},"servers":[{"name":"corp","zones":["corp.example.com"],"forwardPlugin":{"upstreams":["10.1.0.53"],"transportConfig":{"transport":"TLS","tls":{"serverName":"dns.corp.example.com"}}}}]}]`,
+			expectedError: `server "corp" in node pool "edge" cannot use the TLS transport`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+			if len(tc.annotation) != 0 {
+				dns.Annotations = map[string]string{dnsNodePoolsAnnotationKey: tc.annotation}
+			}
+			pools, err := dnsNodePoolsForDNS(dns)
+			if len(tc.expectedError) != 0 {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			names := []string{}
+			for _, pool := range pools {
+				names = append(names, pool.Name)
+			}
+			if diff := cmp.Diff(tc.expectPools, names); diff != "" {
+				t.Errorf("unexpected node pools (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNodeAffinityExcluding(t *testing.T) {
+	if affinity := nodeAffinityExcluding(nil); affinity != nil {
+		t.Errorf("expected no affinity, got %+v", affinity)
+	}
+	notIn := func(key, value string) corev1.NodeSelectorRequirement {
+		return corev1.NodeSelectorRequirement{Key: key, Operator: corev1.NodeSelectorOpNotIn, Values: []string{value}}
+	}
+	affinity := nodeAffinityExcluding([]map[string]string{
+		{"canary": "true", "zone": "a"},
+		{"site": "edge"},
+	})
+	expected := []corev1.NodeSelectorTerm{
+		{MatchExpressions: []corev1.NodeSelectorRequirement{notIn("canary", "true"), notIn("site", "edge")}},
+		{MatchExpressions: []corev1.NodeSelectorRequirement{notIn("zone", "a"), notIn("site", "edge")}},
+	}
+	if diff := cmp.Diff(expected, affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms); diff != "" {
+		t.Errorf("unexpected node selector terms (-want +got):\n%s", diff)
+	}
+	for _, tc := range []struct {
+		labels map[string]string
+		expect bool
+	}{
+		{map[string]string{}, true},
+		{map[string]string{"canary": "true"}, true},
+		{map[string]string{"canary": "true", "zone": "a"}, false},
+		{map[string]string{"site": "edge"}, false},
+		{map[string]string{"site": "dc", "zone": "a"}, true},
+	} {
+		node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Labels: tc.labels}}
+		if actual := nodeSelectorMatchesNode(affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution, node); actual != tc.expect {
+			t.Errorf("expected node with labels %v to match: %t, got %t", tc.labels, tc.expect, actual)
+		}
+	}
+}
+
+func TestDesiredNodePoolResources(t *testing.T) {
+	dns := &operatorv1.DNS{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: DefaultDNSController,
+			Annotations: map[string]string{
+				corefileCanaryAnnotationKey: `{"enabled":true,"nodeSelector":{"canary":"true"}}`,
+				dnsNodePoolsAnnotationKey:   `[{"name":"edge","nodeSelector":{"site":"edge"},"servers":[{"name":"corp","zones":["corp.example.com"],"forwardPlugin":{"upstreams":["10.1.0.53"]}}]}]`,
+			},
+		},
+		Spec: operatorv1.DNSSpec{
+			Servers: []operatorv1.Server{{
+				Name:          "corp",
+				Zones:         []string{"corp.example.com"},
+				ForwardPlugin: operatorv1.ForwardPlugin{Upstreams: []string{"10.0.0.53"}},
+			}},
+		},
+	}
+	pools, err := dnsNodePoolsForDNS(dns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := pools[0]
+
+	cm, err := desiredNodePoolConfigMap(dns, pool, "cluster.local", nil, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := NodePoolConfigMapName(dns, "edge"); cm.Namespace != expected.Namespace || cm.Name != expected.Name {
+		t.Errorf("expected name %s, got %s/%s", expected, cm.Namespace, cm.Name)
+	}
+	if cm.Labels[nodePoolDaemonSetLabel] != "edge" {
+		t.Errorf("expected node pool label, got %v", cm.Labels)
+	}
+	if corefile := cm.Data["Corefile"]; !strings.Contains(corefile, "10.1.0.53") || strings.Contains(corefile, "10.0.0.53") {
+		t.Errorf("expected the node pool's server to replace the DNS's server, got Corefile:\n%s", corefile)
+	}
+
+	daemonset, err := desiredNodePoolDaemonSet(dns, pool, "", "", map[string]string{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := NodePoolDaemonSetName(dns, "edge"); daemonset.Namespace != expected.Namespace || daemonset.Name != expected.Name {
+		t.Errorf("expected name %s, got %s/%s", expected, daemonset.Namespace, daemonset.Name)
+	}
+	expectedLabels := map[string]string{
+		dnsServingLabel:        "default",
+		nodePoolDaemonSetLabel: "edge",
+	}
+	if diff := cmp.Diff(expectedLabels, daemonset.Spec.Template.Labels); diff != "" {
+		t.Errorf("unexpected pod labels (-want +got):\n%s", diff)
+	}
+	expectedNodeSelector := map[string]string{
+		"kubernetes.io/os": "linux",
+		"site":             "edge",
+	}
+	if diff := cmp.Diff(expectedNodeSelector, daemonset.Spec.Template.Spec.NodeSelector); diff != "" {
+		t.Errorf("unexpected node selector (-want +got):\n%s", diff)
+	}
+	if daemonset.Spec.Template.Spec.Affinity != nil {
+		t.Errorf("expected no affinity, got %+v", daemonset.Spec.Template.Spec.Affinity)
+	}
+	for _, volume := range daemonset.Spec.Template.Spec.Volumes {
+		if volume.Name == "config-volume" && volume.ConfigMap.Name != cm.Name {
+			t.Errorf("expected config-volume to use configmap %s, got %s", cm.Name, volume.ConfigMap.Name)
+		}
+	}
+
+	// The DNS daemonset excludes canary and node pool nodes, and the
+	// canary daemonset excludes node pool nodes.
+	main, err := desiredDNSDaemonSet(dns, "", "", map[string]string{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(nodeAffinityExcluding([]map[string]string{{"canary": "true"}, {"site": "edge"}}), main.Spec.Template.Spec.Affinity); diff != "" {
+		t.Errorf("unexpected dns daemonset affinity (-want +got):\n%s", diff)
+	}
+	// Until the node pool's daemonset is available, the DNS daemonset
+	// keeps running on the node pool's nodes.
+	if err := keepPendingNodePools(dns, main, sets.NewString("edge")); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(nodeAffinityExcluding([]map[string]string{{"canary": "true"}}), main.Spec.Template.Spec.Affinity); diff != "" {
+		t.Errorf("unexpected dns daemonset affinity with a pending node pool (-want +got):\n%s", diff)
+	}
+	canaryConfig, err := corefileCanaryConfigForDNS(dns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	canary, err := desiredCorefileCanaryDaemonSet(dns, canaryConfig, "", "", map[string]string{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(nodeAffinityExcluding([]map[string]string{{"site": "edge"}}), canary.Spec.Template.Spec.Affinity); diff != "" {
+		t.Errorf("unexpected corefile canary daemonset affinity (-want +got):\n%s", diff)
+	}
+}
+
+func TestDNSNodePoolMessages(t *testing.T) {
+	dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+	pools := []dnsNodePool{
+		{Name: "edge", NodeSelector: map[string]string{"site": "edge"}},
+		{Name: "dc", NodeSelector: map[string]string{"site": "dc"}},
+	}
+	daemonsets := []appsv1.DaemonSet{{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{nodePoolDaemonSetLabel: "edge"}},
+		Status:     appsv1.DaemonSetStatus{DesiredNumberScheduled: 3, UpdatedNumberScheduled: 1},
+	}}
+	linux := map[string]string{"kubernetes.io/os": "linux"}
+	nodes := []corev1.Node{
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: linux}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-2", Labels: linux}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "node-3", Labels: linux}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "windows-1", Labels: map[string]string{"kubernetes.io/os": "windows"}}},
+	}
+	pod := func(name, node string) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       corev1.PodSpec{NodeName: node},
+		}
+	}
+	pods := []corev1.Pod{
+		pod("dns-default-a", "node-1"),
+		pod("dns-default-pool-edge-a", "node-1"),
+		pod("dns-default-b", "node-2"),
+	}
+	expected := []string{
+		"Have 1 up-to-date DNS pods in node pool edge, want 3.",
+		"The DNS daemonset for node pool dc does not exist.",
+		"Nodes without a DNS pod: node-3.",
+		"Nodes with more than one DNS pod: node-1.",
+	}
+	if diff := cmp.Diff(expected, dnsNodePoolMessages(dns, pools, daemonsets, pods, nodes)); diff != "" {
+		t.Errorf("unexpected messages (-want +got):\n%s", diff)
+	}
+}
+
+// TestEnsureNodePoolHandover verifies that the nodes of a node pool are handed
+// over from the DNS daemonset only once the node pool's daemonset has become
+// available for the node pool's node selector and that they stay handed over
+// while the node pool's daemonset rolls out.
+func TestEnsureNodePoolHandover(t *testing.T) {
+	pool := dnsNodePool{Name: "edge", NodeSelector: map[string]string{"site": "edge"}}
+	available := appsv1.DaemonSetStatus{DesiredNumberScheduled: 2, UpdatedNumberScheduled: 2, NumberAvailable: 2}
+	rolling := appsv1.DaemonSetStatus{DesiredNumberScheduled: 2, UpdatedNumberScheduled: 1, NumberAvailable: 1}
+	testCases := []struct {
+		name             string
+		annotation       string
+		status           appsv1.DaemonSetStatus
+		updated          bool
+		expectHandedOver bool
+	}{
+		{
+			name:   "not yet available",
+			status: rolling,
+		},
+		{
+			name:             "available",
+			status:           available,
+			expectHandedOver: true,
+		},
+		{
+			name:             "rolling out after the handover",
+			annotation:       "site=edge",
+			status:           rolling,
+			expectHandedOver: true,
+		},
+		{
+			name:       "just updated for a new node selector",
+			annotation: "site=branch",
+			status:     available,
+			updated:    true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			daemonset := &appsv1.DaemonSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "dns-default-edge",
+					Namespace: DefaultOperandNamespace,
+				},
+				Status: tc.status,
+			}
+			if len(tc.annotation) != 0 {
+				daemonset.Annotations = map[string]string{nodePoolHandoverAnnotationKey: tc.annotation}
+			}
+			scheme := runtime.NewScheme()
+			appsv1.AddToScheme(scheme)
+			fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(daemonset).Build()
+			r := &reconciler{client: fakeClient}
+			current := &appsv1.DaemonSet{}
+			if err := fakeClient.Get(context.Background(), client.ObjectKeyFromObject(daemonset), current); err != nil {
+				t.Fatal(err)
+			}
+
+			handedOver, err := r.ensureNodePoolHandover(pool, current, tc.updated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if handedOver != tc.expectHandedOver {
+				t.Errorf("expected handed over %t, got %t", tc.expectHandedOver, handedOver)
+			}
+			if err := fakeClient.Get(context.Background(), client.ObjectKeyFromObject(daemonset), current); err != nil {
+				t.Fatal(err)
+			}
+			if recorded := current.Annotations[nodePoolHandoverAnnotationKey] == "site=edge"; recorded != tc.expectHandedOver {
+				t.Errorf("expected handover recorded %t, got annotations %v", tc.expectHandedOver, current.Annotations)
+			}
+		})
+	}
+}
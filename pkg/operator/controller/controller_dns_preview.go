@@ -0,0 +1,352 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	configv1 "github.com/openshift/api/config/v1"
+	operatorv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/cluster-dns-operator/pkg/manifests"
+
+	"github.com/sirupsen/logrus"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// dnsPreviewAnnotationKey is the annotation on a DNS that requests a
+	// dry-run preview of a change to the DNS.  The value is a JSON object
+	// with the following fields:
+	//
+	//   - "spec" is the candidate spec of the DNS.  If it is omitted,
+	//     the current spec is used.
+	//   - "annotations" is a map of annotations to set on the candidate
+	//     DNS.  A null value removes the annotation.
+	//
+	// The operator renders the Corefile and the DNS daemonset, or the DNS
+	// deployment if the candidate DNS runs CoreDNS in a deployment, for the
+	// candidate DNS and publishes them, along with their diffs from the
+	// live resources, in the preview configmap.  The live resources are
+	// not modified.  The operator deletes the preview configmap when the
+	// annotation is removed.
+	dnsPreviewAnnotationKey = "dns.operator.openshift.io/preview"
+
+	// dnsPreviewRequestHashAnnotationKey is the annotation on the preview
+	// configmap that records the hash of the preview annotation value
+	// that the preview was rendered for.  Tools that set the preview
+	// annotation should wait for this hash to match before they read the
+	// preview.
+	dnsPreviewRequestHashAnnotationKey = "dns.operator.openshift.io/preview-request-hash"
+
+	// dnsPreviewResultKey is the key in the preview configmap for the
+	// result of the preview, which is one of dnsPreviewResultNoChanges,
+	// dnsPreviewResultChanges, and dnsPreviewResultInvalid.
+	dnsPreviewResultKey = "result"
+	// dnsPreviewMessageKey is the key in the preview configmap for a
+	// human-readable explanation of the result.
+	dnsPreviewMessageKey = "message"
+	// dnsPreviewCorefileKey is the key in the preview configmap for the
+	// candidate Corefile.
+	dnsPreviewCorefileKey = "Corefile"
+	// dnsPreviewCorefileDiffKey is the key in the preview configmap for
+	// the diff from the live Corefile to the candidate Corefile.
+	dnsPreviewCorefileDiffKey = "Corefile.diff"
+	// dnsPreviewDaemonSetKey is the key in the preview configmap for the
+	// candidate DNS daemonset.
+	dnsPreviewDaemonSetKey = "daemonset.yaml"
+	// dnsPreviewDaemonSetDiffKey is the key in the preview configmap for
+	// the diff from the live DNS daemonset to the daemonset that the
+	// operator would apply.
+	dnsPreviewDaemonSetDiffKey = "daemonset.diff"
+	// dnsPreviewDeploymentKey is the key in the preview configmap for the
+	// candidate DNS deployment.
+	dnsPreviewDeploymentKey = "deployment.yaml"
+	// dnsPreviewDeploymentDiffKey is the key in the preview configmap for
+	// the diff from the live DNS deployment to the deployment that the
+	// operator would apply.
+	dnsPreviewDeploymentDiffKey = "deployment.diff"
+
+	// dnsPreviewResultNoChanges indicates that the candidate DNS would
+	// not change the live Corefile or DNS workload.
+	dnsPreviewResultNoChanges = "NoChanges"
+	// dnsPreviewResultChanges indicates that the candidate DNS would
+	// change the live Corefile or DNS workload.
+	dnsPreviewResultChanges = "Changes"
+	// dnsPreviewResultInvalid indicates that the operator could not
+	// render the candidate DNS.
+	dnsPreviewResultInvalid = "Invalid"
+)
+
+// dnsPreviewRequest is the value of the preview annotation.
+type dnsPreviewRequest struct {
+	// Spec is the candidate spec of the DNS.
+	Spec *operatorv1.DNSSpec `json:"spec,omitempty"`
+	// Annotations are the annotations to set on, or, if nil, remove from,
+	// the candidate DNS.
+	Annotations map[string]*string `json:"annotations,omitempty"`
+}
+
+// candidateDNSForPreview returns the candidate DNS that the preview
+// annotation on the given DNS describes.
+func candidateDNSForPreview(dns *operatorv1.DNS, value string) (*operatorv1.DNS, error) {
+	var request dnsPreviewRequest
+	if err := json.Unmarshal([]byte(value), &request); err != nil {
+		return nil, fmt.Errorf("failed to parse annotation %s: %w", dnsPreviewAnnotationKey, err)
+	}
+	candidate := dns.DeepCopy()
+	if request.Spec != nil {
+		candidate.Spec = *request.Spec
+	}
+	if candidate.Annotations == nil {
+		candidate.Annotations = map[string]string{}
+	}
+	for k, v := range request.Annotations {
+		if v == nil {
+			delete(candidate.Annotations, k)
+		} else {
+			candidate.Annotations[k] = *v
+		}
+	}
+	delete(candidate.Annotations, dnsPreviewAnnotationKey)
+	return candidate, nil
+}
+
+// ensureDNSPreview publishes a preview of the change that the preview
+// annotation on the given DNS describes, or deletes the preview configmap if
+// the DNS has no preview annotation.
+func (r *reconciler) ensureDNSPreview(dns *operatorv1.DNS, clusterDomain string, tlsSecurityProfile *configv1.TLSSecurityProfile) error {
+	value, ok := dns.Annotations[dnsPreviewAnnotationKey]
+	if !ok || len(strings.TrimSpace(value)) == 0 {
+		return r.ensureDNSPreviewDeleted(dns)
+	}
+
+	data, err := r.renderDNSPreview(dns, value, clusterDomain, tlsSecurityProfile)
+	if err != nil {
+		data = map[string]string{
+			dnsPreviewResultKey:  dnsPreviewResultInvalid,
+			dnsPreviewMessageKey: err.Error(),
+		}
+	}
+	desired := desiredDNSPreviewConfigMap(dns, desiredStateHash(value), data)
+
+	current := &corev1.ConfigMap{}
+	if err := r.client.Get(context.TODO(), DNSPreviewConfigMapName(dns), current); err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to get dns preview configmap: %w", err)
+		}
+		if err := r.client.Create(context.TODO(), desired); err != nil {
+			return fmt.Errorf("failed to create dns preview configmap: %w", err)
+		}
+		logrus.Infof("created dns preview configmap: %s/%s", desired.Namespace, desired.Name)
+		return nil
+	}
+	if cmp.Equal(current.Data, desired.Data, cmpopts.EquateEmpty()) && current.Annotations[dnsPreviewRequestHashAnnotationKey] == desired.Annotations[dnsPreviewRequestHashAnnotationKey] {
+		return nil
+	}
+	updated := current.DeepCopy()
+	updated.Data = desired.Data
+	if updated.Annotations == nil {
+		updated.Annotations = map[string]string{}
+	}
+	updated.Annotations[dnsPreviewRequestHashAnnotationKey] = desired.Annotations[dnsPreviewRequestHashAnnotationKey]
+	if err := r.client.Update(context.TODO(), updated); err != nil {
+		return fmt.Errorf("failed to update dns preview configmap: %w", err)
+	}
+	logrus.Infof("updated dns preview configmap %s/%s: %s", updated.Namespace, updated.Name, updated.Data[dnsPreviewResultKey])
+	return nil
+}
+
+// dnsPreviewWorkload is the candidate workload that runs CoreDNS in a preview.
+type dnsPreviewWorkload struct {
+	// description names the workload in the preview message.
+	description string
+	// key and diffKey are the keys in the preview configmap for the
+	// rendered workload and its diff from the live workload.
+	key, diffKey string
+	// desired is the workload that the operator would apply.
+	desired interface{}
+	// diff is the diff from the live workload to the workload that the
+	// operator would apply, or empty if the operator would not change it.
+	diff string
+}
+
+// renderDNSPreview renders the Corefile and the DNS daemonset or deployment for
+// the candidate DNS that the given preview annotation value describes and
+// returns the data for the preview configmap.
+func (r *reconciler) renderDNSPreview(dns *operatorv1.DNS, value, clusterDomain string, tlsSecurityProfile *configv1.TLSSecurityProfile) (map[string]string, error) {
+	candidate, err := candidateDNSForPreview(dns, value)
+	if err != nil {
+		return nil, err
+	}
+	workloadConfig, err := dnsWorkloadConfigForDNS(candidate)
+	if err != nil {
+		return nil, err
+	}
+	// The candidate may refer to CA bundles that the operator has not
+	// copied yet; the preview omits their volumes.
+	cmMap := r.caBundleRevisionMap(candidate)
+	namespaces, err := DNSNameResolverNamespaces(candidate, r.dnsNameResolverNamespaces)
+	if err != nil {
+		return nil, err
+	}
+	desiredCM, err := desiredDNSConfigMap(candidate, clusterDomain, cmMap, r.dnsNameResolverEnabled, namespaces)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build configmap: %w", err)
+	}
+	desiredDS, err := desiredDNSDaemonSet(candidate, r.CoreDNSImage, r.KubeRBACProxyImage, cmMap, tlsSecurityProfile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build dns daemonset: %w", err)
+	}
+
+	_, currentCM, err := r.currentDNSConfigMap(dns)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get configmap: %w", err)
+	}
+	var workload dnsPreviewWorkload
+	if workloadConfig.Kind == dnsWorkloadKindDeployment {
+		workload, err = r.renderDNSDeploymentPreview(candidate, workloadConfig, desiredDS)
+	} else {
+		workload, err = r.renderDNSDaemonSetPreview(candidate, desiredDS)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return dnsPreviewData(currentCM, desiredCM, workload)
+}
+
+// renderDNSDaemonSetPreview returns the preview of the given desired DNS
+// daemonset for the given candidate DNS.  The daemonset diff is computed with
+// daemonsetConfigChanged so that it shows only what the operator would
+// actually update.
+func (r *reconciler) renderDNSDaemonSetPreview(candidate *operatorv1.DNS, desired *appsv1.DaemonSet) (dnsPreviewWorkload, error) {
+	_, current, err := r.currentDNSDaemonSet(candidate)
+	if err != nil {
+		return dnsPreviewWorkload{}, fmt.Errorf("failed to get dns daemonset: %w", err)
+	}
+	r.applyAutosizedDNSRequests(candidate, current, desired)
+	workload := dnsPreviewWorkload{
+		description: "the DNS daemonset",
+		key:         dnsPreviewDaemonSetKey,
+		diffKey:     dnsPreviewDaemonSetDiffKey,
+		desired:     desired,
+	}
+	if current == nil {
+		workload.diff = cmp.Diff(nil, desired)
+	} else if changed, updated := daemonsetConfigChanged(current, desired); changed {
+		workload.diff = cmp.Diff(current, updated, cmpopts.EquateEmpty())
+	}
+	return workload, nil
+}
+
+// renderDNSDeploymentPreview returns the preview of the DNS deployment that
+// runs the pod template of the given desired DNS daemonset for the given
+// candidate DNS with the given workload configuration.  The deployment diff is
+// computed with deploymentConfigChanged so that it shows only what the
+// operator would actually update.
+func (r *reconciler) renderDNSDeploymentPreview(candidate *operatorv1.DNS, config dnsWorkloadConfig, daemonset *appsv1.DaemonSet) (dnsPreviewWorkload, error) {
+	haveDeployment, current, err := r.currentDNSDeployment(candidate)
+	if err != nil {
+		return dnsPreviewWorkload{}, fmt.Errorf("failed to get dns deployment: %w", err)
+	}
+	var currentDaemonSet *appsv1.DaemonSet
+	if haveDeployment {
+		currentDaemonSet = DNSDeploymentAsDaemonSet(current)
+	}
+	r.applyAutosizedDNSRequests(candidate, currentDaemonSet, daemonset)
+	nodeList := &corev1.NodeList{}
+	if err := r.cache.List(context.TODO(), nodeList); err != nil {
+		return dnsPreviewWorkload{}, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	replicas := dnsDeploymentReplicas(config, dnsDeploymentNodes(candidate, nodeList.Items))
+	desired := desiredDNSDeployment(candidate, daemonset, replicas)
+	workload := dnsPreviewWorkload{
+		description: "the DNS deployment",
+		key:         dnsPreviewDeploymentKey,
+		diffKey:     dnsPreviewDeploymentDiffKey,
+		desired:     desired,
+	}
+	if !haveDeployment {
+		workload.diff = cmp.Diff(nil, desired)
+	} else if changed, updated := deploymentConfigChanged(current, desired); changed {
+		workload.diff = cmp.Diff(current, updated, cmpopts.EquateEmpty())
+	}
+	return workload, nil
+}
+
+// dnsPreviewData returns the data for the preview configmap given the live
+// and candidate configmaps and the candidate workload.  The live configmap may
+// be nil if it does not exist.
+func dnsPreviewData(currentCM, desiredCM *corev1.ConfigMap, workload dnsPreviewWorkload) (map[string]string, error) {
+	var currentCorefile string
+	if currentCM != nil {
+		currentCorefile = currentCM.Data["Corefile"]
+	}
+	desiredCorefile := desiredCM.Data["Corefile"]
+	corefileDiff := ""
+	if currentCorefile != desiredCorefile {
+		corefileDiff = cmp.Diff(currentCorefile, desiredCorefile)
+	}
+
+	rendered, err := yaml.Marshal(workload.desired)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render %s: %w", workload.description, err)
+	}
+
+	data := map[string]string{
+		dnsPreviewResultKey:       dnsPreviewResultNoChanges,
+		dnsPreviewMessageKey:      fmt.Sprintf("The change would not modify the Corefile or %s.", workload.description),
+		dnsPreviewCorefileKey:     desiredCorefile,
+		dnsPreviewCorefileDiffKey: corefileDiff,
+		workload.key:              string(rendered),
+		workload.diffKey:          workload.diff,
+	}
+	var changed []string
+	if len(corefileDiff) != 0 {
+		changed = append(changed, "the Corefile")
+	}
+	if len(workload.diff) != 0 {
+		changed = append(changed, workload.description)
+	}
+	if len(changed) != 0 {
+		data[dnsPreviewResultKey] = dnsPreviewResultChanges
+		data[dnsPreviewMessageKey] = fmt.Sprintf("The change would modify %s.", strings.Join(changed, " and "))
+	}
+	return data, nil
+}
+
+// desiredDNSPreviewConfigMap returns the preview configmap for the given DNS
+// with the given request hash and data.
+func desiredDNSPreviewConfigMap(dns *operatorv1.DNS, requestHash string, data map[string]string) *corev1.ConfigMap {
+	name := DNSPreviewConfigMapName(dns)
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name.Name,
+			Namespace: name.Namespace,
+			Labels: map[string]string{
+				manifests.OwningDNSLabel: DNSDaemonSetLabel(dns),
+			},
+			Annotations: map[string]string{
+				dnsPreviewRequestHashAnnotationKey: requestHash,
+			},
+		},
+		Data: data,
+	}
+	cm.SetOwnerReferences([]metav1.OwnerReference{dnsOwnerRef(dns)})
+	return cm
+}
+
+// ensureDNSPreviewDeleted deletes the preview configmap for the given DNS if
+// it exists.
+func (r *reconciler) ensureDNSPreviewDeleted(dns *operatorv1.DNS) error {
+	return r.deleteCachedObject("dns preview configmap", DNSPreviewConfigMapName(dns), &corev1.ConfigMap{})
+}
@@ -0,0 +1,219 @@
+package controller
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestCandidateDNSForPreview(t *testing.T) {
+	dns := &operatorv1.DNS{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: DefaultDNSController,
+			Annotations: map[string]string{
+				dnsPreviewAnnotationKey: "ignored",
+				dns64AnnotationKey:      `{"enabled":true}`,
+				"example.com/keep":      "true",
+			},
+		},
+		Spec: operatorv1.DNSSpec{LogLevel: operatorv1.DNSLogLevelNormal},
+	}
+	testCases := []struct {
+		name                string
+		value               string
+		expectedLogLevel    operatorv1.DNSLogLevel
+		expectedAnnotations map[string]string
+		expectError         bool
+	}{
+		{
+			name:             "live spec and annotations",
+			value:            `{}`,
+			expectedLogLevel: operatorv1.DNSLogLevelNormal,
+			expectedAnnotations: map[string]string{
+				dns64AnnotationKey: `{"enabled":true}`,
+				"example.com/keep": "true",
+			},
+		},
+		{
+			name:             "candidate spec",
+			value:            `{"spec":{"logLevel":"Debug"}}`,
+			expectedLogLevel: operatorv1.DNSLogLevelDebug,
+			expectedAnnotations: map[string]string{
+				dns64AnnotationKey: `{"enabled":true}`,
+				"example.com/keep": "true",
+			},
+		},
+		{
+			name:             "annotation set and removed",
+			value:            `{"annotations":{"example.com/new":"1","` + dns64AnnotationKey + `":null}}`,
+			expectedLogLevel: operatorv1.DNSLogLevelNormal,
+			expectedAnnotations: map[string]string{
+				"example.com/keep": "true",
+				"example.com/new":  "1",
+			},
+		},
+		{
+			name:        "invalid JSON",
+			value:       `{"spec":`,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			candidate, err := candidateDNSForPreview(dns, tc.value)
+			switch {
+			case tc.expectError && err == nil:
+				t.Fatalf("expected an error, got %+v", candidate)
+			case !tc.expectError && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case tc.expectError:
+				return
+			}
+			if candidate.Spec.LogLevel != tc.expectedLogLevel {
+				t.Errorf("expected log level %q, got %q", tc.expectedLogLevel, candidate.Spec.LogLevel)
+			}
+			if len(candidate.Annotations) != len(tc.expectedAnnotations) {
+				t.Errorf("expected annotations %v, got %v", tc.expectedAnnotations, candidate.Annotations)
+			}
+			for k, v := range tc.expectedAnnotations {
+				if candidate.Annotations[k] != v {
+					t.Errorf("expected annotations %v, got %v", tc.expectedAnnotations, candidate.Annotations)
+					break
+				}
+			}
+			if _, ok := dns.Annotations[dns64AnnotationKey]; !ok {
+				t.Error("expected the live DNS to be left unmodified")
+			}
+		})
+	}
+}
+
+// TestEnsureDNSPreview verifies that the operator publishes a preview of the
+// candidate DNS without modifying the live resources and deletes the preview
+// when the preview annotation is removed.
+func TestEnsureDNSPreview(t *testing.T) {
+	dns := &operatorv1.DNS{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController},
+		Spec: operatorv1.DNSSpec{
+			LogLevel:          operatorv1.DNSLogLevelNormal,
+			OperatorLogLevel:  operatorv1.DNSLogLevelNormal,
+			UpstreamResolvers: operatorv1.UpstreamResolvers{Policy: operatorv1.SequentialForwardingPolicy},
+		},
+	}
+	liveCM, err := desiredDNSConfigMap(dns, "cluster.local", map[string]string{}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	liveDS, err := desiredDNSDaemonSet(dns, "", "", map[string]string{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		name           string
+		annotation     string
+		expectedResult string
+		expectedDiffs  []string
+		// expectedWorkloadKey is the key of the rendered workload, or
+		// empty for the daemonset.
+		expectedWorkloadKey string
+	}{
+		{
+			name:           "no change",
+			annotation:     `{}`,
+			expectedResult: dnsPreviewResultNoChanges,
+		},
+		{
+			name:           "log level change",
+			annotation:     `{"spec":{"logLevel":"Trace","operatorLogLevel":"Normal","upstreamResolvers":{"policy":"Sequential"}}}`,
+			expectedResult: dnsPreviewResultChanges,
+			expectedDiffs:  []string{dnsPreviewCorefileDiffKey},
+		},
+		{
+			name:           "node placement change",
+			annotation:     `{"spec":{"logLevel":"Normal","operatorLogLevel":"Normal","upstreamResolvers":{"policy":"Sequential"},"nodePlacement":{"nodeSelector":{"dns":"true"}}}}`,
+			expectedResult: dnsPreviewResultChanges,
+			expectedDiffs:  []string{dnsPreviewDaemonSetDiffKey},
+		},
+		{
+			name:                "deployment mode",
+			annotation:          `{"annotations":{"` + dnsWorkloadAnnotationKey + `":"{\"kind\":\"Deployment\"}"}}`,
+			expectedResult:      dnsPreviewResultChanges,
+			expectedDiffs:       []string{dnsPreviewDeploymentDiffKey},
+			expectedWorkloadKey: dnsPreviewDeploymentKey,
+		},
+		{
+			name:           "invalid candidate",
+			annotation:     `{"annotations":{"` + dns64AnnotationKey + `":"not JSON"}}`,
+			expectedResult: dnsPreviewResultInvalid,
+		},
+	}
+
+	scheme := runtime.NewScheme()
+	corev1.AddToScheme(scheme)
+	appsv1.AddToScheme(scheme)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(liveCM.DeepCopy(), liveDS.DeepCopy()).Build()
+			r := &reconciler{client: fakeClient, cache: fakeCache{Reader: fakeClient}}
+			candidate := dns.DeepCopy()
+			candidate.Annotations = map[string]string{dnsPreviewAnnotationKey: tc.annotation}
+
+			if err := r.ensureDNSPreview(candidate, "cluster.local", nil); err != nil {
+				t.Fatal(err)
+			}
+			preview := &corev1.ConfigMap{}
+			if err := fakeClient.Get(context.Background(), DNSPreviewConfigMapName(dns), preview); err != nil {
+				t.Fatalf("expected a preview configmap: %v", err)
+			}
+			if result := preview.Data[dnsPreviewResultKey]; result != tc.expectedResult {
+				t.Errorf("expected result %q, got %q: %s", tc.expectedResult, result, preview.Data[dnsPreviewMessageKey])
+			}
+			if hash := preview.Annotations[dnsPreviewRequestHashAnnotationKey]; hash != desiredStateHash(tc.annotation) {
+				t.Errorf("expected request hash %q, got %q", desiredStateHash(tc.annotation), hash)
+			}
+			for _, key := range []string{dnsPreviewCorefileDiffKey, dnsPreviewDaemonSetDiffKey, dnsPreviewDeploymentDiffKey} {
+				expectDiff := false
+				for _, k := range tc.expectedDiffs {
+					expectDiff = expectDiff || k == key
+				}
+				if hasDiff := len(preview.Data[key]) != 0; hasDiff != expectDiff {
+					t.Errorf("expected %s to be present: %t, got %q", key, expectDiff, preview.Data[key])
+				}
+			}
+			// Only a deployment has replicas.
+			workloadKey, workloadField := dnsPreviewDaemonSetKey, "kind: DaemonSet"
+			if tc.expectedWorkloadKey == dnsPreviewDeploymentKey {
+				workloadKey, workloadField = dnsPreviewDeploymentKey, "replicas: 1"
+			}
+			if tc.expectedResult != dnsPreviewResultInvalid && !strings.Contains(preview.Data[workloadKey], workloadField) {
+				t.Errorf("expected the rendered %s, got %q", workloadKey, preview.Data[workloadKey])
+			}
+
+			cm := &corev1.ConfigMap{}
+			if err := fakeClient.Get(context.Background(), DNSConfigMapName(dns), cm); err != nil {
+				t.Fatal(err)
+			}
+			if cm.Data["Corefile"] != liveCM.Data["Corefile"] {
+				t.Errorf("expected the live Corefile to be left unmodified, got %q", cm.Data["Corefile"])
+			}
+
+			if err := r.ensureDNSPreview(dns, "cluster.local", nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := fakeClient.Get(context.Background(), DNSPreviewConfigMapName(dns), preview); err == nil {
+				t.Error("expected the preview configmap to be deleted")
+			}
+		})
+	}
+}
@@ -0,0 +1,662 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/sirupsen/logrus"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// dnsResourcesAnnotationKey is the annotation on a DNS that configures
+	// the resource requests and limits of the containers of the DNS
+	// daemonset.  The value is a JSON object with the following fields:
+	//
+	//   - "mode" is "Manual", "Recommend", or "Auto".  The default is
+	//     "Manual".
+	//   - "dns" and "kubeRBACProxy" are the resource requirements, with
+	//     "requests" and "limits", of the dns and kube-rbac-proxy
+	//     containers.  Requests that are not specified keep their
+	//     defaults, and limits that are not specified are unset.
+	//   - "autosizing" configures how the operator computes recommended
+	//     requests for the dns container in the Recommend and Auto modes:
+	//       - "cpuPerThousandQPS" is the CPU that CoreDNS needs for every
+	//         1000 queries per second.  The default is 100m.
+	//       - "minCPU" and "maxCPU" bound the recommended CPU request.  The
+	//         defaults are 50m and 4.
+	//       - "minMemory" and "maxMemory" bound the recommended memory
+	//         request.  The defaults are 70Mi and 2Gi.
+	//       - "headroomPercent" is the headroom added to the observed
+	//         peak usage.  The default is 25.
+	//       - "hysteresisPercent" is how far a new recommendation must be
+	//         from the current one before the operator changes it.  The
+	//         default is 20.
+	//       - "window" is a duration that specifies the period over which
+	//         the operator takes the peak of the observed usage.  The
+	//         default is 1h, and the minimum is 10m.
+	//
+	// In the Recommend and Auto modes, the operator scrapes the query rate
+	// and resident memory of each CoreDNS pod every minute and recommends
+	// requests that fit the busiest pod over the window.  A recommendation
+	// is available once the operator has observed the pods for a whole
+	// window, and it changes only if the new recommendation differs from the
+	// previous one by more than the hysteresis.  In the Recommend mode, the
+	// operator reports the recommendation in the ResourceRecommendationPending
+	// status condition and the dns_operator_coredns_recommended_requests
+	// metric.  In the Auto mode, the operator also applies it to the dns
+	// container, capped at the container's limits.
+	dnsResourcesAnnotationKey = "dns.operator.openshift.io/resources"
+
+	// dnsResourcesModeManual applies the configured requests and limits.
+	dnsResourcesModeManual = "Manual"
+	// dnsResourcesModeRecommend applies the configured requests and limits
+	// and reports recommended requests.
+	dnsResourcesModeRecommend = "Recommend"
+	// dnsResourcesModeAuto applies recommended requests.
+	dnsResourcesModeAuto = "Auto"
+
+	// defaultAutosizingHeadroomPercent is the default headroom added to the
+	// observed peak usage.
+	defaultAutosizingHeadroomPercent = 25
+	// defaultAutosizingHysteresisPercent is the default difference between
+	// recommendations below which the recommendation does not change.
+	defaultAutosizingHysteresisPercent = 20
+	// defaultAutosizingWindow is the default period over which the operator
+	// takes the peak of the observed usage.
+	defaultAutosizingWindow = time.Hour
+	// minAutosizingWindow is the minimum autosizing window.
+	minAutosizingWindow = 10 * time.Minute
+	// autosizingSampleInterval is how often the operator scrapes the metrics
+	// of CoreDNS pods for autosizing.
+	autosizingSampleInterval = time.Minute
+	// autosizingScrapeConcurrency is the maximum number of CoreDNS pods
+	// whose metrics the operator scrapes at the same time for autosizing.
+	autosizingScrapeConcurrency = 10
+	// autosizingScrapeTimeout is how long the operator waits for the
+	// metrics of a single CoreDNS pod for autosizing, so that unresponsive
+	// pods cannot delay the sample of the other pods past the next
+	// sample interval.
+	autosizingScrapeTimeout = 10 * time.Second
+
+	// ResourceRecommendationPendingConditionType is the type of the DNS
+	// status condition that indicates whether the requests of the dns
+	// container differ from the recommended requests in the Recommend mode.
+	// It is only reported for a DNS with autosizing enabled.
+	ResourceRecommendationPendingConditionType = "ResourceRecommendationPending"
+)
+
+var (
+	// defaultAutosizingCPUPerThousandQPS, defaultAutosizingMinCPU,
+	// defaultAutosizingMaxCPU, defaultAutosizingMinMemory, and
+	// defaultAutosizingMaxMemory are the defaults for the corresponding
+	// autosizing parameters.
+	defaultAutosizingCPUPerThousandQPS = resource.MustParse("100m")
+	defaultAutosizingMinCPU            = resource.MustParse("50m")
+	defaultAutosizingMaxCPU            = resource.MustParse("4")
+	defaultAutosizingMinMemory         = resource.MustParse("70Mi")
+	defaultAutosizingMaxMemory         = resource.MustParse("2Gi")
+
+	// recommendedRequests reports the recommended requests of the dns
+	// container of each DNS in the Recommend and Auto modes.
+	recommendedRequests = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dns_operator_coredns_recommended_requests",
+		Help: "Recommended requests of the CoreDNS container, in cores for cpu and bytes for memory.",
+	}, []string{"dns", "resource"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(recommendedRequests)
+}
+
+// dnsResourcesConfig is the resource configuration of a DNS.
+type dnsResourcesConfig struct {
+	// Mode is Manual, Recommend, or Auto.
+	Mode string `json:"mode,omitempty"`
+	// DNS is the resource requirements of the dns container.
+	DNS *corev1.ResourceRequirements `json:"dns,omitempty"`
+	// KubeRBACProxy is the resource requirements of the kube-rbac-proxy
+	// container.
+	KubeRBACProxy *corev1.ResourceRequirements `json:"kubeRBACProxy,omitempty"`
+	// Autosizing configures recommendations.
+	Autosizing dnsAutosizingConfig `json:"autosizing,omitempty"`
+}
+
+// dnsAutosizingConfig configures how the operator recommends requests.
+type dnsAutosizingConfig struct {
+	CPUPerThousandQPS *resource.Quantity `json:"cpuPerThousandQPS,omitempty"`
+	MinCPU            *resource.Quantity `json:"minCPU,omitempty"`
+	MaxCPU            *resource.Quantity `json:"maxCPU,omitempty"`
+	MinMemory         *resource.Quantity `json:"minMemory,omitempty"`
+	MaxMemory         *resource.Quantity `json:"maxMemory,omitempty"`
+	HeadroomPercent   *int               `json:"headroomPercent,omitempty"`
+	HysteresisPercent *int               `json:"hysteresisPercent,omitempty"`
+	Window            string             `json:"window,omitempty"`
+
+	window time.Duration
+}
+
+// autosizing returns a Boolean value indicating whether the operator
+// recommends requests in the given configuration's mode.
+func (c dnsResourcesConfig) autosizing() bool {
+	return c.Mode == dnsResourcesModeRecommend || c.Mode == dnsResourcesModeAuto
+}
+
+// dnsResourcesConfigForDNS parses and validates the resource configuration of
+// the given DNS and fills in defaults.
+func dnsResourcesConfigForDNS(dns *operatorv1.DNS) (dnsResourcesConfig, error) {
+	config := dnsResourcesConfig{}
+	value, ok := dns.Annotations[dnsResourcesAnnotationKey]
+	if ok && len(strings.TrimSpace(value)) != 0 {
+		if err := json.Unmarshal([]byte(value), &config); err != nil {
+			return dnsResourcesConfig{}, fmt.Errorf("failed to parse annotation %s: %w", dnsResourcesAnnotationKey, err)
+		}
+	}
+	switch config.Mode {
+	case "":
+		config.Mode = dnsResourcesModeManual
+	case dnsResourcesModeManual, dnsResourcesModeRecommend, dnsResourcesModeAuto:
+	default:
+		return dnsResourcesConfig{}, fmt.Errorf("invalid annotation %s: mode %q must be %q, %q, or %q", dnsResourcesAnnotationKey, config.Mode, dnsResourcesModeManual, dnsResourcesModeRecommend, dnsResourcesModeAuto)
+	}
+	for name, requirements := range map[string]*corev1.ResourceRequirements{"dns": config.DNS, "kubeRBACProxy": config.KubeRBACProxy} {
+		if requirements == nil {
+			continue
+		}
+		for _, list := range []corev1.ResourceList{requirements.Requests, requirements.Limits} {
+			for resourceName, quantity := range list {
+				if resourceName != corev1.ResourceCPU && resourceName != corev1.ResourceMemory {
+					return dnsResourcesConfig{}, fmt.Errorf("invalid annotation %s: %s: unsupported resource %q", dnsResourcesAnnotationKey, name, resourceName)
+				}
+				if quantity.Sign() <= 0 {
+					return dnsResourcesConfig{}, fmt.Errorf("invalid annotation %s: %s: %s quantity %s must be positive", dnsResourcesAnnotationKey, name, resourceName, quantity.String())
+				}
+			}
+		}
+	}
+
+	autosizing := &config.Autosizing
+	for _, q := range []struct {
+		name     string
+		value    **resource.Quantity
+		fallback resource.Quantity
+	}{
+		{"cpuPerThousandQPS", &autosizing.CPUPerThousandQPS, defaultAutosizingCPUPerThousandQPS},
+		{"minCPU", &autosizing.MinCPU, defaultAutosizingMinCPU},
+		{"maxCPU", &autosizing.MaxCPU, defaultAutosizingMaxCPU},
+		{"minMemory", &autosizing.MinMemory, defaultAutosizingMinMemory},
+		{"maxMemory", &autosizing.MaxMemory, defaultAutosizingMaxMemory},
+	} {
+		if *q.value == nil {
+			fallback := q.fallback.DeepCopy()
+			*q.value = &fallback
+		} else if (*q.value).Sign() <= 0 {
+			return dnsResourcesConfig{}, fmt.Errorf("invalid annotation %s: autosizing %s %s must be positive", dnsResourcesAnnotationKey, q.name, (*q.value).String())
+		}
+	}
+	if autosizing.MinCPU.Cmp(*autosizing.MaxCPU) > 0 {
+		return dnsResourcesConfig{}, fmt.Errorf("invalid annotation %s: autosizing minCPU %s must not exceed maxCPU %s", dnsResourcesAnnotationKey, autosizing.MinCPU.String(), autosizing.MaxCPU.String())
+	}
+	if autosizing.MinMemory.Cmp(*autosizing.MaxMemory) > 0 {
+		return dnsResourcesConfig{}, fmt.Errorf("invalid annotation %s: autosizing minMemory %s must not exceed maxMemory %s", dnsResourcesAnnotationKey, autosizing.MinMemory.String(), autosizing.MaxMemory.String())
+	}
+	for _, p := range []struct {
+		name     string
+		value    **int
+		fallback int
+	}{
+		{"headroomPercent", &autosizing.HeadroomPercent, defaultAutosizingHeadroomPercent},
+		{"hysteresisPercent", &autosizing.HysteresisPercent, defaultAutosizingHysteresisPercent},
+	} {
+		if *p.value == nil {
+			fallback := p.fallback
+			*p.value = &fallback
+		} else if v := **p.value; v < 0 || v > 100 {
+			return dnsResourcesConfig{}, fmt.Errorf("invalid annotation %s: autosizing %s %d must be between 0 and 100", dnsResourcesAnnotationKey, p.name, v)
+		}
+	}
+	autosizing.window = defaultAutosizingWindow
+	if len(autosizing.Window) != 0 {
+		d, err := time.ParseDuration(autosizing.Window)
+		if err != nil {
+			return dnsResourcesConfig{}, fmt.Errorf("invalid annotation %s: invalid autosizing window %q: %w", dnsResourcesAnnotationKey, autosizing.Window, err)
+		}
+		if d < minAutosizingWindow {
+			return dnsResourcesConfig{}, fmt.Errorf("invalid annotation %s: autosizing window %q must be at least %v", dnsResourcesAnnotationKey, autosizing.Window, minAutosizingWindow)
+		}
+		autosizing.window = d
+	}
+	return config, nil
+}
+
+// applyContainerResources overrides the given container's requests with the
+// given requirements' requests, sets its limits to the given requirements'
+// limits, and returns an error if a request exceeds its limit.
+func applyContainerResources(container *corev1.Container, requirements *corev1.ResourceRequirements) error {
+	if requirements != nil {
+		requests := corev1.ResourceList{}
+		for k, v := range container.Resources.Requests {
+			requests[k] = v.DeepCopy()
+		}
+		for k, v := range requirements.Requests {
+			requests[k] = v.DeepCopy()
+		}
+		container.Resources.Requests = requests
+		container.Resources.Limits = requirements.Limits.DeepCopy()
+	}
+	for k, limit := range container.Resources.Limits {
+		if request, ok := container.Resources.Requests[k]; ok && request.Cmp(limit) > 0 {
+			return fmt.Errorf("%s container %s request %s exceeds its limit %s", container.Name, k, request.String(), limit.String())
+		}
+	}
+	return nil
+}
+
+// dnsResourceObservation is the peak usage of the CoreDNS pods of a DNS at the
+// time of a scrape.
+type dnsResourceObservation struct {
+	// Time is when the pods were scraped.
+	Time time.Time
+	// QPS is the highest query rate of any pod.
+	QPS float64
+	// MemoryBytes is the highest resident memory of any pod.
+	MemoryBytes float64
+}
+
+// recommendDNSRequests returns the requests for the dns container that fit
+// the peak of the given observations and a Boolean value indicating whether
+// the observations, which start at the given time, cover the whole autosizing
+// window at the given time.
+func recommendDNSRequests(config dnsAutosizingConfig, since time.Time, observations []dnsResourceObservation, now time.Time) (corev1.ResourceList, bool) {
+	if since.IsZero() || now.Sub(since) < config.window {
+		return nil, false
+	}
+	var peakQPS, peakMemory float64
+	found := false
+	for _, o := range observations {
+		if now.Sub(o.Time) > config.window {
+			continue
+		}
+		found = true
+		peakQPS = math.Max(peakQPS, o.QPS)
+		peakMemory = math.Max(peakMemory, o.MemoryBytes)
+	}
+	if !found {
+		return nil, false
+	}
+	headroom := 1 + float64(*config.HeadroomPercent)/100
+
+	// Round CPU up to 10m and memory up to 1Mi so that small fluctuations
+	// do not produce distinct recommendations.
+	cpu := int64(math.Ceil(peakQPS/1000*float64(config.CPUPerThousandQPS.MilliValue())*headroom/10) * 10)
+	cpu = clampInt64(cpu, config.MinCPU.MilliValue(), config.MaxCPU.MilliValue())
+	const mebibyte = 1 << 20
+	memory := int64(math.Ceil(peakMemory*headroom/mebibyte)) * mebibyte
+	memory = clampInt64(memory, config.MinMemory.Value(), config.MaxMemory.Value())
+
+	return corev1.ResourceList{
+		corev1.ResourceCPU:    *resource.NewMilliQuantity(cpu, resource.DecimalSI),
+		corev1.ResourceMemory: *resource.NewQuantity(memory, resource.BinarySI),
+	}, true
+}
+
+// clampInt64 returns v bounded by min and max.
+func clampInt64(v, min, max int64) int64 {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// requestsWithinHysteresis returns a Boolean value indicating whether the
+// current cpu and memory requests are within the given percentage of the
+// recommended ones.
+func requestsWithinHysteresis(current, recommended corev1.ResourceList, percent int) bool {
+	for _, name := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+		r, ok := recommended[name]
+		if !ok {
+			continue
+		}
+		c, ok := current[name]
+		if !ok || c.Sign() <= 0 {
+			return false
+		}
+		cv, rv := float64(c.MilliValue()), float64(r.MilliValue())
+		if math.Abs(rv-cv)/cv*100 > float64(percent) {
+			return false
+		}
+	}
+	return true
+}
+
+// autosizedDNSRequests returns the requests that the dns container should
+// have in the Auto mode given its current requests, which are nil if the
+// daemonset does not exist, the requests of the desired container, the
+// recommendation, which is nil if none is available, and the container's
+// limits.  The current requests are kept until a recommendation falls
+// outside the hysteresis, so that the daemonset does not roll out for small
+// changes, and requests are capped at the limits.
+func autosizedDNSRequests(config dnsAutosizingConfig, current, desired, recommended, limits corev1.ResourceList) corev1.ResourceList {
+	requests := desired.DeepCopy()
+	switch {
+	case recommended != nil && (current == nil || !requestsWithinHysteresis(current, recommended, *config.HysteresisPercent)):
+		for k, v := range recommended {
+			requests[k] = v.DeepCopy()
+		}
+	case current != nil:
+		for _, k := range []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory} {
+			if v, ok := current[k]; ok {
+				requests[k] = v.DeepCopy()
+			}
+		}
+	}
+	for k, limit := range limits {
+		if request, ok := requests[k]; ok && request.Cmp(limit) > 0 {
+			requests[k] = limit.DeepCopy()
+		}
+	}
+	return requests
+}
+
+// containerRequests returns the requests of the container with the given name
+// in the given daemonset, or nil if the daemonset has no such container.
+func containerRequests(daemonset *appsv1.DaemonSet, name string) corev1.ResourceList {
+	if daemonset == nil {
+		return nil
+	}
+	for _, c := range daemonset.Spec.Template.Spec.Containers {
+		if c.Name == name {
+			return c.Resources.Requests
+		}
+	}
+	return nil
+}
+
+// applyAutosizedDNSRequests sets the requests of the dns container of the
+// given desired daemonset according to the given DNS's recommendation if the
+// DNS is in the Auto mode.  The current daemonset is nil if it does not exist.
+func (r *reconciler) applyAutosizedDNSRequests(dns *operatorv1.DNS, current, desired *appsv1.DaemonSet) {
+	config, err := dnsResourcesConfigForDNS(dns)
+	if err != nil || config.Mode != dnsResourcesModeAuto {
+		return
+	}
+	recommended := r.resourceAutosizer.Recommendation(dns)
+	for i := range desired.Spec.Template.Spec.Containers {
+		c := &desired.Spec.Template.Spec.Containers[i]
+		if c.Name != "dns" {
+			continue
+		}
+		c.Resources.Requests = autosizedDNSRequests(config.Autosizing, containerRequests(current, "dns"), c.Resources.Requests, recommended, c.Resources.Limits)
+	}
+}
+
+// dnsAutosizingPodSample is a sample of the query counter of a CoreDNS pod.
+type dnsAutosizingPodSample struct {
+	time     time.Time
+	requests float64
+}
+
+// dnsAutosizingState is the autosizing state of a DNS.
+type dnsAutosizingState struct {
+	// pods are the latest samples of the DNS's pods, keyed by pod UID.
+	pods map[string]dnsAutosizingPodSample
+	// since is when the first observation was made.
+	since time.Time
+	// observations are the observations within the autosizing window.
+	observations []dnsResourceObservation
+	// recommendation is the current recommendation, or nil if there is
+	// none yet.
+	recommendation corev1.ResourceList
+}
+
+// dnsResourceAutosizer periodically scrapes the metrics of the CoreDNS pods of
+// every DNS in the Recommend or Auto mode and maintains the recommended
+// requests for the DNS's dns container.  It is a manager runnable.
+type dnsResourceAutosizer struct {
+	client  client.Reader
+	scraper corefileCanaryMetricsScraper
+	// events receives an event for a DNS whenever its recommendation
+	// changes so that the controller can apply or report it.
+	events chan event.GenericEvent
+
+	lock   sync.Mutex
+	states map[string]*dnsAutosizingState
+}
+
+// newDNSResourceAutosizer returns a new autosizer that uses the given reader
+// to list DNSes and pods and the given scraper to scrape the pods.
+func newDNSResourceAutosizer(reader client.Reader, scraper corefileCanaryMetricsScraper) *dnsResourceAutosizer {
+	return &dnsResourceAutosizer{
+		client:  reader,
+		scraper: scraper,
+		events:  make(chan event.GenericEvent, 1),
+		states:  map[string]*dnsAutosizingState{},
+	}
+}
+
+// Start scrapes CoreDNS pods until the given context is done.
+func (a *dnsResourceAutosizer) Start(ctx context.Context) error {
+	ticker := time.NewTicker(autosizingSampleInterval)
+	defer ticker.Stop()
+	for {
+		if err := a.sampleAll(ctx, time.Now()); err != nil {
+			logrus.Warningf("failed to sample coredns pods for autosizing: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Recommendation returns the current recommended requests for the dns
+// container of the given DNS, or nil if there is none.
+func (a *dnsResourceAutosizer) Recommendation(dns *operatorv1.DNS) corev1.ResourceList {
+	if a == nil {
+		return nil
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	state, ok := a.states[dns.Name]
+	if !ok || state.recommendation == nil {
+		return nil
+	}
+	return state.recommendation.DeepCopy()
+}
+
+// sampleAll samples the pods of every DNS in the Recommend or Auto mode at the
+// given time and forgets the state of other DNSes.
+func (a *dnsResourceAutosizer) sampleAll(ctx context.Context, now time.Time) error {
+	dnses := &operatorv1.DNSList{}
+	if err := a.client.List(ctx, dnses); err != nil {
+		return fmt.Errorf("failed to list dnses: %w", err)
+	}
+	sampled := map[string]struct{}{}
+	for i := range dnses.Items {
+		dns := &dnses.Items[i]
+		config, err := dnsResourcesConfigForDNS(dns)
+		if err != nil || !config.autosizing() || dns.DeletionTimestamp != nil {
+			continue
+		}
+		sampled[dns.Name] = struct{}{}
+		// Sample the pods of the workload that the DNS's workload mode
+		// selects, whose requests the recommendation is for.
+		podSelector := DNSDaemonSetPodSelector(dns)
+		if workload, err := dnsWorkloadConfigForDNS(dns); err == nil && workload.Kind == dnsWorkloadKindDeployment {
+			podSelector = DNSDeploymentPodSelector(dns)
+		}
+		pods := &corev1.PodList{}
+		listOpts := []client.ListOption{
+			client.MatchingLabels(podSelector.MatchLabels),
+			client.InNamespace(DefaultOperandNamespace),
+		}
+		if err := a.client.List(ctx, pods, listOpts...); err != nil {
+			logrus.Warningf("failed to list pods of dns %s for autosizing: %v", dns.Name, err)
+			continue
+		}
+		a.record(dns, config.Autosizing, a.scrapeAll(ctx, dns, pods.Items), now)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	for name := range a.states {
+		if _, ok := sampled[name]; !ok {
+			recommendedRequests.DeletePartialMatch(prometheus.Labels{"dns": name})
+			delete(a.states, name)
+		}
+	}
+	return nil
+}
+
+// scrapeAll scrapes the metrics of the given ready pods of the given DNS
+// concurrently, at most autosizingScrapeConcurrency pods at a time and each
+// within autosizingScrapeTimeout, and returns the metrics keyed by pod UID.
+// Pods that fail to be scraped are omitted.
+func (a *dnsResourceAutosizer) scrapeAll(ctx context.Context, dns *operatorv1.DNS, pods []corev1.Pod) map[string]*corefileCanaryMetrics {
+	var (
+		lock    sync.Mutex
+		wg      sync.WaitGroup
+		metrics = map[string]*corefileCanaryMetrics{}
+		slots   = make(chan struct{}, autosizingScrapeConcurrency)
+	)
+	for i := range pods {
+		pod := &pods[i]
+		if pod.DeletionTimestamp != nil || len(pod.Status.PodIP) == 0 || !podIsReady(pod) {
+			continue
+		}
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(pod *corev1.Pod) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			scrapeCtx, cancel := context.WithTimeout(ctx, autosizingScrapeTimeout)
+			defer cancel()
+			m, err := a.scraper.scrape(scrapeCtx, dns, pod)
+			if err != nil {
+				logrus.Warningf("failed to scrape metrics of pod %s/%s for autosizing: %v", pod.Namespace, pod.Name, err)
+				return
+			}
+			lock.Lock()
+			metrics[string(pod.UID)] = m
+			lock.Unlock()
+		}(pod)
+	}
+	wg.Wait()
+	return metrics
+}
+
+// record records the given metrics of the given DNS's pods, keyed by pod UID,
+// that were scraped at the given time, updates the recommendation, and
+// notifies the controller if the recommendation changed.
+func (a *dnsResourceAutosizer) record(dns *operatorv1.DNS, config dnsAutosizingConfig, metrics map[string]*corefileCanaryMetrics, now time.Time) {
+	a.lock.Lock()
+	state, ok := a.states[dns.Name]
+	if !ok {
+		state = &dnsAutosizingState{pods: map[string]dnsAutosizingPodSample{}}
+		a.states[dns.Name] = state
+	}
+	observation := dnsResourceObservation{Time: now}
+	haveQPS := false
+	pods := map[string]dnsAutosizingPodSample{}
+	for uid, m := range metrics {
+		pods[uid] = dnsAutosizingPodSample{time: now, requests: m.Requests}
+		observation.MemoryBytes = math.Max(observation.MemoryBytes, m.ResidentMemoryBytes)
+		previous, ok := state.pods[uid]
+		// Skip pods that were not sampled before or whose counter was
+		// reset because CoreDNS restarted.
+		if !ok || m.Requests < previous.requests || !now.After(previous.time) {
+			continue
+		}
+		haveQPS = true
+		observation.QPS = math.Max(observation.QPS, (m.Requests-previous.requests)/now.Sub(previous.time).Seconds())
+	}
+	state.pods = pods
+	if haveQPS {
+		if state.since.IsZero() {
+			state.since = now
+		}
+		var observations []dnsResourceObservation
+		for _, o := range state.observations {
+			if now.Sub(o.Time) <= config.window {
+				observations = append(observations, o)
+			}
+		}
+		state.observations = append(observations, observation)
+	}
+	recommendation, ok := recommendDNSRequests(config, state.since, state.observations, now)
+	changed := ok && (state.recommendation == nil || !requestsWithinHysteresis(state.recommendation, recommendation, *config.HysteresisPercent))
+	if changed {
+		state.recommendation = recommendation
+	}
+	a.lock.Unlock()
+
+	if !changed {
+		return
+	}
+	cpu, memory := recommendation[corev1.ResourceCPU], recommendation[corev1.ResourceMemory]
+	recommendedRequests.WithLabelValues(dns.Name, string(corev1.ResourceCPU)).Set(float64(cpu.MilliValue()) / 1000)
+	recommendedRequests.WithLabelValues(dns.Name, string(corev1.ResourceMemory)).Set(float64(memory.Value()))
+	logrus.Infof("recommended requests for dns %s changed to cpu=%s, memory=%s", dns.Name, cpu.String(), memory.String())
+	select {
+	case a.events <- event.GenericEvent{Object: dns}:
+	default:
+	}
+}
+
+// formatRequests formats the cpu and memory requests in the given list for
+// status messages.
+func formatRequests(requests corev1.ResourceList) string {
+	cpu, memory := requests[corev1.ResourceCPU], requests[corev1.ResourceMemory]
+	return fmt.Sprintf("cpu=%s, memory=%s", cpu.String(), memory.String())
+}
+
+// computeResourceRecommendationPendingCondition computes the
+// ResourceRecommendationPending status condition for a DNS with the given
+// autosizing resources configuration from the given DNS daemonset, which is
+// nil if it does not exist, and the given recommendation, which is nil if none
+// is available.
+func computeResourceRecommendationPendingCondition(oldCondition *operatorv1.OperatorCondition, config dnsResourcesConfig, daemonset *appsv1.DaemonSet, recommendation corev1.ResourceList) operatorv1.OperatorCondition {
+	condition := &operatorv1.OperatorCondition{
+		Type:   ResourceRecommendationPendingConditionType,
+		Status: operatorv1.ConditionFalse,
+	}
+	current := containerRequests(daemonset, "dns")
+	switch {
+	case recommendation == nil:
+		condition.Reason = "CollectingMetrics"
+		condition.Message = fmt.Sprintf("The operator is observing the CoreDNS pods for %v before it recommends requests.", config.Autosizing.window)
+	case config.Mode == dnsResourcesModeAuto:
+		condition.Reason = "Autosized"
+		condition.Message = fmt.Sprintf("The operator applies the recommended requests for the dns container: %s.", formatRequests(recommendation))
+	case current != nil && requestsWithinHysteresis(current, recommendation, *config.Autosizing.HysteresisPercent):
+		condition.Reason = "AsExpected"
+		condition.Message = fmt.Sprintf("The requests of the dns container are within %d%% of the recommended requests: %s.", *config.Autosizing.HysteresisPercent, formatRequests(recommendation))
+	default:
+		condition.Status = operatorv1.ConditionTrue
+		condition.Reason = "RecommendationPending"
+		condition.Message = fmt.Sprintf("The recommended requests for the dns container are %s; the current requests are %s.", formatRequests(recommendation), formatRequests(current))
+	}
+	return setDNSLastTransitionTime(condition, oldCondition)
+}
@@ -0,0 +1,483 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestDNSResourcesConfigForDNS(t *testing.T) {
+	testCases := []struct {
+		name          string
+		annotation    string
+		expectMode    string
+		expectWindow  time.Duration
+		expectedError string
+	}{
+		{
+			name:         "no annotation",
+			expectMode:   dnsResourcesModeManual,
+			expectWindow: defaultAutosizingWindow,
+		},
+		{
+			name:         "auto with window",
+			annotation:   `{"mode":"Auto","autosizing":{"window":"30m","hysteresisPercent":10}}`,
+			expectMode:   dnsResourcesModeAuto,
+			expectWindow: 30 * time.Minute,
+		},
+		{
+			name:         "manual requests and limits",
+			annotation:   `{"dns":{"requests":{"cpu":"200m"},"limits":{"memory":"512Mi"}}}`,
+			expectMode:   dnsResourcesModeManual,
+			expectWindow: defaultAutosizingWindow,
+		},
+		{
+			name:          "invalid JSON",
+			annotation:    `{"mode":`,
+			expectedError: "failed to parse annotation",
+		},
+		{
+			name:          "invalid mode",
+			annotation:    `{"mode":"Sometimes"}`,
+			expectedError: `mode "Sometimes" must be`,
+		},
+		{
+			name:          "unsupported resource",
+			annotation:    `{"kubeRBACProxy":{"requests":{"ephemeral-storage":"1Gi"}}}`,
+			expectedError: `unsupported resource "ephemeral-storage"`,
+		},
+		{
+			name:          "zero quantity",
+			annotation:    `{"dns":{"limits":{"cpu":"0"}}}`,
+			expectedError: "must be positive",
+		},
+		{
+			name:          "min exceeds max",
+			annotation:    `{"mode":"Recommend","autosizing":{"minCPU":"2","maxCPU":"1"}}`,
+			expectedError: "minCPU 2 must not exceed maxCPU 1",
+		},
+		{
+			name:          "hysteresis out of range",
+			annotation:    `{"mode":"Auto","autosizing":{"hysteresisPercent":150}}`,
+			expectedError: "hysteresisPercent 150 must be between 0 and 100",
+		},
+		{
+			name:          "window too short",
+			annotation:    `{"mode":"Auto","autosizing":{"window":"1m"}}`,
+			expectedError: "must be at least",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+			if len(tc.annotation) != 0 {
+				dns.Annotations = map[string]string{dnsResourcesAnnotationKey: tc.annotation}
+			}
+			config, err := dnsResourcesConfigForDNS(dns)
+			if len(tc.expectedError) != 0 {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if config.Mode != tc.expectMode {
+				t.Errorf("expected mode %q, got %q", tc.expectMode, config.Mode)
+			}
+			if config.Autosizing.window != tc.expectWindow {
+				t.Errorf("expected window %v, got %v", tc.expectWindow, config.Autosizing.window)
+			}
+			if config.Autosizing.CPUPerThousandQPS == nil || config.Autosizing.HysteresisPercent == nil || config.Autosizing.HeadroomPercent == nil {
+				t.Errorf("expected autosizing defaults to be filled in, got %+v", config.Autosizing)
+			}
+		})
+	}
+}
+
+func TestDesiredDNSDaemonSetResources(t *testing.T) {
+	dns := &operatorv1.DNS{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: DefaultDNSController,
+			Annotations: map[string]string{
+				dnsResourcesAnnotationKey: `{"dns":{"requests":{"cpu":"200m"},"limits":{"cpu":"1","memory":"512Mi"}},"kubeRBACProxy":{"limits":{"memory":"64Mi"}}}`,
+			},
+		},
+	}
+	ds, err := desiredDNSDaemonSet(dns, "coredns", "kube-rbac-proxy", nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]string{
+		"dns/requests/cpu":                "200m",
+		"dns/requests/memory":             "70Mi",
+		"dns/limits/cpu":                  "1",
+		"dns/limits/memory":               "512Mi",
+		"kube-rbac-proxy/requests/cpu":    "10m",
+		"kube-rbac-proxy/requests/memory": "40Mi",
+		"kube-rbac-proxy/limits/memory":   "64Mi",
+	}
+	actual := map[string]string{}
+	for _, c := range ds.Spec.Template.Spec.Containers {
+		for k, v := range c.Resources.Requests {
+			actual[c.Name+"/requests/"+string(k)] = v.String()
+		}
+		for k, v := range c.Resources.Limits {
+			actual[c.Name+"/limits/"+string(k)] = v.String()
+		}
+	}
+	if len(actual) != len(expected) {
+		t.Errorf("expected resources %v, got %v", expected, actual)
+	}
+	for k, v := range expected {
+		if actual[k] != v {
+			t.Errorf("expected %s to be %s, got %q", k, v, actual[k])
+		}
+	}
+
+	dns.Annotations[dnsResourcesAnnotationKey] = `{"dns":{"limits":{"memory":"50Mi"}}}`
+	if _, err := desiredDNSDaemonSet(dns, "coredns", "kube-rbac-proxy", nil, nil); err == nil || !strings.Contains(err.Error(), "exceeds its limit") {
+		t.Errorf("expected an error for a request that exceeds its limit, got %v", err)
+	}
+}
+
+func TestRecommendDNSRequests(t *testing.T) {
+	dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{
+		Name:        DefaultDNSController,
+		Annotations: map[string]string{dnsResourcesAnnotationKey: `{"mode":"Auto","autosizing":{"window":"10m"}}`},
+	}}
+	config, err := dnsResourcesConfigForDNS(dns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		name         string
+		since        time.Time
+		observations []dnsResourceObservation
+		expectOK     bool
+		expectCPU    string
+		expectMemory string
+	}{
+		{
+			name:         "window not covered",
+			since:        now.Add(-5 * time.Minute),
+			observations: []dnsResourceObservation{{Time: now, QPS: 1000, MemoryBytes: 100 << 20}},
+		},
+		{
+			name:  "peak within window",
+			since: now.Add(-time.Hour),
+			observations: []dnsResourceObservation{
+				{Time: now.Add(-20 * time.Minute), QPS: 100000, MemoryBytes: 1 << 30},
+				{Time: now.Add(-5 * time.Minute), QPS: 8000, MemoryBytes: 200 << 20},
+				{Time: now, QPS: 4000, MemoryBytes: 300 << 20},
+			},
+			expectOK:     true,
+			expectCPU:    "1",
+			expectMemory: "375Mi",
+		},
+		{
+			name:         "clamped to minimums",
+			since:        now.Add(-time.Hour),
+			observations: []dnsResourceObservation{{Time: now, QPS: 10, MemoryBytes: 10 << 20}},
+			expectOK:     true,
+			expectCPU:    "50m",
+			expectMemory: "70Mi",
+		},
+		{
+			name:         "clamped to maximums",
+			since:        now.Add(-time.Hour),
+			observations: []dnsResourceObservation{{Time: now, QPS: 1000000, MemoryBytes: 10 << 30}},
+			expectOK:     true,
+			expectCPU:    "4",
+			expectMemory: "2Gi",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			requests, ok := recommendDNSRequests(config.Autosizing, tc.since, tc.observations, now)
+			if ok != tc.expectOK {
+				t.Fatalf("expected ok to be %t, got %t", tc.expectOK, ok)
+			}
+			if !ok {
+				return
+			}
+			cpu, memory := requests[corev1.ResourceCPU], requests[corev1.ResourceMemory]
+			if cpu.String() != tc.expectCPU || memory.String() != tc.expectMemory {
+				t.Errorf("expected cpu=%s, memory=%s, got %s", tc.expectCPU, tc.expectMemory, formatRequests(requests))
+			}
+		})
+	}
+}
+
+func TestAutosizedDNSRequests(t *testing.T) {
+	dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{
+		Name:        DefaultDNSController,
+		Annotations: map[string]string{dnsResourcesAnnotationKey: `{"mode":"Auto"}`},
+	}}
+	config, err := dnsResourcesConfigForDNS(dns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := func(cpu, memory string) corev1.ResourceList {
+		return corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(cpu),
+			corev1.ResourceMemory: resource.MustParse(memory),
+		}
+	}
+	desired := requests("50m", "70Mi")
+	testCases := []struct {
+		name        string
+		current     corev1.ResourceList
+		recommended corev1.ResourceList
+		limits      corev1.ResourceList
+		expected    string
+	}{
+		{
+			name:     "no recommendation and no daemonset",
+			expected: "cpu=50m, memory=70Mi",
+		},
+		{
+			name:     "no recommendation keeps current requests",
+			current:  requests("300m", "200Mi"),
+			expected: "cpu=300m, memory=200Mi",
+		},
+		{
+			name:        "recommendation within hysteresis keeps current requests",
+			current:     requests("300m", "200Mi"),
+			recommended: requests("330m", "220Mi"),
+			expected:    "cpu=300m, memory=200Mi",
+		},
+		{
+			name:        "recommendation outside hysteresis is applied",
+			current:     requests("300m", "200Mi"),
+			recommended: requests("500m", "210Mi"),
+			expected:    "cpu=500m, memory=210Mi",
+		},
+		{
+			name:        "recommendation is capped at limits",
+			current:     requests("300m", "200Mi"),
+			recommended: requests("2", "1Gi"),
+			limits:      corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+			expected:    "cpu=1, memory=1Gi",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := autosizedDNSRequests(config.Autosizing, tc.current, desired, tc.recommended, tc.limits)
+			if got := formatRequests(actual); got != tc.expected {
+				t.Errorf("expected %s, got %s", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestDNSResourceAutosizerRecord(t *testing.T) {
+	dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{
+		Name:        DefaultDNSController,
+		Annotations: map[string]string{dnsResourcesAnnotationKey: `{"mode":"Recommend","autosizing":{"window":"10m"}}`},
+	}}
+	config, err := dnsResourcesConfigForDNS(dns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	autosizer := newDNSResourceAutosizer(nil, nil)
+	start := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	metrics := func(requests, memory float64) map[string]*corefileCanaryMetrics {
+		return map[string]*corefileCanaryMetrics{"a": {Requests: requests, ResidentMemoryBytes: memory}}
+	}
+
+	// 2000 queries per second and 100Mi of memory for 11 minutes.
+	for i := 0; i <= 11; i++ {
+		autosizer.record(dns, config.Autosizing, metrics(float64(i)*120000, 100<<20), start.Add(time.Duration(i)*time.Minute))
+		if i <= 10 && autosizer.Recommendation(dns) != nil {
+			t.Fatalf("expected no recommendation before the window is covered, got one after %d minutes", i)
+		}
+	}
+	recommendation := autosizer.Recommendation(dns)
+	if got, expected := formatRequests(recommendation), "cpu=250m, memory=125Mi"; got != expected {
+		t.Fatalf("expected recommendation %s, got %s", expected, got)
+	}
+	select {
+	case <-autosizer.events:
+	default:
+		t.Errorf("expected an event when the recommendation became available")
+	}
+
+	// A slightly higher load is within the hysteresis and does not change
+	// the recommendation.
+	autosizer.record(dns, config.Autosizing, metrics(11*120000+130000, 110<<20), start.Add(12*time.Minute))
+	if got, expected := formatRequests(autosizer.Recommendation(dns)), "cpu=250m, memory=125Mi"; got != expected {
+		t.Errorf("expected recommendation to stay %s, got %s", expected, got)
+	}
+
+	// A counter reset does not produce a negative rate.
+	autosizer.record(dns, config.Autosizing, metrics(100, 110<<20), start.Add(13*time.Minute))
+	if got, expected := formatRequests(autosizer.Recommendation(dns)), "cpu=250m, memory=125Mi"; got != expected {
+		t.Errorf("expected recommendation to stay %s, got %s", expected, got)
+	}
+
+	// A much higher load changes the recommendation.
+	autosizer.record(dns, config.Autosizing, metrics(100+600000, 110<<20), start.Add(14*time.Minute))
+	if got, expected := formatRequests(autosizer.Recommendation(dns)), "cpu=1250m, memory=138Mi"; got != expected {
+		t.Errorf("expected recommendation %s, got %s", expected, got)
+	}
+}
+
+// fakeMetricsScraper is a metrics scraper that records how many scrapes are
+// in progress at the same time and never answers for the pod named "hung".
+type fakeMetricsScraper struct {
+	lock        sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (s *fakeMetricsScraper) scrape(ctx context.Context, dns *operatorv1.DNS, pod *corev1.Pod) (*corefileCanaryMetrics, error) {
+	s.lock.Lock()
+	s.inFlight++
+	if s.inFlight > s.maxInFlight {
+		s.maxInFlight = s.inFlight
+	}
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		s.inFlight--
+		s.lock.Unlock()
+	}()
+
+	if pod.Name == "hung" {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		return nil, fmt.Errorf("scrape of pod %s has no timeout", pod.Name)
+	}
+	// Hold the slot long enough for the other scrapes to pile up.
+	time.Sleep(10 * time.Millisecond)
+	return &corefileCanaryMetrics{Requests: 1}, nil
+}
+
+// TestDNSResourceAutosizerScrapeAll verifies that the autosizer scrapes ready
+// pods with bounded concurrency and that a pod that does not answer does not
+// keep the autosizer from recording the metrics of the other pods.
+func TestDNSResourceAutosizerScrapeAll(t *testing.T) {
+	dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+	pod := func(name string, ready corev1.ConditionStatus) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: DefaultOperandNamespace, Name: name, UID: types.UID(name)},
+			Status: corev1.PodStatus{
+				PodIP:      "10.0.0.1",
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: ready}},
+			},
+		}
+	}
+	pods := []corev1.Pod{pod("hung", corev1.ConditionTrue), pod("not-ready", corev1.ConditionFalse)}
+	for i := 0; i < 3*autosizingScrapeConcurrency; i++ {
+		pods = append(pods, pod(fmt.Sprintf("pod-%d", i), corev1.ConditionTrue))
+	}
+
+	scraper := &fakeMetricsScraper{}
+	autosizer := newDNSResourceAutosizer(nil, scraper)
+	// The deadline of the sample bounds the per-pod timeout so that the
+	// test does not wait for autosizingScrapeTimeout.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	metrics := autosizer.scrapeAll(ctx, dns, pods)
+
+	if len(metrics) != 3*autosizingScrapeConcurrency {
+		t.Errorf("expected metrics for %d pods, got %d", 3*autosizingScrapeConcurrency, len(metrics))
+	}
+	for _, name := range []string{"hung", "not-ready"} {
+		if _, ok := metrics[name]; ok {
+			t.Errorf("expected no metrics for pod %s", name)
+		}
+	}
+	if scraper.maxInFlight > autosizingScrapeConcurrency {
+		t.Errorf("expected at most %d concurrent scrapes, got %d", autosizingScrapeConcurrency, scraper.maxInFlight)
+	}
+	if scraper.maxInFlight < 2 {
+		t.Errorf("expected concurrent scrapes, got %d at a time", scraper.maxInFlight)
+	}
+}
+
+func TestComputeResourceRecommendationPendingCondition(t *testing.T) {
+	recommendation := corev1.ResourceList{
+		corev1.ResourceCPU:    resource.MustParse("500m"),
+		corev1.ResourceMemory: resource.MustParse("200Mi"),
+	}
+	dnsWithMode := func(annotation string) *operatorv1.DNS {
+		dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+		if len(annotation) != 0 {
+			dns.Annotations = map[string]string{dnsResourcesAnnotationKey: annotation}
+		}
+		return dns
+	}
+	daemonset, err := desiredDNSDaemonSet(dnsWithMode(""), "coredns", "kube-rbac-proxy", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCases := []struct {
+		name           string
+		dns            *operatorv1.DNS
+		recommendation corev1.ResourceList
+		expectStatus   operatorv1.ConditionStatus
+		expectReason   string
+	}{
+		{
+			name:         "no recommendation yet",
+			dns:          dnsWithMode(`{"mode":"Recommend"}`),
+			expectStatus: operatorv1.ConditionFalse,
+			expectReason: "CollectingMetrics",
+		},
+		{
+			name:           "recommendation differs",
+			dns:            dnsWithMode(`{"mode":"Recommend"}`),
+			recommendation: recommendation,
+			expectStatus:   operatorv1.ConditionTrue,
+			expectReason:   "RecommendationPending",
+		},
+		{
+			name:           "recommendation matches",
+			dns:            dnsWithMode(`{"mode":"Recommend","dns":{"requests":{"cpu":"500m","memory":"200Mi"}}}`),
+			recommendation: recommendation,
+			expectStatus:   operatorv1.ConditionFalse,
+			expectReason:   "AsExpected",
+		},
+		{
+			name:           "auto",
+			dns:            dnsWithMode(`{"mode":"Auto"}`),
+			recommendation: recommendation,
+			expectStatus:   operatorv1.ConditionFalse,
+			expectReason:   "Autosized",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			current := daemonset
+			if tc.expectReason == "AsExpected" {
+				if current, err = desiredDNSDaemonSet(tc.dns, "coredns", "kube-rbac-proxy", nil, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+			config, err := dnsResourcesConfigForDNS(tc.dns)
+			if err != nil {
+				t.Fatal(err)
+			}
+			condition := computeResourceRecommendationPendingCondition(nil, config, current, tc.recommendation)
+			if condition.Status != tc.expectStatus || condition.Reason != tc.expectReason {
+				t.Errorf("expected %s/%s, got %s/%s: %s", tc.expectStatus, tc.expectReason, condition.Status, condition.Reason, condition.Message)
+			}
+		})
+	}
+}
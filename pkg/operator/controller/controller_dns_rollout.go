@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// dnsRolloutAnnotationKey is the annotation on a DNS that configures how
+// updates roll out to the pods of the DNS daemonset, or the DNS deployment in
+// the Deployment workload mode.  The value is a JSON object with the following
+// fields:
+//
+//   - "maxSurge" is the number, for example 1, or the percentage, for
+//     example "10%", of nodes that may run an updated pod next to the old pod
+//     during the rollout.
+//   - "maxUnavailable" is the number or the percentage of nodes whose pod
+//     may be taken down before its replacement is ready.
+//
+// Exactly one of the two must be nonzero; a field that is not specified is
+// zero if the other field is specified.  If neither is specified, the rollout
+// surges on 10% of the nodes at a time, which keeps a ready CoreDNS pod on
+// every node throughout the rollout but can be slow on large clusters and
+// cannot make progress on a node that lacks room for a second pod.
+const dnsRolloutAnnotationKey = "dns.operator.openshift.io/rollout"
+
+// dnsRolloutConfig is the rollout configuration of a DNS.
+type dnsRolloutConfig struct {
+	// MaxSurge is the maximum number or percentage of surged pods.
+	MaxSurge *intstr.IntOrString `json:"maxSurge,omitempty"`
+	// MaxUnavailable is the maximum number or percentage of unavailable
+	// pods.
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+// dnsRolloutConfigForDNS parses and validates the rollout configuration of the
+// given DNS.  If the DNS does not configure the rollout, the returned
+// configuration has neither field set.
+func dnsRolloutConfigForDNS(dns *operatorv1.DNS) (dnsRolloutConfig, error) {
+	config := dnsRolloutConfig{}
+	value, ok := dns.Annotations[dnsRolloutAnnotationKey]
+	if !ok || len(strings.TrimSpace(value)) == 0 {
+		return config, nil
+	}
+	if err := json.Unmarshal([]byte(value), &config); err != nil {
+		return dnsRolloutConfig{}, fmt.Errorf("failed to parse annotation %s: %w", dnsRolloutAnnotationKey, err)
+	}
+	if config.MaxSurge == nil && config.MaxUnavailable == nil {
+		return config, nil
+	}
+	zero := intstr.FromInt32(0)
+	if config.MaxSurge == nil {
+		config.MaxSurge = &zero
+	}
+	if config.MaxUnavailable == nil {
+		config.MaxUnavailable = &zero
+	}
+	nonzero := 0
+	for _, p := range []struct {
+		name  string
+		value *intstr.IntOrString
+	}{
+		{"maxSurge", config.MaxSurge},
+		{"maxUnavailable", config.MaxUnavailable},
+	} {
+		// Scaling 100 by the value yields the percentage for a
+		// percentage and the value itself for an integer.
+		scaled, err := intstr.GetScaledValueFromIntOrPercent(p.value, 100, true)
+		if err != nil {
+			return dnsRolloutConfig{}, fmt.Errorf("invalid annotation %s: invalid %s %q: %w", dnsRolloutAnnotationKey, p.name, p.value.String(), err)
+		}
+		if scaled < 0 {
+			return dnsRolloutConfig{}, fmt.Errorf("invalid annotation %s: %s %q must not be negative", dnsRolloutAnnotationKey, p.name, p.value.String())
+		}
+		if p.value.Type == intstr.String && scaled > 100 {
+			return dnsRolloutConfig{}, fmt.Errorf("invalid annotation %s: %s %q must not exceed 100%%", dnsRolloutAnnotationKey, p.name, p.value.String())
+		}
+		if scaled != 0 {
+			nonzero++
+		}
+	}
+	if nonzero != 1 {
+		return dnsRolloutConfig{}, fmt.Errorf("invalid annotation %s: exactly one of maxSurge %q and maxUnavailable %q must be nonzero", dnsRolloutAnnotationKey, config.MaxSurge.String(), config.MaxUnavailable.String())
+	}
+	return config, nil
+}
+
+// applyDNSRolloutConfig sets the rolling update parameters of the given
+// daemonset to the given rollout configuration, if it specifies them.
+func applyDNSRolloutConfig(daemonset *appsv1.DaemonSet, config dnsRolloutConfig) {
+	if config.MaxSurge == nil || config.MaxUnavailable == nil {
+		return
+	}
+	maxSurge := *config.MaxSurge
+	maxUnavailable := *config.MaxUnavailable
+	daemonset.Spec.UpdateStrategy = appsv1.DaemonSetUpdateStrategy{
+		Type: appsv1.RollingUpdateDaemonSetStrategyType,
+		RollingUpdate: &appsv1.RollingUpdateDaemonSet{
+			MaxSurge:       &maxSurge,
+			MaxUnavailable: &maxUnavailable,
+		},
+	}
+}
+
+// rolloutMessage describes the rolling update parameters of the given
+// daemonset, or returns the empty string if it has none.
+func rolloutMessage(daemonset *appsv1.DaemonSet) string {
+	rollingUpdate := daemonset.Spec.UpdateStrategy.RollingUpdate
+	if rollingUpdate == nil || rollingUpdate.MaxSurge == nil || rollingUpdate.MaxUnavailable == nil {
+		return ""
+	}
+	return fmt.Sprintf("Rolling out with maxSurge %s and maxUnavailable %s.", rollingUpdate.MaxSurge.String(), rollingUpdate.MaxUnavailable.String())
+}
@@ -0,0 +1,176 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestDNSRolloutConfigForDNS(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		annotation           string
+		expectMaxSurge       string
+		expectMaxUnavailable string
+		expectedError        string
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:       "empty object",
+			annotation: `{}`,
+		},
+		{
+			name:                 "max unavailable percentage",
+			annotation:           `{"maxUnavailable":"10%"}`,
+			expectMaxSurge:       "0",
+			expectMaxUnavailable: "10%",
+		},
+		{
+			name:                 "max surge number",
+			annotation:           `{"maxSurge":1,"maxUnavailable":0}`,
+			expectMaxSurge:       "1",
+			expectMaxUnavailable: "0",
+		},
+		{
+			name:          "invalid JSON",
+			annotation:    `{"maxSurge":`,
+			expectedError: "failed to parse annotation",
+		},
+		{
+			name:          "both nonzero",
+			annotation:    `{"maxSurge":"10%","maxUnavailable":1}`,
+			expectedError: "exactly one of maxSurge",
+		},
+		{
+			name:          "both zero",
+			annotation:    `{"maxSurge":0,"maxUnavailable":"0%"}`,
+			expectedError: "exactly one of maxSurge",
+		},
+		{
+			name:          "negative",
+			annotation:    `{"maxUnavailable":-1}`,
+			expectedError: `maxUnavailable "-1" must not be negative`,
+		},
+		{
+			name:          "percentage over 100",
+			annotation:    `{"maxSurge":"150%"}`,
+			expectedError: `maxSurge "150%" must not exceed 100%`,
+		},
+		{
+			name:          "malformed percentage",
+			annotation:    `{"maxSurge":"ten"}`,
+			expectedError: `invalid maxSurge "ten"`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+			if len(tc.annotation) != 0 {
+				dns.Annotations = map[string]string{dnsRolloutAnnotationKey: tc.annotation}
+			}
+			config, err := dnsRolloutConfigForDNS(dns)
+			if len(tc.expectedError) != 0 {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(tc.expectMaxSurge) == 0 {
+				if config.MaxSurge != nil || config.MaxUnavailable != nil {
+					t.Fatalf("expected no rollout parameters, got %+v", config)
+				}
+				return
+			}
+			if config.MaxSurge.String() != tc.expectMaxSurge || config.MaxUnavailable.String() != tc.expectMaxUnavailable {
+				t.Errorf("expected maxSurge %s and maxUnavailable %s, got %s and %s", tc.expectMaxSurge, tc.expectMaxUnavailable, config.MaxSurge.String(), config.MaxUnavailable.String())
+			}
+		})
+	}
+}
+
+func TestDesiredDNSWorkloadRollout(t *testing.T) {
+	dns := &operatorv1.DNS{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        DefaultDNSController,
+			Annotations: map[string]string{dnsRolloutAnnotationKey: `{"maxUnavailable":"33%"}`},
+		},
+	}
+	daemonset, err := desiredDNSDaemonSet(dns, "coredns", "kube-rbac-proxy", nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rollingUpdate := daemonset.Spec.UpdateStrategy.RollingUpdate
+	if rollingUpdate == nil || *rollingUpdate.MaxUnavailable != intstr.FromString("33%") || *rollingUpdate.MaxSurge != intstr.FromInt32(0) {
+		t.Errorf("unexpected daemonset update strategy: %+v", daemonset.Spec.UpdateStrategy)
+	}
+	deployment := desiredDNSDeployment(dns, daemonset, 3)
+	if strategy := deployment.Spec.Strategy.RollingUpdate; strategy == nil || *strategy.MaxUnavailable != intstr.FromString("33%") || *strategy.MaxSurge != intstr.FromInt32(0) {
+		t.Errorf("unexpected deployment strategy: %+v", deployment.Spec.Strategy)
+	}
+
+	// The updated strategy must roll out to an existing daemonset.
+	current, err := desiredDNSDaemonSet(&operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}, "coredns", "kube-rbac-proxy", nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if changed, updated := daemonsetConfigChanged(current, daemonset); !changed {
+		t.Errorf("expected daemonsetConfigChanged to detect the changed rollout parameters")
+	} else if updated.Spec.UpdateStrategy.RollingUpdate.MaxUnavailable.String() != "33%" {
+		t.Errorf("unexpected updated strategy: %+v", updated.Spec.UpdateStrategy)
+	}
+
+	dns.Annotations[dnsRolloutAnnotationKey] = `{"maxSurge":1,"maxUnavailable":1}`
+	if _, err := desiredDNSDaemonSet(dns, "coredns", "kube-rbac-proxy", nil, nil); err == nil {
+		t.Errorf("expected an error for an invalid rollout configuration")
+	}
+}
+
+func TestRolloutProgressingMessage(t *testing.T) {
+	maxSurge := intstr.FromString("10%")
+	maxUnavailable := intstr.FromInt32(0)
+	dnsDaemonset := &appsv1.DaemonSet{
+		Spec: appsv1.DaemonSetSpec{
+			UpdateStrategy: appsv1.DaemonSetUpdateStrategy{
+				RollingUpdate: &appsv1.RollingUpdateDaemonSet{
+					MaxSurge:       &maxSurge,
+					MaxUnavailable: &maxUnavailable,
+				},
+			},
+		},
+		Status: appsv1.DaemonSetStatus{
+			DesiredNumberScheduled: 10,
+			NumberAvailable:        10,
+			UpdatedNumberScheduled: 4,
+		},
+	}
+	dnsDaemonset.Spec.Template.Spec.NodeSelector = nodeSelectorForDNS(&operatorv1.DNS{})
+	dnsDaemonset.Spec.Template.Spec.Tolerations = tolerationsForDNS(&operatorv1.DNS{})
+	nrDaemonset := &appsv1.DaemonSet{Status: appsv1.DaemonSetStatus{DesiredNumberScheduled: 10, UpdatedNumberScheduled: 10}}
+
+	condition := computeDNSProgressingCondition(nil, &operatorv1.DNS{}, &dnsStatusInputs{clusterIP: "172.30.0.10", haveDNSDaemonset: true, dnsDaemonset: dnsDaemonset, haveNodeResolverDaemonset: true, nodeResolverDaemonset: nrDaemonset}, 0, time.Time{}, &reconcile.Result{})
+	if condition.Status != operatorv1.ConditionTrue {
+		t.Fatalf("expected Progressing=True, got %+v", condition)
+	}
+	if expected := "Rolling out with maxSurge 10% and maxUnavailable 0."; !strings.Contains(condition.Message, expected) {
+		t.Errorf("expected message to contain %q, got %q", expected, condition.Message)
+	}
+
+	dnsDaemonset.Status.UpdatedNumberScheduled = 10
+	condition = computeDNSProgressingCondition(nil, &operatorv1.DNS{}, &dnsStatusInputs{clusterIP: "172.30.0.10", haveDNSDaemonset: true, dnsDaemonset: dnsDaemonset, haveNodeResolverDaemonset: true, nodeResolverDaemonset: nrDaemonset}, 0, time.Time{}, &reconcile.Result{})
+	if condition.Status != operatorv1.ConditionFalse {
+		t.Errorf("expected Progressing=False after the rollout, got %+v", condition)
+	}
+}
@@ -0,0 +1,176 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+const (
+	// dnsRuntimeAnnotationKey is the annotation on a DNS that configures
+	// the Go runtime of CoreDNS.  The value is a JSON object with the
+	// following fields:
+	//
+	//   - "goMemLimitPercent" is the percentage, from 1 to 100, of the dns
+	//     container's memory limit that the operator sets as GOMEMLIMIT.
+	//     The default is 90.
+	//   - "env" is a map of additional environment variables for the dns
+	//     container.  Only GOGC, GODEBUG, GOMAXPROCS, GOMEMLIMIT, and
+	//     GOTRACEBACK are allowed.
+	//
+	// By default, the Go runtime runs as many threads as the node has CPUs
+	// and collects garbage without regard to the container's memory limit,
+	// which on large nodes makes CoreDNS oversubscribe its CPU limit and
+	// hold on to memory until it is OOM-killed.  If the dns container has a
+	// CPU limit, the operator therefore sets GOMAXPROCS to the limit rounded
+	// up to a whole number of CPUs, and if the dns container has a memory
+	// limit, the operator sets GOMEMLIMIT to goMemLimitPercent of the limit.
+	// GOMAXPROCS and GOMEMLIMIT in "env" take precedence over the derived
+	// values.
+	dnsRuntimeAnnotationKey = "dns.operator.openshift.io/runtime"
+
+	// defaultGoMemLimitPercent is the default percentage of the memory limit
+	// that the operator sets as GOMEMLIMIT.
+	defaultGoMemLimitPercent = 90
+)
+
+// allowedDNSRuntimeEnv is the set of environment variables that the runtime
+// annotation may set on the dns container.
+var allowedDNSRuntimeEnv = sets.NewString("GOGC", "GODEBUG", "GOMAXPROCS", "GOMEMLIMIT", "GOTRACEBACK")
+
+// goTracebackLevels is the set of values that the Go runtime accepts for
+// GOTRACEBACK.
+var goTracebackLevels = sets.NewString("none", "single", "all", "system", "crash", "wer", "0", "1", "2")
+
+// goMemLimitSuffixes are the unit suffixes that the Go runtime accepts for
+// GOMEMLIMIT, longest first so that "B" does not match the end of "MiB".
+var goMemLimitSuffixes = []string{"KiB", "MiB", "GiB", "TiB", "B"}
+
+// dnsRuntimeConfig is the Go runtime configuration of a DNS.
+type dnsRuntimeConfig struct {
+	// GoMemLimitPercent is the percentage of the memory limit to set as
+	// GOMEMLIMIT.
+	GoMemLimitPercent *int `json:"goMemLimitPercent,omitempty"`
+	// Env is the additional environment variables of the dns container.
+	Env map[string]string `json:"env,omitempty"`
+}
+
+// dnsRuntimeConfigForDNS parses and validates the Go runtime configuration of
+// the given DNS and fills in defaults.
+func dnsRuntimeConfigForDNS(dns *operatorv1.DNS) (dnsRuntimeConfig, error) {
+	config := dnsRuntimeConfig{}
+	value, ok := dns.Annotations[dnsRuntimeAnnotationKey]
+	if ok && len(strings.TrimSpace(value)) != 0 {
+		if err := json.Unmarshal([]byte(value), &config); err != nil {
+			return dnsRuntimeConfig{}, fmt.Errorf("failed to parse annotation %s: %w", dnsRuntimeAnnotationKey, err)
+		}
+	}
+	if config.GoMemLimitPercent == nil {
+		percent := defaultGoMemLimitPercent
+		config.GoMemLimitPercent = &percent
+	} else if v := *config.GoMemLimitPercent; v < 1 || v > 100 {
+		return dnsRuntimeConfig{}, fmt.Errorf("invalid annotation %s: goMemLimitPercent %d must be between 1 and 100", dnsRuntimeAnnotationKey, v)
+	}
+	for name, value := range config.Env {
+		if !allowedDNSRuntimeEnv.Has(name) {
+			return dnsRuntimeConfig{}, fmt.Errorf("invalid annotation %s: environment variable %q is not one of %s", dnsRuntimeAnnotationKey, name, strings.Join(allowedDNSRuntimeEnv.List(), ", "))
+		}
+		if len(value) == 0 {
+			return dnsRuntimeConfig{}, fmt.Errorf("invalid annotation %s: environment variable %s must not be empty", dnsRuntimeAnnotationKey, name)
+		}
+		switch name {
+		case "GOMAXPROCS":
+			if n, err := strconv.Atoi(value); err != nil || n < 1 {
+				return dnsRuntimeConfig{}, fmt.Errorf("invalid annotation %s: GOMAXPROCS %q must be a positive integer", dnsRuntimeAnnotationKey, value)
+			}
+		case "GOGC":
+			if n, err := strconv.Atoi(value); value != "off" && (err != nil || n < 0) {
+				return dnsRuntimeConfig{}, fmt.Errorf("invalid annotation %s: GOGC %q must be \"off\" or a non-negative integer", dnsRuntimeAnnotationKey, value)
+			}
+		case "GOMEMLIMIT":
+			if !validGoMemLimit(value) {
+				return dnsRuntimeConfig{}, fmt.Errorf("invalid annotation %s: GOMEMLIMIT %q must be \"off\" or a non-negative integer with an optional B, KiB, MiB, GiB, or TiB suffix", dnsRuntimeAnnotationKey, value)
+			}
+		case "GOTRACEBACK":
+			if !goTracebackLevels.Has(value) {
+				return dnsRuntimeConfig{}, fmt.Errorf("invalid annotation %s: GOTRACEBACK %q is not one of %s", dnsRuntimeAnnotationKey, value, strings.Join(goTracebackLevels.List(), ", "))
+			}
+		}
+	}
+	return config, nil
+}
+
+// validGoMemLimit returns a Boolean value indicating whether the Go runtime
+// accepts the given value for GOMEMLIMIT.
+func validGoMemLimit(value string) bool {
+	if value == "off" {
+		return true
+	}
+	for _, suffix := range goMemLimitSuffixes {
+		if strings.HasSuffix(value, suffix) {
+			value = strings.TrimSuffix(value, suffix)
+			break
+		}
+	}
+	// The runtime rejects signs, so require plain digits.
+	if len(value) == 0 || strings.TrimLeft(value, "0123456789") != "" {
+		return false
+	}
+	_, err := strconv.ParseInt(value, 10, 64)
+	return err == nil
+}
+
+// dnsRuntimeEnv returns the environment variables for a dns container with the
+// given resource requirements according to the given configuration, sorted by
+// name.
+func dnsRuntimeEnv(config dnsRuntimeConfig, resources corev1.ResourceRequirements) []corev1.EnvVar {
+	values := map[string]string{}
+	if cpu, ok := resources.Limits[corev1.ResourceCPU]; ok {
+		// Round up so that a fractional limit still gets a thread.
+		procs := (cpu.MilliValue() + 999) / 1000
+		if procs < 1 {
+			procs = 1
+		}
+		values["GOMAXPROCS"] = strconv.FormatInt(procs, 10)
+	}
+	if memory, ok := resources.Limits[corev1.ResourceMemory]; ok {
+		values["GOMEMLIMIT"] = strconv.FormatInt(memory.Value()/100*int64(*config.GoMemLimitPercent), 10)
+	}
+	for name, value := range config.Env {
+		values[name] = value
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	env := make([]corev1.EnvVar, 0, len(names))
+	for _, name := range names {
+		env = append(env, corev1.EnvVar{Name: name, Value: values[name]})
+	}
+	return env
+}
+
+// applyDNSRuntimeConfig replaces the runtime environment variables of the
+// given dns container with the ones for the given configuration and the
+// container's limits, keeping any other environment variables.
+func applyDNSRuntimeConfig(container *corev1.Container, config dnsRuntimeConfig) {
+	env := []corev1.EnvVar{}
+	for _, v := range container.Env {
+		if !allowedDNSRuntimeEnv.Has(v.Name) {
+			env = append(env, v)
+		}
+	}
+	env = append(env, dnsRuntimeEnv(config, container.Resources)...)
+	if len(env) == 0 {
+		env = nil
+	}
+	container.Env = env
+}
@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDNSRuntimeConfigForDNS(t *testing.T) {
+	testCases := []struct {
+		name          string
+		annotation    string
+		expectPercent int
+		expectedError string
+	}{
+		{
+			name:          "no annotation",
+			expectPercent: defaultGoMemLimitPercent,
+		},
+		{
+			name:          "memory limit percentage and env",
+			annotation:    `{"goMemLimitPercent":80,"env":{"GOGC":"off","GODEBUG":"madvdontneed=1"}}`,
+			expectPercent: 80,
+		},
+		{
+			name:          "invalid JSON",
+			annotation:    `{"env":`,
+			expectedError: "failed to parse annotation",
+		},
+		{
+			name:          "percentage out of range",
+			annotation:    `{"goMemLimitPercent":0}`,
+			expectedError: "goMemLimitPercent 0 must be between 1 and 100",
+		},
+		{
+			name:          "unsupported variable",
+			annotation:    `{"env":{"HTTP_PROXY":"http://proxy"}}`,
+			expectedError: `environment variable "HTTP_PROXY" is not one of GODEBUG, GOGC, GOMAXPROCS, GOMEMLIMIT, GOTRACEBACK`,
+		},
+		{
+			name:          "empty value",
+			annotation:    `{"env":{"GODEBUG":""}}`,
+			expectedError: "environment variable GODEBUG must not be empty",
+		},
+		{
+			name:          "invalid GOMAXPROCS",
+			annotation:    `{"env":{"GOMAXPROCS":"0"}}`,
+			expectedError: `GOMAXPROCS "0" must be a positive integer`,
+		},
+		{
+			name:          "invalid GOGC",
+			annotation:    `{"env":{"GOGC":"on"}}`,
+			expectedError: `GOGC "on" must be "off" or a non-negative integer`,
+		},
+		{
+			name:          "valid GOMEMLIMIT and GOTRACEBACK",
+			annotation:    `{"env":{"GOMEMLIMIT":"512MiB","GOTRACEBACK":"crash"}}`,
+			expectPercent: defaultGoMemLimitPercent,
+		},
+		{
+			name:          "GOMEMLIMIT off",
+			annotation:    `{"env":{"GOMEMLIMIT":"off"}}`,
+			expectPercent: defaultGoMemLimitPercent,
+		},
+		{
+			name:          "GOMEMLIMIT with unknown suffix",
+			annotation:    `{"env":{"GOMEMLIMIT":"512M"}}`,
+			expectedError: `GOMEMLIMIT "512M" must be "off" or a non-negative integer`,
+		},
+		{
+			name:          "negative GOMEMLIMIT",
+			annotation:    `{"env":{"GOMEMLIMIT":"-1GiB"}}`,
+			expectedError: `GOMEMLIMIT "-1GiB" must be "off" or a non-negative integer`,
+		},
+		{
+			name:          "GOMEMLIMIT suffix only",
+			annotation:    `{"env":{"GOMEMLIMIT":"MiB"}}`,
+			expectedError: `GOMEMLIMIT "MiB" must be "off" or a non-negative integer`,
+		},
+		{
+			name:          "invalid GOTRACEBACK",
+			annotation:    `{"env":{"GOTRACEBACK":"verbose"}}`,
+			expectedError: `GOTRACEBACK "verbose" is not one of 0, 1, 2, all, crash, none, single, system, wer`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+			if len(tc.annotation) != 0 {
+				dns.Annotations = map[string]string{dnsRuntimeAnnotationKey: tc.annotation}
+			}
+			config, err := dnsRuntimeConfigForDNS(dns)
+			if len(tc.expectedError) != 0 {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *config.GoMemLimitPercent != tc.expectPercent {
+				t.Errorf("expected goMemLimitPercent %d, got %d", tc.expectPercent, *config.GoMemLimitPercent)
+			}
+		})
+	}
+}
+
+func TestDNSRuntimeEnv(t *testing.T) {
+	ninety := defaultGoMemLimitPercent
+	testCases := []struct {
+		name   string
+		env    map[string]string
+		limits corev1.ResourceList
+		expect []corev1.EnvVar
+	}{
+		{
+			name:   "no limits",
+			expect: []corev1.EnvVar{},
+		},
+		{
+			name: "fractional CPU limit and memory limit",
+			limits: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("1500m"),
+				corev1.ResourceMemory: resource.MustParse("100Mi"),
+			},
+			expect: []corev1.EnvVar{
+				{Name: "GOMAXPROCS", Value: "2"},
+				{Name: "GOMEMLIMIT", Value: "94371840"},
+			},
+		},
+		{
+			name:   "small CPU limit",
+			limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+			expect: []corev1.EnvVar{{Name: "GOMAXPROCS", Value: "1"}},
+		},
+		{
+			name: "explicit values take precedence",
+			env:  map[string]string{"GOMAXPROCS": "4", "GOGC": "50"},
+			limits: corev1.ResourceList{
+				corev1.ResourceCPU: resource.MustParse("2"),
+			},
+			expect: []corev1.EnvVar{
+				{Name: "GOGC", Value: "50"},
+				{Name: "GOMAXPROCS", Value: "4"},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := dnsRuntimeConfig{GoMemLimitPercent: &ninety, Env: tc.env}
+			env := dnsRuntimeEnv(config, corev1.ResourceRequirements{Limits: tc.limits})
+			if len(env) != len(tc.expect) {
+				t.Fatalf("expected env %v, got %v", tc.expect, env)
+			}
+			for i := range env {
+				if env[i] != tc.expect[i] {
+					t.Errorf("expected env %v, got %v", tc.expect, env)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestDesiredDNSDaemonSetRuntime(t *testing.T) {
+	dns := &operatorv1.DNS{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: DefaultDNSController,
+			Annotations: map[string]string{
+				dnsResourcesAnnotationKey: `{"dns":{"limits":{"cpu":"2","memory":"1Gi"}}}`,
+				dnsRuntimeAnnotationKey:   `{"goMemLimitPercent":50,"env":{"GODEBUG":"madvdontneed=1"}}`,
+			},
+		},
+	}
+	ds, err := desiredDNSDaemonSet(dns, "coredns", "kube-rbac-proxy", nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]string{
+		"GODEBUG":    "madvdontneed=1",
+		"GOMAXPROCS": "2",
+		"GOMEMLIMIT": "536870900",
+	}
+	for _, c := range ds.Spec.Template.Spec.Containers {
+		if c.Name != "dns" {
+			if len(c.Env) != 0 {
+				t.Errorf("expected no env on the %s container, got %v", c.Name, c.Env)
+			}
+			continue
+		}
+		if len(c.Env) != len(expected) {
+			t.Errorf("expected env %v, got %v", expected, c.Env)
+		}
+		for _, v := range c.Env {
+			if expected[v.Name] != v.Value {
+				t.Errorf("expected %s to be %q, got %q", v.Name, expected[v.Name], v.Value)
+			}
+		}
+	}
+
+	// Changing the runtime configuration must roll out.
+	current, err := desiredDNSDaemonSet(&operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}, "coredns", "kube-rbac-proxy", nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	delete(dns.Annotations, dnsResourcesAnnotationKey)
+	dns.Annotations[dnsRuntimeAnnotationKey] = `{"env":{"GOGC":"200"}}`
+	desired, err := desiredDNSDaemonSet(dns, "coredns", "kube-rbac-proxy", nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if changed, updated := daemonsetConfigChanged(current, desired); !changed {
+		t.Errorf("expected daemonsetConfigChanged to detect the changed env")
+	} else if env := updated.Spec.Template.Spec.Containers[0].Env; len(env) != 1 || env[0].Name != "GOGC" || env[0].Value != "200" {
+		t.Errorf("unexpected updated env: %v", env)
+	}
+}
@@ -17,6 +17,10 @@ import (
 
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 const (
@@ -51,17 +55,27 @@ func (r *reconciler) ensureDNSService(dns *operatorv1.DNS, clusterIP string, dae
 		return false, nil, err
 	}
 
+	podSelector, err := r.dnsServingPodLabels(dns)
+	if err != nil {
+		return haveService, current, err
+	}
 	desired := desiredDNSService(dns, clusterIP, enableTopologyAwareHints, daemonsetRef)
+	desired.Spec.Selector = podSelector
+	desired, err = applyUnsupportedServiceOverrides(dns, desired)
+	if err != nil {
+		return haveService, current, err
+	}
 
 	switch {
 	case !haveService:
+		setDesiredStateHash(desired, desiredServiceStateHash(desired))
 		if err := r.client.Create(context.TODO(), desired); err != nil {
 			return false, nil, fmt.Errorf("failed to create dns service: %v", err)
 		}
 		logrus.Infof("created dns service: %s/%s", desired.Namespace, desired.Name)
 		return r.currentDNSService(dns)
 	case haveService:
-		if updated, err := r.updateDNSService(current, desired); err != nil {
+		if updated, err := r.updateDNSService(dns, current, desired); err != nil {
 			return true, current, err
 		} else if updated {
 			return r.currentDNSService(dns)
@@ -166,7 +180,7 @@ func desiredDNSService(dns *operatorv1.DNS, clusterIP string, enableTopologyAwar
 		manifests.OwningDNSLabel: DNSDaemonSetLabel(dns),
 	}
 
-	s.Spec.Selector = DNSDaemonSetPodSelector(dns).MatchLabels
+	s.Spec.Selector = DNSServingPodSelector(dns).MatchLabels
 
 	if len(clusterIP) > 0 {
 		s.Spec.ClusterIP = clusterIP
@@ -174,14 +188,41 @@ func desiredDNSService(dns *operatorv1.DNS, clusterIP string, enableTopologyAwar
 	return s
 }
 
-func (r *reconciler) updateDNSService(current, desired *corev1.Service) (bool, error) {
+// dnsServingPodLabels returns the labels that the dns service and the dns
+// network policy of the given DNS select pods by.  These are the serving label,
+// unless pods of the dns daemonset from before the operator added the serving
+// label still run.  Until those pods are replaced, the daemonset's pod label is
+// selected instead so that they keep answering queries while the daemonset
+// rolls out.
+func (r *reconciler) dnsServingPodLabels(dns *operatorv1.DNS) (map[string]string, error) {
+	unlabeled, err := labels.NewRequirement(dnsServingLabel, selection.DoesNotExist, nil)
+	if err != nil {
+		return nil, err
+	}
+	selector := labels.SelectorFromSet(DNSDaemonSetPodSelector(dns).MatchLabels).Add(*unlabeled)
+	pods := &corev1.PodList{}
+	if err := r.cache.List(context.TODO(), pods, client.MatchingLabelsSelector{Selector: selector}, client.InNamespace(DefaultOperandNamespace)); err != nil {
+		return nil, fmt.Errorf("failed to list dns pods without the serving label: %w", err)
+	}
+	if len(pods.Items) != 0 {
+		return DNSDaemonSetPodSelector(dns).MatchLabels, nil
+	}
+	return DNSServingPodSelector(dns).MatchLabels, nil
+}
+
+func (r *reconciler) updateDNSService(dns *operatorv1.DNS, current, desired *corev1.Service) (bool, error) {
+	hash := desiredServiceStateHash(desired)
 	changed, updated := serviceChanged(current, desired)
 	if !changed {
-		return false, nil
+		return r.seedDesiredStateHash("Service", current, hash)
 	}
 
 	// Diff before updating because the client may mutate the object.
 	diff := cmp.Diff(current, updated, cmpopts.EquateEmpty())
+	if driftDetected(current, hash) {
+		r.reportDrift(dns, "Service", current, diff)
+	}
+	setDesiredStateHash(updated, hash)
 	if err := r.client.Update(context.TODO(), updated); err != nil {
 		return false, fmt.Errorf("failed to update dns service %s/%s: %v", updated.Namespace, updated.Name, err)
 	}
@@ -189,6 +230,21 @@ func (r *reconciler) updateDNSService(current, desired *corev1.Service) (bool, e
 	return true, nil
 }
 
+// desiredServiceStateHash returns a hash of the spec and the managed
+// annotations of the given desired dns service.
+func desiredServiceStateHash(desired *corev1.Service) string {
+	annotations := map[string]string{}
+	for k, v := range desired.Annotations {
+		if managedDNSServiceAnnotations.Has(k) {
+			annotations[k] = v
+		}
+	}
+	return desiredStateHash(struct {
+		Annotations map[string]string
+		Spec        corev1.ServiceSpec
+	}{annotations, desired.Spec})
+}
+
 func serviceChanged(current, expected *corev1.Service) (bool, *corev1.Service) {
 	annotationCmpOpts := []cmp.Option{
 		cmpopts.IgnoreMapEntries(func(k, _ string) bool {
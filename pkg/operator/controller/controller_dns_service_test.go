@@ -372,6 +372,57 @@ func Test_shouldEnableTopologyAwareHints(t *testing.T) {
 	}
 }
 
+// TestDNSServingPodLabels verifies that the dns service selects the dns
+// daemonset's pod label while pods from before the serving label still run and
+// the serving label afterwards.
+func TestDNSServingPodLabels(t *testing.T) {
+	dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+	pod := func(name string, labels map[string]string) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: DefaultOperandNamespace, Labels: labels}}
+	}
+	testCases := []struct {
+		name   string
+		pods   []runtime.Object
+		expect map[string]string
+	}{
+		{
+			name:   "no pods",
+			expect: DNSServingPodSelector(dns).MatchLabels,
+		},
+		{
+			name: "pods from before the serving label",
+			pods: []runtime.Object{
+				pod("dns-default-old", map[string]string{controllerDaemonSetLabel: "default"}),
+				pod("dns-default-new", map[string]string{controllerDaemonSetLabel: "default", dnsServingLabel: "default"}),
+			},
+			expect: DNSDaemonSetPodSelector(dns).MatchLabels,
+		},
+		{
+			name: "all pods have the serving label",
+			pods: []runtime.Object{
+				pod("dns-default-new", map[string]string{controllerDaemonSetLabel: "default", dnsServingLabel: "default"}),
+				pod("dns-default-canary", map[string]string{corefileCanaryDaemonSetLabel: "default", dnsServingLabel: "default"}),
+				pod("dns-other-old", map[string]string{controllerDaemonSetLabel: "other"}),
+			},
+			expect: DNSServingPodSelector(dns).MatchLabels,
+		},
+	}
+
+	scheme := runtime.NewScheme()
+	corev1.AddToScheme(scheme)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(tc.pods...).Build()
+			informer := informertest.FakeInformers{Scheme: scheme}
+			r := &reconciler{cache: fakeCache{Informers: &informer, Reader: fakeClient}}
+			selector, err := r.dnsServingPodLabels(dns)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expect, selector)
+		})
+	}
+}
+
 type fakeCache struct {
 	cache.Informers
 	client.Reader
@@ -0,0 +1,373 @@
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	"github.com/sirupsen/logrus"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+const (
+	// tlsPreflightAnnotationKey is the annotation on a DNS that enables
+	// pre-flight TLS handshakes with the DNS's DNS-over-TLS upstreams.
+	// The value is a JSON object with the following fields:
+	//
+	//   - "policy" is what the operator does when a handshake fails:
+	//     "Block" keeps the current Corefile, and "Warn" rolls out the
+	//     new Corefile anyway.
+	//   - "timeout" is a duration that specifies how long the operator
+	//     waits for a handshake to complete.  The default is 5s.
+	//
+	// Before the operator rolls out a new Corefile, it does a TLS
+	// handshake with each DNS-over-TLS upstream using the configured
+	// server name and the synced CA bundle, as CoreDNS would, and reports
+	// the failures in the TLSPreflightFailed status condition.  The
+	// handshakes run in the background, and their results are kept for
+	// the desired Corefile that they were made for.  With the Block policy,
+	// the operator keeps the current Corefile until the handshakes for the
+	// desired Corefile have succeeded, and it retries failed handshakes
+	// every tlsPreflightRetryInterval.
+	tlsPreflightAnnotationKey = "dns.operator.openshift.io/tls-preflight"
+
+	// tlsPreflightPolicyBlock keeps the current Corefile if a handshake
+	// fails.
+	tlsPreflightPolicyBlock = "Block"
+	// tlsPreflightPolicyWarn rolls out the new Corefile even if a
+	// handshake fails.
+	tlsPreflightPolicyWarn = "Warn"
+
+	// defaultTLSPreflightTimeout is the default time that the operator
+	// waits for a handshake to complete.
+	defaultTLSPreflightTimeout = 5 * time.Second
+
+	// tlsPreflightRetryInterval is how long the operator waits before it
+	// retries failed handshakes for the same desired Corefile.
+	tlsPreflightRetryInterval = time.Minute
+
+	// TLSPreflightFailedConditionType is the type of the DNS status
+	// condition that indicates whether a pre-flight TLS handshake with a
+	// DNS-over-TLS upstream failed.  It is only reported once the operator
+	// has made pre-flight handshakes for the DNS.
+	TLSPreflightFailedConditionType = "TLSPreflightFailed"
+)
+
+// tlsPreflightConfig is the pre-flight TLS handshake configuration of a DNS.
+type tlsPreflightConfig struct {
+	// Policy is what the operator does when a handshake fails.  If it is
+	// empty, the operator does not do pre-flight handshakes.
+	Policy string `json:"policy"`
+	// Timeout is how long the operator waits for a handshake.
+	Timeout string `json:"timeout,omitempty"`
+
+	timeout time.Duration
+}
+
+// tlsPreflightResult is the result of a pre-flight TLS handshake.
+type tlsPreflightResult struct {
+	Target upstreamProbeTarget
+	// Error describes why the handshake failed, or is empty if it
+	// succeeded.
+	Error string
+}
+
+// tlsPreflightStatus is the result of the latest pre-flight TLS handshakes for
+// a DNS.
+type tlsPreflightStatus struct {
+	// Policy is the policy that is in effect for the handshakes.
+	Policy string
+	// CorefileHash is the hash of the desired Corefile for which the
+	// handshakes were made.
+	CorefileHash string
+	// Time is when the handshakes completed.
+	Time time.Time
+	// Results are the results of the handshakes.
+	Results []tlsPreflightResult
+}
+
+// failures returns descriptions of the failed handshakes in the status.
+func (s *tlsPreflightStatus) failures() []string {
+	var failures []string
+	for _, result := range s.Results {
+		if len(result.Error) != 0 {
+			failures = append(failures, fmt.Sprintf("%s: %s", result.Target, result.Error))
+		}
+	}
+	return failures
+}
+
+// tlsPreflighter makes pre-flight TLS handshakes in the background and records
+// the latest results for each DNS so that the controller does not block on
+// handshakes and the status can report them.
+type tlsPreflighter struct {
+	client client.Reader
+	// events receives an event for a DNS whenever handshakes for the DNS
+	// complete so that the controller can roll out or hold the Corefile.
+	// If it is nil, no events are sent.
+	events chan event.GenericEvent
+	// wg tracks the handshakes that are in progress.
+	wg sync.WaitGroup
+
+	lock     sync.Mutex
+	statuses map[string]tlsPreflightStatus
+	// inFlight maps the name of each DNS with handshakes in progress to
+	// the hash of the desired Corefile for which they are made.
+	inFlight map[string]string
+}
+
+// newTLSPreflighter returns a new pre-flighter that uses the given reader to
+// get CA bundles.
+func newTLSPreflighter(reader client.Reader) *tlsPreflighter {
+	return &tlsPreflighter{
+		client: reader,
+		events: make(chan event.GenericEvent, 1),
+	}
+}
+
+// get returns the latest handshake results for the given DNS, or nil if there
+// are none.
+func (p *tlsPreflighter) get(dns *operatorv1.DNS) *tlsPreflightStatus {
+	if p == nil {
+		return nil
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	status, ok := p.statuses[dns.Name]
+	if !ok {
+		return nil
+	}
+	return &status
+}
+
+// forget forgets the handshake results for the given DNS.
+func (p *tlsPreflighter) forget(dns *operatorv1.DNS) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	delete(p.statuses, dns.Name)
+}
+
+// setPolicy records the given policy as the policy in effect for the given
+// DNS's latest handshake results, if any.
+func (p *tlsPreflighter) setPolicy(dns *operatorv1.DNS, policy string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if status, ok := p.statuses[dns.Name]; ok {
+		status.Policy = policy
+		p.statuses[dns.Name] = status
+	}
+}
+
+// start starts handshakes with the given targets of the given DNS for the
+// desired Corefile with the given hash, unless handshakes for that Corefile
+// are already in progress.
+func (p *tlsPreflighter) start(dns *operatorv1.DNS, corefileHash string, config tlsPreflightConfig, targets []upstreamProbeTarget) {
+	p.lock.Lock()
+	if p.inFlight == nil {
+		p.inFlight = map[string]string{}
+	}
+	if p.inFlight[dns.Name] == corefileHash {
+		p.lock.Unlock()
+		return
+	}
+	p.inFlight[dns.Name] = corefileHash
+	p.lock.Unlock()
+
+	dns = dns.DeepCopy()
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		results := p.handshakes(dns, targets, config.timeout)
+
+		p.lock.Lock()
+		if p.inFlight[dns.Name] == corefileHash {
+			delete(p.inFlight, dns.Name)
+		}
+		if p.statuses == nil {
+			p.statuses = map[string]tlsPreflightStatus{}
+		}
+		p.statuses[dns.Name] = tlsPreflightStatus{
+			Policy:       config.Policy,
+			CorefileHash: corefileHash,
+			Time:         time.Now(),
+			Results:      results,
+		}
+		p.lock.Unlock()
+
+		if p.events != nil {
+			p.events <- event.GenericEvent{Object: dns}
+		}
+	}()
+}
+
+// handshakes does a TLS handshake with each of the given targets of the given
+// DNS concurrently and returns the results.
+func (p *tlsPreflighter) handshakes(dns *operatorv1.DNS, targets []upstreamProbeTarget, timeout time.Duration) []tlsPreflightResult {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	results := make([]tlsPreflightResult, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		results[i].Target = target
+		tlsConfig, err := upstreamTLSConfig(ctx, p.client, dns, target)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		wg.Add(1)
+		go func(i int, tlsConfig *tls.Config) {
+			defer wg.Done()
+			if err := tlsHandshake(results[i].Target, tlsConfig, timeout); err != nil {
+				results[i].Error = err.Error()
+			}
+		}(i, tlsConfig)
+	}
+	wg.Wait()
+	return results
+}
+
+// tlsPreflightTargets returns the DNS-over-TLS upstreams of the given DNS.
+func tlsPreflightTargets(dns *operatorv1.DNS) []upstreamProbeTarget {
+	var targets []upstreamProbeTarget
+	for _, target := range upstreamProbeTargets(dns) {
+		if target.Transport == upstreamProbeTransportTLS {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// tlsPreflightConfigForDNS returns the pre-flight TLS handshake configuration
+// for the given DNS.
+func tlsPreflightConfigForDNS(dns *operatorv1.DNS) (tlsPreflightConfig, error) {
+	config := tlsPreflightConfig{}
+	value, ok := dns.Annotations[tlsPreflightAnnotationKey]
+	if !ok || len(strings.TrimSpace(value)) == 0 {
+		return config, nil
+	}
+	if err := json.Unmarshal([]byte(value), &config); err != nil {
+		return tlsPreflightConfig{}, fmt.Errorf("failed to parse annotation %s: %w", tlsPreflightAnnotationKey, err)
+	}
+	switch config.Policy {
+	case tlsPreflightPolicyBlock, tlsPreflightPolicyWarn:
+	default:
+		return tlsPreflightConfig{}, fmt.Errorf("invalid annotation %s: policy %q must be %q or %q", tlsPreflightAnnotationKey, config.Policy, tlsPreflightPolicyBlock, tlsPreflightPolicyWarn)
+	}
+	config.timeout = defaultTLSPreflightTimeout
+	if len(config.Timeout) != 0 {
+		d, err := time.ParseDuration(config.Timeout)
+		if err != nil {
+			return tlsPreflightConfig{}, fmt.Errorf("invalid annotation %s: invalid timeout %q: %w", tlsPreflightAnnotationKey, config.Timeout, err)
+		}
+		if d <= 0 {
+			return tlsPreflightConfig{}, fmt.Errorf("invalid annotation %s: timeout %q must be positive", tlsPreflightAnnotationKey, config.Timeout)
+		}
+		config.timeout = d
+	}
+	return config, nil
+}
+
+// tlsHandshake does a TLS handshake with the given target using the given TLS
+// configuration.
+func tlsHandshake(target upstreamProbeTarget, tlsConfig *tls.Config, timeout time.Duration) error {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", target.Address, tlsConfig)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// ensureTLSPreflight makes sure that there are pre-flight TLS handshake
+// results with each DNS-over-TLS upstream of the given DNS for the desired
+// Corefile if the DNS has pre-flight handshakes enabled and the desired
+// Corefile differs from the current one, starting the handshakes in the
+// background if there are no results or failed results are due for a retry.
+// It returns a Boolean value indicating whether the caller must keep the
+// current Corefile, which is the case with the Block policy while the
+// handshakes are in progress or if a handshake failed.  It returns an error if
+// a handshake failed and the policy is Block.
+func (r *reconciler) ensureTLSPreflight(dns *operatorv1.DNS, clusterDomain string, caBundleRevisionMap map[string]string, dnsNameResolverNamespaces []string) (bool, error) {
+	config, err := tlsPreflightConfigForDNS(dns)
+	if err != nil {
+		// Keep the current Corefile rather than bypassing the
+		// handshakes because of a typo in the annotation.
+		return true, err
+	}
+	if r.tlsPreflighter == nil {
+		return false, nil
+	}
+	if len(config.Policy) == 0 {
+		r.tlsPreflighter.forget(dns)
+		return false, nil
+	}
+
+	desired, err := desiredDNSConfigMap(dns, clusterDomain, caBundleRevisionMap, r.dnsNameResolverEnabled, dnsNameResolverNamespaces)
+	if err != nil {
+		// ensureDNSConfigMap reports the error.
+		return false, nil
+	}
+	haveCM, current, err := r.currentDNSConfigMap(dns)
+	if err != nil {
+		return false, fmt.Errorf("failed to get configmap: %w", err)
+	}
+	if haveCM {
+		if changed, _ := corefileChanged(current, desired); !changed {
+			return false, nil
+		}
+	}
+
+	corefileHash := desiredStateHash(desired.Data)
+	r.tlsPreflighter.setPolicy(dns, config.Policy)
+	status := r.tlsPreflighter.get(dns)
+	if status == nil || status.CorefileHash != corefileHash {
+		r.tlsPreflighter.start(dns, corefileHash, config, tlsPreflightTargets(dns))
+		return config.Policy == tlsPreflightPolicyBlock, nil
+	}
+
+	failures := status.failures()
+	if len(failures) == 0 {
+		return false, nil
+	}
+	if time.Since(status.Time) >= tlsPreflightRetryInterval {
+		r.tlsPreflighter.start(dns, corefileHash, config, tlsPreflightTargets(dns))
+	}
+	if config.Policy == tlsPreflightPolicyBlock {
+		return true, fmt.Errorf("keeping the current Corefile because TLS handshakes failed with upstreams: %s", strings.Join(failures, "; "))
+	}
+	logrus.Warningf("rolling out the Corefile for dns %s although TLS handshakes failed with upstreams: %s", dns.Name, strings.Join(failures, "; "))
+	return false, nil
+}
+
+// computeTLSPreflightFailedCondition computes the TLSPreflightFailed status
+// condition from the given latest pre-flight TLS handshake results.
+func computeTLSPreflightFailedCondition(oldCondition *operatorv1.OperatorCondition, status *tlsPreflightStatus) operatorv1.OperatorCondition {
+	condition := &operatorv1.OperatorCondition{
+		Type: TLSPreflightFailedConditionType,
+	}
+	failures := status.failures()
+	switch {
+	case len(failures) == 0:
+		condition.Status = operatorv1.ConditionFalse
+		condition.Reason = "AsExpected"
+		condition.Message = fmt.Sprintf("TLS handshakes succeeded with all %d DNS-over-TLS upstreams.", len(status.Results))
+	case status.Policy == tlsPreflightPolicyBlock:
+		condition.Status = operatorv1.ConditionTrue
+		condition.Reason = "RolloutBlocked"
+		condition.Message = fmt.Sprintf("The Corefile rollout is blocked because TLS handshakes failed with upstreams: %s", strings.Join(failures, "; "))
+	default:
+		condition.Status = operatorv1.ConditionTrue
+		condition.Reason = "HandshakeFailed"
+		condition.Message = fmt.Sprintf("TLS handshakes failed with upstreams: %s", strings.Join(failures, "; "))
+	}
+	return setDNSLastTransitionTime(condition, oldCondition)
+}
@@ -0,0 +1,233 @@
+package controller
+
+import (
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	configv1 "github.com/openshift/api/config/v1"
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestTLSPreflightConfigForDNS(t *testing.T) {
+	testCases := []struct {
+		name           string
+		annotation     string
+		expectedConfig tlsPreflightConfig
+		expectError    bool
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:           "block",
+			annotation:     `{"policy":"Block"}`,
+			expectedConfig: tlsPreflightConfig{Policy: "Block", timeout: 5 * time.Second},
+		},
+		{
+			name:           "warn with timeout",
+			annotation:     `{"policy":"Warn","timeout":"1s"}`,
+			expectedConfig: tlsPreflightConfig{Policy: "Warn", Timeout: "1s", timeout: time.Second},
+		},
+		{
+			name:        "unknown policy",
+			annotation:  `{"policy":"Ignore"}`,
+			expectError: true,
+		},
+		{
+			name:        "missing policy",
+			annotation:  `{"timeout":"1s"}`,
+			expectError: true,
+		},
+		{
+			name:        "negative timeout",
+			annotation:  `{"policy":"Warn","timeout":"-1s"}`,
+			expectError: true,
+		},
+		{
+			name:        "invalid JSON",
+			annotation:  `Block`,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+			if len(tc.annotation) != 0 {
+				dns.Annotations = map[string]string{tlsPreflightAnnotationKey: tc.annotation}
+			}
+			config, err := tlsPreflightConfigForDNS(dns)
+			switch {
+			case tc.expectError && err == nil:
+				t.Fatalf("expected an error, got %+v", config)
+			case !tc.expectError && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.expectedConfig, config, cmp.AllowUnexported(tlsPreflightConfig{})); diff != "" {
+				t.Errorf("unexpected config (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestEnsureTLSPreflight verifies that the operator does TLS handshakes with
+// DNS-over-TLS upstreams using the configured server name and the synced CA
+// bundle and that a failed handshake blocks the Corefile rollout only with the
+// Block policy.
+func TestEnsureTLSPreflight(t *testing.T) {
+	// The test server's certificate is valid for "example.com".
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+	address := strings.TrimPrefix(server.URL, "https://")
+	caBundle := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+
+	testCases := []struct {
+		name             string
+		policy           string
+		serverName       string
+		caBundle         string
+		currentIsDesired bool
+		expectError      bool
+		// expectedCondition is the expected reason of the
+		// TLSPreflightFailed condition, or empty if the operator
+		// should not make handshakes.
+		expectedCondition string
+	}{
+		{
+			name:       "disabled",
+			serverName: "wrong.example.org",
+			caBundle:   caBundle,
+		},
+		{
+			name:              "valid server name and CA bundle",
+			policy:            tlsPreflightPolicyBlock,
+			serverName:        "example.com",
+			caBundle:          caBundle,
+			expectedCondition: "AsExpected",
+		},
+		{
+			name:              "wrong server name with Block",
+			policy:            tlsPreflightPolicyBlock,
+			serverName:        "wrong.example.org",
+			caBundle:          caBundle,
+			expectError:       true,
+			expectedCondition: "RolloutBlocked",
+		},
+		{
+			name:              "CA bundle not synced with Warn",
+			policy:            tlsPreflightPolicyWarn,
+			serverName:        "example.com",
+			expectedCondition: "HandshakeFailed",
+		},
+		{
+			name:             "Corefile unchanged",
+			policy:           tlsPreflightPolicyBlock,
+			serverName:       "wrong.example.org",
+			caBundle:         caBundle,
+			currentIsDesired: true,
+		},
+	}
+
+	scheme := runtime.NewScheme()
+	corev1.AddToScheme(scheme)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dns := &operatorv1.DNS{
+				ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController},
+				Spec: operatorv1.DNSSpec{
+					Servers: []operatorv1.Server{{
+						Name:  "secure",
+						Zones: []string{"secure.example.com"},
+						ForwardPlugin: operatorv1.ForwardPlugin{
+							Upstreams: []string{address},
+							TransportConfig: operatorv1.DNSTransportConfig{
+								Transport: operatorv1.TLSTransport,
+								TLS: &operatorv1.DNSOverTLSConfig{
+									ServerName: tc.serverName,
+									CABundle:   configv1.ConfigMapNameReference{Name: "ca"},
+								},
+							},
+						},
+					}},
+				},
+			}
+			if len(tc.policy) != 0 {
+				dns.Annotations = map[string]string{tlsPreflightAnnotationKey: `{"policy":"` + tc.policy + `","timeout":"2s"}`}
+			}
+			desired, err := desiredDNSConfigMap(dns, "cluster.local", map[string]string{}, false, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			current := &corev1.ConfigMap{ObjectMeta: desired.ObjectMeta, Data: map[string]string{"Corefile": "old"}}
+			if tc.currentIsDesired {
+				current = desired
+			}
+			objects := []runtime.Object{current}
+			if len(tc.caBundle) != 0 {
+				name := CABundleConfigMapName(dns, "ca")
+				objects = append(objects, &corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Namespace: name.Namespace, Name: name.Name},
+					Data:       map[string]string{caBundleFileName: tc.caBundle},
+				})
+			}
+			fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(objects...).Build()
+			r := &reconciler{client: fakeClient, tlsPreflighter: &tlsPreflighter{client: fakeClient}}
+
+			// The handshakes run in the background, and the
+			// Corefile is held while they are in progress only
+			// with the Block policy.
+			hold, err := r.ensureTLSPreflight(dns, "cluster.local", map[string]string{}, nil)
+			if err != nil {
+				t.Fatalf("unexpected error while the handshakes are in progress: %v", err)
+			}
+			if expectHold := tc.policy == tlsPreflightPolicyBlock && !tc.currentIsDesired; hold != expectHold {
+				t.Errorf("expected hold to be %t while the handshakes are in progress, got %t", expectHold, hold)
+			}
+			r.tlsPreflighter.wg.Wait()
+
+			hold, err = r.ensureTLSPreflight(dns, "cluster.local", map[string]string{}, nil)
+			switch {
+			case tc.expectError && err == nil:
+				t.Error("expected an error")
+			case !tc.expectError && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			}
+			if hold != tc.expectError {
+				t.Errorf("expected hold to be %t once the handshakes have completed, got %t", tc.expectError, hold)
+			}
+			status := r.tlsPreflighter.get(dns)
+			if len(tc.expectedCondition) == 0 {
+				if status != nil {
+					t.Errorf("expected no handshake results, got %+v", status)
+				}
+				return
+			}
+			if status == nil {
+				t.Fatal("expected handshake results")
+			}
+			if hash := desiredStateHash(desired.Data); status.CorefileHash != hash {
+				t.Errorf("expected results for Corefile %s, got %s", hash, status.CorefileHash)
+			}
+			condition := computeTLSPreflightFailedCondition(nil, status)
+			if condition.Reason != tc.expectedCondition {
+				t.Errorf("expected reason %q, got %+v", tc.expectedCondition, condition)
+			}
+		})
+	}
+}
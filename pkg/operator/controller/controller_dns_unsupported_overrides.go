@@ -0,0 +1,241 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+const (
+	// unsupportedConfigOverridesAnnotationKey is the annotation on a DNS
+	// that overrides the resources that the operator generates for it in
+	// ways that the API does not model.  The value is a JSON object with
+	// the following fields:
+	//
+	//   - "daemonset" is a strategic merge patch for the DNS daemonset.
+	//   - "service" is a strategic merge patch for the DNS service.
+	//   - "corefile" is a map of Corefile server block keys, for example
+	//     ".:5353", to directives that the operator adds to the end of the
+	//     server blocks with these keys.
+	//
+	// The operator applies the patches after it generates the desired
+	// daemonset, service, and Corefile, so the patches also apply to the
+	// corefile canary and node pool daemonsets and Corefiles.  Patches must
+	// not change the selectors of the daemonset and service, and they must
+	// not set the metadata of the daemonset and service because the
+	// operator does not reconcile labels and annotations that it does not
+	// manage, so such changes would not be applied to existing resources.
+	// The daemonset patch may change the pod template's metadata.  A
+	// DNS with unsupported config overrides is not upgradeable because the
+	// overrides may not apply to the resources that the next version of
+	// the operator generates.
+	unsupportedConfigOverridesAnnotationKey = "dns.operator.openshift.io/unsupported-config-overrides"
+
+	// unsupportedConfigOverridesHashAnnotationKey is the annotation on the
+	// pod template of the DNS daemonset that records a hash of the
+	// daemonset patch.  When the hash changes, the operator replaces the
+	// daemonset's spec because the patch may have changed any field.
+	unsupportedConfigOverridesHashAnnotationKey = "dns.operator.openshift.io/unsupported-config-overrides-hash"
+
+	// maxReportedUnsupportedConfigOverrideLength is the length to which
+	// the Upgradeable status condition truncates each reported patch.
+	maxReportedUnsupportedConfigOverrideLength = 256
+)
+
+// unsupportedConfigOverrides is the unsupported config overrides of a DNS.
+type unsupportedConfigOverrides struct {
+	// DaemonSet is a strategic merge patch for the DNS daemonset.
+	DaemonSet json.RawMessage `json:"daemonset,omitempty"`
+	// Service is a strategic merge patch for the DNS service.
+	Service json.RawMessage `json:"service,omitempty"`
+	// Corefile maps server block keys to directives to add to the server
+	// blocks.
+	Corefile map[string]string `json:"corefile,omitempty"`
+}
+
+// unsupportedConfigOverridesForDNS parses the unsupported config overrides of
+// the given DNS.
+func unsupportedConfigOverridesForDNS(dns *operatorv1.DNS) (unsupportedConfigOverrides, error) {
+	overrides := unsupportedConfigOverrides{}
+	value, ok := dns.Annotations[unsupportedConfigOverridesAnnotationKey]
+	if !ok || len(strings.TrimSpace(value)) == 0 {
+		return overrides, nil
+	}
+	if err := json.Unmarshal([]byte(value), &overrides); err != nil {
+		return unsupportedConfigOverrides{}, fmt.Errorf("failed to parse annotation %s: %w", unsupportedConfigOverridesAnnotationKey, err)
+	}
+	for _, patch := range []struct {
+		name  string
+		value *json.RawMessage
+	}{
+		{"daemonset", &overrides.DaemonSet},
+		{"service", &overrides.Service},
+	} {
+		if len(*patch.value) == 0 || string(*patch.value) == "null" {
+			*patch.value = nil
+			continue
+		}
+		compact := &bytes.Buffer{}
+		if err := json.Compact(compact, *patch.value); err != nil || compact.Bytes()[0] != '{' {
+			return unsupportedConfigOverrides{}, fmt.Errorf("invalid annotation %s: %s patch must be a JSON object", unsupportedConfigOverridesAnnotationKey, patch.name)
+		}
+		fields := map[string]json.RawMessage{}
+		if err := json.Unmarshal(compact.Bytes(), &fields); err != nil {
+			return unsupportedConfigOverrides{}, fmt.Errorf("invalid annotation %s: %s patch must be a JSON object", unsupportedConfigOverridesAnnotationKey, patch.name)
+		}
+		if _, ok := fields["metadata"]; ok {
+			return unsupportedConfigOverrides{}, fmt.Errorf("invalid annotation %s: %s patch must not set metadata", unsupportedConfigOverridesAnnotationKey, patch.name)
+		}
+		*patch.value = compact.Bytes()
+	}
+	return overrides, nil
+}
+
+// present returns a Boolean value indicating whether any override is set.
+func (o unsupportedConfigOverrides) present() bool {
+	return len(o.DaemonSet) != 0 || len(o.Service) != 0 || len(o.Corefile) != 0
+}
+
+// applyUnsupportedDaemonSetOverrides applies the daemonset patch of the given
+// DNS to the given dns daemonset and returns the patched daemonset.
+func applyUnsupportedDaemonSetOverrides(dns *operatorv1.DNS, daemonset *appsv1.DaemonSet) (*appsv1.DaemonSet, error) {
+	overrides, err := unsupportedConfigOverridesForDNS(dns)
+	if err != nil || len(overrides.DaemonSet) == 0 {
+		return daemonset, err
+	}
+	original, err := json.Marshal(daemonset)
+	if err != nil {
+		return nil, err
+	}
+	patched, err := strategicpatch.StrategicMergePatch(original, overrides.DaemonSet, appsv1.DaemonSet{})
+	if err != nil {
+		return nil, fmt.Errorf("invalid annotation %s: failed to apply daemonset patch: %w", unsupportedConfigOverridesAnnotationKey, err)
+	}
+	updated := &appsv1.DaemonSet{}
+	if err := json.Unmarshal(patched, updated); err != nil {
+		return nil, fmt.Errorf("invalid annotation %s: failed to apply daemonset patch: %w", unsupportedConfigOverridesAnnotationKey, err)
+	}
+	if updated.Name != daemonset.Name || updated.Namespace != daemonset.Namespace || !reflect.DeepEqual(updated.Spec.Selector, daemonset.Spec.Selector) || !reflect.DeepEqual(updated.Spec.Template.Labels, daemonset.Spec.Template.Labels) {
+		return nil, fmt.Errorf("invalid annotation %s: daemonset patch must not change the name, namespace, selector, or pod labels", unsupportedConfigOverridesAnnotationKey)
+	}
+	if updated.Spec.Template.Annotations == nil {
+		updated.Spec.Template.Annotations = map[string]string{}
+	}
+	updated.Spec.Template.Annotations[unsupportedConfigOverridesHashAnnotationKey] = desiredStateHash(string(overrides.DaemonSet))
+	return updated, nil
+}
+
+// applyUnsupportedServiceOverrides applies the service patch of the given DNS
+// to the given dns service and returns the patched service.
+func applyUnsupportedServiceOverrides(dns *operatorv1.DNS, service *corev1.Service) (*corev1.Service, error) {
+	overrides, err := unsupportedConfigOverridesForDNS(dns)
+	if err != nil || len(overrides.Service) == 0 {
+		return service, err
+	}
+	original, err := json.Marshal(service)
+	if err != nil {
+		return nil, err
+	}
+	patched, err := strategicpatch.StrategicMergePatch(original, overrides.Service, corev1.Service{})
+	if err != nil {
+		return nil, fmt.Errorf("invalid annotation %s: failed to apply service patch: %w", unsupportedConfigOverridesAnnotationKey, err)
+	}
+	updated := &corev1.Service{}
+	if err := json.Unmarshal(patched, updated); err != nil {
+		return nil, fmt.Errorf("invalid annotation %s: failed to apply service patch: %w", unsupportedConfigOverridesAnnotationKey, err)
+	}
+	if updated.Name != service.Name || updated.Namespace != service.Namespace || !reflect.DeepEqual(updated.Spec.Selector, service.Spec.Selector) {
+		return nil, fmt.Errorf("invalid annotation %s: service patch must not change the name, namespace, or selector", unsupportedConfigOverridesAnnotationKey)
+	}
+	return updated, nil
+}
+
+// applyUnsupportedCorefileOverrides adds the Corefile directives of the given
+// DNS to the server blocks of the given Corefile and returns the patched
+// Corefile.
+func applyUnsupportedCorefileOverrides(dns *operatorv1.DNS, corefile string) (string, error) {
+	overrides, err := unsupportedConfigOverridesForDNS(dns)
+	if err != nil || len(overrides.Corefile) == 0 {
+		return corefile, err
+	}
+	keys := make([]string, 0, len(overrides.Corefile))
+	for key := range overrides.Corefile {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	lines := strings.Split(corefile, "\n")
+	for _, key := range keys {
+		directives := []string{}
+		for _, directive := range strings.Split(overrides.Corefile[key], "\n") {
+			if len(strings.TrimSpace(directive)) != 0 {
+				directives = append(directives, "    "+strings.TrimRight(directive, " \t"))
+			}
+		}
+		found := false
+		patched := make([]string, 0, len(lines)+len(directives))
+		inBlock := false
+		for _, line := range lines {
+			if inBlock && line == "}" {
+				patched = append(patched, directives...)
+				inBlock = false
+			}
+			patched = append(patched, line)
+			if !strings.HasPrefix(line, " ") && strings.HasSuffix(line, "{") && strings.TrimSpace(strings.TrimSuffix(line, "{")) == key {
+				inBlock = true
+				found = true
+			}
+		}
+		if !found {
+			return "", fmt.Errorf("invalid annotation %s: the Corefile has no server block %q", unsupportedConfigOverridesAnnotationKey, key)
+		}
+		lines = patched
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// unsupportedConfigOverridesMessage returns a description of the unsupported
+// config overrides of the given DNS, or empty if there are none.
+func unsupportedConfigOverridesMessage(dns *operatorv1.DNS) string {
+	overrides, err := unsupportedConfigOverridesForDNS(dns)
+	if err != nil {
+		return fmt.Sprintf("The annotation %s is present but invalid.", unsupportedConfigOverridesAnnotationKey)
+	}
+	if !overrides.present() {
+		return ""
+	}
+	patches := []string{}
+	if len(overrides.DaemonSet) != 0 {
+		patches = append(patches, "daemonset patch "+truncateUnsupportedConfigOverride(string(overrides.DaemonSet)))
+	}
+	if len(overrides.Service) != 0 {
+		patches = append(patches, "service patch "+truncateUnsupportedConfigOverride(string(overrides.Service)))
+	}
+	keys := make([]string, 0, len(overrides.Corefile))
+	for key := range overrides.Corefile {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		directives := strings.Join(strings.Fields(overrides.Corefile[key]), " ")
+		patches = append(patches, fmt.Sprintf("Corefile server block %s directives %q", key, truncateUnsupportedConfigOverride(directives)))
+	}
+	return "Unsupported config overrides are applied: " + strings.Join(patches, "; ") + "."
+}
+
+// truncateUnsupportedConfigOverride truncates the given patch to
+// maxReportedUnsupportedConfigOverrideLength characters.
+func truncateUnsupportedConfigOverride(patch string) string {
+	if len(patch) <= maxReportedUnsupportedConfigOverrideLength {
+		return patch
+	}
+	return patch[:maxReportedUnsupportedConfigOverrideLength] + "..."
+}
@@ -0,0 +1,219 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestUnsupportedConfigOverridesForDNS(t *testing.T) {
+	testCases := []struct {
+		name          string
+		annotation    string
+		expectPresent bool
+		expectedError string
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:          "all overrides",
+			annotation:    `{"daemonset":{"spec":{"minReadySeconds":5}},"service":{"spec":{"publishNotReadyAddresses":true}},"corefile":{".:5353":"any"}}`,
+			expectPresent: true,
+		},
+		{
+			name:       "null patches",
+			annotation: `{"daemonset":null,"service":null}`,
+		},
+		{
+			name:          "invalid JSON",
+			annotation:    `{"daemonset":`,
+			expectedError: "failed to parse annotation",
+		},
+		{
+			name:          "patch is not an object",
+			annotation:    `{"service":[]}`,
+			expectedError: "service patch must be a JSON object",
+		},
+		{
+			name:          "daemonset patch sets metadata",
+			annotation:    `{"daemonset":{"metadata":{"labels":{"example.com/team":"dns"}}}}`,
+			expectedError: "daemonset patch must not set metadata",
+		},
+		{
+			name:          "service patch sets metadata",
+			annotation:    `{"service":{"metadata":{"annotations":{"example.com/owner":"dns-team"}}}}`,
+			expectedError: "service patch must not set metadata",
+		},
+		{
+			name:          "daemonset patch sets pod template metadata",
+			annotation:    `{"daemonset":{"spec":{"template":{"metadata":{"annotations":{"example.com/owner":"dns-team"}}}}}}`,
+			expectPresent: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+			if len(tc.annotation) != 0 {
+				dns.Annotations = map[string]string{unsupportedConfigOverridesAnnotationKey: tc.annotation}
+			}
+			overrides, err := unsupportedConfigOverridesForDNS(dns)
+			if len(tc.expectedError) != 0 {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if overrides.present() != tc.expectPresent {
+				t.Errorf("expected present to be %t, got %t", tc.expectPresent, overrides.present())
+			}
+		})
+	}
+}
+
+func TestDesiredDNSDaemonSetUnsupportedConfigOverrides(t *testing.T) {
+	dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+	current, err := desiredDNSDaemonSet(dns, "coredns", "kube-rbac-proxy", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dns.Annotations = map[string]string{
+		unsupportedConfigOverridesAnnotationKey: `{"daemonset":{"spec":{"template":{"metadata":{"annotations":{"example.com/owner":"dns-team"}},"spec":{"volumes":[{"name":"extra","emptyDir":{}}],"priorityClassName":"custom"}}}}}`,
+	}
+	desired, err := desiredDNSDaemonSet(dns, "coredns", "kube-rbac-proxy", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	volumes := map[string]bool{}
+	for _, volume := range desired.Spec.Template.Spec.Volumes {
+		volumes[volume.Name] = true
+	}
+	if !volumes["extra"] || !volumes["config-volume"] {
+		t.Errorf("expected the patch to add a volume to the generated volumes, got %v", desired.Spec.Template.Spec.Volumes)
+	}
+	if len(desired.Spec.Template.Annotations[unsupportedConfigOverridesHashAnnotationKey]) == 0 {
+		t.Errorf("expected the pod template to record the hash of the patch")
+	}
+	// The operator does not otherwise compare the priority class name,
+	// so the patch rolls out by replacing the spec.
+	changed, updated := daemonsetConfigChanged(current, desired)
+	if !changed {
+		t.Fatal("expected daemonsetConfigChanged to detect the patch")
+	}
+	if updated.Spec.Template.Spec.PriorityClassName != "custom" {
+		t.Errorf("expected the updated daemonset to have the patched priority class name, got %q", updated.Spec.Template.Spec.PriorityClassName)
+	}
+	if updated.Spec.Template.Annotations["example.com/owner"] != "dns-team" {
+		t.Errorf("expected the updated daemonset to have the patched pod template annotation, got %v", updated.Spec.Template.Annotations)
+	}
+	if changed, _ := daemonsetConfigChanged(updated, desired); changed {
+		t.Error("expected no change once the patch is rolled out")
+	}
+	// Removing the patch restores the generated spec.
+	if changed, updated := daemonsetConfigChanged(desired, current); !changed || updated.Spec.Template.Spec.PriorityClassName != current.Spec.Template.Spec.PriorityClassName {
+		t.Error("expected daemonsetConfigChanged to revert the patch")
+	}
+
+	dns.Annotations[unsupportedConfigOverridesAnnotationKey] = `{"daemonset":{"spec":{"selector":{"matchLabels":{"app":"other"}}}}}`
+	if _, err := desiredDNSDaemonSet(dns, "coredns", "kube-rbac-proxy", nil, nil); err == nil || !strings.Contains(err.Error(), "must not change the name, namespace, selector, or pod labels") {
+		t.Errorf("expected an error for a patch of the selector, got %v", err)
+	}
+}
+
+func TestApplyUnsupportedServiceOverrides(t *testing.T) {
+	dns := &operatorv1.DNS{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: DefaultDNSController,
+			Annotations: map[string]string{
+				unsupportedConfigOverridesAnnotationKey: `{"service":{"spec":{"publishNotReadyAddresses":true}}}`,
+			},
+		},
+	}
+	current := desiredDNSService(dns, "172.30.0.10", false, metav1.OwnerReference{})
+	service, err := applyUnsupportedServiceOverrides(dns, current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !service.Spec.PublishNotReadyAddresses {
+		t.Error("expected the patch to set publishNotReadyAddresses")
+	}
+	if len(service.Annotations[MetricsServingCertAnnotation]) == 0 {
+		t.Errorf("expected the patch to keep the generated annotations, got %v", service.Annotations)
+	}
+	if service.Spec.ClusterIP != "172.30.0.10" || len(service.Spec.Ports) == 0 {
+		t.Errorf("expected the patch to keep the generated spec, got %+v", service.Spec)
+	}
+	// The patch must roll out to an existing service.
+	changed, updated := serviceChanged(current, service)
+	if !changed || !updated.Spec.PublishNotReadyAddresses {
+		t.Error("expected serviceChanged to detect the patch")
+	}
+	if changed, _ := serviceChanged(updated, service); changed {
+		t.Error("expected no change once the patch is rolled out")
+	}
+
+	dns.Annotations[unsupportedConfigOverridesAnnotationKey] = `{"service":{"spec":{"selector":{"app":"other"}}}}`
+	if _, err := applyUnsupportedServiceOverrides(dns, current); err == nil || !strings.Contains(err.Error(), "must not change the name, namespace, or selector") {
+		t.Errorf("expected an error for a patch of the selector, got %v", err)
+	}
+}
+
+func TestDesiredDNSConfigMapUnsupportedConfigOverrides(t *testing.T) {
+	dns := &operatorv1.DNS{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: DefaultDNSController,
+			Annotations: map[string]string{
+				unsupportedConfigOverridesAnnotationKey: `{"corefile":{".:5353":"rewrite name exact a.example.com b.example.com\nloop","hostname.bind:5353":"log"}}`,
+			},
+		},
+	}
+	cm, err := desiredDNSConfigMap(dns, "cluster.local", nil, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corefile := cm.Data["Corefile"]
+	for _, expected := range []string{
+		"    reload\n    rewrite name exact a.example.com b.example.com\n    loop\n}\n",
+		"hostname.bind:5353 {\n    chaos\n    log\n}\n",
+	} {
+		if !strings.Contains(corefile, expected) {
+			t.Errorf("expected Corefile to contain %q, got:\n%s", expected, corefile)
+		}
+	}
+
+	dns.Annotations[unsupportedConfigOverridesAnnotationKey] = `{"corefile":{"example.com:5353":"log"}}`
+	if _, err := desiredDNSConfigMap(dns, "cluster.local", nil, false, nil); err == nil || !strings.Contains(err.Error(), `the Corefile has no server block "example.com:5353"`) {
+		t.Errorf("expected an error for an unknown server block, got %v", err)
+	}
+}
+
+func TestComputeDNSUpgradeableConditionUnsupportedConfigOverrides(t *testing.T) {
+	dns := &operatorv1.DNS{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: DefaultDNSController,
+			Annotations: map[string]string{
+				unsupportedConfigOverridesAnnotationKey: `{"service":{"spec":{"publishNotReadyAddresses":true}},"corefile":{".:5353":"loop"}}`,
+			},
+		},
+	}
+	condition := computeDNSUpgradeableCondition(nil, dns)
+	if condition.Status != operatorv1.ConditionFalse || condition.Reason != "UnsupportedConfigOverrides" {
+		t.Fatalf("expected Upgradeable=False with reason UnsupportedConfigOverrides, got %+v", condition)
+	}
+	for _, expected := range []string{`service patch {"spec":{"publishNotReadyAddresses":true}}`, `Corefile server block .:5353 directives "loop"`} {
+		if !strings.Contains(condition.Message, expected) {
+			t.Errorf("expected message to contain %q, got %q", expected, condition.Message)
+		}
+	}
+
+	delete(dns.Annotations, unsupportedConfigOverridesAnnotationKey)
+	if condition := computeDNSUpgradeableCondition(nil, dns); condition.Status != operatorv1.ConditionTrue {
+		t.Errorf("expected Upgradeable=True without overrides, got %+v", condition)
+	}
+}
@@ -0,0 +1,520 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/rest"
+
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// dnsUpdateSafetyAnnotationKey is the annotation on a DNS that
+	// configures how the operator judges whether an update to the node
+	// placement or resources of the DNS daemonset is safe.  The value is a
+	// JSON object with the following field:
+	//
+	//   - "minCoveragePercent" is the lowest percentage, from 0 to 100, of
+	//     the nodes that run a CoreDNS pod that must still be able to run
+	//     one after the update.  The default is 0, which refuses only an
+	//     update after which no node at all could run a CoreDNS pod.
+	//
+	// Before the operator changes the node selector, tolerations, affinity,
+	// or resources of the DNS daemonset, it simulates scheduling the updated
+	// pod on every node: the node must match the node selector and the
+	// required node affinity, the pod must tolerate the node's taints, and
+	// the pod's requests must fit in the node's allocatable resources next
+	// to the other pods on the node.  If the update would leave too few
+	// nodes with a CoreDNS pod, the operator keeps the current node
+	// placement and resources and reports the nodes that would lose their
+	// CoreDNS pod in the Degraded status condition.
+	dnsUpdateSafetyAnnotationKey = "dns.operator.openshift.io/update-safety"
+
+	// maxReportedLostNodes is the number of nodes that would lose their
+	// CoreDNS pod that the Degraded status condition lists by name.
+	maxReportedLostNodes = 10
+)
+
+// dnsUpdateSafetyConfig is the update safety configuration of a DNS.
+type dnsUpdateSafetyConfig struct {
+	// MinCoveragePercent is the lowest percentage of the nodes with a
+	// CoreDNS pod that must keep one.
+	MinCoveragePercent int `json:"minCoveragePercent,omitempty"`
+}
+
+// dnsUpdateSafetyStatus is the result of simulating an update to the DNS
+// daemonset.
+type dnsUpdateSafetyStatus struct {
+	// CoveredNodes is the number of nodes that run a CoreDNS pod.
+	CoveredNodes int
+	// LostNodes maps the name of each node that runs a CoreDNS pod but
+	// could not run the updated pod to the reason why.
+	LostNodes map[string]string
+	// SchedulableNodes is the number of nodes that could run the updated
+	// pod.
+	SchedulableNodes int
+	// MinCoveragePercent is the threshold that was in effect.
+	MinCoveragePercent int
+}
+
+// coveragePercent returns the percentage of the nodes with a CoreDNS pod that
+// could run the updated pod.
+func (s *dnsUpdateSafetyStatus) coveragePercent() int {
+	if s.CoveredNodes == 0 {
+		return 100
+	}
+	return (s.CoveredNodes - len(s.LostNodes)) * 100 / s.CoveredNodes
+}
+
+// safe returns a Boolean value indicating whether the update is safe.
+func (s *dnsUpdateSafetyStatus) safe() bool {
+	return s.SchedulableNodes != 0 && s.coveragePercent() >= s.MinCoveragePercent
+}
+
+// message describes why the update is unsafe.
+func (s *dnsUpdateSafetyStatus) message() string {
+	names := make([]string, 0, len(s.LostNodes))
+	for name := range s.LostNodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lost := []string{}
+	for i, name := range names {
+		if i == maxReportedLostNodes {
+			lost = append(lost, fmt.Sprintf("and %d more", len(names)-maxReportedLostNodes))
+			break
+		}
+		lost = append(lost, fmt.Sprintf("%s (%s)", name, s.LostNodes[name]))
+	}
+	message := fmt.Sprintf("Refused to update the DNS daemonset because the updated pods would run on %d nodes", s.SchedulableNodes)
+	if s.SchedulableNodes != 0 {
+		message += fmt.Sprintf(" and only %d%% of the nodes with a DNS pod would keep one, below the minimum of %d%%", s.coveragePercent(), s.MinCoveragePercent)
+	}
+	message += "."
+	if len(lost) != 0 {
+		message += " Nodes that would lose their DNS pod: " + strings.Join(lost, ", ") + "."
+	}
+	return message
+}
+
+// dnsUpdateSafetyStatuses records the latest refused update to the DNS
+// daemonset of each DNS so that the status can report it.
+type dnsUpdateSafetyStatuses struct {
+	lock     sync.Mutex
+	statuses map[string]dnsUpdateSafetyStatus
+}
+
+// get returns the latest refused update for the given DNS, or nil if the
+// latest update was not refused.
+func (s *dnsUpdateSafetyStatuses) get(dns *operatorv1.DNS) *dnsUpdateSafetyStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	status, ok := s.statuses[dns.Name]
+	if !ok {
+		return nil
+	}
+	return &status
+}
+
+// set records the given refused update for the given DNS, or forgets the
+// DNS's refused update if status is nil.
+func (s *dnsUpdateSafetyStatuses) set(dns *operatorv1.DNS, status *dnsUpdateSafetyStatus) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if status == nil {
+		delete(s.statuses, dns.Name)
+		return
+	}
+	if s.statuses == nil {
+		s.statuses = map[string]dnsUpdateSafetyStatus{}
+	}
+	s.statuses[dns.Name] = *status
+}
+
+// dnsUpdateSafetyConfigForDNS parses and validates the update safety
+// configuration of the given DNS.
+func dnsUpdateSafetyConfigForDNS(dns *operatorv1.DNS) (dnsUpdateSafetyConfig, error) {
+	config := dnsUpdateSafetyConfig{}
+	value, ok := dns.Annotations[dnsUpdateSafetyAnnotationKey]
+	if !ok || len(strings.TrimSpace(value)) == 0 {
+		return config, nil
+	}
+	if err := json.Unmarshal([]byte(value), &config); err != nil {
+		return dnsUpdateSafetyConfig{}, fmt.Errorf("failed to parse annotation %s: %w", dnsUpdateSafetyAnnotationKey, err)
+	}
+	if config.MinCoveragePercent < 0 || config.MinCoveragePercent > 100 {
+		return dnsUpdateSafetyConfig{}, fmt.Errorf("invalid annotation %s: minCoveragePercent %d must be between 0 and 100", dnsUpdateSafetyAnnotationKey, config.MinCoveragePercent)
+	}
+	return config, nil
+}
+
+// daemonsetPlacementChanged returns a Boolean value indicating whether the
+// updated daemonset's pods could be scheduled on different nodes than the
+// current daemonset's pods.
+func daemonsetPlacementChanged(current, updated *appsv1.DaemonSet) bool {
+	a, b := current.Spec.Template.Spec, updated.Spec.Template.Spec
+	if !cmp.Equal(a.NodeSelector, b.NodeSelector, cmpopts.EquateEmpty()) ||
+		!cmp.Equal(a.Tolerations, b.Tolerations, cmpopts.EquateEmpty()) ||
+		!cmp.Equal(a.Affinity, b.Affinity, cmpopts.EquateEmpty()) {
+		return true
+	}
+	return !cmp.Equal(podRequests(&a), podRequests(&b), cmpopts.EquateEmpty(), cmp.Comparer(cmpQuantity))
+}
+
+// revertDaemonSetPlacement sets the node placement and the resources of the
+// updated daemonset's pods back to the current daemonset's.
+func revertDaemonSetPlacement(current, updated *appsv1.DaemonSet) {
+	updated.Spec.Template.Spec.NodeSelector = current.Spec.Template.Spec.NodeSelector
+	updated.Spec.Template.Spec.Tolerations = current.Spec.Template.Spec.Tolerations
+	updated.Spec.Template.Spec.Affinity = current.Spec.Template.Spec.Affinity
+	for i := range updated.Spec.Template.Spec.Containers {
+		for _, c := range current.Spec.Template.Spec.Containers {
+			if c.Name == updated.Spec.Template.Spec.Containers[i].Name {
+				updated.Spec.Template.Spec.Containers[i].Resources = c.Resources
+			}
+		}
+	}
+}
+
+// daemonsetUpdateIsSafe takes current and updated daemonsets, simulates
+// scheduling the updated daemonset's pods, and returns the result if the
+// update is unsafe, or nil if it is safe.
+func (r *reconciler) daemonsetUpdateIsSafe(dns *operatorv1.DNS, current, updated *appsv1.DaemonSet) (*dnsUpdateSafetyStatus, error) {
+	if !daemonsetPlacementChanged(current, updated) {
+		return nil, nil
+	}
+	config, err := dnsUpdateSafetyConfigForDNS(dns)
+	if err != nil {
+		return nil, err
+	}
+	// Allow the update if the current daemonset doesn't even have a
+	// valid selector.
+	selector, err := metav1.LabelSelectorAsSelector(current.Spec.Selector)
+	if err != nil {
+		return nil, nil
+	}
+	podList := &corev1.PodList{}
+	if err := r.cache.List(context.TODO(), podList, client.MatchingLabelsSelector{Selector: selector}, client.InNamespace(current.Namespace)); err != nil {
+		return nil, fmt.Errorf("failed to list the daemonset's pods: %w", err)
+	}
+	// Allow the update if the current daemonset has 0 pods as the update
+	// cannot reduce the number of pods below 0.
+	if len(podList.Items) == 0 {
+		return nil, nil
+	}
+	nodeList := &corev1.NodeList{}
+	if err := r.cache.List(context.TODO(), nodeList); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	// The operator cache has only the operand namespaces' pods, so list
+	// the pods in all namespaces from the pod resources cache to account
+	// for the resources that they request.
+	allPods := &corev1.PodList{}
+	if err := r.podResourcesCache.List(context.TODO(), allPods); err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+	surge := false
+	if rollingUpdate := updated.Spec.UpdateStrategy.RollingUpdate; rollingUpdate != nil && rollingUpdate.MaxSurge != nil {
+		maxSurge, err := intstr.GetScaledValueFromIntOrPercent(rollingUpdate.MaxSurge, 100, true)
+		surge = err == nil && maxSurge != 0
+	}
+	status := simulateDNSDaemonSetUpdate(config, &updated.Spec.Template, podList.Items, nodeList.Items, allPods.Items, surge)
+	// Nodes that the dns daemonset leaves to the corefile canary
+	// daemonset or to the daemonset of a node pool keep a DNS pod.
+	if current.Name == DNSDaemonSetName(dns).Name {
+		excluded, err := dnsExcludedNodeSelectors(dns, nil)
+		if err != nil {
+			return nil, err
+		}
+		for i := range nodeList.Items {
+			node := &nodeList.Items[i]
+			for _, nodeSelector := range excluded {
+				if labels.SelectorFromSet(nodeSelector).Matches(labels.Set(node.Labels)) {
+					delete(status.LostNodes, node.Name)
+				}
+			}
+		}
+	}
+	if status.safe() {
+		return nil, nil
+	}
+	return &status, nil
+}
+
+// newPodResourcesCache returns a cache of the pods in all namespaces that keeps
+// only the fields of each pod that simulateDNSDaemonSetUpdate uses, so that
+// the operator can account for the resources that pods request on each node
+// without listing every pod in the cluster from the API or keeping whole pods
+// in memory.
+func newPodResourcesCache(config *rest.Config, scheme *runtime.Scheme) (cache.Cache, error) {
+	return cache.New(config, cache.Options{
+		Scheme: scheme,
+		ByObject: map[client.Object]cache.ByObject{
+			&corev1.Pod{}: {Transform: podResourcesTransform},
+		},
+	})
+}
+
+// podResourcesTransform reduces the given pod to its name, node, phase, and
+// resource requests.
+func podResourcesTransform(obj interface{}) (interface{}, error) {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok {
+		return obj, nil
+	}
+	stripped := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       pod.Namespace,
+			Name:            pod.Name,
+			UID:             pod.UID,
+			ResourceVersion: pod.ResourceVersion,
+		},
+		Spec: corev1.PodSpec{
+			NodeName: pod.Spec.NodeName,
+			Overhead: pod.Spec.Overhead,
+		},
+		Status: corev1.PodStatus{
+			Phase: pod.Status.Phase,
+		},
+	}
+	for _, c := range pod.Spec.InitContainers {
+		stripped.Spec.InitContainers = append(stripped.Spec.InitContainers, corev1.Container{
+			Name:      c.Name,
+			Resources: corev1.ResourceRequirements{Requests: c.Resources.Requests},
+		})
+	}
+	for _, c := range pod.Spec.Containers {
+		stripped.Spec.Containers = append(stripped.Spec.Containers, corev1.Container{
+			Name:      c.Name,
+			Resources: corev1.ResourceRequirements{Requests: c.Resources.Requests},
+		})
+	}
+	return stripped, nil
+}
+
+// simulateDNSDaemonSetUpdate simulates scheduling pods with the given template
+// on the given nodes, which run the given DNS pods and the given pods of all
+// namespaces.  If surge is true, the updated pod must fit next to the current
+// DNS pod on a node; otherwise it replaces the current DNS pod.
+func simulateDNSDaemonSetUpdate(config dnsUpdateSafetyConfig, template *corev1.PodTemplateSpec, dnsPods []corev1.Pod, nodes []corev1.Node, allPods []corev1.Pod, surge bool) dnsUpdateSafetyStatus {
+	status := dnsUpdateSafetyStatus{
+		LostNodes:          map[string]string{},
+		MinCoveragePercent: config.MinCoveragePercent,
+	}
+	covered := map[string]bool{}
+	dnsPodNames := map[string]bool{}
+	for _, pod := range dnsPods {
+		if len(pod.Spec.NodeName) != 0 && !podIsTerminal(&pod) {
+			covered[pod.Spec.NodeName] = true
+			dnsPodNames[pod.Namespace+"/"+pod.Name] = true
+		}
+	}
+	status.CoveredNodes = len(covered)
+	podsByNode := map[string][]corev1.Pod{}
+	for _, pod := range allPods {
+		if len(pod.Spec.NodeName) == 0 || podIsTerminal(&pod) {
+			continue
+		}
+		if !surge && dnsPodNames[pod.Namespace+"/"+pod.Name] {
+			continue
+		}
+		podsByNode[pod.Spec.NodeName] = append(podsByNode[pod.Spec.NodeName], pod)
+	}
+	for i := range nodes {
+		node := &nodes[i]
+		if fits, reason := dnsPodFitsNode(template, node, podsByNode[node.Name]); fits {
+			status.SchedulableNodes++
+		} else if covered[node.Name] {
+			status.LostNodes[node.Name] = reason
+		}
+	}
+	return status
+}
+
+// dnsPodFitsNode returns a Boolean value indicating whether the daemonset
+// controller and the scheduler would run a pod with the given template on the
+// given node, which runs the given pods, and the reason if they would not.
+func dnsPodFitsNode(template *corev1.PodTemplateSpec, node *corev1.Node, nodePods []corev1.Pod) (bool, string) {
+	spec := &template.Spec
+	if !labels.SelectorFromSet(spec.NodeSelector).Matches(labels.Set(node.Labels)) {
+		return false, "node selector does not match"
+	}
+	if spec.Affinity != nil && spec.Affinity.NodeAffinity != nil && spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		if !nodeSelectorMatchesNode(spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution, node) {
+			return false, "node affinity does not match"
+		}
+	}
+	tolerations := append(daemonsetDefaultTolerations(), spec.Tolerations...)
+	for _, taint := range node.Spec.Taints {
+		if taint.Effect == corev1.TaintEffectPreferNoSchedule {
+			continue
+		}
+		if !tolerationsTolerateTaints(tolerations, []corev1.Taint{taint}) {
+			return false, fmt.Sprintf("taint %s:%s is not tolerated", taint.Key, taint.Effect)
+		}
+	}
+	used := corev1.ResourceList{}
+	for _, pod := range nodePods {
+		for name, quantity := range podRequests(&pod.Spec) {
+			total := used[name]
+			total.Add(quantity)
+			used[name] = total
+		}
+	}
+	for name, request := range podRequests(spec) {
+		allocatable, ok := node.Status.Allocatable[name]
+		if !ok {
+			continue
+		}
+		free := allocatable.DeepCopy()
+		free.Sub(used[name])
+		if request.Cmp(free) > 0 {
+			return false, fmt.Sprintf("insufficient %s: requests %s, %s free", name, request.String(), free.String())
+		}
+	}
+	if allocatable, ok := node.Status.Allocatable[corev1.ResourcePods]; ok && int64(len(nodePods)) >= allocatable.Value() {
+		return false, "too many pods"
+	}
+	return true, ""
+}
+
+// daemonsetDefaultTolerations returns the tolerations that the daemonset
+// controller adds to every daemonset pod.
+func daemonsetDefaultTolerations() []corev1.Toleration {
+	tolerations := []corev1.Toleration{}
+	for _, taint := range []struct {
+		key    string
+		effect corev1.TaintEffect
+	}{
+		{corev1.TaintNodeNotReady, corev1.TaintEffectNoExecute},
+		{corev1.TaintNodeUnreachable, corev1.TaintEffectNoExecute},
+		{corev1.TaintNodeDiskPressure, corev1.TaintEffectNoSchedule},
+		{corev1.TaintNodeMemoryPressure, corev1.TaintEffectNoSchedule},
+		{corev1.TaintNodePIDPressure, corev1.TaintEffectNoSchedule},
+		{corev1.TaintNodeUnschedulable, corev1.TaintEffectNoSchedule},
+	} {
+		tolerations = append(tolerations, corev1.Toleration{
+			Key:      taint.key,
+			Operator: corev1.TolerationOpExists,
+			Effect:   taint.effect,
+		})
+	}
+	return tolerations
+}
+
+// nodeSelectorMatchesNode returns a Boolean value indicating whether any of
+// the given node selector's terms matches the given node.
+func nodeSelectorMatchesNode(selector *corev1.NodeSelector, node *corev1.Node) bool {
+	for _, term := range selector.NodeSelectorTerms {
+		if len(term.MatchExpressions) == 0 && len(term.MatchFields) == 0 {
+			continue
+		}
+		matches := true
+		for _, req := range term.MatchExpressions {
+			if !nodeSelectorRequirementMatches(req, node.Labels) {
+				matches = false
+				break
+			}
+		}
+		for _, req := range term.MatchFields {
+			if !nodeSelectorRequirementMatches(req, map[string]string{"metadata.name": node.Name}) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// nodeSelectorRequirementMatches returns a Boolean value indicating whether
+// the given node selector requirement matches the given values.
+func nodeSelectorRequirementMatches(req corev1.NodeSelectorRequirement, values map[string]string) bool {
+	value, ok := values[req.Key]
+	switch req.Operator {
+	case corev1.NodeSelectorOpIn:
+		return ok && slices.Contains(req.Values, value)
+	case corev1.NodeSelectorOpNotIn:
+		return !ok || !slices.Contains(req.Values, value)
+	case corev1.NodeSelectorOpExists:
+		return ok
+	case corev1.NodeSelectorOpDoesNotExist:
+		return !ok
+	case corev1.NodeSelectorOpGt, corev1.NodeSelectorOpLt:
+		if !ok || len(req.Values) != 1 {
+			return false
+		}
+		have, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return false
+		}
+		want, err := strconv.ParseInt(req.Values[0], 10, 64)
+		if err != nil {
+			return false
+		}
+		if req.Operator == corev1.NodeSelectorOpGt {
+			return have > want
+		}
+		return have < want
+	}
+	return false
+}
+
+// podRequests returns the effective requests of a pod with the given spec,
+// which are the larger of the sum of its containers' requests and the requests
+// of each of its init containers, plus its overhead.
+func podRequests(spec *corev1.PodSpec) corev1.ResourceList {
+	requests := corev1.ResourceList{}
+	for _, c := range spec.Containers {
+		for name, quantity := range c.Resources.Requests {
+			total := requests[name]
+			total.Add(quantity)
+			requests[name] = total
+		}
+	}
+	for _, c := range spec.InitContainers {
+		for name, quantity := range c.Resources.Requests {
+			if total, ok := requests[name]; !ok || quantity.Cmp(total) > 0 {
+				requests[name] = quantity.DeepCopy()
+			}
+		}
+	}
+	for name, quantity := range spec.Overhead {
+		total := requests[name]
+		total.Add(quantity)
+		requests[name] = total
+	}
+	for name, quantity := range requests {
+		if quantity.IsZero() {
+			delete(requests, name)
+		}
+	}
+	return requests
+}
+
+// podIsTerminal returns a Boolean value indicating whether the given pod has
+// terminated and no longer uses resources on its node.
+func podIsTerminal(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
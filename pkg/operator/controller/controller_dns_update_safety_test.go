@@ -0,0 +1,432 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDNSUpdateSafetyConfigForDNS(t *testing.T) {
+	testCases := []struct {
+		name          string
+		annotation    string
+		expectMin     int
+		expectedError string
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:       "minimum coverage",
+			annotation: `{"minCoveragePercent":90}`,
+			expectMin:  90,
+		},
+		{
+			name:          "invalid JSON",
+			annotation:    `{"minCoveragePercent":`,
+			expectedError: "failed to parse annotation",
+		},
+		{
+			name:          "out of range",
+			annotation:    `{"minCoveragePercent":101}`,
+			expectedError: "minCoveragePercent 101 must be between 0 and 100",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+			if len(tc.annotation) != 0 {
+				dns.Annotations = map[string]string{dnsUpdateSafetyAnnotationKey: tc.annotation}
+			}
+			config, err := dnsUpdateSafetyConfigForDNS(dns)
+			if len(tc.expectedError) != 0 {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if config.MinCoveragePercent != tc.expectMin {
+				t.Errorf("expected minCoveragePercent %d, got %d", tc.expectMin, config.MinCoveragePercent)
+			}
+		})
+	}
+}
+
+// makeSafetyNode returns a node with the given name, labels, taints, and
+// allocatable CPU.
+func makeSafetyNode(name string, labels map[string]string, cpu string, taints ...corev1.Taint) corev1.Node {
+	return corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels},
+		Spec:       corev1.NodeSpec{Taints: taints},
+		Status: corev1.NodeStatus{
+			Allocatable: corev1.ResourceList{
+				corev1.ResourceCPU:  resource.MustParse(cpu),
+				corev1.ResourcePods: resource.MustParse("10"),
+			},
+		},
+	}
+}
+
+// makeSafetyPod returns a running pod on the given node that requests the
+// given CPU.
+func makeSafetyPod(namespace, name, nodeName, cpu string) corev1.Pod {
+	return corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: corev1.PodSpec{
+			NodeName: nodeName,
+			Containers: []corev1.Container{{
+				Name: "c",
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)},
+				},
+			}},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func TestDNSPodFitsNode(t *testing.T) {
+	linux := map[string]string{"kubernetes.io/os": "linux", "zone": "a"}
+	template := func(cpu string) *corev1.PodTemplateSpec {
+		return &corev1.PodTemplateSpec{
+			Spec: corev1.PodSpec{
+				NodeSelector: map[string]string{"kubernetes.io/os": "linux"},
+				Containers: []corev1.Container{{
+					Name: "dns",
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)},
+					},
+				}},
+			},
+		}
+	}
+	withAffinity := template("100m")
+	withAffinity.Spec.Affinity = &corev1.Affinity{
+		NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+					MatchExpressions: []corev1.NodeSelectorRequirement{{
+						Key:      "zone",
+						Operator: corev1.NodeSelectorOpNotIn,
+						Values:   []string{"a"},
+					}},
+				}},
+			},
+		},
+	}
+	testCases := []struct {
+		name         string
+		template     *corev1.PodTemplateSpec
+		node         corev1.Node
+		pods         []corev1.Pod
+		expect       bool
+		expectReason string
+	}{
+		{
+			name:     "fits",
+			template: template("100m"),
+			node:     makeSafetyNode("n", linux, "1"),
+			pods:     []corev1.Pod{makeSafetyPod("a", "p", "n", "500m")},
+			expect:   true,
+		},
+		{
+			name:         "node selector does not match",
+			template:     template("100m"),
+			node:         makeSafetyNode("n", map[string]string{"kubernetes.io/os": "windows"}, "1"),
+			expectReason: "node selector does not match",
+		},
+		{
+			name:         "node affinity does not match",
+			template:     withAffinity,
+			node:         makeSafetyNode("n", linux, "1"),
+			expectReason: "node affinity does not match",
+		},
+		{
+			name:         "taint is not tolerated",
+			template:     template("100m"),
+			node:         makeSafetyNode("n", linux, "1", corev1.Taint{Key: "dedicated", Effect: corev1.TaintEffectNoSchedule}),
+			expectReason: "taint dedicated:NoSchedule is not tolerated",
+		},
+		{
+			name:     "cordoned node",
+			template: template("100m"),
+			node:     makeSafetyNode("n", linux, "1", corev1.Taint{Key: corev1.TaintNodeUnschedulable, Effect: corev1.TaintEffectNoSchedule}),
+			expect:   true,
+		},
+		{
+			name:         "insufficient cpu",
+			template:     template("600m"),
+			node:         makeSafetyNode("n", linux, "1"),
+			pods:         []corev1.Pod{makeSafetyPod("a", "p", "n", "500m")},
+			expectReason: "insufficient cpu: requests 600m, 500m free",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fits, reason := dnsPodFitsNode(tc.template, &tc.node, tc.pods)
+			if fits != tc.expect {
+				t.Fatalf("expected fits to be %t, got %t (%s)", tc.expect, fits, reason)
+			}
+			if reason != tc.expectReason {
+				t.Errorf("expected reason %q, got %q", tc.expectReason, reason)
+			}
+		})
+	}
+}
+
+func TestSimulateDNSDaemonSetUpdate(t *testing.T) {
+	worker := map[string]string{"kubernetes.io/os": "linux", "role": "worker"}
+	infra := map[string]string{"kubernetes.io/os": "linux", "role": "infra"}
+	nodes := []corev1.Node{
+		makeSafetyNode("infra-0", infra, "1"),
+		makeSafetyNode("worker-0", worker, "1"),
+		makeSafetyNode("worker-1", worker, "1"),
+		makeSafetyNode("worker-2", worker, "1"),
+	}
+	dnsPods := []corev1.Pod{}
+	for _, node := range nodes {
+		dnsPods = append(dnsPods, makeSafetyPod("openshift-dns", "dns-"+node.Name, node.Name, "400m"))
+	}
+	allPods := append([]corev1.Pod{makeSafetyPod("app", "busy", "worker-2", "500m")}, dnsPods...)
+	template := func(role, cpu string) *corev1.PodTemplateSpec {
+		return &corev1.PodTemplateSpec{
+			Spec: corev1.PodSpec{
+				NodeSelector: map[string]string{"role": role},
+				Containers: []corev1.Container{{
+					Name: "dns",
+					Resources: corev1.ResourceRequirements{
+						Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)},
+					},
+				}},
+			},
+		}
+	}
+	testCases := []struct {
+		name        string
+		config      dnsUpdateSafetyConfig
+		template    *corev1.PodTemplateSpec
+		surge       bool
+		expectSafe  bool
+		expectLost  []string
+		expectNodes int
+	}{
+		{
+			name:        "moving to workers loses the infra node but meets the default threshold",
+			template:    template("worker", "400m"),
+			expectSafe:  true,
+			expectLost:  []string{"infra-0"},
+			expectNodes: 3,
+		},
+		{
+			name:        "moving to workers loses too many nodes for a high threshold",
+			config:      dnsUpdateSafetyConfig{MinCoveragePercent: 80},
+			template:    template("worker", "400m"),
+			expectSafe:  false,
+			expectLost:  []string{"infra-0"},
+			expectNodes: 3,
+		},
+		{
+			name:        "no node matches",
+			template:    template("gpu", "400m"),
+			expectSafe:  false,
+			expectLost:  []string{"infra-0", "worker-0", "worker-1", "worker-2"},
+			expectNodes: 0,
+		},
+		{
+			name:        "larger requests replace the current pods",
+			config:      dnsUpdateSafetyConfig{MinCoveragePercent: 100},
+			template:    template("worker", "500m"),
+			expectSafe:  false,
+			expectLost:  []string{"infra-0"},
+			expectNodes: 3,
+		},
+		{
+			name:        "surged pods must fit next to the current pods",
+			template:    template("worker", "500m"),
+			surge:       true,
+			expectSafe:  true,
+			expectLost:  []string{"infra-0", "worker-2"},
+			expectNodes: 2,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			status := simulateDNSDaemonSetUpdate(tc.config, tc.template, dnsPods, nodes, allPods, tc.surge)
+			if status.safe() != tc.expectSafe {
+				t.Errorf("expected safe to be %t, got %t: %s", tc.expectSafe, status.safe(), status.message())
+			}
+			if status.SchedulableNodes != tc.expectNodes {
+				t.Errorf("expected %d schedulable nodes, got %d", tc.expectNodes, status.SchedulableNodes)
+			}
+			if len(status.LostNodes) != len(tc.expectLost) {
+				t.Errorf("expected lost nodes %v, got %v", tc.expectLost, status.LostNodes)
+			}
+			for _, name := range tc.expectLost {
+				if _, ok := status.LostNodes[name]; !ok {
+					t.Errorf("expected node %s to be lost, got %v", name, status.LostNodes)
+				}
+				if !strings.Contains(status.message(), name) {
+					t.Errorf("expected message to list node %s, got %q", name, status.message())
+				}
+			}
+		})
+	}
+}
+
+func TestDNSUpdateSafetyMessage(t *testing.T) {
+	status := dnsUpdateSafetyStatus{
+		CoveredNodes:       20,
+		LostNodes:          map[string]string{},
+		SchedulableNodes:   8,
+		MinCoveragePercent: 50,
+	}
+	for i := 0; i < 12; i++ {
+		status.LostNodes[fmt.Sprintf("node-%02d", i)] = "node selector does not match"
+	}
+	message := status.message()
+	for _, expected := range []string{
+		"only 40% of the nodes with a DNS pod would keep one, below the minimum of 50%",
+		"node-00 (node selector does not match)",
+		"node-09 (node selector does not match), and 2 more.",
+	} {
+		if !strings.Contains(message, expected) {
+			t.Errorf("expected message to contain %q, got %q", expected, message)
+		}
+	}
+	if strings.Contains(message, "node-10") {
+		t.Errorf("expected message to list at most %d nodes, got %q", maxReportedLostNodes, message)
+	}
+}
+
+func TestDaemonsetPlacementChanged(t *testing.T) {
+	dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+	current, err := desiredDNSDaemonSet(dns, "coredns", "kube-rbac-proxy", nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	testCases := []struct {
+		description string
+		mutate      func(*appsv1.DaemonSet)
+		expect      bool
+	}{
+		{
+			description: "image",
+			mutate: func(daemonset *appsv1.DaemonSet) {
+				daemonset.Spec.Template.Spec.Containers[0].Image = "coredns:new"
+			},
+			expect: false,
+		},
+		{
+			description: "node selector",
+			mutate: func(daemonset *appsv1.DaemonSet) {
+				daemonset.Spec.Template.Spec.NodeSelector = map[string]string{"role": "infra"}
+			},
+			expect: true,
+		},
+		{
+			description: "requests",
+			mutate: func(daemonset *appsv1.DaemonSet) {
+				daemonset.Spec.Template.Spec.Containers[0].Resources.Requests[corev1.ResourceCPU] = resource.MustParse("1")
+			},
+			expect: true,
+		},
+	}
+	for _, tc := range testCases {
+		updated := current.DeepCopy()
+		tc.mutate(updated)
+		if changed := daemonsetPlacementChanged(current, updated); changed != tc.expect {
+			t.Errorf("%s: expected daemonsetPlacementChanged to be %t, got %t", tc.description, tc.expect, changed)
+		}
+		if tc.expect {
+			revertDaemonSetPlacement(current, updated)
+			if daemonsetPlacementChanged(current, updated) {
+				t.Errorf("%s: expected revertDaemonSetPlacement to revert the change", tc.description)
+			}
+		}
+	}
+}
+
+func TestUnsafeUpdateDegradedCondition(t *testing.T) {
+	dnsDaemonset := &appsv1.DaemonSet{
+		Status: appsv1.DaemonSetStatus{
+			DesiredNumberScheduled: 4,
+			NumberAvailable:        4,
+			UpdatedNumberScheduled: 4,
+		},
+	}
+	progressing := &operatorv1.OperatorCondition{Type: operatorv1.OperatorStatusTypeProgressing, Status: operatorv1.ConditionTrue}
+	refused := &dnsUpdateSafetyStatus{
+		CoveredNodes:     4,
+		LostNodes:        map[string]string{"worker-0": "node selector does not match"},
+		SchedulableNodes: 0,
+	}
+	condition, _ := computeDNSDegradedCondition(nil, progressing, &dnsStatusInputs{clusterIP: "172.30.0.10", haveDNSDaemonset: true, dnsDaemonset: dnsDaemonset, updateSafetyStatus: refused}, 0, time.Time{})
+	if condition.Status != operatorv1.ConditionTrue {
+		t.Fatalf("expected Degraded=True while an update is refused, got %+v", condition)
+	}
+	if !strings.Contains(condition.Message, "worker-0 (node selector does not match)") {
+		t.Errorf("expected the message to list the affected node, got %q", condition.Message)
+	}
+	condition, _ = computeDNSDegradedCondition(nil, progressing, &dnsStatusInputs{clusterIP: "172.30.0.10", haveDNSDaemonset: true, dnsDaemonset: dnsDaemonset}, 0, time.Time{})
+	if condition.Status != operatorv1.ConditionFalse {
+		t.Errorf("expected Degraded=False without a refused update, got %+v", condition)
+	}
+}
+
+// TestPodResourcesTransform verifies that the pod resources cache keeps what
+// the update safety check uses and drops the rest of each pod.
+func TestPodResourcesTransform(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "app",
+			Name:        "web",
+			Labels:      map[string]string{"app": "web"},
+			Annotations: map[string]string{"large": strings.Repeat("x", 1024)},
+		},
+		Spec: corev1.PodSpec{
+			NodeName: "worker-1",
+			InitContainers: []corev1.Container{{
+				Name:      "init",
+				Image:     "init:latest",
+				Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m")}},
+			}},
+			Containers: []corev1.Container{{
+				Name:  "web",
+				Image: "web:latest",
+				Env:   []corev1.EnvVar{{Name: "A", Value: "B"}},
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m"), corev1.ResourceMemory: resource.MustParse("64Mi")},
+					Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+				},
+			}},
+			Overhead: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("16Mi")},
+		},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning, PodIP: "10.0.0.1"},
+	}
+	obj, err := podResourcesTransform(pod.DeepCopy())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stripped := obj.(*corev1.Pod)
+	if got, expected := podRequests(&stripped.Spec), podRequests(&pod.Spec); !equality.Semantic.DeepEqual(got, expected) {
+		t.Errorf("expected requests %v, got %v", expected, got)
+	}
+	if stripped.Spec.NodeName != "worker-1" || stripped.Status.Phase != corev1.PodRunning || stripped.Namespace != "app" || stripped.Name != "web" {
+		t.Errorf("expected the name, node, and phase to be kept, got %+v", stripped)
+	}
+	if len(stripped.Labels) != 0 || len(stripped.Annotations) != 0 || len(stripped.Status.PodIP) != 0 || len(stripped.Spec.Containers[0].Image) != 0 || len(stripped.Spec.Containers[0].Env) != 0 || len(stripped.Spec.Containers[0].Resources.Limits) != 0 {
+		t.Errorf("expected other fields to be dropped, got %+v", stripped)
+	}
+}
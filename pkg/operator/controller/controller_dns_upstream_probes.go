@@ -0,0 +1,641 @@
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	miekgdns "github.com/miekg/dns"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/cluster-dns-operator/pkg/manifests"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// upstreamProbesAnnotationKey is the annotation on a DNS that enables
+	// probing of the DNS's upstream resolvers by the operator.  The value
+	// is a JSON object with the following fields:
+	//
+	//   - "enabled" is a Boolean value that indicates whether the operator
+	//     probes the upstreams.
+	//   - "interval" is a duration that specifies how often the operator
+	//     probes the upstreams.  The default is 30s, and the minimum is
+	//     10s.
+	//   - "timeout" is a duration that specifies how long the operator
+	//     waits for an upstream to answer a probe.  The default is 2s.
+	//
+	// The operator probes each upstream of type Network in
+	// spec.upstreamResolvers and each upstream in
+	// spec.servers[].forwardPlugin using the transport and protocol that
+	// CoreDNS uses for it.  Upstreams of type SystemResolvConf are not
+	// probed because the operator does not have the nodes' resolv.conf.
+	// The probe is an SOA query for the server's first zone, or for the
+	// root zone for spec.upstreamResolvers; any response counts as an
+	// answer.  While probes or pre-flight TLS handshakes are enabled, the
+	// operator manages a network policy in its own namespace that allows
+	// egress to exactly the probed addresses, ports, and protocols.
+	upstreamProbesAnnotationKey = "dns.operator.openshift.io/upstream-probes"
+
+	// defaultUpstreamProbeInterval is the default interval between probes.
+	defaultUpstreamProbeInterval = 30 * time.Second
+	// minUpstreamProbeInterval is the minimum interval between probes.  It
+	// is also the interval at which the prober checks for DNSes that are
+	// due for probing.
+	minUpstreamProbeInterval = 10 * time.Second
+	// defaultUpstreamProbeTimeout is the default time that the operator
+	// waits for an upstream to answer a probe.
+	defaultUpstreamProbeTimeout = 2 * time.Second
+
+	// UpstreamsDegradedConditionType is the type of the DNS status
+	// condition that indicates whether any probed upstreams failed to
+	// answer.  It is only reported for a DNS with upstream probes enabled.
+	UpstreamsDegradedConditionType = "UpstreamsDegraded"
+
+	// upstreamProbeTransportUDP, upstreamProbeTransportTCP, and
+	// upstreamProbeTransportTLS are the transports that the operator uses
+	// to probe upstreams.  The values are the network names that the DNS
+	// client uses.
+	upstreamProbeTransportUDP = "udp"
+	upstreamProbeTransportTCP = "tcp"
+	upstreamProbeTransportTLS = "tcp-tls"
+)
+
+var (
+	// upstreamReachable reports whether each probed upstream answered the
+	// last probe.
+	upstreamReachable = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dns_operator_upstream_reachable",
+		Help: "Whether the upstream resolver answered the operator's last probe (1) or not (0).",
+	}, []string{"dns", "server", "upstream", "transport"})
+
+	// upstreamProbeLatency reports the latency of each probed upstream's
+	// last answer.
+	upstreamProbeLatency = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "dns_operator_upstream_probe_latency_seconds",
+		Help: "Latency of the upstream resolver's answer to the operator's last probe.",
+	}, []string{"dns", "server", "upstream", "transport"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(upstreamReachable, upstreamProbeLatency)
+}
+
+// upstreamProbesConfig is the upstream probe configuration of a DNS.
+type upstreamProbesConfig struct {
+	// Enabled indicates whether the operator probes the upstreams.
+	Enabled bool `json:"enabled"`
+	// Interval is how often the operator probes the upstreams.
+	Interval string `json:"interval,omitempty"`
+	// Timeout is how long the operator waits for an answer.
+	Timeout string `json:"timeout,omitempty"`
+
+	interval time.Duration
+	timeout  time.Duration
+}
+
+// upstreamProbeTarget is an upstream that the operator probes.
+type upstreamProbeTarget struct {
+	// Server is the name of the server in spec.servers that forwards to
+	// the upstream, or empty for spec.upstreamResolvers.
+	Server string
+	// Address is the upstream's host and port.
+	Address string
+	// Transport is the DNS client network that the operator uses to probe
+	// the upstream.
+	Transport string
+	// ServerName is the name that the upstream's TLS certificate must
+	// match if Transport is upstreamProbeTransportTLS.
+	ServerName string
+	// CABundle is the name of the configmap in openshift-config with the
+	// CA bundle that the upstream's TLS certificate must be signed by, if
+	// any.
+	CABundle string
+	// Zone is the zone that the probe queries.
+	Zone string
+}
+
+// String returns a description of the target for status messages.
+func (t upstreamProbeTarget) String() string {
+	if len(t.Server) == 0 {
+		return fmt.Sprintf("%s (%s)", t.Address, t.Transport)
+	}
+	return fmt.Sprintf("%s (%s, server %s)", t.Address, t.Transport, t.Server)
+}
+
+// upstreamProbeResult is the result of probing an upstream.
+type upstreamProbeResult struct {
+	Target upstreamProbeTarget
+	// Reachable indicates whether the upstream answered.
+	Reachable bool
+	// Latency is how long the upstream took to answer.
+	Latency time.Duration
+	// Error describes why the upstream did not answer.
+	Error string
+}
+
+// upstreamProbesConfigForDNS returns the upstream probe configuration for the
+// given DNS.
+func upstreamProbesConfigForDNS(dns *operatorv1.DNS) (upstreamProbesConfig, error) {
+	config := upstreamProbesConfig{}
+	value, ok := dns.Annotations[upstreamProbesAnnotationKey]
+	if !ok || len(strings.TrimSpace(value)) == 0 {
+		return config, nil
+	}
+	if err := json.Unmarshal([]byte(value), &config); err != nil {
+		return upstreamProbesConfig{}, fmt.Errorf("failed to parse annotation %s: %w", upstreamProbesAnnotationKey, err)
+	}
+	config.interval = defaultUpstreamProbeInterval
+	if len(config.Interval) != 0 {
+		d, err := time.ParseDuration(config.Interval)
+		if err != nil {
+			return upstreamProbesConfig{}, fmt.Errorf("invalid annotation %s: invalid interval %q: %w", upstreamProbesAnnotationKey, config.Interval, err)
+		}
+		if d < minUpstreamProbeInterval {
+			return upstreamProbesConfig{}, fmt.Errorf("invalid annotation %s: interval %q must be at least %s", upstreamProbesAnnotationKey, config.Interval, minUpstreamProbeInterval)
+		}
+		config.interval = d
+	}
+	config.timeout = defaultUpstreamProbeTimeout
+	if len(config.Timeout) != 0 {
+		d, err := time.ParseDuration(config.Timeout)
+		if err != nil {
+			return upstreamProbesConfig{}, fmt.Errorf("invalid annotation %s: invalid timeout %q: %w", upstreamProbesAnnotationKey, config.Timeout, err)
+		}
+		if d <= 0 || d >= config.interval {
+			return upstreamProbesConfig{}, fmt.Errorf("invalid annotation %s: timeout %q must be positive and less than the interval", upstreamProbesAnnotationKey, config.Timeout)
+		}
+		config.timeout = d
+	}
+	return config, nil
+}
+
+// upstreamProbeTargets returns the upstreams of the given DNS that the
+// operator probes.
+func upstreamProbeTargets(dns *operatorv1.DNS) []upstreamProbeTarget {
+	var targets []upstreamProbeTarget
+	transport, serverName, caBundle := upstreamProbeTransport(dns.Spec.UpstreamResolvers.TransportConfig, dns.Spec.UpstreamResolvers.ProtocolStrategy)
+	for _, upstream := range dns.Spec.UpstreamResolvers.Upstreams {
+		if upstream.Type != operatorv1.NetworkResolverType || len(upstream.Address) == 0 {
+			continue
+		}
+		port := int(upstream.Port)
+		if port == 0 {
+			port = defaultUpstreamProbePort(transport)
+		}
+		targets = append(targets, upstreamProbeTarget{
+			Address:    net.JoinHostPort(upstream.Address, strconv.Itoa(port)),
+			Transport:  transport,
+			ServerName: serverName,
+			CABundle:   caBundle,
+			Zone:       ".",
+		})
+	}
+	for _, server := range dns.Spec.Servers {
+		if len(server.Zones) == 0 {
+			continue
+		}
+		transport, serverName, caBundle := upstreamProbeTransport(server.ForwardPlugin.TransportConfig, server.ForwardPlugin.ProtocolStrategy)
+		for _, upstream := range server.ForwardPlugin.Upstreams {
+			address := upstream
+			if _, _, err := net.SplitHostPort(upstream); err != nil {
+				address = net.JoinHostPort(upstream, strconv.Itoa(defaultUpstreamProbePort(transport)))
+			}
+			targets = append(targets, upstreamProbeTarget{
+				Server:     server.Name,
+				Address:    address,
+				Transport:  transport,
+				ServerName: serverName,
+				CABundle:   caBundle,
+				Zone:       miekgdns.Fqdn(normalizeZone(server.Zones[0])),
+			})
+		}
+	}
+	return targets
+}
+
+// upstreamProbeTransport returns the transport, TLS server name, and CA bundle
+// name that the operator uses to probe upstreams with the given transport
+// configuration and protocol strategy.
+func upstreamProbeTransport(config operatorv1.DNSTransportConfig, strategy operatorv1.ProtocolStrategy) (string, string, string) {
+	if config.Transport == operatorv1.TLSTransport && config.TLS != nil {
+		return upstreamProbeTransportTLS, config.TLS.ServerName, config.TLS.CABundle.Name
+	}
+	if strategy == operatorv1.ProtocolStrategyTCP {
+		return upstreamProbeTransportTCP, "", ""
+	}
+	return upstreamProbeTransportUDP, "", ""
+}
+
+// defaultUpstreamProbePort returns the port that CoreDNS uses for upstreams
+// without a port with the given transport.
+func defaultUpstreamProbePort(transport string) int {
+	if transport == upstreamProbeTransportTLS {
+		return 853
+	}
+	return defaultDNSPort
+}
+
+// ensureUpstreamProbesNetworkPolicy ensures that the network policy that allows
+// the operator to probe the upstreams of the given DNS exists if upstream
+// probes or pre-flight TLS handshakes are enabled for the DNS and that it does
+// not exist otherwise.
+func (r *reconciler) ensureUpstreamProbesNetworkPolicy(ctx context.Context, dns *operatorv1.DNS) error {
+	name := UpstreamProbesNetworkPolicyName(dns)
+	current := &networkingv1.NetworkPolicy{}
+	haveNP := true
+	if err := r.client.Get(ctx, name, current); err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to get upstream probes networkpolicy %s/%s: %w", name.Namespace, name.Name, err)
+		}
+		haveNP = false
+	}
+
+	var targets []upstreamProbeTarget
+	if config, err := upstreamProbesConfigForDNS(dns); err != nil {
+		return err
+	} else if config.Enabled {
+		targets = upstreamProbeTargets(dns)
+	} else if config, err := tlsPreflightConfigForDNS(dns); err != nil {
+		return err
+	} else if len(config.Policy) != 0 {
+		targets = tlsPreflightTargets(dns)
+	}
+	if len(targets) == 0 {
+		if !haveNP {
+			return nil
+		}
+		if err := r.client.Delete(ctx, current); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete upstream probes networkpolicy %s/%s: %w", name.Namespace, name.Name, err)
+		}
+		logrus.Infof("deleted upstream probes networkpolicy: %s/%s", name.Namespace, name.Name)
+		return nil
+	}
+
+	desired := desiredUpstreamProbesNetworkPolicy(dns, targets)
+	if !haveNP {
+		if err := r.client.Create(ctx, desired); err != nil {
+			return fmt.Errorf("failed to create upstream probes networkpolicy %s/%s: %w", name.Namespace, name.Name, err)
+		}
+		logrus.Infof("created upstream probes networkpolicy: %s/%s", name.Namespace, name.Name)
+		return nil
+	}
+	_, err := r.updateDNSNetworkPolicy(ctx, current, desired)
+	return err
+}
+
+// desiredUpstreamProbesNetworkPolicy returns the network policy that allows
+// the operator to reach the given upstreams of the given DNS on the ports and
+// with the protocols that it uses to probe them.
+func desiredUpstreamProbesNetworkPolicy(dns *operatorv1.DNS, targets []upstreamProbeTarget) *networkingv1.NetworkPolicy {
+	var rules []networkingv1.NetworkPolicyEgressRule
+	seen := sets.NewString()
+	for _, target := range targets {
+		host, portString, err := net.SplitHostPort(target.Address)
+		if err != nil {
+			continue
+		}
+		ip := net.ParseIP(host)
+		port, err := strconv.Atoi(portString)
+		if ip == nil || err != nil {
+			continue
+		}
+		protocol := corev1.ProtocolTCP
+		if target.Transport == upstreamProbeTransportUDP {
+			protocol = corev1.ProtocolUDP
+		}
+		prefixLength := 32
+		if ip.To4() == nil {
+			prefixLength = 128
+		}
+		cidr := fmt.Sprintf("%s/%d", ip.String(), prefixLength)
+		key := fmt.Sprintf("%s %s %d", cidr, protocol, port)
+		if seen.Has(key) {
+			continue
+		}
+		seen.Insert(key)
+		portValue := intstr.FromInt(port)
+		rules = append(rules, networkingv1.NetworkPolicyEgressRule{
+			To:    []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: cidr}}},
+			Ports: []networkingv1.NetworkPolicyPort{{Protocol: &protocol, Port: &portValue}},
+		})
+	}
+
+	name := UpstreamProbesNetworkPolicyName(dns)
+	np := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name.Name,
+			Namespace: name.Namespace,
+			Labels: map[string]string{
+				manifests.OwningDNSLabel: DNSDaemonSetLabel(dns),
+			},
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{"name": DeploymentNameOfDNSOperator},
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeEgress},
+			Egress:      rules,
+		},
+	}
+	np.SetOwnerReferences([]metav1.OwnerReference{dnsOwnerRef(dns)})
+	return np
+}
+
+// probeUpstream sends an SOA query for the target's zone to the given target
+// and returns the result.
+func probeUpstream(ctx context.Context, target upstreamProbeTarget, tlsConfig *tls.Config, timeout time.Duration) upstreamProbeResult {
+	c := &miekgdns.Client{
+		Net:       target.Transport,
+		Timeout:   timeout,
+		TLSConfig: tlsConfig,
+	}
+	m := new(miekgdns.Msg)
+	m.SetQuestion(target.Zone, miekgdns.TypeSOA)
+	_, rtt, err := c.ExchangeContext(ctx, m, target.Address)
+	if err != nil {
+		return upstreamProbeResult{Target: target, Error: err.Error()}
+	}
+	return upstreamProbeResult{Target: target, Reachable: true, Latency: rtt}
+}
+
+// upstreamsDegraded returns the results of the given results whose upstreams
+// did not answer.
+func upstreamsDegraded(results []upstreamProbeResult) []upstreamProbeResult {
+	var failed []upstreamProbeResult
+	for _, result := range results {
+		if !result.Reachable {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// upstreamProbeStatus is the latest probe results for a DNS.
+type upstreamProbeStatus struct {
+	// Time is when the upstreams were last probed.
+	Time time.Time
+	// Results are the results of the last probe of each upstream.
+	Results []upstreamProbeResult
+}
+
+// upstreamProber periodically probes the upstreams of every DNS that has
+// upstream probes enabled.  It is a manager runnable.
+type upstreamProber struct {
+	client client.Reader
+	// events receives an event for a DNS whenever the set of unreachable
+	// upstreams of the DNS changes so that the controller can update the
+	// DNS's status.
+	events chan event.GenericEvent
+	// probe probes a single upstream.  It is a field so that tests can
+	// replace it.
+	probe func(ctx context.Context, target upstreamProbeTarget, tlsConfig *tls.Config, timeout time.Duration) upstreamProbeResult
+
+	lock     sync.Mutex
+	statuses map[string]upstreamProbeStatus
+}
+
+// newUpstreamProber returns a new upstream prober that uses the given reader
+// to list DNSes and CA bundles.
+func newUpstreamProber(reader client.Reader) *upstreamProber {
+	return &upstreamProber{
+		client:   reader,
+		events:   make(chan event.GenericEvent, 1),
+		probe:    probeUpstream,
+		statuses: map[string]upstreamProbeStatus{},
+	}
+}
+
+// Start probes upstreams until the given context is done.
+func (p *upstreamProber) Start(ctx context.Context) error {
+	ticker := time.NewTicker(minUpstreamProbeInterval)
+	defer ticker.Stop()
+	for {
+		if err := p.probeAll(ctx, time.Now()); err != nil {
+			logrus.Warningf("failed to probe upstreams: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Status returns the latest probe results for the given DNS, or nil if the
+// upstreams of the DNS have not been probed.
+func (p *upstreamProber) Status(dns *operatorv1.DNS) *upstreamProbeStatus {
+	if p == nil {
+		return nil
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	status, ok := p.statuses[dns.Name]
+	if !ok {
+		return nil
+	}
+	return &status
+}
+
+// probeAll probes the upstreams of every DNS that has upstream probes enabled
+// and is due for probing at the given time and forgets the results for other
+// DNSes.
+func (p *upstreamProber) probeAll(ctx context.Context, now time.Time) error {
+	dnses := &operatorv1.DNSList{}
+	if err := p.client.List(ctx, dnses); err != nil {
+		return fmt.Errorf("failed to list dnses: %w", err)
+	}
+	probed := map[string]struct{}{}
+	for i := range dnses.Items {
+		dns := &dnses.Items[i]
+		config, err := upstreamProbesConfigForDNS(dns)
+		if err != nil || !config.Enabled || dns.DeletionTimestamp != nil {
+			continue
+		}
+		probed[dns.Name] = struct{}{}
+		if status := p.Status(dns); status != nil && now.Sub(status.Time) < config.interval {
+			continue
+		}
+		p.probeDNS(ctx, dns, config, now)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for name, status := range p.statuses {
+		if _, ok := probed[name]; ok {
+			continue
+		}
+		deleteUpstreamProbeMetrics(name, status.Results)
+		delete(p.statuses, name)
+	}
+	return nil
+}
+
+// probeDNS probes the upstreams of the given DNS concurrently, records the
+// results and metrics, and notifies the controller if the set of unreachable
+// upstreams has changed.
+func (p *upstreamProber) probeDNS(ctx context.Context, dns *operatorv1.DNS, config upstreamProbesConfig, now time.Time) {
+	targets := upstreamProbeTargets(dns)
+	results := make([]upstreamProbeResult, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		tlsConfig, err := upstreamTLSConfig(ctx, p.client, dns, target)
+		if err != nil {
+			results[i] = upstreamProbeResult{Target: target, Error: err.Error()}
+			continue
+		}
+		wg.Add(1)
+		go func(i int, target upstreamProbeTarget) {
+			defer wg.Done()
+			results[i] = p.probe(ctx, target, tlsConfig, config.timeout)
+		}(i, target)
+	}
+	wg.Wait()
+
+	p.lock.Lock()
+	old := p.statuses[dns.Name]
+	p.statuses[dns.Name] = upstreamProbeStatus{Time: now, Results: results}
+	p.lock.Unlock()
+
+	deleteUpstreamProbeMetrics(dns.Name, old.Results)
+	for _, result := range results {
+		labels := upstreamProbeMetricLabels(dns.Name, result.Target)
+		if result.Reachable {
+			upstreamReachable.With(labels).Set(1)
+			upstreamProbeLatency.With(labels).Set(result.Latency.Seconds())
+		} else {
+			upstreamReachable.With(labels).Set(0)
+		}
+	}
+
+	if upstreamProbeFailuresChanged(old.Results, results) {
+		for _, result := range upstreamsDegraded(results) {
+			logrus.Warningf("upstream %s of dns %s did not answer: %s", result.Target, dns.Name, result.Error)
+		}
+		select {
+		case p.events <- event.GenericEvent{Object: dns}:
+		default:
+		}
+	}
+}
+
+// upstreamTLSConfig returns the TLS configuration for connecting to the given
+// target, or nil if the target does not use TLS.  Like CoreDNS, it trusts the
+// synced copy of the target's CA bundle, or the system roots if the target has
+// no CA bundle or the CA bundle has not been synced.
+func upstreamTLSConfig(ctx context.Context, reader client.Reader, dns *operatorv1.DNS, target upstreamProbeTarget) (*tls.Config, error) {
+	if target.Transport != upstreamProbeTransportTLS {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{ServerName: target.ServerName}
+	if len(target.CABundle) == 0 {
+		return tlsConfig, nil
+	}
+	name := CABundleConfigMapName(dns, target.CABundle)
+	cm := &corev1.ConfigMap{}
+	if err := reader.Get(ctx, name, cm); err != nil {
+		if errors.IsNotFound(err) {
+			return tlsConfig, nil
+		}
+		return nil, fmt.Errorf("failed to get ca bundle configmap %s/%s: %w", name.Namespace, name.Name, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM([]byte(cm.Data[caBundleFileName])) {
+		return nil, fmt.Errorf("ca bundle configmap %s/%s has no valid certificates", name.Namespace, name.Name)
+	}
+	tlsConfig.RootCAs = pool
+	return tlsConfig, nil
+}
+
+// upstreamProbeFailuresChanged returns a Boolean value indicating whether the
+// sets of unreachable upstreams in the given results differ.
+func upstreamProbeFailuresChanged(old, new []upstreamProbeResult) bool {
+	failed := func(results []upstreamProbeResult) string {
+		var targets []string
+		for _, result := range upstreamsDegraded(results) {
+			targets = append(targets, result.Target.String())
+		}
+		sort.Strings(targets)
+		return strings.Join(targets, ",")
+	}
+	return len(old) != len(new) || failed(old) != failed(new)
+}
+
+// upstreamProbeMetricLabels returns the metric labels for the given target of
+// the DNS with the given name.
+func upstreamProbeMetricLabels(dnsName string, target upstreamProbeTarget) prometheus.Labels {
+	return prometheus.Labels{
+		"dns":       dnsName,
+		"server":    target.Server,
+		"upstream":  target.Address,
+		"transport": target.Transport,
+	}
+}
+
+// deleteUpstreamProbeMetrics deletes the metrics for the given results of the
+// DNS with the given name.
+func deleteUpstreamProbeMetrics(dnsName string, results []upstreamProbeResult) {
+	for _, result := range results {
+		labels := upstreamProbeMetricLabels(dnsName, result.Target)
+		upstreamReachable.Delete(labels)
+		upstreamProbeLatency.Delete(labels)
+	}
+}
+
+// computeUpstreamsDegradedCondition computes the UpstreamsDegraded status
+// condition for a DNS with upstream probes enabled from the DNS's latest
+// upstream probe results, which are nil if the upstreams have not been probed.
+func computeUpstreamsDegradedCondition(oldCondition *operatorv1.OperatorCondition, status *upstreamProbeStatus) operatorv1.OperatorCondition {
+	condition := &operatorv1.OperatorCondition{
+		Type: UpstreamsDegradedConditionType,
+	}
+	var failed []upstreamProbeResult
+	if status != nil {
+		failed = upstreamsDegraded(status.Results)
+	}
+	switch {
+	case status == nil:
+		condition.Status = operatorv1.ConditionUnknown
+		condition.Reason = "ProbesPending"
+		condition.Message = "The operator has not probed the upstreams yet."
+	case len(status.Results) == 0:
+		condition.Status = operatorv1.ConditionFalse
+		condition.Reason = "NoUpstreamsProbed"
+		condition.Message = "The DNS has no upstreams that the operator can probe."
+	case len(failed) != 0:
+		descriptions := make([]string, 0, len(failed))
+		for _, result := range failed {
+			descriptions = append(descriptions, fmt.Sprintf("%s: %s", result.Target, result.Error))
+		}
+		condition.Status = operatorv1.ConditionTrue
+		condition.Reason = "UpstreamsUnreachable"
+		condition.Message = fmt.Sprintf("%d of %d upstreams did not answer: %s", len(failed), len(status.Results), strings.Join(descriptions, "; "))
+	default:
+		condition.Status = operatorv1.ConditionFalse
+		condition.Reason = "AsExpected"
+		condition.Message = fmt.Sprintf("All %d upstreams answered.", len(status.Results))
+	}
+	return setDNSLastTransitionTime(condition, oldCondition)
+}
@@ -0,0 +1,393 @@
+package controller
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	miekgdns "github.com/miekg/dns"
+	configv1 "github.com/openshift/api/config/v1"
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestUpstreamProbesConfigForDNS(t *testing.T) {
+	testCases := []struct {
+		name           string
+		annotation     string
+		expectedConfig upstreamProbesConfig
+		expectError    bool
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:       "enabled with defaults",
+			annotation: `{"enabled":true}`,
+			expectedConfig: upstreamProbesConfig{
+				Enabled:  true,
+				interval: 30 * time.Second,
+				timeout:  2 * time.Second,
+			},
+		},
+		{
+			name:       "enabled with custom settings",
+			annotation: `{"enabled":true,"interval":"1m","timeout":"5s"}`,
+			expectedConfig: upstreamProbesConfig{
+				Enabled:  true,
+				Interval: "1m",
+				Timeout:  "5s",
+				interval: time.Minute,
+				timeout:  5 * time.Second,
+			},
+		},
+		{
+			name:        "interval too short",
+			annotation:  `{"enabled":true,"interval":"1s"}`,
+			expectError: true,
+		},
+		{
+			name:        "timeout longer than the interval",
+			annotation:  `{"enabled":true,"timeout":"1m"}`,
+			expectError: true,
+		},
+		{
+			name:        "invalid timeout",
+			annotation:  `{"enabled":true,"timeout":"soon"}`,
+			expectError: true,
+		},
+		{
+			name:        "invalid JSON",
+			annotation:  `{"enabled":`,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+			if len(tc.annotation) != 0 {
+				dns.Annotations = map[string]string{upstreamProbesAnnotationKey: tc.annotation}
+			}
+			config, err := upstreamProbesConfigForDNS(dns)
+			switch {
+			case tc.expectError && err == nil:
+				t.Fatalf("expected an error, got %+v", config)
+			case !tc.expectError && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.expectedConfig, config, cmp.AllowUnexported(upstreamProbesConfig{})); diff != "" {
+				t.Errorf("unexpected config (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUpstreamProbeTargets(t *testing.T) {
+	dns := &operatorv1.DNS{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController},
+		Spec: operatorv1.DNSSpec{
+			UpstreamResolvers: operatorv1.UpstreamResolvers{
+				Upstreams: []operatorv1.Upstream{
+					{Type: operatorv1.SystemResolveConfType},
+					{Type: operatorv1.NetworkResolverType, Address: "10.0.0.1"},
+					{Type: operatorv1.NetworkResolverType, Address: "fd00::1", Port: 5353},
+				},
+				ProtocolStrategy: operatorv1.ProtocolStrategyTCP,
+			},
+			Servers: []operatorv1.Server{
+				{
+					Name:  "corp",
+					Zones: []string{"Corp.Example.com"},
+					ForwardPlugin: operatorv1.ForwardPlugin{
+						Upstreams: []string{"10.0.0.2", "10.0.0.3:5353"},
+					},
+				},
+				{
+					Name:  "secure",
+					Zones: []string{"secure.example.com"},
+					ForwardPlugin: operatorv1.ForwardPlugin{
+						Upstreams: []string{"10.0.0.4"},
+						TransportConfig: operatorv1.DNSTransportConfig{
+							Transport: operatorv1.TLSTransport,
+							TLS: &operatorv1.DNSOverTLSConfig{
+								ServerName: "dns.example.com",
+								CABundle:   configv1.ConfigMapNameReference{Name: "ca"},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	expected := []upstreamProbeTarget{
+		{Address: "10.0.0.1:53", Transport: "tcp", Zone: "."},
+		{Address: "[fd00::1]:5353", Transport: "tcp", Zone: "."},
+		{Server: "corp", Address: "10.0.0.2:53", Transport: "udp", Zone: "corp.example.com."},
+		{Server: "corp", Address: "10.0.0.3:5353", Transport: "udp", Zone: "corp.example.com."},
+		{Server: "secure", Address: "10.0.0.4:853", Transport: "tcp-tls", ServerName: "dns.example.com", CABundle: "ca", Zone: "secure.example.com."},
+	}
+	if diff := cmp.Diff(expected, upstreamProbeTargets(dns)); diff != "" {
+		t.Errorf("unexpected targets (-want +got):\n%s", diff)
+	}
+}
+
+// TestUpstreamProbesNetworkPolicy verifies that the operator allows egress to
+// exactly the probed upstreams, including upstreams on custom ports, and only
+// while upstream probes are enabled.
+func TestUpstreamProbesNetworkPolicy(t *testing.T) {
+	dns := &operatorv1.DNS{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController},
+		Spec: operatorv1.DNSSpec{
+			UpstreamResolvers: operatorv1.UpstreamResolvers{
+				Upstreams: []operatorv1.Upstream{
+					{Type: operatorv1.NetworkResolverType, Address: "10.0.0.1"},
+					{Type: operatorv1.NetworkResolverType, Address: "fd00::1", Port: 5353},
+				},
+			},
+			Servers: []operatorv1.Server{{
+				Name:  "secure",
+				Zones: []string{"secure.example.com"},
+				ForwardPlugin: operatorv1.ForwardPlugin{
+					Upstreams: []string{"2.2.2.2:5353", "10.0.0.1"},
+					TransportConfig: operatorv1.DNSTransportConfig{
+						Transport: operatorv1.TLSTransport,
+						TLS:       &operatorv1.DNSOverTLSConfig{ServerName: "dns.example.com"},
+					},
+				},
+			}},
+		},
+	}
+	type rule struct {
+		cidr     string
+		protocol corev1.Protocol
+		port     int
+	}
+	expected := []rule{
+		{"10.0.0.1/32", corev1.ProtocolUDP, 53},
+		{"fd00::1/128", corev1.ProtocolUDP, 5353},
+		{"2.2.2.2/32", corev1.ProtocolTCP, 5353},
+		{"10.0.0.1/32", corev1.ProtocolTCP, 853},
+	}
+	var actual []rule
+	for _, r := range desiredUpstreamProbesNetworkPolicy(dns, upstreamProbeTargets(dns)).Spec.Egress {
+		actual = append(actual, rule{r.To[0].IPBlock.CIDR, *r.Ports[0].Protocol, r.Ports[0].Port.IntValue()})
+	}
+	if diff := cmp.Diff(expected, actual, cmp.AllowUnexported(rule{})); diff != "" {
+		t.Errorf("unexpected egress rules (-want +got):\n%s", diff)
+	}
+
+	scheme := runtime.NewScheme()
+	networkingv1.AddToScheme(scheme)
+	r := &reconciler{client: fake.NewClientBuilder().WithScheme(scheme).Build()}
+	name := UpstreamProbesNetworkPolicyName(dns)
+	exists := func() bool {
+		err := r.client.Get(context.TODO(), name, &networkingv1.NetworkPolicy{})
+		if err != nil && !errors.IsNotFound(err) {
+			t.Fatal(err)
+		}
+		return err == nil
+	}
+	if err := r.ensureUpstreamProbesNetworkPolicy(context.TODO(), dns); err != nil {
+		t.Fatal(err)
+	}
+	if exists() {
+		t.Errorf("expected no networkpolicy %s while upstream probes are disabled", name)
+	}
+	dns.Annotations = map[string]string{upstreamProbesAnnotationKey: `{"enabled":true}`}
+	if err := r.ensureUpstreamProbesNetworkPolicy(context.TODO(), dns); err != nil {
+		t.Fatal(err)
+	}
+	if !exists() {
+		t.Errorf("expected networkpolicy %s while upstream probes are enabled", name)
+	}
+	dns.Annotations = nil
+	if err := r.ensureUpstreamProbesNetworkPolicy(context.TODO(), dns); err != nil {
+		t.Fatal(err)
+	}
+	if exists() {
+		t.Errorf("expected networkpolicy %s to be deleted when upstream probes are disabled", name)
+	}
+}
+
+// startTestDNSServer starts a DNS server that answers every query on the
+// given network and returns its address.
+func startTestDNSServer(t *testing.T, network string) string {
+	t.Helper()
+	handler := miekgdns.HandlerFunc(func(w miekgdns.ResponseWriter, r *miekgdns.Msg) {
+		m := new(miekgdns.Msg)
+		m.SetReply(r)
+		w.WriteMsg(m)
+	})
+	server := &miekgdns.Server{Net: network, Handler: handler}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	switch network {
+	case "udp":
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.PacketConn = conn
+	case "tcp":
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		server.Listener = listener
+	}
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	if server.PacketConn != nil {
+		return server.PacketConn.LocalAddr().String()
+	}
+	return server.Listener.Addr().String()
+}
+
+// TestProbeUpstream verifies that probeUpstream reports a stand-in DNS server
+// as reachable over UDP and TCP and a closed port as unreachable.
+func TestProbeUpstream(t *testing.T) {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddress := closed.Addr().String()
+	closed.Close()
+
+	testCases := []struct {
+		name            string
+		target          upstreamProbeTarget
+		expectReachable bool
+	}{
+		{
+			name:            "UDP",
+			target:          upstreamProbeTarget{Address: startTestDNSServer(t, "udp"), Transport: "udp", Zone: "."},
+			expectReachable: true,
+		},
+		{
+			name:            "TCP",
+			target:          upstreamProbeTarget{Address: startTestDNSServer(t, "tcp"), Transport: "tcp", Zone: "example.com."},
+			expectReachable: true,
+		},
+		{
+			name:   "closed port",
+			target: upstreamProbeTarget{Address: closedAddress, Transport: "tcp", Zone: "."},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := probeUpstream(context.Background(), tc.target, nil, time.Second)
+			if result.Reachable != tc.expectReachable {
+				t.Fatalf("expected reachable to be %t, got %+v", tc.expectReachable, result)
+			}
+			if !result.Reachable && len(result.Error) == 0 {
+				t.Error("expected an error for an unreachable upstream")
+			}
+		})
+	}
+}
+
+// TestUpstreamProberProbeAll verifies that the prober probes the upstreams of
+// DNSes with probes enabled, notifies the controller when the set of
+// unreachable upstreams changes, and forgets DNSes whose probes are disabled.
+func TestUpstreamProberProbeAll(t *testing.T) {
+	dns := &operatorv1.DNS{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        DefaultDNSController,
+			Annotations: map[string]string{upstreamProbesAnnotationKey: `{"enabled":true}`},
+		},
+		Spec: operatorv1.DNSSpec{
+			UpstreamResolvers: operatorv1.UpstreamResolvers{
+				Upstreams: []operatorv1.Upstream{
+					{Type: operatorv1.NetworkResolverType, Address: "10.0.0.1"},
+					{Type: operatorv1.NetworkResolverType, Address: "10.0.0.2"},
+				},
+			},
+		},
+	}
+	scheme := runtime.NewScheme()
+	operatorv1.Install(scheme)
+	fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(dns.DeepCopy()).Build()
+
+	unreachable := map[string]bool{}
+	p := newUpstreamProber(fakeClient)
+	p.probe = func(_ context.Context, target upstreamProbeTarget, _ *tls.Config, _ time.Duration) upstreamProbeResult {
+		if unreachable[target.Address] {
+			return upstreamProbeResult{Target: target, Error: "i/o timeout"}
+		}
+		return upstreamProbeResult{Target: target, Reachable: true, Latency: time.Millisecond}
+	}
+	expectEvent := func(expected bool) {
+		t.Helper()
+		select {
+		case <-p.events:
+			if !expected {
+				t.Error("unexpected event")
+			}
+		default:
+			if expected {
+				t.Error("expected an event")
+			}
+		}
+	}
+	expectCondition := func(status operatorv1.ConditionStatus, reason string) {
+		t.Helper()
+		condition := computeUpstreamsDegradedCondition(nil, p.Status(dns))
+		if condition.Status != status || condition.Reason != reason {
+			t.Errorf("expected condition %s/%s, got %+v", status, reason, condition)
+		}
+	}
+
+	start := time.Now()
+	expectCondition(operatorv1.ConditionUnknown, "ProbesPending")
+	if err := p.probeAll(context.Background(), start); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(true)
+	expectCondition(operatorv1.ConditionFalse, "AsExpected")
+
+	unreachable["10.0.0.2:53"] = true
+	if err := p.probeAll(context.Background(), start.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(false)
+	expectCondition(operatorv1.ConditionFalse, "AsExpected")
+
+	if err := p.probeAll(context.Background(), start.Add(defaultUpstreamProbeInterval)); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(true)
+	expectCondition(operatorv1.ConditionTrue, "UpstreamsUnreachable")
+
+	if err := p.probeAll(context.Background(), start.Add(2*defaultUpstreamProbeInterval)); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(false)
+
+	disabled := &operatorv1.DNS{}
+	if err := fakeClient.Get(context.Background(), DefaultDNSNamespaceName(), disabled); err != nil {
+		t.Fatal(err)
+	}
+	disabled.Annotations = nil
+	if err := fakeClient.Update(context.Background(), disabled); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.probeAll(context.Background(), start.Add(3*defaultUpstreamProbeInterval)); err != nil {
+		t.Fatal(err)
+	}
+	if status := p.Status(dns); status != nil {
+		t.Errorf("expected the results to be forgotten, got %+v", status)
+	}
+}
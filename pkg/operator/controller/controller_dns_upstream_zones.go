@@ -0,0 +1,157 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"sort"
+	"strings"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// dnsUpstreamZonesAnnotationKey is the annotation on a DNS that assigns
+	// upstream resolvers to topology zones.  The value is a JSON object
+	// that maps the address of each upstream resolver of type Network in
+	// spec.upstreamResolvers.upstreams to the zone that the upstream
+	// resolver is in.
+	//
+	// If the DNS forwards to its upstream resolvers with the Sequential
+	// policy, the operator runs a Corefile variant for each zone that
+	// lists the upstream resolvers in the zone first, in their original
+	// order, followed by the other upstream resolvers.  Each variant runs
+	// as a node pool, that is, in a daemonset that selects the nodes with
+	// the zone's topology.kubernetes.io/zone label, so that queries from a
+	// zone stay in the zone while its upstream resolvers respond.  Nodes in
+	// other zones and nodes without the label use the DNS's Corefile.
+	// Zone-aware upstreams cannot be combined with the node pools
+	// annotation.
+	dnsUpstreamZonesAnnotationKey = "dns.operator.openshift.io/upstream-zones"
+
+	// upstreamZonePoolNamePrefix is the prefix of the names of the node
+	// pools for zones.
+	upstreamZonePoolNamePrefix = "zone-"
+)
+
+// dnsUpstreamZonesForDNS parses and validates the upstream zones of the given
+// DNS.  Returns a map of upstream resolver addresses to zones.
+func dnsUpstreamZonesForDNS(dns *operatorv1.DNS) (map[string]string, error) {
+	zones := map[string]string{}
+	value, ok := dns.Annotations[dnsUpstreamZonesAnnotationKey]
+	if !ok || len(strings.TrimSpace(value)) == 0 {
+		return zones, nil
+	}
+	if err := json.Unmarshal([]byte(value), &zones); err != nil {
+		return nil, fmt.Errorf("failed to parse annotation %s: %w", dnsUpstreamZonesAnnotationKey, err)
+	}
+	for address, zone := range zones {
+		if len(zone) == 0 {
+			return nil, fmt.Errorf("invalid annotation %s: zone of upstream %s must not be empty", dnsUpstreamZonesAnnotationKey, address)
+		}
+		if errs := validation.IsValidLabelValue(zone); len(errs) != 0 {
+			return nil, fmt.Errorf("invalid annotation %s: invalid zone %q of upstream %s: %s", dnsUpstreamZonesAnnotationKey, zone, address, strings.Join(errs, ", "))
+		}
+		found := false
+		for _, upstream := range dns.Spec.UpstreamResolvers.Upstreams {
+			if upstream.Type == operatorv1.NetworkResolverType && upstreamAddressesEqual(upstream.Address, address) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid annotation %s: %s is not the address of an upstream resolver of type %s", dnsUpstreamZonesAnnotationKey, address, operatorv1.NetworkResolverType)
+		}
+	}
+	return zones, nil
+}
+
+// upstreamAddressesEqual returns a Boolean value indicating whether the given
+// upstream resolver addresses are the same IP address.
+func upstreamAddressesEqual(a, b string) bool {
+	if ipA, ipB := net.ParseIP(a), net.ParseIP(b); ipA != nil && ipB != nil {
+		return ipA.Equal(ipB)
+	}
+	return a == b
+}
+
+// upstreamZone returns the zone of the given upstream resolver according to
+// the given map of upstream resolver addresses to zones, or empty if the
+// upstream resolver has no zone.
+func upstreamZone(upstream operatorv1.Upstream, zones map[string]string) string {
+	if upstream.Type != operatorv1.NetworkResolverType {
+		return ""
+	}
+	for address, zone := range zones {
+		if upstreamAddressesEqual(upstream.Address, address) {
+			return zone
+		}
+	}
+	return ""
+}
+
+// dnsUpstreamZonePoolsForDNS returns a node pool for each zone of the upstream
+// resolvers of the given DNS, sorted by zone, or none if the DNS does not use
+// the Sequential policy.  The node pools' node selectors are not validated.
+func dnsUpstreamZonePoolsForDNS(dns *operatorv1.DNS) ([]dnsNodePool, error) {
+	zones, err := dnsUpstreamZonesForDNS(dns)
+	if err != nil {
+		return nil, err
+	}
+	// Other policies spread queries over all upstream resolvers
+	// regardless of their order.
+	if policy := dns.Spec.UpstreamResolvers.Policy; len(zones) == 0 || (policy != "" && policy != operatorv1.SequentialForwardingPolicy) {
+		return nil, nil
+	}
+	distinct := map[string]struct{}{}
+	for _, zone := range zones {
+		distinct[zone] = struct{}{}
+	}
+	sorted := make([]string, 0, len(distinct))
+	for zone := range distinct {
+		sorted = append(sorted, zone)
+	}
+	sort.Strings(sorted)
+	pools := make([]dnsNodePool, 0, len(sorted))
+	for _, zone := range sorted {
+		upstreamResolvers := dns.Spec.UpstreamResolvers.DeepCopy()
+		upstreamResolvers.Upstreams = preferZoneUpstreams(upstreamResolvers.Upstreams, zones, zone)
+		pools = append(pools, dnsNodePool{
+			Name:              upstreamZonePoolName(zone),
+			NodeSelector:      map[string]string{corev1.LabelTopologyZone: zone},
+			UpstreamResolvers: upstreamResolvers,
+		})
+	}
+	return pools, nil
+}
+
+// preferZoneUpstreams returns the given upstream resolvers with the ones in
+// the given zone first.  The order is otherwise unchanged.
+func preferZoneUpstreams(upstreams []operatorv1.Upstream, zones map[string]string, zone string) []operatorv1.Upstream {
+	preferred := []operatorv1.Upstream{}
+	others := []operatorv1.Upstream{}
+	for _, upstream := range upstreams {
+		if upstreamZone(upstream, zones) == zone {
+			preferred = append(preferred, upstream)
+		} else {
+			others = append(others, upstream)
+		}
+	}
+	return append(preferred, others...)
+}
+
+// upstreamZonePoolName returns the name of the node pool for the given zone.
+// Zones that do not make a valid node pool name are hashed.
+func upstreamZonePoolName(zone string) string {
+	name := upstreamZonePoolNamePrefix + zone
+	if len(validation.IsDNS1123Label(name)) == 0 {
+		return name
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(zone))
+	return fmt.Sprintf("%s%08x", upstreamZonePoolNamePrefix, hash.Sum32())
+}
@@ -0,0 +1,125 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDNSUpstreamZonePoolsForDNS(t *testing.T) {
+	upstreams := []operatorv1.Upstream{
+		{Type: operatorv1.NetworkResolverType, Address: "10.0.1.53"},
+		{Type: operatorv1.NetworkResolverType, Address: "10.0.2.53", Port: 5353},
+		{Type: operatorv1.NetworkResolverType, Address: "10.0.1.54"},
+		{Type: operatorv1.SystemResolveConfType},
+	}
+	testCases := []struct {
+		name          string
+		annotations   map[string]string
+		policy        operatorv1.ForwardingPolicy
+		expect        map[string][]string
+		expectedError string
+	}{
+		{
+			name:   "no annotation",
+			expect: map[string][]string{},
+		},
+		{
+			name:        "two zones",
+			annotations: map[string]string{dnsUpstreamZonesAnnotationKey: `{"10.0.1.53":"us-east-1a","10.0.1.54":"us-east-1a","10.0.2.53":"us-east-1b"}`},
+			expect: map[string][]string{
+				"zone-us-east-1a": {"10.0.1.53", "10.0.1.54", "10.0.2.53", ""},
+				"zone-us-east-1b": {"10.0.2.53", "10.0.1.53", "10.0.1.54", ""},
+			},
+		},
+		{
+			name:        "explicit sequential policy",
+			annotations: map[string]string{dnsUpstreamZonesAnnotationKey: `{"10.0.2.53":"us-east-1b"}`},
+			policy:      operatorv1.SequentialForwardingPolicy,
+			expect: map[string][]string{
+				"zone-us-east-1b": {"10.0.2.53", "10.0.1.53", "10.0.1.54", ""},
+			},
+		},
+		{
+			name:        "round robin policy",
+			annotations: map[string]string{dnsUpstreamZonesAnnotationKey: `{"10.0.2.53":"us-east-1b"}`},
+			policy:      operatorv1.RoundRobinForwardingPolicy,
+			expect:      map[string][]string{},
+		},
+		{
+			name:          "invalid JSON",
+			annotations:   map[string]string{dnsUpstreamZonesAnnotationKey: `{"10.0.1.53":`},
+			expectedError: "failed to parse annotation",
+		},
+		{
+			name:          "unknown upstream",
+			annotations:   map[string]string{dnsUpstreamZonesAnnotationKey: `{"10.0.3.53":"us-east-1c"}`},
+			expectedError: "10.0.3.53 is not the address of an upstream resolver of type Network",
+		},
+		{
+			name:          "invalid zone",
+			annotations:   map[string]string{dnsUpstreamZonesAnnotationKey: `{"10.0.1.53":"us east"}`},
+			expectedError: `invalid zone "us east" of upstream 10.0.1.53`,
+		},
+		{
+			name: "combined with node pools",
+			annotations: map[string]string{
+				dnsUpstreamZonesAnnotationKey: `{"10.0.1.53":"us-east-1a"}`,
+				dnsNodePoolsAnnotationKey:     `[{"name":"edge","nodeSelector":{"site":"edge"}}]`,
+			},
+			expectedError: "zone-aware upstreams cannot be combined with the node pools",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dns := &operatorv1.DNS{
+				ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController, Annotations: tc.annotations},
+				Spec: operatorv1.DNSSpec{
+					UpstreamResolvers: operatorv1.UpstreamResolvers{Upstreams: upstreams, Policy: tc.policy},
+				},
+			}
+			pools, err := dnsNodePoolsForDNS(dns)
+			if len(tc.expectedError) != 0 {
+				if err == nil || !strings.Contains(err.Error(), tc.expectedError) {
+					t.Fatalf("expected error containing %q, got %v", tc.expectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			actual := map[string][]string{}
+			for _, pool := range pools {
+				addresses := []string{}
+				for _, upstream := range pool.UpstreamResolvers.Upstreams {
+					addresses = append(addresses, upstream.Address)
+				}
+				actual[pool.Name] = addresses
+				if zone := strings.TrimPrefix(pool.Name, upstreamZonePoolNamePrefix); pool.NodeSelector["topology.kubernetes.io/zone"] != zone {
+					t.Errorf("expected node pool %s to select zone %s, got %v", pool.Name, zone, pool.NodeSelector)
+				}
+			}
+			if diff := cmp.Diff(tc.expect, actual); diff != "" {
+				t.Errorf("unexpected upstreams (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUpstreamZonePoolName(t *testing.T) {
+	if name := upstreamZonePoolName("us-east-1a"); name != "zone-us-east-1a" {
+		t.Errorf("expected zone-us-east-1a, got %s", name)
+	}
+	a, b := upstreamZonePoolName("Zone_A"), upstreamZonePoolName("Zone_B")
+	if !strings.HasPrefix(a, upstreamZonePoolNamePrefix) || a == b {
+		t.Errorf("expected distinct hashed names, got %s and %s", a, b)
+	}
+	if a != upstreamZonePoolName("Zone_A") {
+		t.Errorf("expected a stable name for zone Zone_A")
+	}
+}
@@ -0,0 +1,259 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// dnsViewsAnnotationKey is the annotation on a DNS that configures
+// split-horizon views.  The value is a JSON list of views.  Each view is
+// rendered as its own server block that uses CoreDNS's view plugin to select
+// the clients that the block serves.  View server blocks precede the server
+// blocks for spec.servers so that a server block without a view acts as the
+// fallback for clients that no view selects.
+const dnsViewsAnnotationKey = "dns.operator.openshift.io/views"
+
+// dnsView describes a split-horizon view.
+type dnsView struct {
+	// name identifies the view.  It must be a valid DNS-1123 label and
+	// unique among views.
+	Name string `json:"name"`
+	// zones is the list of zones that the view serves.
+	Zones []string `json:"zones"`
+	// clientCIDRs is the list of client subnets that the view selects.
+	// Exactly one of clientCIDRs and expression must be specified.
+	ClientCIDRs []string `json:"clientCIDRs,omitempty"`
+	// expression is a CoreDNS view plugin expression that selects
+	// clients, for example "name() == 'app.example.com.'".  Exactly one
+	// of clientCIDRs and expression must be specified.
+	Expression string `json:"expression,omitempty"`
+	// upstreams is the list of resolvers, in the form "address" or
+	// "address:port", to which the view forwards queries that do not
+	// match a record.
+	Upstreams []string `json:"upstreams,omitempty"`
+	// records is the list of static records that the view serves.
+	Records []dnsViewRecord `json:"records,omitempty"`
+}
+
+// dnsViewRecord describes a static record in a split-horizon view.
+type dnsViewRecord struct {
+	// name is the fully qualified name of the record.  It must be in one
+	// of the view's zones.
+	Name string `json:"name"`
+	// addresses is the list of IPv4 or IPv6 addresses for the record.
+	Addresses []string `json:"addresses"`
+}
+
+// corefileView is the representation of a dnsView that corefileTemplate
+// renders.
+type corefileView struct {
+	Name       string
+	Zones      []string
+	Expression string
+	Hosts      []string
+	Upstreams  []string
+}
+
+// dnsViews parses and validates the views that are configured on the given
+// DNS.  Views are returned in the order in which they are specified.
+func dnsViews(dns *operatorv1.DNS) ([]dnsView, error) {
+	value, ok := dns.Annotations[dnsViewsAnnotationKey]
+	if !ok || len(strings.TrimSpace(value)) == 0 {
+		return nil, nil
+	}
+	var views []dnsView
+	if err := json.Unmarshal([]byte(value), &views); err != nil {
+		return nil, fmt.Errorf("failed to parse annotation %s: %w", dnsViewsAnnotationKey, err)
+	}
+	if err := validateDNSViews(views); err != nil {
+		return nil, fmt.Errorf("invalid annotation %s: %w", dnsViewsAnnotationKey, err)
+	}
+	return views, nil
+}
+
+// validateDNSViews verifies that each view is well formed and that no two
+// views serve the same zone for overlapping sets of clients.  CoreDNS uses the
+// first view in the Corefile that matches a query, so overlapping views would
+// make the result depend on the order of the views.  The clients that an
+// expression selects cannot be determined statically, so a view that uses an
+// expression may not share a zone with any other view.
+func validateDNSViews(views []dnsView) error {
+	names := sets.NewString()
+	viewsByZone := map[string][]int{}
+	for i, view := range views {
+		if errs := validation.IsDNS1123Label(view.Name); len(errs) != 0 {
+			return fmt.Errorf("view %q has an invalid name: %s", view.Name, strings.Join(errs, ", "))
+		}
+		if names.Has(view.Name) {
+			return fmt.Errorf("view %q is specified more than once", view.Name)
+		}
+		names.Insert(view.Name)
+
+		if len(view.Zones) == 0 {
+			return fmt.Errorf("view %q must specify at least one zone", view.Name)
+		}
+		for _, zone := range view.Zones {
+			if !isValidZone(zone) {
+				return fmt.Errorf("view %q has an invalid zone %q", view.Name, zone)
+			}
+			normalized := normalizeZone(zone)
+			viewsByZone[normalized] = append(viewsByZone[normalized], i)
+		}
+
+		switch {
+		case len(view.ClientCIDRs) == 0 && len(view.Expression) == 0:
+			return fmt.Errorf("view %q must specify either clientCIDRs or expression", view.Name)
+		case len(view.ClientCIDRs) != 0 && len(view.Expression) != 0:
+			return fmt.Errorf("view %q must not specify both clientCIDRs and expression", view.Name)
+		}
+		for _, cidr := range view.ClientCIDRs {
+			if _, _, err := net.ParseCIDR(cidr); err != nil {
+				return fmt.Errorf("view %q has an invalid client CIDR %q: %w", view.Name, cidr, err)
+			}
+		}
+		if strings.ContainsAny(view.Expression, "\n\r{}#") {
+			return fmt.Errorf("view %q has an expression with a newline, brace, or comment character", view.Name)
+		}
+
+		if len(view.Upstreams) == 0 && len(view.Records) == 0 {
+			return fmt.Errorf("view %q must specify upstreams, records, or both", view.Name)
+		}
+		for _, upstream := range view.Upstreams {
+			if !isValidUpstreamAddress(upstream) {
+				return fmt.Errorf("view %q has an invalid upstream %q", view.Name, upstream)
+			}
+		}
+		for _, record := range view.Records {
+			if errs := validation.IsDNS1123Subdomain(normalizeZone(record.Name)); len(errs) != 0 {
+				return fmt.Errorf("view %q has a record with an invalid name %q: %s", view.Name, record.Name, strings.Join(errs, ", "))
+			}
+			if !nameInZones(record.Name, view.Zones) {
+				return fmt.Errorf("view %q has a record %q that is not in any of the view's zones", view.Name, record.Name)
+			}
+			if len(record.Addresses) == 0 {
+				return fmt.Errorf("view %q has a record %q without addresses", view.Name, record.Name)
+			}
+			for _, address := range record.Addresses {
+				if net.ParseIP(address) == nil {
+					return fmt.Errorf("view %q has a record %q with an invalid address %q", view.Name, record.Name, address)
+				}
+			}
+		}
+	}
+
+	zones := make([]string, 0, len(viewsByZone))
+	for zone := range viewsByZone {
+		zones = append(zones, zone)
+	}
+	sort.Strings(zones)
+	for _, zone := range zones {
+		indices := viewsByZone[zone]
+		for i := range indices {
+			for _, j := range indices[i+1:] {
+				a, b := views[indices[i]], views[j]
+				if len(a.Expression) != 0 || len(b.Expression) != 0 {
+					return fmt.Errorf("views %q and %q both serve zone %q and at least one of them uses an expression, so their order is ambiguous", a.Name, b.Name, zone)
+				}
+				if cidrA, cidrB, overlap := overlappingCIDRs(a.ClientCIDRs, b.ClientCIDRs); overlap {
+					return fmt.Errorf("views %q and %q both serve zone %q and their client CIDRs %s and %s overlap, so their order is ambiguous", a.Name, b.Name, zone, cidrA, cidrB)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// corefileViews converts the given views into their Corefile representation.
+// The views must already have been validated.
+func corefileViews(views []dnsView) []corefileView {
+	result := make([]corefileView, 0, len(views))
+	for _, view := range views {
+		cv := corefileView{
+			Name:       view.Name,
+			Zones:      view.Zones,
+			Expression: view.Expression,
+			Upstreams:  view.Upstreams,
+		}
+		if len(view.ClientCIDRs) != 0 {
+			terms := make([]string, 0, len(view.ClientCIDRs))
+			for _, cidr := range view.ClientCIDRs {
+				terms = append(terms, fmt.Sprintf("incidr(client_ip(), '%s')", cidr))
+			}
+			cv.Expression = strings.Join(terms, " || ")
+		}
+		for _, record := range view.Records {
+			for _, address := range record.Addresses {
+				cv.Hosts = append(cv.Hosts, fmt.Sprintf("%s %s", address, normalizeZone(record.Name)))
+			}
+		}
+		result = append(result, cv)
+	}
+	return result
+}
+
+// isValidZone returns a Boolean value indicating whether the given zone is
+// either the root zone or a valid DNS subdomain.
+func isValidZone(zone string) bool {
+	normalized := normalizeZone(zone)
+	if normalized == "." {
+		return true
+	}
+	return len(validation.IsDNS1123Subdomain(normalized)) == 0
+}
+
+// normalizeZone returns the given zone in lowercase and without a trailing
+// dot, except for the root zone, which is returned as ".".
+func normalizeZone(zone string) string {
+	zone = strings.ToLower(strings.TrimSpace(zone))
+	if zone == "." {
+		return zone
+	}
+	return strings.TrimSuffix(zone, ".")
+}
+
+// nameInZones returns a Boolean value indicating whether the given name is
+// equal to or a subdomain of any of the given zones.
+func nameInZones(name string, zones []string) bool {
+	name = normalizeZone(name)
+	for _, zone := range zones {
+		zone = normalizeZone(zone)
+		if zone == "." || name == zone || strings.HasSuffix(name, "."+zone) {
+			return true
+		}
+	}
+	return false
+}
+
+// isValidUpstreamAddress returns a Boolean value indicating whether the given
+// upstream is an IP address, optionally followed by a port.
+func isValidUpstreamAddress(upstream string) bool {
+	host := upstream
+	if h, _, err := net.SplitHostPort(upstream); err == nil {
+		host = h
+	}
+	return net.ParseIP(host) != nil
+}
+
+// overlappingCIDRs returns the first pair of CIDRs from the given lists that
+// overlap, if any.  The CIDRs must already have been validated.
+func overlappingCIDRs(a, b []string) (string, string, bool) {
+	for _, x := range a {
+		_, netX, _ := net.ParseCIDR(x)
+		for _, y := range b {
+			_, netY, _ := net.ParseCIDR(y)
+			if netX.Contains(netY.IP) || netY.Contains(netX.IP) {
+				return x, y, true
+			}
+		}
+	}
+	return "", "", false
+}
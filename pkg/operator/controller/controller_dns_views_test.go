@@ -0,0 +1,186 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDNSViews(t *testing.T) {
+	testCases := []struct {
+		name          string
+		annotation    string
+		expectedViews []dnsView
+		expectError   bool
+	}{
+		{
+			name: "no annotation",
+		},
+		{
+			name:       "disjoint client CIDRs for the same zone",
+			annotation: `[{"name":"dmz","zones":["example.com"],"clientCIDRs":["10.0.0.0/24"],"upstreams":["1.1.1.1"]},{"name":"internal","zones":["example.com"],"clientCIDRs":["10.0.1.0/24","fd00::/64"],"upstreams":["2.2.2.2:5353"]}]`,
+			expectedViews: []dnsView{
+				{
+					Name:        "dmz",
+					Zones:       []string{"example.com"},
+					ClientCIDRs: []string{"10.0.0.0/24"},
+					Upstreams:   []string{"1.1.1.1"},
+				},
+				{
+					Name:        "internal",
+					Zones:       []string{"example.com"},
+					ClientCIDRs: []string{"10.0.1.0/24", "fd00::/64"},
+					Upstreams:   []string{"2.2.2.2:5353"},
+				},
+			},
+		},
+		{
+			name:       "expression views for different zones",
+			annotation: `[{"name":"a","zones":["a.example.com"],"expression":"name() == 'x.a.example.com.'","upstreams":["1.1.1.1"]},{"name":"b","zones":["b.example.com"],"expression":"client_ip() == '10.0.0.1'","records":[{"name":"x.b.example.com","addresses":["10.1.1.1"]}]}]`,
+			expectedViews: []dnsView{
+				{
+					Name:       "a",
+					Zones:      []string{"a.example.com"},
+					Expression: "name() == 'x.a.example.com.'",
+					Upstreams:  []string{"1.1.1.1"},
+				},
+				{
+					Name:       "b",
+					Zones:      []string{"b.example.com"},
+					Expression: "client_ip() == '10.0.0.1'",
+					Records: []dnsViewRecord{
+						{Name: "x.b.example.com", Addresses: []string{"10.1.1.1"}},
+					},
+				},
+			},
+		},
+		{
+			name:        "invalid JSON",
+			annotation:  `[{"name":`,
+			expectError: true,
+		},
+		{
+			name:        "invalid name",
+			annotation:  `[{"name":"DMZ view","zones":["example.com"],"clientCIDRs":["10.0.0.0/24"],"upstreams":["1.1.1.1"]}]`,
+			expectError: true,
+		},
+		{
+			name:        "duplicate name",
+			annotation:  `[{"name":"dmz","zones":["a.com"],"clientCIDRs":["10.0.0.0/24"],"upstreams":["1.1.1.1"]},{"name":"dmz","zones":["b.com"],"clientCIDRs":["10.0.0.0/24"],"upstreams":["1.1.1.1"]}]`,
+			expectError: true,
+		},
+		{
+			name:        "no zones",
+			annotation:  `[{"name":"dmz","clientCIDRs":["10.0.0.0/24"],"upstreams":["1.1.1.1"]}]`,
+			expectError: true,
+		},
+		{
+			name:        "neither client CIDRs nor expression",
+			annotation:  `[{"name":"dmz","zones":["example.com"],"upstreams":["1.1.1.1"]}]`,
+			expectError: true,
+		},
+		{
+			name:        "both client CIDRs and expression",
+			annotation:  `[{"name":"dmz","zones":["example.com"],"clientCIDRs":["10.0.0.0/24"],"expression":"true","upstreams":["1.1.1.1"]}]`,
+			expectError: true,
+		},
+		{
+			name:        "expression with a brace",
+			annotation:  `[{"name":"dmz","zones":["example.com"],"expression":"true }","upstreams":["1.1.1.1"]}]`,
+			expectError: true,
+		},
+		{
+			name:        "invalid client CIDR",
+			annotation:  `[{"name":"dmz","zones":["example.com"],"clientCIDRs":["10.0.0.0/33"],"upstreams":["1.1.1.1"]}]`,
+			expectError: true,
+		},
+		{
+			name:        "neither upstreams nor records",
+			annotation:  `[{"name":"dmz","zones":["example.com"],"clientCIDRs":["10.0.0.0/24"]}]`,
+			expectError: true,
+		},
+		{
+			name:        "upstream is not an IP address",
+			annotation:  `[{"name":"dmz","zones":["example.com"],"clientCIDRs":["10.0.0.0/24"],"upstreams":["dns.example.com"]}]`,
+			expectError: true,
+		},
+		{
+			name:        "record outside of the view's zones",
+			annotation:  `[{"name":"dmz","zones":["example.com"],"clientCIDRs":["10.0.0.0/24"],"records":[{"name":"www.example.org","addresses":["10.1.1.1"]}]}]`,
+			expectError: true,
+		},
+		{
+			name:        "record with an invalid address",
+			annotation:  `[{"name":"dmz","zones":["example.com"],"clientCIDRs":["10.0.0.0/24"],"records":[{"name":"www.example.com","addresses":["10.1.1"]}]}]`,
+			expectError: true,
+		},
+		{
+			name:        "overlapping client CIDRs for the same zone",
+			annotation:  `[{"name":"dmz","zones":["example.com"],"clientCIDRs":["10.0.0.0/16"],"upstreams":["1.1.1.1"]},{"name":"internal","zones":["Example.com."],"clientCIDRs":["10.0.1.0/24"],"upstreams":["2.2.2.2"]}]`,
+			expectError: true,
+		},
+		{
+			name:        "expression view sharing a zone with another view",
+			annotation:  `[{"name":"dmz","zones":["example.com"],"clientCIDRs":["10.0.0.0/16"],"upstreams":["1.1.1.1"]},{"name":"internal","zones":["example.com"],"expression":"client_ip() == '192.168.0.1'","upstreams":["2.2.2.2"]}]`,
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dns := &operatorv1.DNS{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: DefaultDNSController,
+				},
+			}
+			if len(tc.annotation) != 0 {
+				dns.Annotations = map[string]string{
+					dnsViewsAnnotationKey: tc.annotation,
+				}
+			}
+			views, err := dnsViews(dns)
+			switch {
+			case tc.expectError && err == nil:
+				t.Fatalf("expected an error, got views %+v", views)
+			case !tc.expectError && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.expectedViews, views); diff != "" {
+				t.Errorf("unexpected views (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDesiredDNSConfigmapViews(t *testing.T) {
+	dns := &operatorv1.DNS{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: DefaultDNSController,
+			Annotations: map[string]string{
+				dnsViewsAnnotationKey: `[{"name":"dmz","zones":["example.com"],"clientCIDRs":["10.0.0.0/24","10.0.2.0/24"],"upstreams":["1.1.1.1"],"records":[{"name":"www.example.com","addresses":["10.0.0.10","fd00::10"]}]},{"name":"lab","zones":["lab.example.com"],"expression":"client_ip() == '10.0.1.5'","records":[{"name":"www.lab.example.com","addresses":["10.0.1.10"]}]}]`,
+			},
+		},
+		Spec: operatorv1.DNSSpec{
+			Servers: []operatorv1.Server{
+				{
+					Name:  "example",
+					Zones: []string{"example.com"},
+					ForwardPlugin: operatorv1.ForwardPlugin{
+						Upstreams: []string{"3.3.3.3"},
+						Policy:    operatorv1.RandomForwardingPolicy,
+					},
+				},
+			},
+		},
+	}
+	cm, err := desiredDNSConfigMap(dns, "cluster.local", nil, false, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if diff := cmp.Diff(cm.Data["Corefile"], mustLoadTestFile(t, "views")); diff != "" {
+		t.Errorf("unexpected Corefile;\n%s", diff)
+	}
+}
@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+)
+
+const (
+	// InvalidConfigurationConditionType is the type of the DNS status
+	// condition that indicates whether the DNS has a configuration that the
+	// operator refuses to render.  While the condition is true, the
+	// operator keeps the last good Corefile.  It is only reported for a
+	// DNS that has servers, views, or forward except-lists.
+	InvalidConfigurationConditionType = "InvalidConfiguration"
+)
+
+// reverseZones are the reverse-lookup zones that the kubernetes plugin serves
+// in the default server block.
+var reverseZones = []string{"in-addr.arpa", "ip6.arpa"}
+
+// validateDNSZones returns an error describing every conflict among the zones
+// of the given DNS's servers and views, the domains in its forward
+// except-lists, the given cluster domain, the DNS's previous cluster domains,
+// and the reverse zones.
+//
+// CoreDNS rejects a Corefile in which two server blocks claim the same zone.
+// CoreDNS routes a query to the server block with the most specific matching
+// zone, and the kubernetes plugin is in the default "." server block, so a
+// server that claims the cluster domain, a subdomain of it, or a parent of it
+// would take queries for cluster names away from the kubernetes plugin.  The
+// same is true of a server that claims a reverse zone or a parent of one.
+// Servers may claim subdomains of the reverse zones, such as
+// "168.192.in-addr.arpa", in order to forward reverse lookups for addresses
+// outside of the cluster.
+//
+// The same rules apply to the zones of views.  In addition, a view must not
+// serve a parent of a server's zone: CoreDNS routes queries for the server's
+// zone to the server's block, so the server would answer them even for the
+// clients that the view selects.  A view may serve a server's zone or a
+// subdomain of it, in which case the server answers the clients that the view
+// does not select.
+// Finally, a domain in the except-list for spec.upstreamResolvers must not be
+// in a cluster domain because the kubernetes plugin answers queries for
+// cluster names and never passes them to the forward plugin.
+//
+// Views and except-lists that fail to parse are ignored here; dnsViews and
+// dnsForwardExcept report those errors when the Corefile is rendered.
+func validateDNSZones(dns *operatorv1.DNS, clusterDomain string) error {
+	if len(clusterDomain) == 0 {
+		clusterDomain = defaultClusterDomain
+	}
+	clusterDomains := append([]string{clusterDomain}, desiredPreviousClusterDomains(dns, clusterDomain)...)
+
+	var conflicts []string
+	owners := map[string]string{}
+	for _, server := range dns.Spec.Servers {
+		for _, zone := range server.Zones {
+			normalized := normalizeZone(zone)
+			if owner, ok := owners[normalized]; ok {
+				if owner == server.Name {
+					conflicts = append(conflicts, fmt.Sprintf("server %q lists zone %q more than once", server.Name, normalized))
+				} else {
+					conflicts = append(conflicts, fmt.Sprintf("servers %q and %q both claim zone %q", owner, server.Name, normalized))
+				}
+				continue
+			}
+			owners[normalized] = server.Name
+			for _, domain := range clusterDomains {
+				if zonesOverlap(normalized, domain) {
+					conflicts = append(conflicts, fmt.Sprintf("server %q claims zone %q, which overlaps the cluster domain %q", server.Name, normalized, normalizeZone(domain)))
+				}
+			}
+			for _, reverse := range reverseZones {
+				if nameInZones(reverse, []string{normalized}) {
+					conflicts = append(conflicts, fmt.Sprintf("server %q claims zone %q, which contains the reverse zone %q", server.Name, normalized, reverse))
+				}
+			}
+		}
+	}
+
+	if views, err := dnsViews(dns); err == nil {
+		for _, view := range views {
+			for _, zone := range view.Zones {
+				normalized := normalizeZone(zone)
+				for _, domain := range clusterDomains {
+					if zonesOverlap(normalized, domain) {
+						conflicts = append(conflicts, fmt.Sprintf("view %q claims zone %q, which overlaps the cluster domain %q", view.Name, normalized, normalizeZone(domain)))
+					}
+				}
+				for _, reverse := range reverseZones {
+					if nameInZones(reverse, []string{normalized}) {
+						conflicts = append(conflicts, fmt.Sprintf("view %q claims zone %q, which contains the reverse zone %q", view.Name, normalized, reverse))
+					}
+				}
+				for _, server := range dns.Spec.Servers {
+					for _, serverZone := range server.Zones {
+						serverZone = normalizeZone(serverZone)
+						if serverZone != normalized && nameInZones(serverZone, []string{normalized}) {
+							conflicts = append(conflicts, fmt.Sprintf("view %q claims zone %q, which contains zone %q of server %q", view.Name, normalized, serverZone, server.Name))
+						}
+					}
+				}
+			}
+		}
+	}
+
+	if except, err := dnsForwardExcept(dns); err == nil {
+		for _, domain := range except.UpstreamResolvers {
+			for _, clusterDomain := range clusterDomains {
+				if nameInZones(domain, []string{clusterDomain}) {
+					conflicts = append(conflicts, fmt.Sprintf("the except-list for the upstream resolvers lists domain %q, which is in the cluster domain %q", domain, normalizeZone(clusterDomain)))
+				}
+			}
+		}
+	}
+
+	if len(conflicts) == 0 {
+		return nil
+	}
+	sort.Strings(conflicts)
+	return fmt.Errorf("zone conflicts: %s", strings.Join(conflicts, "; "))
+}
+
+// zonesOverlap returns a Boolean value indicating whether either of the given
+// zones is equal to or a subdomain of the other.
+func zonesOverlap(a, b string) bool {
+	return nameInZones(a, []string{b}) || nameInZones(b, []string{a})
+}
+
+// computeDNSInvalidConfigurationCondition computes the InvalidConfiguration
+// status condition for the given DNS and cluster domain.
+func computeDNSInvalidConfigurationCondition(oldCondition *operatorv1.OperatorCondition, dns *operatorv1.DNS, clusterDomain string) operatorv1.OperatorCondition {
+	condition := &operatorv1.OperatorCondition{
+		Type: InvalidConfigurationConditionType,
+	}
+	if err := validateDNSZones(dns, clusterDomain); err != nil {
+		condition.Status = operatorv1.ConditionTrue
+		condition.Reason = "ZoneConflict"
+		condition.Message = fmt.Sprintf("The DNS configuration has %v.  The operator keeps the last valid Corefile until the conflicts are resolved.", err)
+	} else {
+		condition.Status = operatorv1.ConditionFalse
+		condition.Reason = "AsExpected"
+		condition.Message = "The DNS configuration is valid."
+	}
+	return setDNSLastTransitionTime(condition, oldCondition)
+}
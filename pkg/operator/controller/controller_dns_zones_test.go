@@ -0,0 +1,166 @@
+package controller
+
+import (
+	"strings"
+	"testing"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestValidateDNSZones(t *testing.T) {
+	server := func(name string, zones ...string) operatorv1.Server {
+		return operatorv1.Server{
+			Name:          name,
+			Zones:         zones,
+			ForwardPlugin: operatorv1.ForwardPlugin{Upstreams: []string{"1.1.1.1"}},
+		}
+	}
+	testCases := []struct {
+		name             string
+		servers          []operatorv1.Server
+		previousDomains  string
+		views            string
+		forwardExcept    string
+		expectedConflict string
+	}{
+		{
+			name: "no conflicts",
+			servers: []operatorv1.Server{
+				server("corp", "corp.example.com"),
+				server("eng", "eng.corp.example.com"),
+				server("reverse", "168.192.in-addr.arpa"),
+			},
+		},
+		{
+			name: "duplicate zone in two servers",
+			servers: []operatorv1.Server{
+				server("corp", "corp.example.com"),
+				server("other", "Corp.Example.com."),
+			},
+			expectedConflict: `servers "corp" and "other" both claim zone "corp.example.com"`,
+		},
+		{
+			name:             "duplicate zone in one server",
+			servers:          []operatorv1.Server{server("corp", "corp.example.com", "corp.example.com")},
+			expectedConflict: `server "corp" lists zone "corp.example.com" more than once`,
+		},
+		{
+			name:             "cluster domain",
+			servers:          []operatorv1.Server{server("cluster", "cluster.local")},
+			expectedConflict: `overlaps the cluster domain "cluster.local"`,
+		},
+		{
+			name:             "subdomain of the cluster domain",
+			servers:          []operatorv1.Server{server("svc", "svc.cluster.local")},
+			expectedConflict: `overlaps the cluster domain "cluster.local"`,
+		},
+		{
+			name:             "parent of the cluster domain",
+			servers:          []operatorv1.Server{server("local", "local")},
+			expectedConflict: `overlaps the cluster domain "cluster.local"`,
+		},
+		{
+			name:             "previous cluster domain",
+			servers:          []operatorv1.Server{server("old", "old.example.com")},
+			previousDomains:  "old.example.com",
+			expectedConflict: `overlaps the cluster domain "old.example.com"`,
+		},
+		{
+			name:             "reverse zone",
+			servers:          []operatorv1.Server{server("reverse", "in-addr.arpa")},
+			expectedConflict: `contains the reverse zone "in-addr.arpa"`,
+		},
+		{
+			name:             "parent of the reverse zones",
+			servers:          []operatorv1.Server{server("arpa", "arpa")},
+			expectedConflict: `contains the reverse zone "ip6.arpa"`,
+		},
+		{
+			name:    "view serving the zone of a server",
+			servers: []operatorv1.Server{server("corp", "corp.example.com")},
+			views:   `[{"name":"internal","zones":["corp.example.com"],"clientCIDRs":["10.0.0.0/8"],"upstreams":["10.0.0.53"]}]`,
+		},
+		{
+			name:             "view claiming the cluster domain",
+			views:            `[{"name":"internal","zones":["cluster.local"],"clientCIDRs":["10.0.0.0/8"],"upstreams":["10.0.0.53"]}]`,
+			expectedConflict: `view "internal" claims zone "cluster.local", which overlaps the cluster domain "cluster.local"`,
+		},
+		{
+			name:             "view claiming a reverse zone",
+			views:            `[{"name":"internal","zones":["ip6.arpa"],"clientCIDRs":["10.0.0.0/8"],"upstreams":["10.0.0.53"]}]`,
+			expectedConflict: `view "internal" claims zone "ip6.arpa", which contains the reverse zone "ip6.arpa"`,
+		},
+		{
+			name:             "view claiming a parent of a server's zone",
+			servers:          []operatorv1.Server{server("corp", "corp.example.com")},
+			views:            `[{"name":"internal","zones":["example.com"],"clientCIDRs":["10.0.0.0/8"],"upstreams":["10.0.0.53"]}]`,
+			expectedConflict: `view "internal" claims zone "example.com", which contains zone "corp.example.com" of server "corp"`,
+		},
+		{
+			name:    "view serving a subdomain of a server's zone",
+			servers: []operatorv1.Server{server("corp", "example.com")},
+			views:   `[{"name":"internal","zones":["corp.example.com"],"clientCIDRs":["10.0.0.0/8"],"upstreams":["10.0.0.53"]}]`,
+		},
+		{
+			name:          "upstream resolvers except-list outside of the cluster domain",
+			forwardExcept: `{"upstreamResolvers":["local"]}`,
+		},
+		{
+			name:             "upstream resolvers except-list in the cluster domain",
+			forwardExcept:    `{"upstreamResolvers":["svc.cluster.local"]}`,
+			expectedConflict: `the except-list for the upstream resolvers lists domain "svc.cluster.local", which is in the cluster domain "cluster.local"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dns := &operatorv1.DNS{
+				ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController},
+				Spec:       operatorv1.DNSSpec{Servers: tc.servers},
+			}
+			dns.Annotations = map[string]string{}
+			if len(tc.previousDomains) != 0 {
+				dns.Annotations[previousClusterDomainsAnnotationKey] = tc.previousDomains
+			}
+			if len(tc.views) != 0 {
+				dns.Annotations[dnsViewsAnnotationKey] = tc.views
+			}
+			if len(tc.forwardExcept) != 0 {
+				dns.Annotations[forwardExceptAnnotationKey] = tc.forwardExcept
+			}
+			err := validateDNSZones(dns, "cluster.local")
+			switch {
+			case len(tc.expectedConflict) == 0 && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			case len(tc.expectedConflict) != 0 && err == nil:
+				t.Fatalf("expected an error containing %q", tc.expectedConflict)
+			case err != nil && !strings.Contains(err.Error(), tc.expectedConflict):
+				t.Errorf("expected an error containing %q, got %v", tc.expectedConflict, err)
+			}
+
+			condition := computeDNSInvalidConfigurationCondition(nil, dns, "cluster.local")
+			if expected := len(tc.expectedConflict) != 0; (condition.Status == operatorv1.ConditionTrue) != expected {
+				t.Errorf("expected InvalidConfiguration to be %t, got %+v", expected, condition)
+			}
+		})
+	}
+}
+
+// TestDesiredDNSConfigMapRejectsZoneConflicts verifies that the operator does
+// not render a Corefile with conflicting zones.
+func TestDesiredDNSConfigMapRejectsZoneConflicts(t *testing.T) {
+	dns := &operatorv1.DNS{
+		ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController},
+		Spec: operatorv1.DNSSpec{
+			Servers: []operatorv1.Server{
+				{Name: "a", Zones: []string{"example.com"}, ForwardPlugin: operatorv1.ForwardPlugin{Upstreams: []string{"1.1.1.1"}}},
+				{Name: "b", Zones: []string{"example.com"}, ForwardPlugin: operatorv1.ForwardPlugin{Upstreams: []string{"2.2.2.2"}}},
+			},
+		},
+	}
+	if cm, err := desiredDNSConfigMap(dns, "cluster.local", map[string]string{}, false, nil); err == nil {
+		t.Errorf("expected an error, got Corefile %q", cm.Data["Corefile"])
+	}
+}
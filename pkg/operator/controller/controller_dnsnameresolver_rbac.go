@@ -0,0 +1,242 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	operatorv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/cluster-dns-operator/pkg/manifests"
+
+	"github.com/sirupsen/logrus"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// dnsNameResolverNamespacesAnnotationKey is the annotation on a DNS
+	// that specifies a comma-separated list of namespaces in which CoreDNS
+	// watches and updates DNSNameResolver resources.  If the annotation is
+	// absent or empty, the namespaces from the operator's configuration
+	// are used.  When the annotation on the default DNS changes, the
+	// operator starts and stops its DNSNameResolver controllers so that
+	// they watch the new namespaces.
+	dnsNameResolverNamespacesAnnotationKey = "dns.operator.openshift.io/dnsnameresolver-namespaces"
+
+	// dnsNameResolverRBACLabel identifies a role or role binding that the
+	// operator manages in order to grant CoreDNS access to DNSNameResolver
+	// resources.
+	dnsNameResolverRBACLabel = "dns.operator.openshift.io/dnsnameresolver-rbac"
+)
+
+// DNSNameResolverNamespaces returns the namespaces from the given DNS's
+// dnsNameResolverNamespacesAnnotationKey annotation, or the given default
+// namespaces if the annotation is absent or empty.  Namespaces are returned
+// sorted and without duplicates.
+func DNSNameResolverNamespaces(dns *operatorv1.DNS, defaultNamespaces []string) ([]string, error) {
+	namespaces := sets.NewString()
+	value := strings.TrimSpace(dns.Annotations[dnsNameResolverNamespacesAnnotationKey])
+	if len(value) == 0 {
+		namespaces.Insert(defaultNamespaces...)
+		return namespaces.List(), nil
+	}
+	for _, ns := range strings.Split(value, ",") {
+		ns = strings.TrimSpace(ns)
+		if len(ns) == 0 {
+			continue
+		}
+		if errs := validation.IsDNS1123Label(ns); len(errs) != 0 {
+			return nil, fmt.Errorf("invalid namespace %q in annotation %s: %s", ns, dnsNameResolverNamespacesAnnotationKey, strings.Join(errs, ", "))
+		}
+		namespaces.Insert(ns)
+	}
+	return namespaces.List(), nil
+}
+
+// ensureDNSNameResolverRBAC ensures that CoreDNS has access to DNSNameResolver
+// resources in exactly the given namespaces by creating or updating a role and
+// role binding in each namespace and deleting the roles and role bindings that
+// the operator previously created in other namespaces.
+func (r *reconciler) ensureDNSNameResolverRBAC(dns *operatorv1.DNS, namespaces []string) error {
+	var errs []error
+	desiredNamespaces := sets.NewString()
+	if r.dnsNameResolverEnabled {
+		desiredNamespaces.Insert(namespaces...)
+	}
+	for _, ns := range desiredNamespaces.List() {
+		if err := r.ensureDNSNameResolverRole(desiredDNSNameResolverRole(dns, ns)); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := r.ensureDNSNameResolverRoleBinding(desiredDNSNameResolverRoleBinding(dns, ns)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	selector := client.MatchingLabels{
+		dnsNameResolverRBACLabel: "",
+		manifests.OwningDNSLabel: DNSDaemonSetLabel(dns),
+	}
+	roleBindings := &rbacv1.RoleBindingList{}
+	if err := r.client.List(context.TODO(), roleBindings, selector); err != nil {
+		errs = append(errs, fmt.Errorf("failed to list dnsnameresolver role bindings: %w", err))
+	} else {
+		for i := range roleBindings.Items {
+			rb := &roleBindings.Items[i]
+			if desiredNamespaces.Has(rb.Namespace) {
+				continue
+			}
+			if err := r.client.Delete(context.TODO(), rb); err != nil && !errors.IsNotFound(err) {
+				errs = append(errs, fmt.Errorf("failed to delete dnsnameresolver role binding %s/%s: %w", rb.Namespace, rb.Name, err))
+				continue
+			}
+			logrus.Infof("deleted dnsnameresolver role binding: %s/%s", rb.Namespace, rb.Name)
+		}
+	}
+	roles := &rbacv1.RoleList{}
+	if err := r.client.List(context.TODO(), roles, selector); err != nil {
+		errs = append(errs, fmt.Errorf("failed to list dnsnameresolver roles: %w", err))
+	} else {
+		for i := range roles.Items {
+			role := &roles.Items[i]
+			if desiredNamespaces.Has(role.Namespace) {
+				continue
+			}
+			if err := r.client.Delete(context.TODO(), role); err != nil && !errors.IsNotFound(err) {
+				errs = append(errs, fmt.Errorf("failed to delete dnsnameresolver role %s/%s: %w", role.Namespace, role.Name, err))
+				continue
+			}
+			logrus.Infof("deleted dnsnameresolver role: %s/%s", role.Namespace, role.Name)
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+func (r *reconciler) ensureDNSNameResolverRole(desired *rbacv1.Role) error {
+	current := &rbacv1.Role{}
+	if err := r.client.Get(context.TODO(), client.ObjectKeyFromObject(desired), current); err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to get dnsnameresolver role %s/%s: %w", desired.Namespace, desired.Name, err)
+		}
+		if err := r.client.Create(context.TODO(), desired); err != nil {
+			return fmt.Errorf("failed to create dnsnameresolver role %s/%s: %w", desired.Namespace, desired.Name, err)
+		}
+		logrus.Infof("created dnsnameresolver role: %s/%s", desired.Namespace, desired.Name)
+		return nil
+	}
+	if cmp.Equal(current.Rules, desired.Rules, cmpopts.EquateEmpty()) {
+		return nil
+	}
+	updated := current.DeepCopy()
+	updated.Rules = desired.Rules
+	// Diff before updating because the client may mutate the object.
+	diff := cmp.Diff(current, updated, cmpopts.EquateEmpty())
+	if err := r.client.Update(context.TODO(), updated); err != nil {
+		return fmt.Errorf("failed to update dnsnameresolver role %s/%s: %w", updated.Namespace, updated.Name, err)
+	}
+	logrus.Infof("updated dnsnameresolver role %s/%s: %v", updated.Namespace, updated.Name, diff)
+	return nil
+}
+
+func (r *reconciler) ensureDNSNameResolverRoleBinding(desired *rbacv1.RoleBinding) error {
+	current := &rbacv1.RoleBinding{}
+	if err := r.client.Get(context.TODO(), client.ObjectKeyFromObject(desired), current); err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to get dnsnameresolver role binding %s/%s: %w", desired.Namespace, desired.Name, err)
+		}
+		if err := r.client.Create(context.TODO(), desired); err != nil {
+			return fmt.Errorf("failed to create dnsnameresolver role binding %s/%s: %w", desired.Namespace, desired.Name, err)
+		}
+		logrus.Infof("created dnsnameresolver role binding: %s/%s", desired.Namespace, desired.Name)
+		return nil
+	}
+	// The role reference of a role binding is immutable, and the operator
+	// always uses the same role, so only the subjects need to be checked.
+	if cmp.Equal(current.Subjects, desired.Subjects, cmpopts.EquateEmpty()) {
+		return nil
+	}
+	updated := current.DeepCopy()
+	updated.Subjects = desired.Subjects
+	// Diff before updating because the client may mutate the object.
+	diff := cmp.Diff(current, updated, cmpopts.EquateEmpty())
+	if err := r.client.Update(context.TODO(), updated); err != nil {
+		return fmt.Errorf("failed to update dnsnameresolver role binding %s/%s: %w", updated.Namespace, updated.Name, err)
+	}
+	logrus.Infof("updated dnsnameresolver role binding %s/%s: %v", updated.Namespace, updated.Name, diff)
+	return nil
+}
+
+// desiredDNSNameResolverRole returns the role that grants CoreDNS access to
+// DNSNameResolver resources in the given namespace.
+func desiredDNSNameResolverRole(dns *operatorv1.DNS, namespace string) *rbacv1.Role {
+	name := DNSNameResolverRoleName(dns, namespace)
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name.Name,
+			Namespace: name.Namespace,
+			Labels: map[string]string{
+				dnsNameResolverRBACLabel: "",
+				manifests.OwningDNSLabel: DNSDaemonSetLabel(dns),
+			},
+		},
+		Rules: dnsNameResolverPolicyRules(),
+	}
+	role.SetOwnerReferences([]metav1.OwnerReference{dnsOwnerRef(dns)})
+	return role
+}
+
+// desiredDNSNameResolverRoleBinding returns the role binding that binds the
+// role from desiredDNSNameResolverRole to CoreDNS's service account.
+func desiredDNSNameResolverRoleBinding(dns *operatorv1.DNS, namespace string) *rbacv1.RoleBinding {
+	name := DNSNameResolverRoleName(dns, namespace)
+	sa := manifests.DNSServiceAccount()
+	rb := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name.Name,
+			Namespace: name.Namespace,
+			Labels: map[string]string{
+				dnsNameResolverRBACLabel: "",
+				manifests.OwningDNSLabel: DNSDaemonSetLabel(dns),
+			},
+		},
+		Subjects: []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      sa.Name,
+			Namespace: DefaultOperandNamespace,
+		}},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     name.Name,
+		},
+	}
+	rb.SetOwnerReferences([]metav1.OwnerReference{dnsOwnerRef(dns)})
+	return rb
+}
+
+// dnsNameResolverPolicyRules returns the rules that CoreDNS needs in order to
+// watch DNSNameResolver resources and update their status.
+func dnsNameResolverPolicyRules() []rbacv1.PolicyRule {
+	return []rbacv1.PolicyRule{
+		{
+			APIGroups: []string{"network.openshift.io"},
+			Resources: []string{"dnsnameresolvers"},
+			Verbs:     []string{"get", "list", "watch"},
+		},
+		{
+			APIGroups: []string{"network.openshift.io"},
+			Resources: []string{"dnsnameresolvers/status"},
+			Verbs:     []string{"get", "update", "patch"},
+		},
+	}
+}
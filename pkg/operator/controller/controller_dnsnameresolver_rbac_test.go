@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDNSNameResolverNamespaces(t *testing.T) {
+	defaults := []string{DefaultDNSNameResolverNamespace}
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		expected    []string
+		expectError bool
+	}{
+		{
+			name:     "no annotation",
+			expected: defaults,
+		},
+		{
+			name: "empty annotation",
+			annotations: map[string]string{
+				dnsNameResolverNamespacesAnnotationKey: " ",
+			},
+			expected: defaults,
+		},
+		{
+			name: "namespaces are sorted and deduplicated",
+			annotations: map[string]string{
+				dnsNameResolverNamespacesAnnotationKey: "openshift-ovn-kubernetes, egress-firewall,,egress-firewall",
+			},
+			expected: []string{"egress-firewall", "openshift-ovn-kubernetes"},
+		},
+		{
+			name: "invalid namespace",
+			annotations: map[string]string{
+				dnsNameResolverNamespacesAnnotationKey: "Egress_Firewall",
+			},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dns := &operatorv1.DNS{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        DefaultDNSController,
+					Annotations: tc.annotations,
+				},
+			}
+			actual, err := DNSNameResolverNamespaces(dns, defaults)
+			switch {
+			case tc.expectError && err == nil:
+				t.Fatalf("expected an error, got %v", actual)
+			case !tc.expectError && err != nil:
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if diff := cmp.Diff(tc.expected, actual); diff != "" {
+				t.Errorf("unexpected namespaces (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDesiredDNSNameResolverRoleBinding(t *testing.T) {
+	dns := &operatorv1.DNS{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: DefaultDNSController,
+		},
+	}
+	role := desiredDNSNameResolverRole(dns, "egress-firewall")
+	rb := desiredDNSNameResolverRoleBinding(dns, "egress-firewall")
+
+	if role.Namespace != "egress-firewall" || rb.Namespace != "egress-firewall" {
+		t.Errorf("expected role and role binding in namespace egress-firewall, got %q and %q", role.Namespace, rb.Namespace)
+	}
+	if rb.RoleRef.Kind != "Role" || rb.RoleRef.Name != role.Name {
+		t.Errorf("expected role binding to refer to role %q, got %+v", role.Name, rb.RoleRef)
+	}
+	expectedSubjects := []rbacv1.Subject{{
+		Kind:      rbacv1.ServiceAccountKind,
+		Name:      "dns",
+		Namespace: DefaultOperandNamespace,
+	}}
+	if diff := cmp.Diff(expectedSubjects, rb.Subjects); diff != "" {
+		t.Errorf("unexpected subjects (-want +got):\n%s", diff)
+	}
+	for _, labels := range []map[string]string{role.Labels, rb.Labels} {
+		if _, ok := labels[dnsNameResolverRBACLabel]; !ok {
+			t.Errorf("expected label %s, got %v", dnsNameResolverRBACLabel, labels)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/cluster-dns-operator/pkg/manifests"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/sirupsen/logrus"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/rest"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	// desiredStateHashAnnotationKey is the annotation on an
+	// operator-managed resource that records a hash of the state that the
+	// operator last applied to it.  If the resource differs from the
+	// desired state while the hash still matches, the desired state has
+	// not changed since the operator applied it, so someone else must
+	// have modified the resource.
+	desiredStateHashAnnotationKey = "dns.operator.openshift.io/desired-state-hash"
+
+	// driftHistoryConfigMapKey is the key in the drift history configmap
+	// for the JSON list of drift records.
+	driftHistoryConfigMapKey = "history"
+
+	// driftHistoryLength is the number of drift records that the operator
+	// keeps in the drift history configmap.
+	driftHistoryLength = 20
+
+	// driftSummaryLength is the maximum length of the diff summary in
+	// drift events and records.  Event notes are limited to 1 KiB.
+	driftSummaryLength = 512
+)
+
+var (
+	// operatorFieldManager is the field manager name that the API server
+	// records for the operator's own writes.  The API server derives it
+	// from the client's user agent.
+	operatorFieldManager = strings.SplitN(rest.DefaultKubernetesUserAgent(), "/", 2)[0]
+
+	// driftDetectedTotal counts the modifications of operator-managed
+	// resources that the operator has reverted.
+	driftDetectedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "dns_operator_drift_detected_total",
+		Help: "Number of out-of-band modifications of operator-managed resources that the operator has reverted.",
+	}, []string{"kind"})
+)
+
+func init() {
+	ctrlmetrics.Registry.MustRegister(driftDetectedTotal)
+}
+
+// driftRecord describes an out-of-band modification of an operator-managed
+// resource.
+type driftRecord struct {
+	// Time is when the operator reverted the modification.
+	Time metav1.Time `json:"time"`
+	// Kind is the kind of the modified resource.
+	Kind string `json:"kind"`
+	// Namespace is the namespace of the modified resource.
+	Namespace string `json:"namespace"`
+	// Name is the name of the modified resource.
+	Name string `json:"name"`
+	// Manager is the field manager that most recently modified the
+	// resource, which is usually the client that made the modification.
+	Manager string `json:"manager"`
+	// Summary is a summary of the reverted diff.
+	Summary string `json:"summary"`
+}
+
+// desiredStateHash returns a hash of the given desired state.
+func desiredStateHash(state interface{}) string {
+	// json.Marshal sorts map keys, so the encoding is stable.
+	encoded, _ := json.Marshal(state)
+	return fmt.Sprintf("%x", sha256.Sum256(encoded))[:16]
+}
+
+// setDesiredStateHash records the given hash of the desired state on the given
+// resource.
+func setDesiredStateHash(obj metav1.Object, hash string) {
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[desiredStateHashAnnotationKey] = hash
+	obj.SetAnnotations(annotations)
+}
+
+// driftDetected returns a Boolean value indicating whether the given resource,
+// which differs from the desired state with the given hash, was modified
+// outside of the operator.  Resources that do not record a hash are never
+// considered to have drifted; seedDesiredStateHash records one on them once
+// they match the desired state.
+func driftDetected(current metav1.Object, desiredHash string) bool {
+	hash, ok := current.GetAnnotations()[desiredStateHashAnnotationKey]
+	return ok && hash == desiredHash
+}
+
+// seedDesiredStateHash records the given hash of the desired state on the given
+// resource of the given kind, which already matches the desired state, unless
+// the resource already records it.  Without this, a resource that the operator
+// created before it recorded hashes, or that already matched a changed
+// desired state, would never record the current hash, and modifications of it
+// would never be reported as drift.  seedDesiredStateHash returns a Boolean
+// value indicating whether it updated the resource.
+func (r *reconciler) seedDesiredStateHash(kind string, current client.Object, hash string) (bool, error) {
+	if current.GetAnnotations()[desiredStateHashAnnotationKey] == hash {
+		return false, nil
+	}
+	updated := current.DeepCopyObject().(client.Object)
+	setDesiredStateHash(updated, hash)
+	if err := r.client.Update(context.TODO(), updated); err != nil {
+		return false, fmt.Errorf("failed to record desired state hash on %s %s/%s: %w", kind, updated.GetNamespace(), updated.GetName(), err)
+	}
+	logrus.Infof("recorded desired state hash on %s %s/%s", kind, updated.GetNamespace(), updated.GetName())
+	return true, nil
+}
+
+// driftManager returns the name of the field manager, other than the operator,
+// that most recently modified the given resource, or "unknown" if the
+// resource does not record one.  Writes to subresources such as status are
+// ignored.
+func driftManager(obj metav1.Object) string {
+	var latest *metav1.ManagedFieldsEntry
+	managedFields := obj.GetManagedFields()
+	for i := range managedFields {
+		entry := &managedFields[i]
+		if entry.Manager == operatorFieldManager || len(entry.Subresource) != 0 || entry.Time == nil {
+			continue
+		}
+		if latest == nil || entry.Time.After(latest.Time.Time) {
+			latest = entry
+		}
+	}
+	if latest == nil {
+		return "unknown"
+	}
+	return latest.Manager
+}
+
+// driftSummary reduces the given diff to its added and removed lines,
+// compacts them onto a single line, and truncates the result to
+// driftSummaryLength.
+func driftSummary(diff string) string {
+	var changes []string
+	for _, line := range strings.Split(diff, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "-") || strings.HasPrefix(line, "+") {
+			changes = append(changes, line)
+		}
+	}
+	if len(changes) == 0 {
+		changes = []string{diff}
+	}
+	summary := strings.Join(strings.Fields(strings.Join(changes, " ")), " ")
+	if len(summary) > driftSummaryLength {
+		summary = summary[:driftSummaryLength-3] + "..."
+	}
+	return summary
+}
+
+// appendDriftRecord appends the given record to the given history and drops
+// the oldest records beyond driftHistoryLength.
+func appendDriftRecord(history []driftRecord, record driftRecord) []driftRecord {
+	history = append(history, record)
+	if len(history) > driftHistoryLength {
+		history = history[len(history)-driftHistoryLength:]
+	}
+	return history
+}
+
+// reportDrift reports that the given resource of the given DNS was modified
+// outside of the operator and is being reverted with the given diff.  The
+// operator logs a warning, emits an event on the DNS, increments the drift
+// metric, and records the modification in the drift history configmap.
+func (r *reconciler) reportDrift(dns *operatorv1.DNS, kind string, current client.Object, diff string) {
+	record := driftRecord{
+		Time:      metav1.NewTime(time.Now().UTC().Truncate(time.Second)),
+		Kind:      kind,
+		Namespace: current.GetNamespace(),
+		Name:      current.GetName(),
+		Manager:   driftManager(current),
+		Summary:   driftSummary(diff),
+	}
+	logrus.Warningf("reverting modification of %s %s/%s by %s: %v", kind, record.Namespace, record.Name, record.Manager, diff)
+	driftDetectedTotal.WithLabelValues(kind).Inc()
+	if r.eventRecorder != nil {
+		r.eventRecorder.Eventf(dns, current, corev1.EventTypeWarning, "DriftReverted", "Update", "%s %s/%s was modified by %s; reverting: %s", kind, record.Namespace, record.Name, record.Manager, record.Summary)
+	}
+	if err := r.recordDrift(dns, record); err != nil {
+		logrus.Warningf("failed to record drift for dns %s: %v", dns.Name, err)
+	}
+}
+
+// recordDrift adds the given record to the drift history configmap of the
+// given DNS.
+func (r *reconciler) recordDrift(dns *operatorv1.DNS, record driftRecord) error {
+	name := DriftHistoryConfigMapName(dns)
+	current := &corev1.ConfigMap{}
+	if err := r.client.Get(context.TODO(), name, current); err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to get drift history configmap %s/%s: %w", name.Namespace, name.Name, err)
+		}
+		desired, err := desiredDriftHistoryConfigMap(dns, []driftRecord{record})
+		if err != nil {
+			return err
+		}
+		if err := r.client.Create(context.TODO(), desired); err != nil {
+			return fmt.Errorf("failed to create drift history configmap %s/%s: %w", name.Namespace, name.Name, err)
+		}
+		logrus.Infof("created drift history configmap: %s/%s", name.Namespace, name.Name)
+		return nil
+	}
+	var history []driftRecord
+	if err := json.Unmarshal([]byte(current.Data[driftHistoryConfigMapKey]), &history); err != nil {
+		logrus.Warningf("discarding invalid drift history in configmap %s/%s: %v", name.Namespace, name.Name, err)
+		history = nil
+	}
+	desired, err := desiredDriftHistoryConfigMap(dns, appendDriftRecord(history, record))
+	if err != nil {
+		return err
+	}
+	updated := current.DeepCopy()
+	updated.Data = desired.Data
+	if err := r.client.Update(context.TODO(), updated); err != nil {
+		return fmt.Errorf("failed to update drift history configmap %s/%s: %w", name.Namespace, name.Name, err)
+	}
+	return nil
+}
+
+// desiredDriftHistoryConfigMap returns the drift history configmap for the
+// given DNS with the given history.
+func desiredDriftHistoryConfigMap(dns *operatorv1.DNS, history []driftRecord) (*corev1.ConfigMap, error) {
+	encoded, err := json.MarshalIndent(history, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode drift history: %w", err)
+	}
+	name := DriftHistoryConfigMapName(dns)
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name.Name,
+			Namespace: name.Namespace,
+			Labels: map[string]string{
+				manifests.OwningDNSLabel: DNSDaemonSetLabel(dns),
+			},
+		},
+		Data: map[string]string{
+			driftHistoryConfigMapKey: string(encoded),
+		},
+	}
+	cm.SetOwnerReferences([]metav1.OwnerReference{dnsOwnerRef(dns)})
+	return cm, nil
+}
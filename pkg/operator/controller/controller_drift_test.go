@@ -0,0 +1,285 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/events"
+
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestDriftDetected(t *testing.T) {
+	testCases := []struct {
+		name        string
+		annotations map[string]string
+		expected    bool
+	}{
+		{
+			name:     "no hash",
+			expected: false,
+		},
+		{
+			name:        "hash of the desired state",
+			annotations: map[string]string{desiredStateHashAnnotationKey: "abc"},
+			expected:    true,
+		},
+		{
+			name:        "hash of an older desired state",
+			annotations: map[string]string{desiredStateHashAnnotationKey: "def"},
+			expected:    false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Annotations: tc.annotations}}
+			if actual := driftDetected(cm, "abc"); actual != tc.expected {
+				t.Errorf("expected %t, got %t", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestDriftManager(t *testing.T) {
+	at := func(minutes int) *metav1.Time {
+		t := metav1.NewTime(time.Date(2026, 1, 1, 0, minutes, 0, 0, time.UTC))
+		return &t
+	}
+	testCases := []struct {
+		name          string
+		managedFields []metav1.ManagedFieldsEntry
+		expected      string
+	}{
+		{
+			name:     "no managed fields",
+			expected: "unknown",
+		},
+		{
+			name: "only the operator",
+			managedFields: []metav1.ManagedFieldsEntry{
+				{Manager: operatorFieldManager, Operation: metav1.ManagedFieldsOperationUpdate, Time: at(1)},
+			},
+			expected: "unknown",
+		},
+		{
+			name: "latest other manager",
+			managedFields: []metav1.ManagedFieldsEntry{
+				{Manager: operatorFieldManager, Operation: metav1.ManagedFieldsOperationUpdate, Time: at(5)},
+				{Manager: "kubectl-edit", Operation: metav1.ManagedFieldsOperationUpdate, Time: at(3)},
+				{Manager: "kubectl-patch", Operation: metav1.ManagedFieldsOperationUpdate, Time: at(2)},
+				{Manager: "kube-controller-manager", Operation: metav1.ManagedFieldsOperationUpdate, Time: at(4), Subresource: "status"},
+			},
+			expected: "kubectl-edit",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{ManagedFields: tc.managedFields}}
+			if actual := driftManager(cm); actual != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestDriftSummary(t *testing.T) {
+	if actual, expected := driftSummary("  foo{\n\t-  bar: 1,\n  +  bar: 2,\n  }\n"), "- bar: 1, + bar: 2,"; actual != expected {
+		t.Errorf("expected %q, got %q", expected, actual)
+	}
+	if actual := driftSummary("+" + strings.Repeat("x", 2*driftSummaryLength)); len(actual) != driftSummaryLength || !strings.HasSuffix(actual, "...") {
+		t.Errorf("expected a truncated summary of %d characters, got %q", driftSummaryLength, actual)
+	}
+}
+
+func TestAppendDriftRecord(t *testing.T) {
+	var history []driftRecord
+	for i := 0; i < driftHistoryLength+5; i++ {
+		history = appendDriftRecord(history, driftRecord{Name: fmt.Sprintf("record-%d", i)})
+	}
+	if len(history) != driftHistoryLength {
+		t.Fatalf("expected %d records, got %d", driftHistoryLength, len(history))
+	}
+	if first, last := history[0].Name, history[len(history)-1].Name; first != "record-5" || last != fmt.Sprintf("record-%d", driftHistoryLength+4) {
+		t.Errorf("expected the oldest records to be dropped, got records %s through %s", first, last)
+	}
+}
+
+// TestUpdateDNSConfigMapReportsDrift verifies that reverting a modification of
+// the Corefile emits an event and records the modification in the drift
+// history, and that a change of the desired Corefile does not.
+func TestUpdateDNSConfigMapReportsDrift(t *testing.T) {
+	dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+	desired := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-dns", Name: "dns-default"},
+		Data:       map[string]string{"Corefile": "desired"},
+	}
+	now := metav1.Now()
+	testCases := []struct {
+		name        string
+		currentHash string
+		expectDrift bool
+	}{
+		{
+			name:        "modified outside of the operator",
+			currentHash: desiredStateHash(desired.Data),
+			expectDrift: true,
+		},
+		{
+			name:        "desired Corefile changed",
+			currentHash: desiredStateHash(map[string]string{"Corefile": "older"}),
+			expectDrift: false,
+		},
+	}
+
+	scheme := runtime.NewScheme()
+	corev1.AddToScheme(scheme)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			current := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   "openshift-dns",
+					Name:        "dns-default",
+					Annotations: map[string]string{desiredStateHashAnnotationKey: tc.currentHash},
+				},
+				Data: map[string]string{"Corefile": "edited"},
+			}
+			fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(current.DeepCopy()).Build()
+			recorder := events.NewFakeRecorder(10)
+			r := &reconciler{client: fakeClient, eventRecorder: recorder}
+
+			if err := fakeClient.Get(context.Background(), DNSConfigMapName(dns), current); err != nil {
+				t.Fatal(err)
+			}
+			// The fake client does not track field managers.
+			current.ManagedFields = []metav1.ManagedFieldsEntry{
+				{Manager: "kubectl-edit", Operation: metav1.ManagedFieldsOperationUpdate, Time: &now, APIVersion: "v1", FieldsType: "FieldsV1", FieldsV1: &metav1.FieldsV1{Raw: []byte(`{"f:data":{"f:Corefile":{}}}`)}},
+			}
+			if updated, err := r.updateDNSConfigMap(dns, current, desired); err != nil {
+				t.Fatal(err)
+			} else if !updated {
+				t.Fatal("expected the configmap to be updated")
+			}
+
+			result := &corev1.ConfigMap{}
+			if err := fakeClient.Get(context.Background(), DNSConfigMapName(dns), result); err != nil {
+				t.Fatal(err)
+			}
+			if result.Data["Corefile"] != "desired" {
+				t.Errorf("expected the Corefile to be reverted, got %q", result.Data["Corefile"])
+			}
+			if hash := result.Annotations[desiredStateHashAnnotationKey]; hash != desiredStateHash(desired.Data) {
+				t.Errorf("expected the desired state hash to be recorded, got %q", hash)
+			}
+
+			history := &corev1.ConfigMap{}
+			err := fakeClient.Get(context.Background(), DriftHistoryConfigMapName(dns), history)
+			if !tc.expectDrift {
+				if err == nil {
+					t.Errorf("expected no drift history, got %v", history.Data)
+				}
+				if len(recorder.Events) != 0 {
+					t.Errorf("expected no events, got %q", <-recorder.Events)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("expected drift history: %v", err)
+			}
+			var records []driftRecord
+			if err := json.Unmarshal([]byte(history.Data[driftHistoryConfigMapKey]), &records); err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != 1 || records[0].Kind != "ConfigMap" || records[0].Name != "dns-default" || records[0].Manager != "kubectl-edit" {
+				t.Errorf("unexpected drift history: %+v", records)
+			}
+			select {
+			case event := <-recorder.Events:
+				if !strings.Contains(event, "DriftReverted") || !strings.Contains(event, "kubectl-edit") {
+					t.Errorf("unexpected event: %q", event)
+				}
+			default:
+				t.Error("expected an event")
+			}
+		})
+	}
+}
+
+// TestUpdateDNSConfigMapSeedsDesiredStateHash verifies that the operator
+// records the desired state hash on a configmap that already has the desired
+// Corefile, so that later modifications of it are reported as drift.
+func TestUpdateDNSConfigMapSeedsDesiredStateHash(t *testing.T) {
+	dns := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}}
+	desired := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "openshift-dns", Name: "dns-default"},
+		Data:       map[string]string{"Corefile": "desired"},
+	}
+	testCases := []struct {
+		name          string
+		annotations   map[string]string
+		expectUpdated bool
+	}{
+		{
+			name:          "created before hashes were recorded",
+			expectUpdated: true,
+		},
+		{
+			name:          "desired Corefile changed to the current one",
+			annotations:   map[string]string{desiredStateHashAnnotationKey: desiredStateHash(map[string]string{"Corefile": "older"})},
+			expectUpdated: true,
+		},
+		{
+			name:          "hash already recorded",
+			annotations:   map[string]string{desiredStateHashAnnotationKey: desiredStateHash(desired.Data)},
+			expectUpdated: false,
+		},
+	}
+
+	scheme := runtime.NewScheme()
+	corev1.AddToScheme(scheme)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			current := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace:   "openshift-dns",
+					Name:        "dns-default",
+					Annotations: tc.annotations,
+				},
+				Data: map[string]string{"Corefile": "desired"},
+			}
+			fakeClient := fake.NewClientBuilder().WithScheme(scheme).WithObjects(current.DeepCopy()).Build()
+			recorder := events.NewFakeRecorder(10)
+			r := &reconciler{client: fakeClient, eventRecorder: recorder}
+
+			if err := fakeClient.Get(context.Background(), DNSConfigMapName(dns), current); err != nil {
+				t.Fatal(err)
+			}
+			if updated, err := r.updateDNSConfigMap(dns, current, desired); err != nil {
+				t.Fatal(err)
+			} else if updated != tc.expectUpdated {
+				t.Errorf("expected updated to be %t, got %t", tc.expectUpdated, updated)
+			}
+
+			result := &corev1.ConfigMap{}
+			if err := fakeClient.Get(context.Background(), DNSConfigMapName(dns), result); err != nil {
+				t.Fatal(err)
+			}
+			if hash := result.Annotations[desiredStateHashAnnotationKey]; hash != desiredStateHash(desired.Data) {
+				t.Errorf("expected the desired state hash to be recorded, got %q", hash)
+			}
+			if len(recorder.Events) != 0 {
+				t.Errorf("expected no events, got %q", <-recorder.Events)
+			}
+		})
+	}
+}
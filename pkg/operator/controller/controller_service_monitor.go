@@ -115,12 +115,17 @@ func (r *reconciler) updateDNSServiceMonitor(current, desired *unstructured.Unst
 }
 
 func serviceMonitorChanged(current, expected *unstructured.Unstructured) (bool, *unstructured.Unstructured) {
-	if cmp.Equal(current.Object["spec"], expected.Object["spec"], cmpopts.EquateEmpty()) {
+	// The owner must follow the workload that runs CoreDNS so that
+	// deleting the previous workload after a migration does not cause the
+	// garbage collector to delete the servicemonitor.
+	if cmp.Equal(current.Object["spec"], expected.Object["spec"], cmpopts.EquateEmpty()) &&
+		cmp.Equal(current.GetOwnerReferences(), expected.GetOwnerReferences(), cmpopts.EquateEmpty()) {
 		return false, nil
 	}
 
 	updated := current.DeepCopy()
 	updated.Object["spec"] = expected.Object["spec"]
+	updated.SetOwnerReferences(expected.GetOwnerReferences())
 
 	return true, updated
 }
@@ -3,6 +3,7 @@ package controller
 import (
 	"testing"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
@@ -40,6 +41,18 @@ func TestDNSServiceMonitorChanged(t *testing.T) {
 			},
 			expect: false,
 		},
+		{
+			description: "if the owner changes",
+			mutate: func(serviceMonitor *unstructured.Unstructured) {
+				serviceMonitor.SetOwnerReferences([]metav1.OwnerReference{{
+					APIVersion: "apps/v1",
+					Kind:       "Deployment",
+					Name:       "dns-original",
+					UID:        "2",
+				}})
+			},
+			expect: true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -48,6 +61,14 @@ func TestDNSServiceMonitorChanged(t *testing.T) {
 				"metadata": map[string]interface{}{
 					"namespace": "openshift-dns",
 					"name":      "dns-original",
+					"ownerReferences": []interface{}{
+						map[string]interface{}{
+							"apiVersion": "apps/v1",
+							"kind":       "DaemonSet",
+							"name":       "dns-original",
+							"uid":        "1",
+						},
+					},
 				},
 				"spec": map[string]interface{}{
 					"selector": map[string]interface{}{},
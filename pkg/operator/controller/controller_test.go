@@ -1,10 +1,19 @@
 package controller
 
 import (
-	"github.com/openshift/cluster-dns-operator/pkg/manifests"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	configv1 "github.com/openshift/api/config/v1"
+	operatorv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/cluster-dns-operator/pkg/manifests"
+
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 func TestDNSNamespaceLabelsChanged(t *testing.T) {
@@ -54,3 +63,183 @@ func TestDNSNamespaceLabelsChanged(t *testing.T) {
 		}
 	}
 }
+
+func TestClusterDomainFromConfigMap(t *testing.T) {
+	testCases := []struct {
+		name          string
+		data          map[string]string
+		expected      string
+		expectedError bool
+	}{
+		{
+			name:     "no data",
+			expected: "cluster.local",
+		},
+		{
+			name:     "empty cluster domain",
+			data:     map[string]string{"clusterDomain": ""},
+			expected: "cluster.local",
+		},
+		{
+			name:     "custom cluster domain",
+			data:     map[string]string{"clusterDomain": "corp.example"},
+			expected: "corp.example",
+		},
+		{
+			name:     "custom cluster domain with trailing dot and uppercase letters",
+			data:     map[string]string{"clusterDomain": " Corp.Example. "},
+			expected: "corp.example",
+		},
+		{
+			name:          "invalid cluster domain",
+			data:          map[string]string{"clusterDomain": "corp_example!"},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			cm := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Namespace: GlobalUserSpecifiedConfigNamespace,
+					Name:      ClusterDNSConfigMap,
+				},
+				Data: tc.data,
+			}
+			actual, err := clusterDomainFromConfigMap(cm)
+			switch {
+			case tc.expectedError && err == nil:
+				t.Errorf("expected an error, got %q", actual)
+			case !tc.expectedError && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case actual != tc.expected:
+				t.Errorf("expected %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
+func TestDesiredPreviousClusterDomains(t *testing.T) {
+	testCases := []struct {
+		name           string
+		annotation     string
+		statusDomain   string
+		clusterDomain  string
+		expectedResult []string
+	}{
+		{
+			name:          "new dns",
+			clusterDomain: "cluster.local",
+		},
+		{
+			name:          "unchanged cluster domain",
+			statusDomain:  "cluster.local",
+			clusterDomain: "cluster.local",
+		},
+		{
+			name:           "changed cluster domain",
+			statusDomain:   "cluster.local",
+			clusterDomain:  "corp.example",
+			expectedResult: []string{"cluster.local"},
+		},
+		{
+			name:           "migration already recorded",
+			annotation:     "cluster.local",
+			statusDomain:   "corp.example",
+			clusterDomain:  "corp.example",
+			expectedResult: []string{"cluster.local"},
+		},
+		{
+			name:           "changed cluster domain twice",
+			annotation:     "cluster.local",
+			statusDomain:   "corp.example",
+			clusterDomain:  "other.example",
+			expectedResult: []string{"cluster.local", "corp.example"},
+		},
+		{
+			name:          "cluster domain changed back",
+			annotation:    "cluster.local",
+			statusDomain:  "corp.example",
+			clusterDomain: "cluster.local",
+			// corp.example is still served because pods may have
+			// been created with it in their search path.
+			expectedResult: []string{"corp.example"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dns := &operatorv1.DNS{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: DefaultDNSController,
+				},
+				Status: operatorv1.DNSStatus{
+					ClusterDomain: tc.statusDomain,
+				},
+			}
+			if len(tc.annotation) != 0 {
+				dns.Annotations = map[string]string{
+					previousClusterDomainsAnnotationKey: tc.annotation,
+				}
+			}
+			actual := desiredPreviousClusterDomains(dns, tc.clusterDomain)
+			if diff := cmp.Diff(tc.expectedResult, actual, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("unexpected previous cluster domains (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGetClusterIPForDNS(t *testing.T) {
+	network := &configv1.Network{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster"},
+		Status:     configv1.NetworkStatus{ServiceNetwork: []string{"172.30.0.0/16"}},
+	}
+	tenant := &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: "tenant"}}
+	tenantService := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: DNSServiceName(tenant).Namespace,
+			Name:      DNSServiceName(tenant).Name,
+		},
+		Spec: corev1.ServiceSpec{ClusterIP: "172.30.14.7"},
+	}
+	testCases := []struct {
+		name     string
+		dns      *operatorv1.DNS
+		objects  []runtime.Object
+		expectIP string
+	}{
+		{
+			name:     "default dns",
+			dns:      &operatorv1.DNS{ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController}},
+			objects:  []runtime.Object{network, tenantService},
+			expectIP: "172.30.0.10",
+		},
+		{
+			name:     "additional dns with a service",
+			dns:      tenant,
+			objects:  []runtime.Object{network, tenantService},
+			expectIP: "172.30.14.7",
+		},
+		{
+			name:    "additional dns without a service",
+			dns:     tenant,
+			objects: []runtime.Object{network},
+		},
+	}
+	scheme := runtime.NewScheme()
+	corev1.AddToScheme(scheme)
+	configv1.AddToScheme(scheme)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := &reconciler{client: fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(tc.objects...).Build()}
+			clusterIP, err := r.getClusterIPForDNS(tc.dns)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if clusterIP != tc.expectIP {
+				t.Errorf("expected cluster IP %q, got %q", tc.expectIP, clusterIP)
+			}
+		})
+	}
+}
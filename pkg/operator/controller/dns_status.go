@@ -8,6 +8,7 @@ import (
 	"strings"
 	"time"
 
+	configv1 "github.com/openshift/api/config/v1"
 	operatorv1 "github.com/openshift/api/operator/v1"
 	cond "github.com/openshift/cluster-dns-operator/pkg/util/conditions"
 	retryable "github.com/openshift/cluster-dns-operator/pkg/util/retryableerror"
@@ -15,24 +16,94 @@ import (
 	"github.com/sirupsen/logrus"
 
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
-// syncDNSStatus computes the current status of dns and
+// dnsStatusInputs is the state of a DNS's resources and features from which
+// the DNS's status conditions are computed.
+type dnsStatusInputs struct {
+	// clusterIP is the address of the DNS service, or empty if the
+	// service has no address.
+	clusterIP string
+	// clusterDomain is the cluster domain that the DNS serves.
+	clusterDomain string
+	// haveDNSDaemonset indicates whether the DNS workload exists, and
+	// dnsDaemonset is its daemonset.
+	haveDNSDaemonset bool
+	dnsDaemonset     *appsv1.DaemonSet
+	// haveNodeResolverDaemonset indicates whether the node-resolver
+	// daemonset exists, and nodeResolverDaemonset is the daemonset.
+	haveNodeResolverDaemonset bool
+	nodeResolverDaemonset     *appsv1.DaemonSet
+	// haveNodeLocalCacheDaemonset indicates whether the node-local cache
+	// daemonset exists, and nodeLocalCacheDaemonset is the daemonset.
+	haveNodeLocalCacheDaemonset bool
+	nodeLocalCacheDaemonset     *appsv1.DaemonSet
+
+	// ipv6OnlyServiceNetwork indicates whether the cluster has only IPv6
+	// service networks.
+	ipv6OnlyServiceNetwork bool
+	// canaryStatus is the status of the corefile canary.
+	canaryStatus corefileCanaryStatus
+	// nodePoolMessages describes the node pools that are progressing.
+	nodePoolMessages []string
+	// upstreamProbeStatus is the latest result of the upstream probes, or
+	// nil if the upstreams have not been probed.
+	upstreamProbeStatus *upstreamProbeStatus
+	// tlsPreflightStatus is the latest result of the pre-flight TLS
+	// handshakes, or nil if none have been made.
+	tlsPreflightStatus *tlsPreflightStatus
+	// caBundleExpiries is the expiry of each CA bundle that the DNS uses.
+	caBundleExpiries []caBundleExpiry
+	// resourceRecommendation is the recommended requests for the CoreDNS
+	// container, or nil if none is available.
+	resourceRecommendation corev1.ResourceList
+	// updateSafetyStatus describes a refused update to the DNS
+	// daemonset, or is nil if no update was refused.
+	updateSafetyStatus *dnsUpdateSafetyStatus
+}
+
+// syncDNSStatus computes the current status of dns from the given inputs and
+// the state of the DNS's features and
 // updates status upon any changes since last sync.
 // If the elapsed time between time.Now() and
 // oldCondition.LastTransitionTime is <= transitionUnchangedToleration
 // for progressing and degraded then consider oldCondition to be recent
 // and return oldCondition to prevent frequent updates.
-func (r *reconciler) syncDNSStatus(dns *operatorv1.DNS, clusterIP, clusterDomain string, haveDNSDaemonset bool, dnsDaemonset *appsv1.DaemonSet, haveNodeResolverDaemonset bool, nodeResolverDaemonset *appsv1.DaemonSet, transitionUnchangedToleration time.Duration, reconcileResult *reconcile.Result) error {
+func (r *reconciler) syncDNSStatus(dns *operatorv1.DNS, inputs dnsStatusInputs, transitionUnchangedToleration time.Duration, reconcileResult *reconcile.Result) error {
 	var errs []error
 	updated := dns.DeepCopy()
-	updated.Status.ClusterIP = clusterIP
-	updated.Status.ClusterDomain = clusterDomain
+	updated.Status.ClusterIP = inputs.clusterIP
+	updated.Status.ClusterDomain = inputs.clusterDomain
+	networkConfig := &configv1.Network{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: "cluster"}, networkConfig); err != nil {
+		logrus.Warningf("failed to get network 'cluster': %v", err)
+	} else {
+		inputs.ipv6OnlyServiceNetwork = serviceNetworksAreIPv6Only(networkConfig.Status.ServiceNetwork)
+	}
+	var err error
+	inputs.canaryStatus, err = r.currentCorefileCanaryStatus(dns)
+	if err != nil {
+		logrus.Warningf("failed to get corefile canary status for dns %s: %v", dns.Name, err)
+	}
+	inputs.upstreamProbeStatus = r.upstreamProber.Status(dns)
+	inputs.tlsPreflightStatus = r.tlsPreflighter.get(dns)
+	inputs.caBundleExpiries, err = r.caBundleExpiries(dns)
+	if err != nil {
+		logrus.Warningf("failed to get ca bundle expiries for dns %s: %v", dns.Name, err)
+	}
+	inputs.resourceRecommendation = r.resourceAutosizer.Recommendation(dns)
+	inputs.updateSafetyStatus = r.updateSafetyStatuses.get(dns)
+	inputs.nodePoolMessages, err = r.currentDNSNodePoolMessages(dns)
+	if err != nil {
+		logrus.Warningf("failed to get node pool status for dns %s: %v", dns.Name, err)
+	}
 	// This can return a retryable error.
-	statusConds, err := computeDNSStatusConditions(dns, clusterIP, haveDNSDaemonset, dnsDaemonset, haveNodeResolverDaemonset, nodeResolverDaemonset, transitionUnchangedToleration, reconcileResult)
+	statusConds, err := computeDNSStatusConditions(dns, &inputs, transitionUnchangedToleration, reconcileResult)
 	if err != nil {
 		logrus.Infof("error computing DNS %s status: %v got %v", dns.ObjectMeta.Name, statusConds, err)
 		errs = append(errs, err)
@@ -51,14 +122,15 @@ func (r *reconciler) syncDNSStatus(dns *operatorv1.DNS, clusterIP, clusterDomain
 }
 
 // computeDNSStatusConditions computes dns status conditions based on
-// the status of the dns and node-resolver daemonsets and clusterIP.
+// the given inputs.  The conditions for optional features are only
+// reported if the DNS has the feature configured.
 // If the elapsed time between time.Now() and
 // oldCondition.LastTransitionTime is <= transitionUnchangedToleration
 // for progressing and degraded then consider oldCondition to be recent
 // and return oldCondition to prevent frequent updates.
-func computeDNSStatusConditions(dns *operatorv1.DNS, clusterIP string, haveDNSDaemonset bool, dnsDaemonset *appsv1.DaemonSet, haveNodeResolverDaemonset bool, nodeResolverDaemonset *appsv1.DaemonSet, transitionUnchangedToleration time.Duration, reconcileResult *reconcile.Result) ([]operatorv1.OperatorCondition, error) {
+func computeDNSStatusConditions(dns *operatorv1.DNS, inputs *dnsStatusInputs, transitionUnchangedToleration time.Duration, reconcileResult *reconcile.Result) ([]operatorv1.OperatorCondition, error) {
 	oldConditions := dns.Status.Conditions
-	var oldDegradedCondition, oldProgressingCondition, oldAvailableCondition, oldUpgradeableCondition *operatorv1.OperatorCondition
+	var oldDegradedCondition, oldProgressingCondition, oldAvailableCondition, oldUpgradeableCondition, oldDNS64SuggestedCondition, oldInvalidConfigurationCondition, oldUpstreamsDegradedCondition, oldTLSPreflightFailedCondition, oldCABundleExpiringCondition, oldResourceRecommendationPendingCondition *operatorv1.OperatorCondition
 	for i := range oldConditions {
 		switch oldConditions[i].Type {
 		case operatorv1.OperatorStatusTypeDegraded:
@@ -69,18 +141,48 @@ func computeDNSStatusConditions(dns *operatorv1.DNS, clusterIP string, haveDNSDa
 			oldAvailableCondition = &oldConditions[i]
 		case operatorv1.OperatorStatusTypeUpgradeable:
 			oldUpgradeableCondition = &oldConditions[i]
+		case DNS64SuggestedConditionType:
+			oldDNS64SuggestedCondition = &oldConditions[i]
+		case InvalidConfigurationConditionType:
+			oldInvalidConfigurationCondition = &oldConditions[i]
+		case UpstreamsDegradedConditionType:
+			oldUpstreamsDegradedCondition = &oldConditions[i]
+		case TLSPreflightFailedConditionType:
+			oldTLSPreflightFailedCondition = &oldConditions[i]
+		case CABundleExpiringConditionType:
+			oldCABundleExpiringCondition = &oldConditions[i]
+		case ResourceRecommendationPendingConditionType:
+			oldResourceRecommendationPendingCondition = &oldConditions[i]
 		}
 	}
 
 	now := time.Now()
 	var conditions []operatorv1.OperatorCondition
 	// If the operator is currently Progressing=true, we may not want to mark it Degraded=true.
-	newProgressingCondition := computeDNSProgressingCondition(oldProgressingCondition, dns, clusterIP, haveDNSDaemonset, dnsDaemonset, haveNodeResolverDaemonset, nodeResolverDaemonset, transitionUnchangedToleration, now, reconcileResult)
+	newProgressingCondition := computeDNSProgressingCondition(oldProgressingCondition, dns, inputs, transitionUnchangedToleration, now, reconcileResult)
 	conditions = append(conditions, newProgressingCondition)
-	conditions = append(conditions, computeDNSAvailableCondition(oldAvailableCondition, clusterIP, haveDNSDaemonset, dnsDaemonset))
+	conditions = append(conditions, computeDNSAvailableCondition(oldAvailableCondition, dns, inputs))
 	conditions = append(conditions, computeDNSUpgradeableCondition(oldUpgradeableCondition, dns))
+	if inputs.ipv6OnlyServiceNetwork {
+		conditions = append(conditions, computeDNS64SuggestedCondition(oldDNS64SuggestedCondition, dns))
+	}
+	if len(dns.Spec.Servers) != 0 || len(dns.Annotations[dnsViewsAnnotationKey]) != 0 || len(dns.Annotations[forwardExceptAnnotationKey]) != 0 {
+		conditions = append(conditions, computeDNSInvalidConfigurationCondition(oldInvalidConfigurationCondition, dns, inputs.clusterDomain))
+	}
+	if config, err := upstreamProbesConfigForDNS(dns); err == nil && config.Enabled {
+		conditions = append(conditions, computeUpstreamsDegradedCondition(oldUpstreamsDegradedCondition, inputs.upstreamProbeStatus))
+	}
+	if inputs.tlsPreflightStatus != nil {
+		conditions = append(conditions, computeTLSPreflightFailedCondition(oldTLSPreflightFailedCondition, inputs.tlsPreflightStatus))
+	}
+	if len(inputs.caBundleExpiries) != 0 {
+		conditions = append(conditions, computeCABundleExpiringCondition(oldCABundleExpiringCondition, inputs.caBundleExpiries, now))
+	}
+	if config, err := dnsResourcesConfigForDNS(dns); err == nil && config.autosizing() {
+		conditions = append(conditions, computeResourceRecommendationPendingCondition(oldResourceRecommendationPendingCondition, config, inputs.dnsDaemonset, inputs.resourceRecommendation))
+	}
 	// Store the error from computeDNSDegradedCondition for use in retries by caller.
-	degradedCondition, err := computeDNSDegradedCondition(oldDegradedCondition, &newProgressingCondition, clusterIP, haveDNSDaemonset, dnsDaemonset, transitionUnchangedToleration, now)
+	degradedCondition, err := computeDNSDegradedCondition(oldDegradedCondition, &newProgressingCondition, inputs, transitionUnchangedToleration, now)
 	conditions = append(conditions, degradedCondition)
 
 	return conditions, err
@@ -107,16 +209,20 @@ const (
 	// DNSMaxUnavailableDNSPodsExceeded indicates that the number of unavailable DNS
 	// pods is greater than the configured MaxUnavailable.
 	DNSMaxUnavailableDNSPodsExceeded = "MaxUnavailableDNSPodsExceeded"
+
+	// DNSUnsafeUpdateRefused indicates that the operator refused an update
+	// to the DNS daemonset that would leave too few nodes with a DNS pod.
+	DNSUnsafeUpdateRefused = "UnsafeUpdateRefused"
 )
 
 // computeDNSDegradedCondition computes the dns Degraded status
-// condition based on the status of clusterIP and the DNS daemonset.
-// The node-resolver daemonset is not a part of the calculation of
+// condition based on the status of clusterIP and the DNS daemonset and on
+// any refused update to the DNS daemonset.  The node-resolver daemonset is not a part of the calculation of
 // degraded condition. If the elapsed time between currentTime and
 // oldCondition.LastTransitionTime is <= transitionUnchangedToleration
 // then consider oldCondition to be recent and return oldCondition to
 // prevent frequent updates.
-func computeDNSDegradedCondition(oldDegradedCondition, newProgressingCondition *operatorv1.OperatorCondition, clusterIP string, haveDNSDaemonset bool, dnsDaemonset *appsv1.DaemonSet, transitionUnchangedToleration time.Duration, currentTime time.Time) (operatorv1.OperatorCondition, error) {
+func computeDNSDegradedCondition(oldDegradedCondition, newProgressingCondition *operatorv1.OperatorCondition, inputs *dnsStatusInputs, transitionUnchangedToleration time.Duration, currentTime time.Time) (operatorv1.OperatorCondition, error) {
 	degradedCondition := operatorv1.OperatorCondition{
 		Type:   operatorv1.OperatorStatusTypeDegraded,
 		Status: operatorv1.ConditionUnknown,
@@ -125,17 +231,16 @@ func computeDNSDegradedCondition(oldDegradedCondition, newProgressingCondition *
 
 	transitionTime := metav1.NewTime(currentTime)
 
-	if len(clusterIP) == 0 {
+	if len(inputs.clusterIP) == 0 {
 		degradedConditions = append(degradedConditions, generateCondition(DNSNoService, DNSNoService, "No IP address is assigned to the DNS service.", operatorv1.ConditionTrue, transitionTime))
 	}
-	if !haveDNSDaemonset {
+	if !inputs.haveDNSDaemonset {
 		degradedConditions = append(degradedConditions, generateCondition(DNSNoDNSDaemonSet, DNSNoDNSDaemonSet, "The DNS daemonset does not exist.", operatorv1.ConditionTrue, transitionTime))
 	} else {
-		want := dnsDaemonset.Status.DesiredNumberScheduled
-		have := dnsDaemonset.Status.NumberAvailable
+		want := inputs.dnsDaemonset.Status.DesiredNumberScheduled
+		have := inputs.dnsDaemonset.Status.NumberAvailable
 		numberUnavailable := want - have
-		maxUnavailableIntStr := intstr.FromString("10%")
-		maxUnavailable, intstrErr := intstr.GetScaledValueFromIntOrPercent(&maxUnavailableIntStr, int(want), true)
+		maxUnavailable, intstrErr := intstr.GetScaledValueFromIntOrPercent(dnsDaemonsetMaxUnavailable(inputs.dnsDaemonset), int(want), true)
 
 		switch {
 		case want == 0:
@@ -150,6 +255,13 @@ func computeDNSDegradedCondition(oldDegradedCondition, newProgressingCondition *
 		}
 	}
 
+	// A refused update keeps the DNS daemonset from progressing to the
+	// desired node placement, so it is reported even while Progressing.
+	refused := inputs.updateSafetyStatus != nil
+	if refused {
+		degradedConditions = append(degradedConditions, generateCondition(DNSUnsafeUpdateRefused, DNSUnsafeUpdateRefused, inputs.updateSafetyStatus.message(), operatorv1.ConditionTrue, transitionTime))
+	}
+
 	// Record whether the operator is Progressing.
 	progressing := newProgressingCondition != nil && newProgressingCondition.Status == operatorv1.ConditionTrue
 
@@ -163,7 +275,7 @@ func computeDNSDegradedCondition(oldDegradedCondition, newProgressingCondition *
 
 	var err error
 
-	if !progressing && len(degradedConditions) != 0 {
+	if (!progressing || refused) && len(degradedConditions) != 0 {
 		degradedMessages := cond.FormatConditions(degradedConditions)
 		degradedCondition.Status = operatorv1.ConditionTrue
 		degradedCondition.Reason = "DegradedConditions"
@@ -177,6 +289,21 @@ func computeDNSDegradedCondition(oldDegradedCondition, newProgressingCondition *
 	return setDNSLastTransitionTime(&degradedCondition, oldDegradedCondition), err
 }
 
+// defaultDNSMaxUnavailable is the number of unavailable DNS pods beyond which
+// the DNS is degraded if the DNS daemonset does not specify maxUnavailable.
+var defaultDNSMaxUnavailable = intstr.FromString("10%")
+
+// dnsDaemonsetMaxUnavailable returns the maxUnavailable value of the given DNS
+// daemonset's rolling update strategy, or defaultDNSMaxUnavailable if the
+// daemonset does not specify one.
+func dnsDaemonsetMaxUnavailable(daemonset *appsv1.DaemonSet) *intstr.IntOrString {
+	rollingUpdate := daemonset.Spec.UpdateStrategy.RollingUpdate
+	if rollingUpdate == nil || rollingUpdate.MaxUnavailable == nil {
+		return &defaultDNSMaxUnavailable
+	}
+	return rollingUpdate.MaxUnavailable
+}
+
 func generateCondition(condtype, reason, message string, status operatorv1.ConditionStatus, transitionTime metav1.Time) *operatorv1.OperatorCondition {
 	return &operatorv1.OperatorCondition{
 		Type:               condtype,
@@ -189,51 +316,58 @@ func generateCondition(condtype, reason, message string, status operatorv1.Condi
 
 // computeDNSProgressingCondition computes the dns Progressing status
 // condition based on the status of the DNS and node-resolver
-// daemonsets. If the elapsed time between currentTime and
+// daemonsets, the corefile canary, and the node pools. If the elapsed time between currentTime and
 // oldCondition.LastTransitionTime is <= transitionUnchangedToleration then
 // consider oldCondition to be recent and return oldCondition to
 // prevent frequent updates.
-func computeDNSProgressingCondition(oldCondition *operatorv1.OperatorCondition, dns *operatorv1.DNS, clusterIP string, haveDNSDaemonset bool, dnsDaemonset *appsv1.DaemonSet, haveNodeResolverDaemonset bool, nodeResolverDaemonset *appsv1.DaemonSet, transitionUnchangedToleration time.Duration, currentTime time.Time, reconcileResult *reconcile.Result) operatorv1.OperatorCondition {
+func computeDNSProgressingCondition(oldCondition *operatorv1.OperatorCondition, dns *operatorv1.DNS, inputs *dnsStatusInputs, transitionUnchangedToleration time.Duration, currentTime time.Time, reconcileResult *reconcile.Result) operatorv1.OperatorCondition {
 	progressingCondition := &operatorv1.OperatorCondition{
 		Type: operatorv1.OperatorStatusTypeProgressing,
 	}
 	messages := []string{}
-	if len(clusterIP) == 0 {
+	if len(inputs.clusterIP) == 0 {
 		messages = append(messages, "No IP address is assigned to the DNS service.")
 	}
-	if !haveDNSDaemonset {
+	if !inputs.haveDNSDaemonset {
 		messages = append(messages, "The DNS daemonset does not exist.")
 	} else {
-		want := dnsDaemonset.Status.DesiredNumberScheduled // num of nodes that should be running the pod.
-		have := dnsDaemonset.Status.UpdatedNumberScheduled // num of nodes running the updated pod.
+		want := inputs.dnsDaemonset.Status.DesiredNumberScheduled // num of nodes that should be running the pod.
+		have := inputs.dnsDaemonset.Status.UpdatedNumberScheduled // num of nodes running the updated pod.
 		// It's progressing when have < want.  If have >= want, that's okay.
 		if have < want {
 			messages = append(messages, fmt.Sprintf("Have %d up-to-date DNS pods, want %d.", have, want))
+			if message := rolloutMessage(inputs.dnsDaemonset); len(message) != 0 {
+				messages = append(messages, message)
+			}
 		}
 
-		haveSelector := dnsDaemonset.Spec.Template.Spec.NodeSelector
+		haveSelector := inputs.dnsDaemonset.Spec.Template.Spec.NodeSelector
 		wantSelector := nodeSelectorForDNS(dns)
 		if !reflect.DeepEqual(haveSelector, wantSelector) {
 			messages = append(messages, fmt.Sprintf("Have DNS daemonset with node selector %+v, want %+v.", haveSelector, wantSelector))
 		}
 
-		haveTolerations := dnsDaemonset.Spec.Template.Spec.Tolerations
+		haveTolerations := inputs.dnsDaemonset.Spec.Template.Spec.Tolerations
 		wantTolerations := tolerationsForDNS(dns)
 		if !reflect.DeepEqual(haveTolerations, wantTolerations) {
 			messages = append(messages, fmt.Sprintf("Have DNS daemonset with tolerations %+v, want %+v.", haveTolerations, wantTolerations))
 		}
 	}
-	if !haveNodeResolverDaemonset {
+	if !inputs.haveNodeResolverDaemonset {
 		messages = append(messages, "The node-resolver daemonset does not exist.")
 	} else {
-		want := nodeResolverDaemonset.Status.DesiredNumberScheduled // num of nodes that should be running the pod.
-		have := nodeResolverDaemonset.Status.UpdatedNumberScheduled // num of nodes running the updated pod.
+		want := inputs.nodeResolverDaemonset.Status.DesiredNumberScheduled // num of nodes that should be running the pod.
+		have := inputs.nodeResolverDaemonset.Status.UpdatedNumberScheduled // num of nodes running the updated pod.
 
 		// It's progressing when have < want.  If have >= want, that's okay.
 		if have < want {
 			messages = append(messages, fmt.Sprintf("Have %d available node-resolver pods, want %d.", have, want))
 		}
 	}
+	if inputs.canaryStatus.Phase == corefileCanaryPhaseSoaking {
+		messages = append(messages, inputs.canaryStatus.Message)
+	}
+	messages = append(messages, inputs.nodePoolMessages...)
 	if len(messages) != 0 {
 		// if the last status was set to false within the last transitionUnchangedToleration, skip the new update
 		// to prevent frequent status flaps, and try to keep the long-lasting state (i.e. Progressing=False). See https://bugzilla.redhat.com/show_bug.cgi?id=2037190.
@@ -244,7 +378,16 @@ func computeDNSProgressingCondition(oldCondition *operatorv1.OperatorCondition,
 		}
 		progressingCondition.Status = operatorv1.ConditionTrue
 		progressingCondition.Reason = "Reconciling"
+		if inputs.canaryStatus.Phase == corefileCanaryPhaseSoaking {
+			progressingCondition.Reason = "CanarySoaking"
+		}
 		progressingCondition.Message = strings.Join(messages, "\n")
+	} else if inputs.canaryStatus.Phase == corefileCanaryPhaseRolledBack {
+		// The rolled back Corefile change will not progress until
+		// the DNS's configuration changes again.
+		progressingCondition.Status = operatorv1.ConditionFalse
+		progressingCondition.Reason = "CanaryRolledBack"
+		progressingCondition.Message = inputs.canaryStatus.Message
 	} else {
 		progressingCondition.Status = operatorv1.ConditionFalse
 		progressingCondition.Reason = "AsExpected"
@@ -255,24 +398,40 @@ func computeDNSProgressingCondition(oldCondition *operatorv1.OperatorCondition,
 }
 
 // computeDNSAvailableCondition computes the dns Available status condition
-// based on the status of clusterIP and the DNS daemonset.
-func computeDNSAvailableCondition(oldCondition *operatorv1.OperatorCondition, clusterIP string, haveDNSDaemonset bool, dnsDaemonset *appsv1.DaemonSet) operatorv1.OperatorCondition {
+// based on the status of clusterIP, the DNS daemonset, and, if the node-local
+// cache is enabled, the node-local cache daemonset.
+func computeDNSAvailableCondition(oldCondition *operatorv1.OperatorCondition, dns *operatorv1.DNS, inputs *dnsStatusInputs) operatorv1.OperatorCondition {
+	// An invalid node-local cache configuration is reported by the
+	// reconciler; for the purpose of status, treat it as disabled.
+	nodeLocalCache, _ := nodeLocalCacheConfigForDNS(dns)
 	availableCondition := &operatorv1.OperatorCondition{
 		Type: operatorv1.OperatorStatusTypeAvailable,
 	}
 	unavailableReasons := []string{}
 	messages := []string{}
-	if !haveDNSDaemonset {
+	if !inputs.haveDNSDaemonset {
 		unavailableReasons = append(unavailableReasons, "NoDaemonSet")
 		messages = append(messages, "The DNS daemonset does not exist.")
-	} else if dnsDaemonset.Status.NumberAvailable == 0 {
+	} else if inputs.dnsDaemonset.Status.NumberAvailable == 0 {
 		unavailableReasons = append(unavailableReasons, "NoDaemonSetPods")
 		messages = append(messages, "The DNS daemonset has no pods available.")
 	}
-	if len(clusterIP) == 0 {
+	if len(inputs.clusterIP) == 0 {
 		unavailableReasons = append(unavailableReasons, "NoService")
 		messages = append(messages, "No IP address is assigned to the DNS service.")
 	}
+	if nodeLocalCache.Enabled {
+		// Pods that are configured to use the node-local cache
+		// cannot resolve names on nodes where no cache pod is
+		// available.
+		if !inputs.haveNodeLocalCacheDaemonset {
+			unavailableReasons = append(unavailableReasons, "NoNodeLocalCacheDaemonSet")
+			messages = append(messages, "The node-local DNS cache daemonset does not exist.")
+		} else if inputs.nodeLocalCacheDaemonset.Status.NumberAvailable == 0 && inputs.nodeLocalCacheDaemonset.Status.DesiredNumberScheduled != 0 {
+			unavailableReasons = append(unavailableReasons, "NoNodeLocalCacheDaemonSetPods")
+			messages = append(messages, "The node-local DNS cache daemonset has no pods available.")
+		}
+	}
 	if len(unavailableReasons) != 0 {
 		availableCondition.Status = operatorv1.ConditionFalse
 		availableCondition.Reason = strings.Join(unavailableReasons, "")
@@ -295,6 +454,10 @@ func computeDNSUpgradeableCondition(oldCondition *operatorv1.OperatorCondition,
 		upgradeableCondition.Status = operatorv1.ConditionFalse
 		upgradeableCondition.Reason = "OperatorUnmanaged"
 		upgradeableCondition.Message = "Cannot upgrade while managementState is Unmanaged"
+	} else if message := unsupportedConfigOverridesMessage(dns); len(message) != 0 {
+		upgradeableCondition.Status = operatorv1.ConditionFalse
+		upgradeableCondition.Reason = "UnsupportedConfigOverrides"
+		upgradeableCondition.Message = "Cannot upgrade while unsupported config overrides are present. " + message
 	} else {
 		upgradeableCondition.Status = operatorv1.ConditionTrue
 		upgradeableCondition.Reason = "AsExpected"
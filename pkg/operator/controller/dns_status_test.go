@@ -2,6 +2,7 @@ package controller
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,6 +15,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
 	utilclock "k8s.io/utils/clock"
 	utilclocktesting "k8s.io/utils/clock/testing"
 )
@@ -175,7 +177,14 @@ func TestDNSStatusConditions(t *testing.T) {
 				Status: upgradeable,
 			},
 		}
-		actual, _ := computeDNSStatusConditions(&dns, clusterIP, tc.inputs.haveDNS, dnsDaemonset, tc.inputs.haveNR, nodeResolverDaemonset, 0, &reconcile.Result{})
+		inputs := &dnsStatusInputs{
+			clusterIP:                 clusterIP,
+			haveDNSDaemonset:          tc.inputs.haveDNS,
+			dnsDaemonset:              dnsDaemonset,
+			haveNodeResolverDaemonset: tc.inputs.haveNR,
+			nodeResolverDaemonset:     nodeResolverDaemonset,
+		}
+		actual, _ := computeDNSStatusConditions(&dns, inputs, 0, &reconcile.Result{})
 		gotExpected := true
 		if len(actual) != len(expected) {
 			gotExpected = false
@@ -427,7 +436,7 @@ func TestComputeDNSDegradedCondition(t *testing.T) {
 	}
 
 	for _, tc := range testCases {
-		actual, retryErr := computeDNSDegradedCondition(tc.oldDegradedCondition, tc.newProgressingCondition, tc.clusterIP, true, tc.dnsDaemonset, 0, time.Time{})
+		actual, retryErr := computeDNSDegradedCondition(tc.oldDegradedCondition, tc.newProgressingCondition, &dnsStatusInputs{clusterIP: tc.clusterIP, haveDNSDaemonset: true, dnsDaemonset: tc.dnsDaemonset}, 0, time.Time{})
 		switch e := retryErr.(type) {
 		case retryable.Error:
 			if !tc.expectRequeue {
@@ -450,6 +459,68 @@ func TestComputeDNSDegradedCondition(t *testing.T) {
 	}
 }
 
+// TestComputeDNSDegradedConditionMaxUnavailable verifies that
+// computeDNSDegradedCondition reports too many unavailable DNS pods based on
+// the DNS daemonset's maxUnavailable value, or 10% if it has none.
+func TestComputeDNSDegradedConditionMaxUnavailable(t *testing.T) {
+	one := intstr.FromInt32(1)
+	two := intstr.FromInt32(2)
+	quarter := intstr.FromString("25%")
+	testCases := []struct {
+		name           string
+		rollingUpdate  *appsv1.RollingUpdateDaemonSet
+		expectedStatus operatorv1.ConditionStatus
+	}{
+		{
+			name:           "no rolling update strategy",
+			expectedStatus: operatorv1.ConditionTrue,
+		},
+		{
+			name:           "no maxUnavailable",
+			rollingUpdate:  &appsv1.RollingUpdateDaemonSet{},
+			expectedStatus: operatorv1.ConditionTrue,
+		},
+		{
+			name:           "maxUnavailable 1",
+			rollingUpdate:  &appsv1.RollingUpdateDaemonSet{MaxUnavailable: &one},
+			expectedStatus: operatorv1.ConditionTrue,
+		},
+		{
+			name:           "maxUnavailable 2",
+			rollingUpdate:  &appsv1.RollingUpdateDaemonSet{MaxUnavailable: &two},
+			expectedStatus: operatorv1.ConditionFalse,
+		},
+		{
+			name:           "maxUnavailable 25%",
+			rollingUpdate:  &appsv1.RollingUpdateDaemonSet{MaxUnavailable: &quarter},
+			expectedStatus: operatorv1.ConditionFalse,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dnsDaemonset := &appsv1.DaemonSet{
+				Spec: appsv1.DaemonSetSpec{
+					UpdateStrategy: appsv1.DaemonSetUpdateStrategy{
+						RollingUpdate: tc.rollingUpdate,
+					},
+				},
+				Status: appsv1.DaemonSetStatus{
+					DesiredNumberScheduled: 10,
+					NumberAvailable:        8,
+				},
+			}
+			progressing := &operatorv1.OperatorCondition{
+				Type:   operatorv1.OperatorStatusTypeProgressing,
+				Status: operatorv1.ConditionFalse,
+			}
+			actual, _ := computeDNSDegradedCondition(nil, progressing, &dnsStatusInputs{clusterIP: "172.30.0.10", haveDNSDaemonset: true, dnsDaemonset: dnsDaemonset}, 0, time.Time{})
+			if actual.Status != tc.expectedStatus {
+				t.Errorf("expected status %s, got %+v", tc.expectedStatus, actual)
+			}
+		})
+	}
+}
+
 // TestComputeDNSProgressingCondition verifies the
 // computeDNSProgressingCondition has the expected behavior.
 func TestComputeDNSProgressingCondition(t *testing.T) {
@@ -611,7 +682,7 @@ func TestComputeDNSProgressingCondition(t *testing.T) {
 				},
 			}
 			var reconcileResult reconcile.Result
-			actual := computeDNSProgressingCondition(&oldCondition, dns, tc.clusterIP, true, tc.dnsDaemonset, true, tc.nrDaemonset, 0, time.Time{}, &reconcileResult)
+			actual := computeDNSProgressingCondition(&oldCondition, dns, &dnsStatusInputs{clusterIP: tc.clusterIP, haveDNSDaemonset: true, dnsDaemonset: tc.dnsDaemonset, haveNodeResolverDaemonset: true, nodeResolverDaemonset: tc.nrDaemonset}, 0, time.Time{}, &reconcileResult)
 			if actual.Status != tc.expected {
 				t.Errorf("%q: expected status to be %s, got %s: %#v", tc.name, tc.expected, actual.Status, actual)
 			}
@@ -797,12 +868,12 @@ func TestSkippingStatusUpdates(t *testing.T) {
 			var actualReconcileResult reconcile.Result
 			var retryErr error
 			if tc.oldCondition.Type == operatorv1.OperatorStatusTypeProgressing {
-				actual = computeDNSProgressingCondition(&tc.oldCondition, dns, tc.clusterIP, true, tc.dnsDaemonset, true, tc.nrDaemonset, tc.toleration, tc.currentTime, &actualReconcileResult)
+				actual = computeDNSProgressingCondition(&tc.oldCondition, dns, &dnsStatusInputs{clusterIP: tc.clusterIP, haveDNSDaemonset: true, dnsDaemonset: tc.dnsDaemonset, haveNodeResolverDaemonset: true, nodeResolverDaemonset: tc.nrDaemonset}, tc.toleration, tc.currentTime, &actualReconcileResult)
 				if actualReconcileResult != tc.reconcileResult {
 					t.Errorf("%q: expected requeue to be %+v, got %+v", tc.name, tc.reconcileResult, actualReconcileResult)
 				}
 			} else {
-				actual, retryErr = computeDNSDegradedCondition(&tc.oldCondition, &tc.progressingCondition, tc.clusterIP, true, tc.dnsDaemonset, tc.toleration, tc.currentTime)
+				actual, retryErr = computeDNSDegradedCondition(&tc.oldCondition, &tc.progressingCondition, &dnsStatusInputs{clusterIP: tc.clusterIP, haveDNSDaemonset: true, dnsDaemonset: tc.dnsDaemonset}, tc.toleration, tc.currentTime)
 				switch e := retryErr.(type) {
 				case retryable.Error:
 					if !tc.reconcileResult.Requeue {
@@ -1049,14 +1120,14 @@ func TestTransitionTimeIsRecent(t *testing.T) {
 			description: "not recent: currTime is before lastTime",
 			currTime:    time.Date(2022, time.Month(4), 19, 1, 10, 42, 0, time.UTC),
 			prevTime:    time.Date(2022, time.Month(5), 19, 1, 10, 20, 0, time.UTC),
-			toleration:  lameDuckDuration,
+			toleration:  defaultLameDuckDuration,
 			isRecent:    false,
 		},
 		{
 			description: "not recent: a month ago",
 			currTime:    time.Date(2022, time.Month(5), 19, 1, 10, 42, 0, time.UTC),
 			prevTime:    time.Date(2022, time.Month(4), 19, 1, 10, 20, 0, time.UTC),
-			toleration:  lameDuckDuration,
+			toleration:  defaultLameDuckDuration,
 			isRecent:    false,
 		},
 	}
@@ -1070,3 +1141,232 @@ func TestTransitionTimeIsRecent(t *testing.T) {
 		})
 	}
 }
+
+// TestComputeDNSAvailableConditionNodeLocalCache verifies that the Available
+// condition takes the node-local cache daemonset into account if, and only
+// if, the node-local cache is enabled.
+func TestComputeDNSAvailableConditionNodeLocalCache(t *testing.T) {
+	dnsDaemonset := &appsv1.DaemonSet{
+		Status: appsv1.DaemonSetStatus{
+			DesiredNumberScheduled: 3,
+			NumberAvailable:        3,
+		},
+	}
+	makeDaemonSet := func(desired, available int32) *appsv1.DaemonSet {
+		return &appsv1.DaemonSet{
+			Status: appsv1.DaemonSetStatus{
+				DesiredNumberScheduled: desired,
+				NumberAvailable:        available,
+			},
+		}
+	}
+	testCases := []struct {
+		name               string
+		wantNodeLocalCache bool
+		nodeLocalCacheDS   *appsv1.DaemonSet
+		expectedStatus     operatorv1.ConditionStatus
+		expectedReason     string
+	}{
+		{
+			name:           "node-local cache disabled",
+			expectedStatus: operatorv1.ConditionTrue,
+			expectedReason: "AsExpected",
+		},
+		{
+			name:               "node-local cache enabled and available",
+			wantNodeLocalCache: true,
+			nodeLocalCacheDS:   makeDaemonSet(3, 2),
+			expectedStatus:     operatorv1.ConditionTrue,
+			expectedReason:     "AsExpected",
+		},
+		{
+			name:               "node-local cache enabled without daemonset",
+			wantNodeLocalCache: true,
+			expectedStatus:     operatorv1.ConditionFalse,
+			expectedReason:     "NoNodeLocalCacheDaemonSet",
+		},
+		{
+			name:               "node-local cache enabled without available pods",
+			wantNodeLocalCache: true,
+			nodeLocalCacheDS:   makeDaemonSet(3, 0),
+			expectedStatus:     operatorv1.ConditionFalse,
+			expectedReason:     "NoNodeLocalCacheDaemonSetPods",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dns := &operatorv1.DNS{}
+			if tc.wantNodeLocalCache {
+				dns.Annotations = map[string]string{nodeLocalCacheAnnotationKey: `{"enabled":true}`}
+			}
+			inputs := &dnsStatusInputs{
+				clusterIP:                   "1.2.3.4",
+				haveDNSDaemonset:            true,
+				dnsDaemonset:                dnsDaemonset,
+				haveNodeLocalCacheDaemonset: tc.nodeLocalCacheDS != nil,
+				nodeLocalCacheDaemonset:     tc.nodeLocalCacheDS,
+			}
+			actual := computeDNSAvailableCondition(nil, dns, inputs)
+			if actual.Status != tc.expectedStatus || actual.Reason != tc.expectedReason {
+				t.Errorf("expected status %s with reason %q, got %s with reason %q", tc.expectedStatus, tc.expectedReason, actual.Status, actual.Reason)
+			}
+		})
+	}
+}
+
+func TestComputeDNSProgressingConditionCorefileCanary(t *testing.T) {
+	dns := &operatorv1.DNS{}
+	dnsDaemonset := &appsv1.DaemonSet{
+		Spec: appsv1.DaemonSetSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					NodeSelector: nodeSelectorForDNS(dns),
+					Tolerations:  tolerationsForDNS(dns),
+				},
+			},
+		},
+		Status: appsv1.DaemonSetStatus{
+			DesiredNumberScheduled: 3,
+			UpdatedNumberScheduled: 3,
+		},
+	}
+	nrDaemonset := &appsv1.DaemonSet{
+		Status: appsv1.DaemonSetStatus{
+			DesiredNumberScheduled: 3,
+			UpdatedNumberScheduled: 3,
+		},
+	}
+	testCases := []struct {
+		name           string
+		canaryStatus   corefileCanaryStatus
+		expectedStatus operatorv1.ConditionStatus
+		expectedReason string
+	}{
+		{
+			name:           "no corefile change",
+			expectedStatus: operatorv1.ConditionFalse,
+			expectedReason: "AsExpected",
+		},
+		{
+			name:           "corefile change soaking",
+			canaryStatus:   corefileCanaryStatus{Phase: corefileCanaryPhaseSoaking, Message: "soaking"},
+			expectedStatus: operatorv1.ConditionTrue,
+			expectedReason: "CanarySoaking",
+		},
+		{
+			name:           "corefile change rolled back",
+			canaryStatus:   corefileCanaryStatus{Phase: corefileCanaryPhaseRolledBack, Message: "rolled back"},
+			expectedStatus: operatorv1.ConditionFalse,
+			expectedReason: "CanaryRolledBack",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual := computeDNSProgressingCondition(nil, dns, &dnsStatusInputs{clusterIP: "1.2.3.4", haveDNSDaemonset: true, dnsDaemonset: dnsDaemonset, haveNodeResolverDaemonset: true, nodeResolverDaemonset: nrDaemonset, canaryStatus: tc.canaryStatus}, 0, time.Time{}, &reconcile.Result{})
+			if actual.Status != tc.expectedStatus || actual.Reason != tc.expectedReason {
+				t.Errorf("expected status %s with reason %q, got %s with reason %q", tc.expectedStatus, tc.expectedReason, actual.Status, actual.Reason)
+			}
+			if len(tc.canaryStatus.Message) != 0 && !strings.Contains(actual.Message, tc.canaryStatus.Message) {
+				t.Errorf("expected message to contain %q, got %q", tc.canaryStatus.Message, actual.Message)
+			}
+		})
+	}
+}
+
+// TestDNSStatusConditionsFeatureConditions verifies that the status conditions
+// of optional features are reported if, and only if, the DNS has the feature
+// configured.
+func TestDNSStatusConditionsFeatureConditions(t *testing.T) {
+	featureTypes := []string{
+		DNS64SuggestedConditionType,
+		InvalidConfigurationConditionType,
+		UpstreamsDegradedConditionType,
+		TLSPreflightFailedConditionType,
+		CABundleExpiringConditionType,
+		ResourceRecommendationPendingConditionType,
+	}
+	dnsDaemonset := &appsv1.DaemonSet{
+		Status: appsv1.DaemonSetStatus{
+			DesiredNumberScheduled: 3,
+			NumberAvailable:        3,
+			UpdatedNumberScheduled: 3,
+		},
+	}
+	testCases := []struct {
+		name          string
+		annotations   map[string]string
+		servers       []operatorv1.Server
+		mutateInputs  func(*dnsStatusInputs)
+		expectedTypes []string
+	}{
+		{
+			name: "no features configured",
+		},
+		{
+			name:          "IPv6-only service network",
+			mutateInputs:  func(inputs *dnsStatusInputs) { inputs.ipv6OnlyServiceNetwork = true },
+			expectedTypes: []string{DNS64SuggestedConditionType},
+		},
+		{
+			name:          "servers",
+			servers:       []operatorv1.Server{{Name: "foo", Zones: []string{"foo.com"}, ForwardPlugin: operatorv1.ForwardPlugin{Upstreams: []string{"1.1.1.1"}}}},
+			expectedTypes: []string{InvalidConfigurationConditionType},
+		},
+		{
+			name:          "upstream probes",
+			annotations:   map[string]string{upstreamProbesAnnotationKey: `{"enabled":true}`},
+			expectedTypes: []string{UpstreamsDegradedConditionType},
+		},
+		{
+			name: "pre-flight TLS handshakes",
+			mutateInputs: func(inputs *dnsStatusInputs) {
+				inputs.tlsPreflightStatus = &tlsPreflightStatus{Policy: tlsPreflightPolicyWarn}
+			},
+			expectedTypes: []string{TLSPreflightFailedConditionType},
+		},
+		{
+			name: "CA bundles",
+			mutateInputs: func(inputs *dnsStatusInputs) {
+				inputs.caBundleExpiries = []caBundleExpiry{{Name: "ca", NotAfter: time.Now().Add(365 * 24 * time.Hour)}}
+			},
+			expectedTypes: []string{CABundleExpiringConditionType},
+		},
+		{
+			name:          "autosizing",
+			annotations:   map[string]string{dnsResourcesAnnotationKey: `{"mode":"Recommend"}`},
+			expectedTypes: []string{ResourceRecommendationPendingConditionType},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dns := &operatorv1.DNS{
+				ObjectMeta: metav1.ObjectMeta{Name: DefaultDNSController, Annotations: tc.annotations},
+				Spec:       operatorv1.DNSSpec{Servers: tc.servers},
+			}
+			inputs := &dnsStatusInputs{
+				clusterIP:                 "172.30.0.10",
+				clusterDomain:             "cluster.local",
+				haveDNSDaemonset:          true,
+				dnsDaemonset:              dnsDaemonset,
+				haveNodeResolverDaemonset: true,
+				nodeResolverDaemonset:     dnsDaemonset,
+			}
+			if tc.mutateInputs != nil {
+				tc.mutateInputs(inputs)
+			}
+			conditions, _ := computeDNSStatusConditions(dns, inputs, 0, &reconcile.Result{})
+			actual := sets.NewString()
+			for _, condition := range conditions {
+				actual.Insert(condition.Type)
+			}
+			expected := sets.NewString(tc.expectedTypes...)
+			for _, conditionType := range featureTypes {
+				if actual.Has(conditionType) != expected.Has(conditionType) {
+					t.Errorf("expected %s to be reported: %t, got conditions %v", conditionType, expected.Has(conditionType), actual.List())
+				}
+			}
+		})
+	}
+}
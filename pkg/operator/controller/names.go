@@ -15,6 +15,30 @@ const (
 	// identifies the node resolver daemonset.
 	nodeResolverDaemonSetLabelName = "dns.operator.openshift.io/daemonset-node-resolver"
 
+	// nodeLocalCacheDaemonSetLabel identifies a daemonset as a node-local
+	// dns cache daemonset, and the value is the name of the owning dns.
+	nodeLocalCacheDaemonSetLabel = "dns.operator.openshift.io/daemonset-node-local-cache"
+
+	// corefileCanaryDaemonSetLabel identifies a daemonset as a corefile
+	// canary daemonset, and the value is the name of the owning dns.
+	corefileCanaryDaemonSetLabel = "dns.operator.openshift.io/daemonset-dns-canary"
+
+	// nodePoolDaemonSetLabel identifies a daemonset or configmap as
+	// belonging to a dns node pool, and the value is the name of the node
+	// pool.
+	nodePoolDaemonSetLabel = "dns.operator.openshift.io/daemonset-dns-node-pool"
+
+	// controllerDeploymentLabel identifies a pod as a pod of a dns
+	// deployment, and the value is the name of the owning dns.
+	controllerDeploymentLabel = "dns.operator.openshift.io/deployment-dns"
+
+	// dnsServingLabel identifies a pod as one that answers queries sent to
+	// the dns service, and the value is the name of the owning dns.  The
+	// pods of the dns daemonset, the dns deployment, the corefile canary
+	// daemonset, and the node pool daemonsets have this label in addition
+	// to the label that their own workload selects.
+	dnsServingLabel = "dns.operator.openshift.io/serves-dns"
+
 	// MetricsServingCertAnnotation is the annotation needed to generate
 	// the certificates for secure DNS metrics.
 	MetricsServingCertAnnotation = "service.beta.openshift.io/serving-cert-secret-name"
@@ -45,6 +69,11 @@ const (
 
 	// DefaultFeatureGate gives the name of the default feature gate object.
 	DefaultFeatureGate = "cluster"
+
+	// ClusterDNSConfigMap is the name of the configmap in the
+	// openshift-config namespace that holds cluster-wide DNS settings
+	// such as the cluster domain.
+	ClusterDNSConfigMap = "cluster-dns"
 )
 
 // DNSClusterOperatorName returns the namespaced name of the ClusterOperator
@@ -96,6 +125,44 @@ func DNSDaemonSetPodSelector(dns *operatorv1.DNS) *metav1.LabelSelector {
 	}
 }
 
+// DNSDeploymentName returns the namespaced name for the dns deployment, which
+// runs CoreDNS in place of the dns daemonset in the Deployment workload mode.
+func DNSDeploymentName(dns *operatorv1.DNS) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: DefaultOperandNamespace,
+		Name:      "dns-" + dns.Name,
+	}
+}
+
+// DNSDeploymentPodSelector is the label selector for pods of the dns
+// deployment.
+func DNSDeploymentPodSelector(dns *operatorv1.DNS) *metav1.LabelSelector {
+	return &metav1.LabelSelector{
+		MatchLabels: map[string]string{
+			controllerDeploymentLabel: DNSDaemonSetLabel(dns),
+		},
+	}
+}
+
+// DNSServingPodSelector is the label selector for all pods that answer queries
+// sent to the dns service, whichever workload runs them.
+func DNSServingPodSelector(dns *operatorv1.DNS) *metav1.LabelSelector {
+	return &metav1.LabelSelector{
+		MatchLabels: map[string]string{
+			dnsServingLabel: DNSDaemonSetLabel(dns),
+		},
+	}
+}
+
+// DNSPodDisruptionBudgetName returns the namespaced name for the pod
+// disruption budget of the dns deployment.
+func DNSPodDisruptionBudgetName(dns *operatorv1.DNS) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: DefaultOperandNamespace,
+		Name:      "dns-" + dns.Name,
+	}
+}
+
 func DNSServiceName(dns *operatorv1.DNS) types.NamespacedName {
 	return types.NamespacedName{
 		Namespace: "openshift-dns",
@@ -103,6 +170,16 @@ func DNSServiceName(dns *operatorv1.DNS) types.NamespacedName {
 	}
 }
 
+// UpstreamProbesNetworkPolicyName returns the namespaced name for the network
+// policy that allows the operator to probe the upstream resolvers of the given
+// dns.
+func UpstreamProbesNetworkPolicyName(dns *operatorv1.DNS) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: DefaultOperatorNamespace,
+		Name:      "dns-operator-upstream-probes-" + dns.Name,
+	}
+}
+
 // DNSNetworkPolicyName returns the namespaced name for the dns pods allow network policy.
 func DNSNetworkPolicyName(dns *operatorv1.DNS) types.NamespacedName {
 	return types.NamespacedName{
@@ -118,11 +195,32 @@ func DNSConfigMapName(dns *operatorv1.DNS) types.NamespacedName {
 	}
 }
 
-// CABundleConfigMapName returns the namespaced name for the dns ca bundle config map.
-func CABundleConfigMapName(sourceName string) types.NamespacedName {
+// ClusterDNSConfigMapName returns the namespaced name for the configmap that
+// holds cluster-wide DNS settings.
+func ClusterDNSConfigMapName() types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: GlobalUserSpecifiedConfigNamespace,
+		Name:      ClusterDNSConfigMap,
+	}
+}
+
+// DNSNameResolverRoleName returns the namespaced name for the role and role
+// binding that grant CoreDNS access to DNSNameResolver resources in the given
+// namespace.
+func DNSNameResolverRoleName(dns *operatorv1.DNS, namespace string) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: namespace,
+		Name:      "dns-" + dns.Name + "-dnsnameresolver",
+	}
+}
+
+// CABundleConfigMapName returns the namespaced name for the given dns's copy
+// of the ca bundle config map with the given name.  Each dns has its own copy
+// so that dnses that refer to the same ca bundle do not share the copy.
+func CABundleConfigMapName(dns *operatorv1.DNS, sourceName string) types.NamespacedName {
 	return types.NamespacedName{
 		Namespace: "openshift-dns",
-		Name:      "ca-" + sourceName,
+		Name:      "ca-" + dns.Name + "-" + sourceName,
 	}
 }
 
@@ -155,6 +253,111 @@ func NodeResolverDaemonSetPodSelector() *metav1.LabelSelector {
 	}
 }
 
+// NodeLocalCacheDaemonSetName returns the namespaced name for the node-local
+// dns cache daemonset.
+func NodeLocalCacheDaemonSetName(dns *operatorv1.DNS) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: DefaultOperandNamespace,
+		Name:      "dns-" + dns.Name + "-node-local-cache",
+	}
+}
+
+// NodeLocalCacheConfigMapName returns the namespaced name for the configmap
+// with the Corefile for the node-local dns cache.
+func NodeLocalCacheConfigMapName(dns *operatorv1.DNS) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: DefaultOperandNamespace,
+		Name:      "dns-" + dns.Name + "-node-local-cache",
+	}
+}
+
+// NodeLocalCacheDaemonSetPodSelector is the label selector for node-local dns
+// cache pods.
+func NodeLocalCacheDaemonSetPodSelector(dns *operatorv1.DNS) *metav1.LabelSelector {
+	return &metav1.LabelSelector{
+		MatchLabels: map[string]string{
+			nodeLocalCacheDaemonSetLabel: DNSDaemonSetLabel(dns),
+		},
+	}
+}
+
+// CorefileCanaryDaemonSetName returns the namespaced name for the dns
+// daemonset that runs on corefile canary nodes.
+func CorefileCanaryDaemonSetName(dns *operatorv1.DNS) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: DefaultOperandNamespace,
+		Name:      "dns-" + dns.Name + "-canary",
+	}
+}
+
+// CorefileCanaryConfigMapName returns the namespaced name for the configmap
+// with the Corefile for corefile canary pods.
+func CorefileCanaryConfigMapName(dns *operatorv1.DNS) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: DefaultOperandNamespace,
+		Name:      "dns-" + dns.Name + "-canary",
+	}
+}
+
+// CorefileCanaryDaemonSetPodSelector is the label selector for corefile
+// canary pods.
+func CorefileCanaryDaemonSetPodSelector(dns *operatorv1.DNS) *metav1.LabelSelector {
+	return &metav1.LabelSelector{
+		MatchLabels: map[string]string{
+			corefileCanaryDaemonSetLabel: DNSDaemonSetLabel(dns),
+		},
+	}
+}
+
+// NodePoolDaemonSetName returns the namespaced name for the dns daemonset
+// that runs on the nodes of the given node pool.
+func NodePoolDaemonSetName(dns *operatorv1.DNS, pool string) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: DefaultOperandNamespace,
+		Name:      "dns-" + dns.Name + "-pool-" + pool,
+	}
+}
+
+// NodePoolConfigMapName returns the namespaced name for the configmap with
+// the Corefile for the pods of the given node pool.
+func NodePoolConfigMapName(dns *operatorv1.DNS, pool string) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: DefaultOperandNamespace,
+		Name:      "dns-" + dns.Name + "-pool-" + pool,
+	}
+}
+
+// NodePoolDaemonSetPodSelector is the label selector for the pods of the
+// given node pool.  Node pool names are unique only within a dns, so the
+// selector includes the serving label for the dns.
+func NodePoolDaemonSetPodSelector(dns *operatorv1.DNS, pool string) *metav1.LabelSelector {
+	return &metav1.LabelSelector{
+		MatchLabels: map[string]string{
+			dnsServingLabel:        DNSDaemonSetLabel(dns),
+			nodePoolDaemonSetLabel: pool,
+		},
+	}
+}
+
+// DNSPreviewConfigMapName returns the namespaced name for the configmap in
+// which the operator publishes a dry-run preview of a change to the dns.
+func DNSPreviewConfigMapName(dns *operatorv1.DNS) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: DefaultOperandNamespace,
+		Name:      "dns-" + dns.Name + "-preview",
+	}
+}
+
+// DriftHistoryConfigMapName returns the namespaced name for the configmap in
+// which the operator records modifications of the resources that it manages
+// for the dns.
+func DriftHistoryConfigMapName(dns *operatorv1.DNS) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: DefaultOperandNamespace,
+		Name:      "dns-" + dns.Name + "-drift-history",
+	}
+}
+
 // FeatureGateClusterConfigName returns the namespaced name of the
 // featuregates.config.openshift.io resource of the cluster.
 func FeatureGateClusterConfigName() types.NamespacedName {
@@ -3,6 +3,7 @@ package status
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/google/go-cmp/cmp"
@@ -89,6 +90,10 @@ func New(mgr manager.Manager, config operatorconfig.Config) (controller.Controll
 		return nil, err
 	}
 
+	if err := c.Watch(source.Kind[client.Object](operatorCache, &appsv1.Deployment{}, handler.EnqueueRequestForOwner(scheme, mapper, &operatorv1.DNS{}))); err != nil {
+		return nil, err
+	}
+
 	isDNSClusterOperator := func(o client.Object) bool {
 		return o.GetName() == operatorcontroller.DefaultOperatorName
 	}
@@ -154,6 +159,13 @@ func (r *reconciler) Reconcile(ctx context.Context, request reconcile.Request) (
 			Namespace: r.Config.OperatorNamespace,
 		},
 	}
+	for i := range state.additionalDNSes {
+		related = append(related, configv1.ObjectReference{
+			Group:    operatorv1.GroupName,
+			Resource: "dnses",
+			Name:     state.additionalDNSes[i].dns.Name,
+		})
+	}
 	if state.haveNamespace {
 		related = append(related, configv1.ObjectReference{
 			Resource: "namespaces",
@@ -191,10 +203,26 @@ func (r *reconciler) Reconcile(ctx context.Context, request reconcile.Request) (
 		state.dnsPodList.Items,
 		state.nodeResolverPodList.Items,
 	)
+	// An operand is at the new version only once every DNS's pods are.
+	additionalDNSes := make([]operatorv1.DNS, 0, len(state.additionalDNSes))
+	for i := range state.additionalDNSes {
+		instance := &state.additionalDNSes[i]
+		additionalDNSes = append(additionalDNSes, instance.dns)
+		instanceVersions := computeCurrentVersions(
+			oldVersions,
+			newVersions,
+			&instance.dnsDaemonSet,
+			&state.nodeResolverDaemonSet,
+			instance.dnsPodList.Items,
+			state.nodeResolverPodList.Items,
+		)
+		curVersions = mergeCurrentVersions(oldVersions, curVersions, instanceVersions)
+	}
 
 	operatorProgressingCondition := computeOperatorProgressingCondition(
 		state.haveDNS,
 		&state.dns,
+		additionalDNSes,
 		oldVersions,
 		newVersions,
 		curVersions,
@@ -202,10 +230,10 @@ func (r *reconciler) Reconcile(ctx context.Context, request reconcile.Request) (
 	co.Status.Conditions = mergeConditions(co.Status.Conditions,
 		computeOperatorAvailableCondition(state.haveDNS, &state.dns),
 		operatorProgressingCondition,
-		computeOperatorDegradedCondition(state.haveDNS, &state.dns, oldVersions, newVersions, curVersions),
+		computeOperatorDegradedCondition(state.haveDNS, &state.dns, additionalDNSes, oldVersions, newVersions, curVersions),
 	)
 	co.Status.Versions = computeOperatorStatusVersions(curVersions)
-	co.Status.Conditions = mergeConditions(co.Status.Conditions, computeOperatorUpgradeableCondition(&state.dns))
+	co.Status.Conditions = mergeConditions(co.Status.Conditions, computeOperatorUpgradeableCondition(&state.dns, additionalDNSes))
 
 	if !operatorStatusesEqual(*oldStatus, co.Status) {
 		if err := r.client.Status().Update(ctx, co); err != nil {
@@ -267,8 +295,9 @@ type operatorState struct {
 	// dns is the "default" dnses.operator.openshift.io CR if it exists.
 	dns operatorv1.DNS
 
-	// dnsDaemonSet is the "dns-default" daemonset if it exists.  If the
-	// daemonset does not exist, dnsDaemonSet will have the zero value.
+	// dnsDaemonSet is the "dns-default" daemonset if it exists, or else a
+	// daemonset that reflects the "dns-default" deployment if that exists.
+	// If neither exists, dnsDaemonSet will have the zero value.
 	dnsDaemonSet appsv1.DaemonSet
 
 	// nodeResolverDaemonSet is the "node-resolver" daemonset if it exists.
@@ -282,6 +311,23 @@ type operatorState struct {
 	// nodeResolverPodList is the list of pods belonging to
 	// nodeResolverDaemonSet.
 	nodeResolverPodList corev1.PodList
+
+	// additionalDNSes are the dnses.operator.openshift.io CRs other than
+	// "default", sorted by name.
+	additionalDNSes []dnsState
+}
+
+// dnsState is the state of a DNS other than the "default" DNS.
+type dnsState struct {
+	// dns is the dnses.operator.openshift.io CR.
+	dns operatorv1.DNS
+
+	// dnsDaemonSet is the DNS's daemonset, or else a daemonset that
+	// reflects the DNS's deployment, or the zero value if neither exists.
+	dnsDaemonSet appsv1.DaemonSet
+
+	// dnsPodList is the list of pods belonging to dnsDaemonSet.
+	dnsPodList corev1.PodList
 }
 
 // getOperatorState gets and returns the resources necessary to compute the
@@ -303,13 +349,23 @@ func (r *reconciler) getOperatorState() (operatorState, error) {
 	}
 
 	dnsList := operatorv1.DNSList{}
-	// TODO: Change to a get call when the following issue is resolved:
-	//       https://github.com/kubernetes-sigs/controller-runtime/issues/934
 	if err := r.cache.List(context.TODO(), &dnsList); err != nil {
 		return state, fmt.Errorf("failed to list dnses: %w", err)
-	} else if len(dnsList.Items) > 0 {
-		state.haveDNS = true
-		state.dns = dnsList.Items[0]
+	}
+	sort.Slice(dnsList.Items, func(i, j int) bool {
+		return dnsList.Items[i].Name < dnsList.Items[j].Name
+	})
+	for _, dns := range dnsList.Items {
+		if dns.Name == operatorcontroller.DefaultDNSName {
+			state.haveDNS = true
+			state.dns = dns
+			continue
+		}
+		instance := dnsState{dns: dns}
+		if err := r.getDNSWorkloadState(&instance.dns, &instance.dnsDaemonSet, &instance.dnsPodList); err != nil {
+			return state, err
+		}
+		state.additionalDNSes = append(state.additionalDNSes, instance)
 	}
 
 	nodeResolverDaemonSetName := operatorcontroller.NodeResolverDaemonSetName()
@@ -339,16 +395,42 @@ func (r *reconciler) getOperatorState() (operatorState, error) {
 		return state, nil
 	}
 
-	dnsDaemonSetName := operatorcontroller.DNSDaemonSetName(&state.dns)
-	if err := r.cache.Get(context.TODO(), dnsDaemonSetName, &state.dnsDaemonSet); err != nil {
+	if err := r.getDNSWorkloadState(&state.dns, &state.dnsDaemonSet, &state.dnsPodList); err != nil {
+		return state, err
+	}
+
+	return state, nil
+}
+
+// getDNSWorkloadState gets the given DNS's daemonset, or a daemonset that
+// reflects its deployment in the Deployment workload mode, and its pods.
+func (r *reconciler) getDNSWorkloadState(dns *operatorv1.DNS, daemonset *appsv1.DaemonSet, pods *corev1.PodList) error {
+	dnsDaemonSetName := operatorcontroller.DNSDaemonSetName(dns)
+	if err := r.cache.Get(context.TODO(), dnsDaemonSetName, daemonset); err != nil {
 		if !errors.IsNotFound(err) {
-			return state, fmt.Errorf("failed to get dns daemonset: %w", err)
+			return fmt.Errorf("failed to get dns daemonset %s: %w", dnsDaemonSetName, err)
+		}
+		// In the Deployment workload mode, the dns deployment runs
+		// CoreDNS instead of the dns daemonset.
+		deployment := &appsv1.Deployment{}
+		if err := r.cache.Get(context.TODO(), operatorcontroller.DNSDeploymentName(dns), deployment); err != nil {
+			if !errors.IsNotFound(err) {
+				return fmt.Errorf("failed to get dns deployment for dns %s: %w", dns.Name, err)
+			}
+		} else {
+			*daemonset = *operatorcontroller.DNSDeploymentAsDaemonSet(deployment)
 		}
 	}
 
-	dnsLabelSelector, err := metav1.LabelSelectorAsSelector(operatorcontroller.DNSDaemonSetPodSelector(&state.dns))
+	// Count only the pods of the workload, not those of the corefile
+	// canary or node pool daemonsets.
+	podSelector := operatorcontroller.DNSDaemonSetPodSelector(dns)
+	if daemonset.Spec.Selector != nil {
+		podSelector = daemonset.Spec.Selector
+	}
+	dnsLabelSelector, err := metav1.LabelSelectorAsSelector(podSelector)
 	if err != nil {
-		return state, err
+		return err
 	}
 
 	dnsPodsListOpts := []client.ListOption{
@@ -357,11 +439,11 @@ func (r *reconciler) getOperatorState() (operatorState, error) {
 		},
 		client.InNamespace(operatorcontroller.DefaultOperandNamespace),
 	}
-	if err := r.cache.List(context.TODO(), &state.dnsPodList, dnsPodsListOpts...); err != nil {
-		return state, fmt.Errorf("failed to list dns pods: %w", err)
+	if err := r.cache.List(context.TODO(), pods, dnsPodsListOpts...); err != nil {
+		return fmt.Errorf("failed to list pods for dns %s: %w", dns.Name, err)
 	}
 
-	return state, nil
+	return nil
 }
 
 // computeOperatorStatusVersions computes the operator's current versions.
@@ -387,28 +469,27 @@ func checkDNSAvailable(dns *operatorv1.DNS) bool {
 }
 
 // computeOperatorUpgradeableCondition computes the operator's current
-// Upgradeable status state by examining the Upgradeable status of the DNS
-// operator
-func computeOperatorUpgradeableCondition(dns *operatorv1.DNS) configv1.ClusterOperatorStatusCondition {
-	upgradeable := true
-	message := ""
-	for _, cond := range dns.Status.Conditions {
-		if cond.Type == operatorv1.OperatorStatusTypeUpgradeable {
-			upgradeable = cond.Status == operatorv1.ConditionTrue
-			message = cond.Message
+// Upgradeable status state by examining the Upgradeable status of the default
+// DNS and the additional DNSes.
+func computeOperatorUpgradeableCondition(dns *operatorv1.DNS, additionalDNSes []operatorv1.DNS) configv1.ClusterOperatorStatusCondition {
+	_, message := dnsUpgradeable(dns)
+	var notUpgradeable []string
+	for _, d := range append([]operatorv1.DNS{*dns}, additionalDNSes...) {
+		if ok, m := dnsUpgradeable(&d); !ok {
+			if len(m) > 0 {
+				notUpgradeable = append(notUpgradeable, fmt.Sprintf("DNS %s is not upgradeable: %s", d.Name, m))
+			} else {
+				notUpgradeable = append(notUpgradeable, fmt.Sprintf("DNS %s is not upgradeable", d.Name))
+			}
 		}
 	}
 	currentUpgradeableCondition := configv1.ClusterOperatorStatusCondition{
 		Type: configv1.OperatorUpgradeable,
 	}
-	if !upgradeable {
+	if len(notUpgradeable) != 0 {
 		currentUpgradeableCondition.Status = configv1.ConditionFalse
 		currentUpgradeableCondition.Reason = "DNSNotUpgradeable"
-		if len(message) > 0 {
-			currentUpgradeableCondition.Message = fmt.Sprintf("DNS %s is not upgradeable: %s", dns.Name, message)
-		} else {
-			currentUpgradeableCondition.Message = fmt.Sprintf("DNS %s is not upgradeable", dns.Name)
-		}
+		currentUpgradeableCondition.Message = strings.Join(notUpgradeable, "\n")
 	} else {
 		currentUpgradeableCondition.Status = configv1.ConditionTrue
 		currentUpgradeableCondition.Reason = "DNSUpgradeable"
@@ -421,9 +502,23 @@ func computeOperatorUpgradeableCondition(dns *operatorv1.DNS) configv1.ClusterOp
 	return currentUpgradeableCondition
 }
 
+// dnsUpgradeable returns a Boolean value indicating whether the given DNS does
+// not report Upgradeable=False and the message of its Upgradeable status
+// condition.
+func dnsUpgradeable(dns *operatorv1.DNS) (bool, string) {
+	upgradeable := true
+	message := ""
+	for _, cond := range dns.Status.Conditions {
+		if cond.Type == operatorv1.OperatorStatusTypeUpgradeable {
+			upgradeable = cond.Status == operatorv1.ConditionTrue
+			message = cond.Message
+		}
+	}
+	return upgradeable, message
+}
+
 // computeOperatorDegradedCondition computes the operator's current Degraded status state.
-func computeOperatorDegradedCondition(haveDNS bool, dns *operatorv1.DNS, oldVersions, newVersions, curVersions map[string]string) configv1.ClusterOperatorStatusCondition {
-	var messages []string
+func computeOperatorDegradedCondition(haveDNS bool, dns *operatorv1.DNS, additionalDNSes []operatorv1.DNS, oldVersions, newVersions, curVersions map[string]string) configv1.ClusterOperatorStatusCondition {
 
 	if !haveDNS {
 		return configv1.ClusterOperatorStatusCondition{
@@ -437,19 +532,24 @@ func computeOperatorDegradedCondition(haveDNS bool, dns *operatorv1.DNS, oldVers
 	// See OCPBUGS-14346.  If the operator is upgrading, we can't consider it as degraded.
 	upgrading, _ := isUpgrading(curVersions, oldVersions, newVersions)
 	if !upgrading {
-		var degraded bool
-		for _, cond := range dns.Status.Conditions {
-			if cond.Type == operatorv1.OperatorStatusTypeDegraded && cond.Status == operatorv1.ConditionTrue {
-				degraded = true
-				messages = append(messages, cond.Message)
+		var degradedDNSes []string
+		for _, d := range append([]operatorv1.DNS{*dns}, additionalDNSes...) {
+			var messages []string
+			for _, cond := range d.Status.Conditions {
+				if cond.Type == operatorv1.OperatorStatusTypeDegraded && cond.Status == operatorv1.ConditionTrue {
+					messages = append(messages, cond.Message)
+				}
+			}
+			if len(messages) != 0 {
+				degradedDNSes = append(degradedDNSes, fmt.Sprintf("DNS %s is degraded: %s", d.Name, strings.Join(messages, "\n")))
 			}
 		}
-		if degraded {
+		if len(degradedDNSes) != 0 {
 			return configv1.ClusterOperatorStatusCondition{
 				Type:    configv1.OperatorDegraded,
 				Status:  configv1.ConditionTrue,
 				Reason:  "DNSDegraded",
-				Message: fmt.Sprintf("DNS %s is degraded: %s", dns.Name, strings.Join(messages, "\n")),
+				Message: strings.Join(degradedDNSes, "\n"),
 			}
 		}
 	}
@@ -461,20 +561,33 @@ func computeOperatorDegradedCondition(haveDNS bool, dns *operatorv1.DNS, oldVers
 }
 
 // computeOperatorProgressingCondition computes the operator's current Progressing status state.
-func computeOperatorProgressingCondition(haveDNS bool, dns *operatorv1.DNS, oldVersions, newVersions, curVersions map[string]string) configv1.ClusterOperatorStatusCondition {
+func computeOperatorProgressingCondition(haveDNS bool, dns *operatorv1.DNS, additionalDNSes []operatorv1.DNS, oldVersions, newVersions, curVersions map[string]string) configv1.ClusterOperatorStatusCondition {
 	progressingCondition := configv1.ClusterOperatorStatusCondition{
 		Type: configv1.OperatorProgressing,
 	}
 	status := configv1.ConditionUnknown
 	var messages, progressingReasons []string
 
+	// addReason adds the given reason unless another DNS already added it.
+	addReason := func(reason string) {
+		for _, r := range progressingReasons {
+			if r == reason {
+				return
+			}
+		}
+		progressingReasons = append(progressingReasons, reason)
+	}
+	dnses := additionalDNSes
 	if !haveDNS {
 		status = configv1.ConditionTrue
-		progressingReasons = append(progressingReasons, "DNSDoesNotExist")
+		addReason("DNSDoesNotExist")
 		messages = append(messages, `DNS "default" does not exist`)
 	} else {
+		dnses = append([]operatorv1.DNS{*dns}, additionalDNSes...)
+	}
+	for _, d := range dnses {
 		foundProgressingCondition := false
-		for _, cond := range dns.Status.Conditions {
+		for _, cond := range d.Status.Conditions {
 			if cond.Type != operatorv1.OperatorStatusTypeProgressing {
 				continue
 			}
@@ -482,17 +595,17 @@ func computeOperatorProgressingCondition(haveDNS bool, dns *operatorv1.DNS, oldV
 			switch cond.Status {
 			case operatorv1.ConditionTrue:
 				status = configv1.ConditionTrue
-				progressingReasons = append(progressingReasons, "DNSReportsProgressingIsTrue")
-				messages = append(messages, fmt.Sprintf("DNS %q reports Progressing=True: %q", dns.Name, cond.Message))
+				addReason("DNSReportsProgressingIsTrue")
+				messages = append(messages, fmt.Sprintf("DNS %q reports Progressing=True: %q", d.Name, cond.Message))
 			case operatorv1.ConditionUnknown:
-				progressingReasons = append(progressingReasons, "DNSReportsProgressingIsUnknown")
-				messages = append(messages, fmt.Sprintf("DNS %q reports Progressing=Unknown: %q", dns.Name, cond.Message))
+				addReason("DNSReportsProgressingIsUnknown")
+				messages = append(messages, fmt.Sprintf("DNS %q reports Progressing=Unknown: %q", d.Name, cond.Message))
 			}
 			break
 		}
 		if !foundProgressingCondition {
-			progressingReasons = append(progressingReasons, "DNSDoesNotReportProgressingStatus")
-			messages = append(messages, fmt.Sprintf("DNS %q is not reporting a Progressing status condition", dns.Name))
+			addReason("DNSDoesNotReportProgressingStatus")
+			messages = append(messages, fmt.Sprintf("DNS %q is not reporting a Progressing status condition", d.Name))
 		}
 	}
 
@@ -625,6 +738,21 @@ func computeCurrentVersions(oldVersions, newVersions map[string]string, dnsDaemo
 	}
 }
 
+// mergeCurrentVersions returns a map of operand name to the version that the
+// given maps of current versions, computed for different DNSes, agree on, or
+// the old version if they disagree.
+func mergeCurrentVersions(oldVersions, a, b map[string]string) map[string]string {
+	result := make(map[string]string, len(a))
+	for name, version := range a {
+		if b[name] == version {
+			result[name] = version
+		} else {
+			result[name] = oldVersions[name]
+		}
+	}
+	return result
+}
+
 // computeOperatorAvailableCondition computes the operator's current Available status state.
 func computeOperatorAvailableCondition(haveDNS bool, dns *operatorv1.DNS) configv1.ClusterOperatorStatusCondition {
 	availableCondition := configv1.ClusterOperatorStatusCondition{
@@ -1,6 +1,7 @@
 package status
 
 import (
+	"strings"
 	"testing"
 	"time"
 
@@ -142,7 +143,7 @@ func TestComputeOperatorProgressingCondition(t *testing.T) {
 			Status: tc.expectProgressing,
 		}
 
-		actual := computeOperatorProgressingCondition(haveDNS, dns, oldVersions, newVersions, curVersions)
+		actual := computeOperatorProgressingCondition(haveDNS, dns, nil, oldVersions, newVersions, curVersions)
 		conditionsCmpOpts := []cmp.Option{
 			cmpopts.IgnoreFields(configv1.ClusterOperatorStatusCondition{}, "LastTransitionTime", "Reason", "Message"),
 		}
@@ -793,7 +794,7 @@ func TestComputeOperatorDegradedCondition(t *testing.T) {
 			Status: tc.expectDegraded,
 		}
 
-		actual := computeOperatorDegradedCondition(haveDNS, dns, oldVersions, newVersions, curVersions)
+		actual := computeOperatorDegradedCondition(haveDNS, dns, nil, oldVersions, newVersions, curVersions)
 		conditionsCmpOpts := []cmp.Option{
 			cmpopts.IgnoreFields(configv1.ClusterOperatorStatusCondition{}, "LastTransitionTime", "Reason", "Message"),
 		}
@@ -802,3 +803,88 @@ func TestComputeOperatorDegradedCondition(t *testing.T) {
 		}
 	}
 }
+
+// TestAdditionalDNSesConditions verifies that the operator's status conditions
+// aggregate the status conditions of the DNSes other than "default".
+func TestAdditionalDNSesConditions(t *testing.T) {
+	dnsWithConditions := func(name string, conditions ...operatorv1.OperatorCondition) operatorv1.DNS {
+		return operatorv1.DNS{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status:     operatorv1.DNSStatus{Conditions: conditions},
+		}
+	}
+	notProgressing := operatorv1.OperatorCondition{Type: operatorv1.OperatorStatusTypeProgressing, Status: operatorv1.ConditionFalse}
+	progressing := operatorv1.OperatorCondition{Type: operatorv1.OperatorStatusTypeProgressing, Status: operatorv1.ConditionTrue, Message: "Have 1 up-to-date DNS pods, want 3."}
+	degraded := operatorv1.OperatorCondition{Type: operatorv1.OperatorStatusTypeDegraded, Status: operatorv1.ConditionTrue, Message: "No DNS pods are available."}
+	notUpgradeable := operatorv1.OperatorCondition{Type: operatorv1.OperatorStatusTypeUpgradeable, Status: operatorv1.ConditionFalse, Message: "Unsupported overrides."}
+	versions := map[string]string{OperatorVersionName: "v1", CoreDNSVersionName: "dns-v1"}
+
+	dns := dnsWithConditions("default", notProgressing)
+	testCases := []struct {
+		description        string
+		additional         []operatorv1.DNS
+		expectProgressing  configv1.ConditionStatus
+		expectReason       string
+		expectDegraded     configv1.ConditionStatus
+		expectUpgradeable  configv1.ConditionStatus
+		expectMessageParts []string
+	}{
+		{
+			description:       "no additional dnses",
+			expectProgressing: configv1.ConditionFalse,
+			expectReason:      "AsExpected",
+			expectDegraded:    configv1.ConditionFalse,
+			expectUpgradeable: configv1.ConditionTrue,
+		},
+		{
+			description:        "additional dnses progressing",
+			additional:         []operatorv1.DNS{dnsWithConditions("a", progressing), dnsWithConditions("b", progressing)},
+			expectProgressing:  configv1.ConditionTrue,
+			expectReason:       "DNSReportsProgressingIsTrue",
+			expectDegraded:     configv1.ConditionFalse,
+			expectUpgradeable:  configv1.ConditionTrue,
+			expectMessageParts: []string{`DNS "a" reports Progressing=True`, `DNS "b" reports Progressing=True`},
+		},
+		{
+			description:        "additional dns degraded and not upgradeable",
+			additional:         []operatorv1.DNS{dnsWithConditions("tenant", notProgressing, degraded, notUpgradeable)},
+			expectProgressing:  configv1.ConditionFalse,
+			expectReason:       "AsExpected",
+			expectDegraded:     configv1.ConditionTrue,
+			expectUpgradeable:  configv1.ConditionFalse,
+			expectMessageParts: []string{"DNS tenant is degraded: No DNS pods are available.", "DNS tenant is not upgradeable: Unsupported overrides."},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			progressingCondition := computeOperatorProgressingCondition(true, &dns, tc.additional, versions, versions, versions)
+			degradedCondition := computeOperatorDegradedCondition(true, &dns, tc.additional, versions, versions, versions)
+			upgradeableCondition := computeOperatorUpgradeableCondition(&dns, tc.additional)
+			if progressingCondition.Status != tc.expectProgressing || progressingCondition.Reason != tc.expectReason {
+				t.Errorf("expected Progressing=%s with reason %q, got %+v", tc.expectProgressing, tc.expectReason, progressingCondition)
+			}
+			if degradedCondition.Status != tc.expectDegraded {
+				t.Errorf("expected Degraded=%s, got %+v", tc.expectDegraded, degradedCondition)
+			}
+			if upgradeableCondition.Status != tc.expectUpgradeable {
+				t.Errorf("expected Upgradeable=%s, got %+v", tc.expectUpgradeable, upgradeableCondition)
+			}
+			messages := strings.Join([]string{progressingCondition.Message, degradedCondition.Message, upgradeableCondition.Message}, "\n")
+			for _, part := range tc.expectMessageParts {
+				if !strings.Contains(messages, part) {
+					t.Errorf("expected messages to contain %q, got %q", part, messages)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeCurrentVersions(t *testing.T) {
+	oldVersions := map[string]string{OperatorVersionName: "v1", CoreDNSVersionName: "dns-v1"}
+	a := map[string]string{OperatorVersionName: "v2", CoreDNSVersionName: "dns-v2"}
+	b := map[string]string{OperatorVersionName: "v1", CoreDNSVersionName: "dns-v2"}
+	expected := map[string]string{OperatorVersionName: "v1", CoreDNSVersionName: "dns-v2"}
+	if actual := mergeCurrentVersions(oldVersions, a, b); !cmp.Equal(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
@@ -0,0 +1,188 @@
+package operator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	operatorv1 "github.com/openshift/api/operator/v1"
+	"github.com/openshift/cluster-dns-operator/pkg/manifests"
+	operatorcontroller "github.com/openshift/cluster-dns-operator/pkg/operator/controller"
+	dnsnameresolver "github.com/openshift/coredns-ocp-dnsnameresolver/operator/controller/dnsnameresolver"
+
+	"github.com/sirupsen/logrus"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	toolscache "k8s.io/client-go/tools/cache"
+
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// dnsNameResolverResyncInterval is how often the operator re-reads the
+// DNSNameResolver namespaces of the default DNS in addition to reacting to
+// changes of DNSes, so that namespaces whose controllers failed to start are
+// retried.
+const dnsNameResolverResyncInterval = time.Minute
+
+// dnsNameResolverControllers runs a DNSNameResolver controller for each
+// namespace in which CoreDNS watches DNSNameResolver resources according to the
+// default DNS.  Each controller has its own caches and resolver, which only
+// track DNSNameResolver resources in the controller's namespace.  Controllers
+// are started and stopped as the namespaces change, without restarting the
+// operator.  It is a manager runnable.
+type dnsNameResolverControllers struct {
+	manager manager.Manager
+
+	lock sync.Mutex
+	// namespaces maps each namespace with a running controller to the
+	// function that stops the controller and its caches.
+	namespaces map[string]context.CancelFunc
+}
+
+// newDNSNameResolverControllers returns a runnable that runs DNSNameResolver
+// controllers in the given manager.
+func newDNSNameResolverControllers(mgr manager.Manager) *dnsNameResolverControllers {
+	return &dnsNameResolverControllers{
+		manager:    mgr,
+		namespaces: map[string]context.CancelFunc{},
+	}
+}
+
+// Start runs the DNSNameResolver controllers until the given context is done.
+func (c *dnsNameResolverControllers) Start(ctx context.Context) error {
+	informer, err := c.manager.GetCache().GetInformer(ctx, &operatorv1.DNS{})
+	if err != nil {
+		return fmt.Errorf("failed to get dns informer: %w", err)
+	}
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	if _, err := informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc:    func(interface{}) { notify() },
+		UpdateFunc: func(interface{}, interface{}) { notify() },
+		DeleteFunc: func(interface{}) { notify() },
+	}); err != nil {
+		return fmt.Errorf("failed to watch dnses: %w", err)
+	}
+
+	ticker := time.NewTicker(dnsNameResolverResyncInterval)
+	defer ticker.Stop()
+	for {
+		c.sync(ctx)
+		select {
+		case <-ctx.Done():
+			c.lock.Lock()
+			defer c.lock.Unlock()
+			for ns, stop := range c.namespaces {
+				stop()
+				delete(c.namespaces, ns)
+			}
+			return nil
+		case <-changed:
+		case <-ticker.C:
+		}
+	}
+}
+
+// sync starts a controller for each namespace of the default DNS that does
+// not have one and stops the controllers for other namespaces.  If the
+// namespaces cannot be determined, the running controllers are left alone.
+func (c *dnsNameResolverControllers) sync(ctx context.Context) {
+	namespaces, err := c.desiredNamespaces(ctx)
+	if err != nil {
+		logrus.Errorf("failed to determine dnsnameresolver namespaces; keeping the current namespaces: %v", err)
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for ns, stop := range c.namespaces {
+		if !namespaces.Has(ns) {
+			stop()
+			delete(c.namespaces, ns)
+			logrus.Infof("stopped dnsnameresolver controller for namespace %s", ns)
+		}
+	}
+	for _, ns := range namespaces.List() {
+		if _, ok := c.namespaces[ns]; ok {
+			continue
+		}
+		stop, err := c.startController(ctx, ns)
+		if err != nil {
+			logrus.Errorf("failed to start dnsnameresolver controller for namespace %s: %v", ns, err)
+			continue
+		}
+		c.namespaces[ns] = stop
+		logrus.Infof("started dnsnameresolver controller for namespace %s", ns)
+	}
+}
+
+// desiredNamespaces returns the namespaces in which CoreDNS watches
+// DNSNameResolver resources according to the default DNS, or the operator's
+// default namespace if the default DNS does not exist.
+func (c *dnsNameResolverControllers) desiredNamespaces(ctx context.Context) (sets.String, error) {
+	defaults := []string{operatorcontroller.DefaultDNSNameResolverNamespace}
+	dns := &operatorv1.DNS{}
+	if err := c.manager.GetCache().Get(ctx, types.NamespacedName{Name: operatorcontroller.DefaultDNSController}, dns); err != nil {
+		if errors.IsNotFound(err) {
+			return sets.NewString(defaults...), nil
+		}
+		return nil, fmt.Errorf("failed to get default dns: %w", err)
+	}
+	namespaces, err := operatorcontroller.DNSNameResolverNamespaces(dns, defaults)
+	if err != nil {
+		return nil, err
+	}
+	return sets.NewString(namespaces...), nil
+}
+
+// startController creates a DNSNameResolver controller for the given namespace,
+// starts it and its caches, and returns a function that stops them.  The
+// resolver of a stopped controller keeps its goroutine, which no longer
+// receives DNS names from the controller.
+func (c *dnsNameResolverControllers) startController(ctx context.Context, ns string) (context.CancelFunc, error) {
+	collector := &runnableCollector{Manager: c.manager}
+	if _, err := dnsnameresolver.New(collector, dnsnameresolver.Config{
+		OperandNamespace: operatorcontroller.DefaultOperandNamespace,
+		ServiceName: operatorcontroller.DNSServiceName(&operatorv1.DNS{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: operatorcontroller.DefaultDNSController,
+			},
+		}).Name,
+		DNSPort:                  fmt.Sprint(manifests.DNSDaemonSet().Spec.Template.Spec.Containers[0].Ports[0].ContainerPort),
+		DNSNameResolverNamespace: ns,
+	}); err != nil {
+		return nil, err
+	}
+	controllerCtx, stop := context.WithCancel(ctx)
+	for _, runnable := range collector.runnables {
+		go func(runnable manager.Runnable) {
+			if err := runnable.Start(controllerCtx); err != nil {
+				logrus.Errorf("dnsnameresolver controller for namespace %s failed: %v", ns, err)
+			}
+		}(runnable)
+	}
+	return stop, nil
+}
+
+// runnableCollector is a manager that collects the runnables that are added to
+// it instead of running them, so that their lifecycle can be managed
+// separately from the manager's.
+type runnableCollector struct {
+	manager.Manager
+	runnables []manager.Runnable
+}
+
+// Add collects the given runnable.
+func (m *runnableCollector) Add(runnable manager.Runnable) error {
+	m.runnables = append(m.runnables, runnable)
+	return nil
+}
@@ -6,12 +6,10 @@ import (
 	"time"
 
 	operatorv1 "github.com/openshift/api/operator/v1"
-	"github.com/openshift/cluster-dns-operator/pkg/manifests"
 	operatorclient "github.com/openshift/cluster-dns-operator/pkg/operator/client"
 	operatorconfig "github.com/openshift/cluster-dns-operator/pkg/operator/config"
 	operatorcontroller "github.com/openshift/cluster-dns-operator/pkg/operator/controller"
 	statuscontroller "github.com/openshift/cluster-dns-operator/pkg/operator/controller/status"
-	dnsnameresolver "github.com/openshift/coredns-ocp-dnsnameresolver/operator/controller/dnsnameresolver"
 	"k8s.io/utils/clock"
 
 	features "github.com/openshift/api/features"
@@ -28,9 +26,11 @@ import (
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/utils/ptr"
 
 	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlconfig "sigs.k8s.io/controller-runtime/pkg/config"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
@@ -111,6 +111,12 @@ func New(ctx context.Context, config operatorconfig.Config, kubeConfig *rest.Con
 				operatorcontroller.GlobalUserSpecifiedConfigNamespace: {},
 			},
 		},
+		// A DNSNameResolver controller is created for each namespace,
+		// and they all have the same name.  Controllers are also
+		// recreated when namespaces are removed and added again.
+		Controller: ctrlconfig.Controller{
+			SkipNameValidation: ptr.To(dnsNameResolverEnabled),
+		},
 		// Use a non-caching client everywhere. The default split client does not
 		// promise to invalidate the cache during writes (nor does it promise
 		// sequential create/get coherence), and we have code which (probably
@@ -149,21 +155,11 @@ func New(ctx context.Context, config operatorconfig.Config, kubeConfig *rest.Con
 		return nil, fmt.Errorf("failed to create status controller: %v", err)
 	}
 
-	// Set up the DNSNameResolver controller if the DNSNameResolver feature gate
-	// is enabled.
+	// Set up a DNSNameResolver controller for each namespace if the
+	// DNSNameResolver feature gate is enabled.
 	if dnsNameResolverEnabled {
-		_, err = dnsnameresolver.New(operatorManager, dnsnameresolver.Config{
-			OperandNamespace: operatorcontroller.DefaultOperandNamespace,
-			ServiceName: operatorcontroller.DNSServiceName(&operatorv1.DNS{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: operatorcontroller.DefaultDNSController,
-				},
-			}).Name,
-			DNSPort:                  fmt.Sprint(manifests.DNSDaemonSet().Spec.Template.Spec.Containers[0].Ports[0].ContainerPort),
-			DNSNameResolverNamespace: operatorcontroller.DefaultDNSNameResolverNamespace,
-		})
-		if err != nil {
-			return nil, fmt.Errorf("failed to create dnsnameresolver controller: %v", err)
+		if err := operatorManager.Add(newDNSNameResolverControllers(operatorManager)); err != nil {
+			return nil, fmt.Errorf("failed to add dnsnameresolver controllers: %w", err)
 		}
 	}
 